@@ -0,0 +1,86 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// WithPerAddressDialTimeout dials each address of a host that resolves to
+// more than one address in turn, giving each address the timeout, until
+// one of them connects. A dead address then costs at most the timeout,
+// rather than failing the request. The default dialer also tries every
+// address, but it divides its timeout between them, so a host with many
+// addresses leaves little time for each.
+//
+// The fallback applies to the transport built by the client, not to a base
+// round tripper. The dial breaker is consulted with each address, rather
+// than the host name. Requests made with DNS rebinding protection dial
+// only the pinned address.
+func WithPerAddressDialTimeout(value time.Duration) Option {
+	return func(opt *options) {
+		opt.perAddressDialTimeout = value
+	}
+}
+
+// fallbackDialContext resolves the host of addr with the lookup and dials
+// each of its addresses in turn, those that failed recently last, with the
+// timeout, returning the first
+// connection made. If every address fails, the error of the first is
+// returned.
+func fallbackDialContext(dial dialContextFunc, lookup lookupFunc, timeout time.Duration) dialContextFunc {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || parseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+		ipAddrs, err := lookup(ctx, host)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(ipAddrs) == 0 {
+			return nil, errors.NotFoundf("address for %q", host)
+		}
+
+		addrs := make([]string, len(ipAddrs))
+		for i, ipAddr := range ipAddrs {
+			addrs[i] = net.JoinHostPort(ipAddr.String(), port)
+		}
+
+		var firstErr error
+		for _, addr := range orderFailedTargets(ctx, addrs) {
+			conn, err := dialWithTimeout(ctx, dial, timeout, network, addr)
+			if err == nil {
+				return conn, nil
+			}
+			midLogger.Debugf("dial to %s address %s of %q failed: %v", network, addr, host, err)
+			emitEvent(ctx, Event{
+				Kind: EventDialFailover,
+				Addr: addr,
+				Err:  err,
+			})
+			if firstErr == nil {
+				firstErr = err
+			}
+			if ctx.Err() != nil {
+				// The request is done, don't try any more addresses.
+				break
+			}
+		}
+		return nil, firstErr
+	}
+}
+
+func dialWithTimeout(ctx context.Context, dial dialContextFunc, timeout time.Duration, network, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return dial(ctx, network, addr)
+}
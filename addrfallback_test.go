@@ -0,0 +1,130 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type addrFallbackSuite struct {
+	testing.IsolationSuite
+
+	addrs []string
+}
+
+var _ = gc.Suite(&addrFallbackSuite{})
+
+func (s *addrFallbackSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.addrs = nil
+	s.PatchValue(&lookupIPAddr, func(_ context.Context, host string) ([]net.IPAddr, error) {
+		if len(s.addrs) == 0 {
+			return nil, errors.NotFoundf("host %q", host)
+		}
+		var ipAddrs []net.IPAddr
+		for _, addr := range s.addrs {
+			ipAddrs = append(ipAddrs, net.IPAddr{IP: net.ParseIP(addr)})
+		}
+		return ipAddrs, nil
+	})
+}
+
+func (s *addrFallbackSuite) TestFallsBack(c *gc.C) {
+	s.addrs = []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}
+
+	var dialed []string
+	dialContext := fallbackDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		switch addr {
+		case "192.0.2.1:80":
+			return nil, &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}
+		case "192.0.2.2:80":
+			// A dead address, which doesn't answer until the timeout.
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return dial(&[]string{})(ctx, network, addr)
+	}, lookupIPAddr, 10*time.Millisecond)
+
+	conn, err := dialContext(context.Background(), "tcp", "example.com:80")
+	c.Assert(err, jc.ErrorIsNil)
+	_ = conn.Close()
+	c.Check(dialed, jc.DeepEquals, []string{"192.0.2.1:80", "192.0.2.2:80", "192.0.2.3:80"})
+}
+
+func (s *addrFallbackSuite) TestAllFail(c *gc.C) {
+	s.addrs = []string{"192.0.2.1", "192.0.2.2"}
+
+	var dialed []string
+	dialContext := fallbackDialContext(func(_ context.Context, _, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		return nil, &net.OpError{Op: "dial", Err: errors.Errorf("failed %s", addr)}
+	}, lookupIPAddr, time.Second)
+
+	_, err := dialContext(context.Background(), "tcp", "example.com:80")
+	c.Check(err, gc.ErrorMatches, "dial: failed 192.0.2.1:80")
+	c.Check(dialed, gc.HasLen, 2)
+}
+
+func (s *addrFallbackSuite) TestStopsWhenDone(c *gc.C) {
+	s.addrs = []string{"192.0.2.1", "192.0.2.2"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var dialed []string
+	dialContext := fallbackDialContext(func(_ context.Context, _, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		cancel()
+		return nil, context.Canceled
+	}, lookupIPAddr, time.Second)
+
+	_, err := dialContext(ctx, "tcp", "example.com:80")
+	c.Check(err, jc.ErrorIs, context.Canceled)
+	c.Check(dialed, jc.DeepEquals, []string{"192.0.2.1:80"})
+}
+
+func (s *addrFallbackSuite) TestIPAddress(c *gc.C) {
+	var dialed []string
+	dialContext := fallbackDialContext(dial(&dialed), lookupIPAddr, time.Second)
+
+	conn, err := dialContext(context.Background(), "tcp", "192.0.2.1:80")
+	c.Assert(err, jc.ErrorIsNil)
+	_ = conn.Close()
+	c.Check(dialed, jc.DeepEquals, []string{"192.0.2.1:80"})
+}
+
+func (s *addrFallbackSuite) TestClient(c *gc.C) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, jc.ErrorIsNil)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	// Nothing listens on 127.0.0.2, so its dial is refused.
+	s.addrs = []string{"127.0.0.2", "127.0.0.1"}
+
+	client := NewClient(WithPerAddressDialTimeout(time.Second))
+	resp, err := client.Get(context.Background(), "http://example.com:"+serverURL.Port())
+	c.Assert(err, jc.ErrorIsNil)
+	_ = resp.Body.Close()
+	c.Check(resp.StatusCode, gc.Equals, http.StatusOK)
+}
+
+func (s *addrFallbackSuite) TestNegativeTimeout(c *gc.C) {
+	_, err := NewClient(WithPerAddressDialTimeout(-time.Second)).Get(context.Background(), "http://example.com")
+	c.Check(err, gc.ErrorMatches, `.*negative per address dial timeout not valid`)
+}
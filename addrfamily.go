@@ -0,0 +1,224 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+)
+
+// AddressFamily selects the IP address families a client dials, and which
+// of them it tries first.
+type AddressFamily int
+
+const (
+	// AddressFamilyAny dials both IPv4 and IPv6 addresses, trying first
+	// the family of the first address a host name resolves to. It is the
+	// default.
+	AddressFamilyAny AddressFamily = iota
+	// PreferIPv4 dials both families, trying IPv4 addresses first.
+	PreferIPv4
+	// PreferIPv6 dials both families, trying IPv6 addresses first.
+	PreferIPv6
+	// IPv4Only dials only IPv4 addresses.
+	IPv4Only
+	// IPv6Only dials only IPv6 addresses.
+	IPv6Only
+)
+
+var addressFamilyNames = map[AddressFamily]string{
+	AddressFamilyAny: "any",
+	PreferIPv4:       "prefer-v4",
+	PreferIPv6:       "prefer-v6",
+	IPv4Only:         "v4-only",
+	IPv6Only:         "v6-only",
+}
+
+// String returns the name of the address family.
+func (f AddressFamily) String() string {
+	if name, ok := addressFamilyNames[f]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Validate validates the AddressFamily for any issues.
+func (f AddressFamily) Validate() error {
+	if _, ok := addressFamilyNames[f]; !ok {
+		return errors.NotValidf("address family %d", int(f))
+	}
+	return nil
+}
+
+// WithPreferredAddressFamily selects the address families the client
+// dials, for dual-stack deployments where one of them is unreliable, such
+// as IPv6 with broken routes.
+//
+// Unless the address family is AddressFamilyAny, the client resolves host
+// names itself, and races dials to their addresses as described by RFC
+// 8305 (Happy Eyeballs). Addresses of the two families are tried
+// alternately, starting with the preferred one. Each time the fallback
+// delay passes without a connection, or a dial fails, the next address is
+// dialed too, and the first connection made is used, see
+// DialOptions.FallbackDelay.
+//
+// Like WithResolver, it applies to the transport built by the client, not
+// to a base round tripper, and not to the hosts of requests sent through a
+// proxy.
+func WithPreferredAddressFamily(value AddressFamily) Option {
+	return func(opt *options) {
+		opt.addressFamily = value
+	}
+}
+
+// allows reports whether the address family allows dialing the IP address.
+func (f AddressFamily) allows(ip net.IP) bool {
+	switch f {
+	case IPv4Only:
+		return ip.To4() != nil
+	case IPv6Only:
+		return ip.To4() == nil
+	}
+	return true
+}
+
+// order returns the addresses the family allows, interleaving IPv4 and
+// IPv6 addresses, starting with the preferred family, as described by RFC
+// 8305, Section 4. The order of the addresses of each family is kept.
+func (f AddressFamily) order(addrs []net.IPAddr) []net.IPAddr {
+	var v4, v6 []net.IPAddr
+	for _, addr := range addrs {
+		if !f.allows(addr.IP) {
+			continue
+		}
+		if addr.IP.To4() != nil {
+			v4 = append(v4, addr)
+		} else {
+			v6 = append(v6, addr)
+		}
+	}
+	first, second := v6, v4
+	switch {
+	case f == PreferIPv4:
+		first, second = v4, v6
+	case f == AddressFamilyAny && len(addrs) > 0 && addrs[0].IP.To4() != nil:
+		first, second = v4, v6
+	}
+	ordered := make([]net.IPAddr, 0, len(v4)+len(v6))
+	for len(first) > 0 || len(second) > 0 {
+		if len(first) > 0 {
+			ordered = append(ordered, first[0])
+			first = first[1:]
+		}
+		if len(second) > 0 {
+			ordered = append(ordered, second[0])
+			second = second[1:]
+		}
+	}
+	return ordered
+}
+
+// lookup returns a lookup returning the addresses of a host the family
+// allows, in the order they are tried.
+func (f AddressFamily) lookup(lookup lookupFunc) lookupFunc {
+	if f == AddressFamilyAny {
+		return lookup
+	}
+	return func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		addrs, err := lookup(ctx, host)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		ordered := f.order(addrs)
+		if len(ordered) == 0 {
+			return nil, errors.NotFoundf("%s address for %q", f, host)
+		}
+		return ordered, nil
+	}
+}
+
+// defaultFallbackDelay is the delay before racing a dial to the next
+// address, as used by net.Dialer.
+const defaultFallbackDelay = 300 * time.Millisecond
+
+// dialHappyEyeballs dials the addresses as described by RFC 8305, starting
+// a dial to the next address each time the delay passes without a
+// connection, or a dial fails, and returning the first connection made.
+// The other dials are canceled, and any connections they make closed. If
+// every address fails, the error of the first is returned. A negative
+// delay dials the addresses in turn.
+func dialHappyEyeballs(ctx context.Context, clk clock.Clock, dial dialContextFunc, network string, addrs []string, delay time.Duration) (net.Conn, error) {
+	if delay < 0 || len(addrs) < 2 {
+		return dialAddrs(ctx, dial, network, addrs)
+	}
+	if delay == 0 {
+		delay = defaultFallbackDelay
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		index int
+		conn  net.Conn
+		err   error
+	}
+	// The channel is large enough for every dial, so that none of them
+	// blocks once a connection has been made.
+	results := make(chan result, len(addrs))
+	errs := make([]error, len(addrs))
+	var (
+		next, pending int
+		wait          <-chan time.Time
+	)
+	start := func() {
+		index := next
+		next++
+		pending++
+		go func() {
+			conn, err := dial(ctx, network, addrs[index])
+			results <- result{index: index, conn: conn, err: err}
+		}()
+		if next < len(addrs) {
+			wait = clk.After(delay)
+		} else {
+			wait = nil
+		}
+	}
+
+	start()
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				// Close the connections of any dials that race this one.
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if late := <-results; late.err == nil {
+							_ = late.conn.Close()
+						}
+					}
+				}(pending)
+				return res.conn, nil
+			}
+			errs[res.index] = res.err
+			if next < len(addrs) && ctx.Err() == nil {
+				start()
+			}
+		case <-wait:
+			start()
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, errors.Errorf("no address dialed")
+}
@@ -0,0 +1,163 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/clock/testclock"
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type addrFamilySuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&addrFamilySuite{})
+
+func ipAddrs(addrs ...string) []net.IPAddr {
+	ipAddrs := make([]net.IPAddr, len(addrs))
+	for i, addr := range addrs {
+		ipAddrs[i] = net.IPAddr{IP: net.ParseIP(addr)}
+	}
+	return ipAddrs
+}
+
+func (s *addrFamilySuite) TestOrder(c *gc.C) {
+	addrs := ipAddrs("192.0.2.1", "192.0.2.2", "2001:db8::1", "2001:db8::2", "2001:db8::3")
+	c.Check(AddressFamilyAny.order(addrs), jc.DeepEquals,
+		ipAddrs("192.0.2.1", "2001:db8::1", "192.0.2.2", "2001:db8::2", "2001:db8::3"))
+	c.Check(PreferIPv4.order(addrs), jc.DeepEquals,
+		ipAddrs("192.0.2.1", "2001:db8::1", "192.0.2.2", "2001:db8::2", "2001:db8::3"))
+	c.Check(PreferIPv6.order(addrs), jc.DeepEquals,
+		ipAddrs("2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2", "2001:db8::3"))
+	c.Check(IPv4Only.order(addrs), jc.DeepEquals, ipAddrs("192.0.2.1", "192.0.2.2"))
+	c.Check(IPv6Only.order(addrs), jc.DeepEquals, ipAddrs("2001:db8::1", "2001:db8::2", "2001:db8::3"))
+
+	// Without a preference, the family of the first address goes first.
+	addrs = ipAddrs("2001:db8::1", "192.0.2.1", "192.0.2.2")
+	c.Check(AddressFamilyAny.order(addrs), jc.DeepEquals, ipAddrs("2001:db8::1", "192.0.2.1", "192.0.2.2"))
+}
+
+func (s *addrFamilySuite) TestLookup(c *gc.C) {
+	lookup := func(context.Context, string) ([]net.IPAddr, error) {
+		return ipAddrs("2001:db8::1"), nil
+	}
+	addrs, err := PreferIPv4.lookup(lookup)(context.Background(), "example.com")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(addrs, jc.DeepEquals, ipAddrs("2001:db8::1"))
+
+	_, err = IPv4Only.lookup(lookup)(context.Background(), "example.com")
+	c.Check(err, jc.Satisfies, errors.IsNotFound)
+	c.Check(err, gc.ErrorMatches, `v4-only address for "example.com" not found`)
+}
+
+func (s *addrFamilySuite) TestValidate(c *gc.C) {
+	c.Check(IPv6Only.Validate(), jc.ErrorIsNil)
+	c.Check(AddressFamily(42).Validate(), gc.ErrorMatches, `address family 42 not valid`)
+	c.Check(AddressFamily(42).String(), gc.Equals, "unknown")
+
+	_, err := NewClient(WithPreferredAddressFamily(AddressFamily(42))).Get(context.Background(), "http://example.com")
+	c.Check(err, gc.ErrorMatches, `.*address family 42 not valid`)
+}
+
+func (s *addrFamilySuite) TestHappyEyeballsRaces(c *gc.C) {
+	canceled := make(chan struct{})
+	dial := func(ctx context.Context, _, addr string) (net.Conn, error) {
+		if addr == "[2001:db8::1]:80" {
+			// A broken route, which doesn't answer until the dial is
+			// canceled.
+			<-ctx.Done()
+			close(canceled)
+			return nil, ctx.Err()
+		}
+		client, server := net.Pipe()
+		_ = server.Close()
+		return client, nil
+	}
+
+	conn, err := dialHappyEyeballs(context.Background(), clock.WallClock, dial, "tcp",
+		[]string{"[2001:db8::1]:80", "192.0.2.1:80"}, 10*time.Millisecond)
+	c.Assert(err, jc.ErrorIsNil)
+	_ = conn.Close()
+
+	// The broken dial is canceled once the other one connects.
+	select {
+	case <-canceled:
+	case <-time.After(testing.LongWait):
+		c.Fatalf("slow dial not canceled")
+	}
+}
+
+func (s *addrFamilySuite) TestHappyEyeballsFailureStartsNext(c *gc.C) {
+	var dialed []string
+	dial := func(_ context.Context, _, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		if addr == "[2001:db8::1]:80" {
+			return nil, &net.OpError{Op: "dial", Err: errors.New("no route to host")}
+		}
+		client, server := net.Pipe()
+		_ = server.Close()
+		return client, nil
+	}
+
+	// The clock is never advanced, so the next address is only dialed
+	// because the first one failed.
+	clk := testclock.NewClock(time.Now())
+	conn, err := dialHappyEyeballs(context.Background(), clk, dial, "tcp",
+		[]string{"[2001:db8::1]:80", "192.0.2.1:80"}, time.Hour)
+	c.Assert(err, jc.ErrorIsNil)
+	_ = conn.Close()
+	c.Check(dialed, jc.DeepEquals, []string{"[2001:db8::1]:80", "192.0.2.1:80"})
+}
+
+func (s *addrFamilySuite) TestHappyEyeballsAllFail(c *gc.C) {
+	dial := func(_ context.Context, _, addr string) (net.Conn, error) {
+		return nil, &net.OpError{Op: "dial", Err: errors.Errorf("failed %s", addr)}
+	}
+	_, err := dialHappyEyeballs(context.Background(), clock.WallClock, dial, "tcp",
+		[]string{"192.0.2.1:80", "192.0.2.2:80", "192.0.2.3:80"}, time.Millisecond)
+	c.Check(err, gc.ErrorMatches, "dial: failed 192.0.2.1:80")
+}
+
+func (s *addrFamilySuite) TestHappyEyeballsDisabled(c *gc.C) {
+	var dialed []string
+	dial := func(_ context.Context, _, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		return nil, &net.OpError{Op: "dial", Err: errors.Errorf("failed %s", addr)}
+	}
+	_, err := dialHappyEyeballs(context.Background(), clock.WallClock, dial, "tcp",
+		[]string{"192.0.2.1:80", "192.0.2.2:80"}, -1)
+	c.Check(err, gc.ErrorMatches, "dial: failed 192.0.2.1:80")
+	c.Check(dialed, jc.DeepEquals, []string{"192.0.2.1:80", "192.0.2.2:80"})
+}
+
+func (s *addrFamilySuite) TestClient(c *gc.C) {
+	s.PatchValue(&lookupIPAddr, func(_ context.Context, host string) ([]net.IPAddr, error) {
+		return ipAddrs("2001:db8::1", "127.0.0.1"), nil
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+
+	client := NewClient(WithPreferredAddressFamily(IPv4Only))
+	resp, err := client.Get(context.Background(), "http://dual.example.com:"+serverURL.Port())
+	c.Assert(err, jc.ErrorIsNil)
+	_ = resp.Body.Close()
+	c.Check(resp.StatusCode, gc.Equals, http.StatusOK)
+
+	client = NewClient(WithPreferredAddressFamily(IPv6Only))
+	_, err = client.Get(context.Background(), server.URL)
+	c.Check(err, gc.ErrorMatches, `.*tcp address "127.0.0.1:[0-9]+" with address family v6-only not valid`)
+}
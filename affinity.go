@@ -0,0 +1,121 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+)
+
+// maxAffinityPools is the number of affinity tokens with their own
+// connection pool. The pool of the least recently used token is closed
+// when another token needs one.
+const maxAffinityPools = 64
+
+// AffinityFunc returns the affinity token of a request, or an empty string
+// if the request has none. Requests with the same token share a pool of
+// connections, see WithConnectionAffinity.
+type AffinityFunc func(req *http.Request) string
+
+// AffinityHeader returns an AffinityFunc that uses the value of the named
+// request header as the token.
+func AffinityHeader(name string) AffinityFunc {
+	return func(req *http.Request) string {
+		return req.Header.Get(name)
+	}
+}
+
+// AffinityCookie returns an AffinityFunc that uses the value of the named
+// request cookie as the token. Cookies added by the cookie jar of the
+// client are not visible to it, as they are added after the request is
+// sent to the transport.
+func AffinityCookie(name string) AffinityFunc {
+	return func(req *http.Request) string {
+		cookie, err := req.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	}
+}
+
+// WithConnectionAffinity gives requests with the same affinity token, for
+// example for the same juju model, their own pool of connections, so that
+// they reuse the connection to the backend that a sticky load balancer
+// pinned them to, avoiding re-authentication with another backend.
+// Requests without a token use the shared pool. Affinity applies to the
+// transport built by the client, not to a base round tripper.
+func WithConnectionAffinity(affinity AffinityFunc) Option {
+	return func(opt *options) {
+		opt.connectionAffinity = affinity
+	}
+}
+
+// affinityTransport sends requests through a transport per affinity token.
+type affinityTransport struct {
+	transport *http.Transport
+	affinity  AffinityFunc
+
+	mu    *sync.Mutex
+	pools map[string]*list.Element
+	lru   *list.List
+}
+
+type affinityPool struct {
+	token     string
+	transport *http.Transport
+}
+
+func newAffinityTransport(transport *http.Transport, affinity AffinityFunc) affinityTransport {
+	return affinityTransport{
+		transport: transport,
+		affinity:  affinity,
+		mu:        &sync.Mutex{},
+		pools:     make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t affinityTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := t.affinity(req)
+	if token == "" {
+		return t.transport.RoundTrip(req)
+	}
+	return t.pool(token).RoundTrip(req)
+}
+
+// pool returns the transport for the token, creating it if necessary.
+func (t affinityTransport) pool(token string) *http.Transport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if elem, ok := t.pools[token]; ok {
+		t.lru.MoveToFront(elem)
+		return elem.Value.(*affinityPool).transport
+	}
+	if t.lru.Len() >= maxAffinityPools {
+		oldest := t.lru.Remove(t.lru.Back()).(*affinityPool)
+		delete(t.pools, oldest.token)
+		oldest.transport.CloseIdleConnections()
+	}
+	pool := &affinityPool{
+		token:     token,
+		transport: t.transport.Clone(),
+	}
+	t.pools[token] = t.lru.PushFront(pool)
+	return pool.transport
+}
+
+// CloseIdleConnections closes the idle connections of every pool.
+func (t affinityTransport) CloseIdleConnections() {
+	t.transport.CloseIdleConnections()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for elem := t.lru.Front(); elem != nil; elem = elem.Next() {
+		elem.Value.(*affinityPool).transport.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type affinitySuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&affinitySuite{})
+
+func (s *affinitySuite) TestConnectionAffinity(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.RemoteAddr)
+	}))
+	defer server.Close()
+
+	client := NewClient(WithConnectionAffinity(AffinityHeader("X-Juju-Model")))
+	remoteAddr := func(model string) string {
+		req, err := http.NewRequestWithContext(context.TODO(), "GET", server.URL, nil)
+		c.Assert(err, jc.ErrorIsNil)
+		if model != "" {
+			req.Header.Set("X-Juju-Model", model)
+		}
+		resp, err := client.Do(req)
+		c.Assert(err, jc.ErrorIsNil)
+		defer resp.Body.Close()
+		addr, err := io.ReadAll(resp.Body)
+		c.Assert(err, jc.ErrorIsNil)
+		return string(addr)
+	}
+
+	model1 := remoteAddr("model-1")
+	model2 := remoteAddr("model-2")
+	shared := remoteAddr("")
+	c.Assert(model2, gc.Not(gc.Equals), model1)
+	c.Assert(shared, gc.Not(gc.Equals), model1)
+	c.Assert(shared, gc.Not(gc.Equals), model2)
+
+	c.Assert(remoteAddr("model-1"), gc.Equals, model1)
+	c.Assert(remoteAddr("model-2"), gc.Equals, model2)
+	c.Assert(remoteAddr(""), gc.Equals, shared)
+}
+
+func (s *affinitySuite) TestPoolsEvicted(c *gc.C) {
+	transport := newAffinityTransport(&http.Transport{}, AffinityHeader("X-Juju-Model"))
+
+	first := transport.pool("model-0")
+	for i := 1; i <= maxAffinityPools; i++ {
+		transport.pool(fmt.Sprintf("model-%d", i))
+	}
+	c.Assert(transport.pools, gc.HasLen, maxAffinityPools)
+	c.Assert(transport.pool("model-0"), gc.Not(gc.Equals), first)
+
+	// Using a pool keeps it from being evicted.
+	recent := transport.pool("model-2")
+	transport.pool("new-model")
+	c.Assert(transport.pool("model-2"), gc.Equals, recent)
+}
+
+func (s *affinitySuite) TestAffinityCookie(c *gc.C) {
+	req, err := http.NewRequest("GET", "http://meshuggah.rocks", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(AffinityCookie("session")(req), gc.Equals, "")
+
+	req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+	c.Assert(AffinityCookie("session")(req), gc.Equals, "abc")
+}
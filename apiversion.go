@@ -0,0 +1,343 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+)
+
+// defaultAPIVersionHeader is the response header listing the API versions
+// supported by a server, unless the policy names another.
+const defaultAPIVersionHeader = "X-Api-Versions"
+
+// maxAPIVersionBody is the number of bytes of a discovery response body
+// parsed for the API versions.
+const maxAPIVersionBody = 64 * 1024
+
+// defaultAPIVersionDiscoveryTimeout is how long the discovery of the API
+// versions of a host may take, unless the policy sets another timeout.
+const defaultAPIVersionDiscoveryTimeout = 30 * time.Second
+
+// APIVersionPolicy configures the negotiation of the API version used for
+// requests to a versioned API, see WithAPIVersionNegotiation.
+type APIVersionPolicy struct {
+	// BasePath is the base path of the versioned API, such as "/api".
+	// Requests for paths below it have the negotiated version inserted
+	// after it, so "/api/models" becomes "/api/v2/models".
+	BasePath string
+
+	// Supported lists the versions supported by the client, most
+	// preferred first, such as "v3" and "v2". The first one also supported
+	// by the server is negotiated.
+	Supported []string
+
+	// Header is the response header listing the versions supported by the
+	// server, separated by commas. It defaults to "X-Api-Versions".
+	// Without the header, the versions are read from a JSON response body
+	// that is either a list of versions, or an object with a "versions"
+	// list.
+	Header string
+
+	// CacheTTL, if set, is how long a negotiated version is used before it
+	// is negotiated again. Otherwise a version is negotiated once for each
+	// host.
+	CacheTTL time.Duration
+
+	// DiscoveryTimeout is how long the discovery of the versions of a host
+	// may take. It defaults to 30 seconds. The discovery is shared by the
+	// concurrent requests to the host, so it isn't cancelled with the
+	// request that started it.
+	DiscoveryTimeout time.Duration
+}
+
+// Validate validates the APIVersionPolicy for any issues.
+func (p APIVersionPolicy) Validate() error {
+	if !strings.HasPrefix(p.BasePath, "/") || path.Clean(p.BasePath) != p.BasePath {
+		return errors.NotValidf("API base path %q", p.BasePath)
+	}
+	if len(p.Supported) == 0 {
+		return errors.NotValidf("empty supported API versions")
+	}
+	for _, version := range p.Supported {
+		if version == "" || strings.ContainsAny(version, "/?#, ") {
+			return errors.NotValidf("API version %q", version)
+		}
+	}
+	if p.CacheTTL < 0 {
+		return errors.NotValidf("negative API version cache ttl")
+	}
+	if p.DiscoveryTimeout < 0 {
+		return errors.NotValidf("negative API version discovery timeout")
+	}
+	return nil
+}
+
+// WithAPIVersionNegotiation negotiates the API version of each host the
+// client sends requests below the base path of the policy to. The first
+// such request to a host is preceded by an OPTIONS request for the base
+// path, or a GET request if the server doesn't allow OPTIONS, which
+// lists the versions the server supports. The discovery requests are sent
+// through every layer of the client, like any other request. The version negotiated is
+// cached for the host, and inserted in the path of the requests to it.
+// A request fails with a NotSupported error if the client and the server
+// have no version in common. Paths that already include a supported
+// version are left unchanged.
+func WithAPIVersionNegotiation(policy APIVersionPolicy) Option {
+	return func(opt *options) {
+		opt.apiVersionPolicy = &policy
+	}
+}
+
+// APIVersion returns the API version negotiated with the host of the URL,
+// negotiating it if it hasn't been yet. The client must have been
+// constructed with WithAPIVersionNegotiation, otherwise a NotSupported
+// error is returned.
+func (c *Client) APIVersion(ctx context.Context, rawURL string) (string, error) {
+	if c.apiVersions == nil {
+		return "", errors.NotSupportedf("API version of client constructed without API version negotiation")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	version, err := c.apiVersions.negotiate(ctx, u)
+	return version, errors.Trace(err)
+}
+
+// apiVersionNegotiator negotiates and caches the API version of hosts.
+type apiVersionNegotiator struct {
+	transport http.RoundTripper
+	clock     clock.Clock
+	policy    APIVersionPolicy
+
+	mu      sync.Mutex
+	entries map[string]*apiVersionEntry
+}
+
+// apiVersionEntry is the version negotiated with a host. The ready channel
+// is closed once the negotiation is complete.
+type apiVersionEntry struct {
+	ready   chan struct{}
+	version string
+	err     error
+	expires time.Time
+}
+
+func newAPIVersionNegotiator(transport http.RoundTripper, clk clock.Clock, policy APIVersionPolicy) *apiVersionNegotiator {
+	if policy.Header == "" {
+		policy.Header = defaultAPIVersionHeader
+	}
+	if policy.DiscoveryTimeout == 0 {
+		policy.DiscoveryTimeout = defaultAPIVersionDiscoveryTimeout
+	}
+	return &apiVersionNegotiator{
+		transport: transport,
+		clock:     clk,
+		policy:    policy,
+		entries:   make(map[string]*apiVersionEntry),
+	}
+}
+
+// negotiate returns the version negotiated with the host of the URL. A
+// single negotiation is made for concurrent requests to a host, and only
+// its success is cached. The negotiation runs without the cancellation of
+// the request that started it, so that cancelling that request neither
+// fails nor delays the others waiting for it.
+func (n *apiVersionNegotiator) negotiate(ctx context.Context, u *url.URL) (string, error) {
+	key := strings.ToLower(u.Scheme + "://" + u.Host)
+	n.mu.Lock()
+	entry, ok := n.entries[key]
+	if ok && entry.err == nil && !entry.expires.IsZero() && !n.clock.Now().Before(entry.expires) {
+		ok = false
+	}
+	if !ok {
+		entry = &apiVersionEntry{ready: make(chan struct{})}
+		n.entries[key] = entry
+		go n.run(context.WithoutCancel(ctx), key, entry, u)
+	}
+	n.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+	case <-ctx.Done():
+		return "", errors.Trace(ctx.Err())
+	}
+	return entry.version, errors.Trace(entry.err)
+}
+
+// run negotiates the version of the entry of the host, within the
+// discovery timeout of the policy.
+func (n *apiVersionNegotiator) run(ctx context.Context, key string, entry *apiVersionEntry, u *url.URL) {
+	ctx, cancel := context.WithTimeout(ctx, n.policy.DiscoveryTimeout)
+	defer cancel()
+	version, err := n.discover(ctx, u)
+
+	n.mu.Lock()
+	entry.version, entry.err = version, err
+	if err != nil {
+		delete(n.entries, key)
+	} else if n.policy.CacheTTL > 0 {
+		entry.expires = n.clock.Now().Add(n.policy.CacheTTL)
+	}
+	n.mu.Unlock()
+	close(entry.ready)
+}
+
+// discover requests the versions supported by the server, and returns the
+// most preferred one the client supports too.
+func (n *apiVersionNegotiator) discover(ctx context.Context, u *url.URL) (string, error) {
+	discoveryURL := &url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host, Path: n.policy.BasePath}
+	versions, err := n.request(ctx, "OPTIONS", discoveryURL)
+	if errors.Is(err, errors.MethodNotAllowed) {
+		versions, err = n.request(ctx, "GET", discoveryURL)
+	}
+	if err != nil {
+		return "", errors.Annotatef(err, "negotiating API version with %s", u.Host)
+	}
+	for _, supported := range n.policy.Supported {
+		for _, version := range versions {
+			if strings.EqualFold(supported, version) {
+				return supported, nil
+			}
+		}
+	}
+	return "", errors.Annotatef(errors.NotSupportedf("API versions %s", strings.Join(versions, ", ")),
+		"negotiating API version with %s", u.Host)
+}
+
+// request sends a discovery request, returning the versions listed by the
+// response.
+func (n *apiVersionNegotiator) request(ctx context.Context, method string, u *url.URL) ([]string, error) {
+	ctx = context.WithValue(ctx, apiVersionDiscoveryKey{}, true)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := n.transport.RoundTrip(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+	if versions := parseAPIVersionHeader(resp.Header.Values(n.policy.Header)); len(versions) > 0 {
+		return versions, nil
+	}
+	switch {
+	case resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented:
+		return nil, errors.MethodNotAllowedf("%s %s", method, u.Redacted())
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, errors.Errorf("%s %s: %s", method, u.Redacted(), resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAPIVersionBody))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	versions, ok := parseAPIVersionBody(body)
+	if !ok {
+		return nil, errors.NotFoundf("API versions in response to %s %s", method, u.Redacted())
+	}
+	return versions, nil
+}
+
+// parseAPIVersionHeader returns the versions listed by the values of the
+// header, which may each be a list separated by commas.
+func parseAPIVersionHeader(values []string) []string {
+	var versions []string
+	for _, value := range values {
+		for _, version := range strings.Split(value, ",") {
+			if version = strings.TrimSpace(version); version != "" {
+				versions = append(versions, version)
+			}
+		}
+	}
+	return versions
+}
+
+// parseAPIVersionBody returns the versions listed by a JSON body, which is
+// either a list of versions, or an object with a "versions" list.
+func parseAPIVersionBody(body []byte) ([]string, bool) {
+	var versions []string
+	if err := json.Unmarshal(body, &versions); err == nil && len(versions) > 0 {
+		return versions, true
+	}
+	var payload struct {
+		Versions []string `json:"versions"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil && len(payload.Versions) > 0 {
+		return payload.Versions, true
+	}
+	return nil, false
+}
+
+// apiVersionDiscoveryKey marks the context of a discovery request, which
+// is sent unchanged by the apiVersionTransport.
+type apiVersionDiscoveryKey struct{}
+
+// apiVersionTransport inserts the negotiated API version in the paths of
+// requests below the base path.
+type apiVersionTransport struct {
+	wrappedRoundTripper http.RoundTripper
+	negotiator          *apiVersionNegotiator
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t apiVersionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if discovery, _ := req.Context().Value(apiVersionDiscoveryKey{}).(bool); discovery {
+		return t.wrappedRoundTripper.RoundTrip(req)
+	}
+	rest, ok := t.versionedPath(req.URL.Path)
+	if !ok {
+		return t.wrappedRoundTripper.RoundTrip(req)
+	}
+	version, err := t.negotiator.negotiate(req.Context(), req.URL)
+	if err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, errors.Trace(err)
+	}
+	versioned := req.Clone(req.Context())
+	versioned.URL.Path = path.Join(t.negotiator.policy.BasePath, version) + rest
+	versioned.URL.RawPath = ""
+	return t.wrappedRoundTripper.RoundTrip(versioned)
+}
+
+// versionedPath returns the rest of the path after the base path, if the
+// path is below the base path and doesn't already include a supported
+// version.
+func (t apiVersionTransport) versionedPath(urlPath string) (string, bool) {
+	policy := t.negotiator.policy
+	rest, ok := strings.CutPrefix(urlPath, policy.BasePath)
+	if !ok {
+		return "", false
+	}
+	if policy.BasePath == "/" {
+		rest = "/" + rest
+	}
+	if rest != "" && !strings.HasPrefix(rest, "/") {
+		// A sibling of the base path, such as "/apiary" for "/api".
+		return "", false
+	}
+	first, _, _ := strings.Cut(strings.TrimPrefix(rest, "/"), "/")
+	for _, version := range policy.Supported {
+		if strings.EqualFold(first, version) {
+			return "", false
+		}
+	}
+	return rest, true
+}
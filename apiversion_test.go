@@ -0,0 +1,308 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/juju/clock/testclock"
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type apiVersionSuite struct {
+	testing.IsolationSuite
+
+	server *httptest.Server
+
+	mu        sync.Mutex
+	requests  []string
+	discovery func(w http.ResponseWriter, r *http.Request)
+}
+
+var _ = gc.Suite(&apiVersionSuite{})
+
+func (s *apiVersionSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.requests = nil
+	s.discovery = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Api-Versions", "v1, v2")
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+		discovery := s.discovery
+		s.mu.Unlock()
+		if r.URL.Path == "/api" {
+			discovery(w, r)
+		}
+	}))
+	s.AddCleanup(func(*gc.C) { s.server.Close() })
+}
+
+func (s *apiVersionSuite) get(client *Client, path string) error {
+	resp, err := client.Get(context.Background(), s.server.URL+path)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+func (s *apiVersionSuite) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *apiVersionSuite) TestValidate(c *gc.C) {
+	tests := []struct {
+		policy APIVersionPolicy
+		err    string
+	}{{
+		policy: APIVersionPolicy{BasePath: "api", Supported: []string{"v1"}},
+		err:    `API base path "api" not valid`,
+	}, {
+		policy: APIVersionPolicy{BasePath: "/api/", Supported: []string{"v1"}},
+		err:    `API base path "/api/" not valid`,
+	}, {
+		policy: APIVersionPolicy{BasePath: "/api"},
+		err:    `empty supported API versions not valid`,
+	}, {
+		policy: APIVersionPolicy{BasePath: "/api", Supported: []string{"v1/beta"}},
+		err:    `API version "v1/beta" not valid`,
+	}, {
+		policy: APIVersionPolicy{BasePath: "/api", Supported: []string{"v1"}, CacheTTL: -time.Second},
+		err:    `negative API version cache ttl not valid`,
+	}, {
+		policy: APIVersionPolicy{BasePath: "/api", Supported: []string{"v1"}, DiscoveryTimeout: -time.Second},
+		err:    `negative API version discovery timeout not valid`,
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.err)
+		c.Check(test.policy.Validate(), gc.ErrorMatches, test.err)
+	}
+	c.Check(APIVersionPolicy{BasePath: "/", Supported: []string{"v1"}}.Validate(), jc.ErrorIsNil)
+}
+
+func (s *apiVersionSuite) TestNegotiateFromHeader(c *gc.C) {
+	client := NewClient(WithAPIVersionNegotiation(APIVersionPolicy{
+		BasePath:  "/api",
+		Supported: []string{"v3", "v2", "v1"},
+	}))
+	c.Assert(s.get(client, "/api/models"), jc.ErrorIsNil)
+	c.Assert(s.get(client, "/api/models/uuid"), jc.ErrorIsNil)
+	c.Assert(s.get(client, "/api"), jc.ErrorIsNil)
+	c.Check(s.received(), jc.DeepEquals, []string{
+		"OPTIONS /api",
+		"GET /api/v2/models",
+		"GET /api/v2/models/uuid",
+		"GET /api/v2",
+	})
+
+	version, err := client.APIVersion(context.Background(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(version, gc.Equals, "v2")
+}
+
+func (s *apiVersionSuite) TestNegotiateFromBody(c *gc.C) {
+	s.discovery = func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		_, _ = io.WriteString(w, `{"versions": ["v1"]}`)
+	}
+	client := NewClient(WithAPIVersionNegotiation(APIVersionPolicy{
+		BasePath:  "/api",
+		Supported: []string{"v2", "v1"},
+	}))
+	c.Assert(s.get(client, "/api/models"), jc.ErrorIsNil)
+	c.Check(s.received(), jc.DeepEquals, []string{
+		"OPTIONS /api",
+		"GET /api",
+		"GET /api/v1/models",
+	})
+}
+
+func (s *apiVersionSuite) TestPathsLeftUnchanged(c *gc.C) {
+	client := NewClient(WithAPIVersionNegotiation(APIVersionPolicy{
+		BasePath:  "/api",
+		Supported: []string{"v2", "v1"},
+	}))
+	c.Assert(s.get(client, "/apiary"), jc.ErrorIsNil)
+	c.Assert(s.get(client, "/charms"), jc.ErrorIsNil)
+	c.Assert(s.get(client, "/api/v1/models"), jc.ErrorIsNil)
+	c.Check(s.received(), jc.DeepEquals, []string{
+		"GET /apiary",
+		"GET /charms",
+		"GET /api/v1/models",
+	})
+}
+
+func (s *apiVersionSuite) TestNoCommonVersion(c *gc.C) {
+	client := NewClient(WithAPIVersionNegotiation(APIVersionPolicy{
+		BasePath:  "/api",
+		Supported: []string{"v4", "v3"},
+	}))
+	err := s.get(client, "/api/models")
+	c.Assert(err, jc.ErrorIs, errors.NotSupported)
+	c.Check(err, gc.ErrorMatches, `.*: negotiating API version with .*: API versions v1, v2 not supported`)
+	c.Check(s.received(), jc.DeepEquals, []string{"OPTIONS /api"})
+}
+
+func (s *apiVersionSuite) TestFailedNegotiationNotCached(c *gc.C) {
+	s.discovery = func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	client := NewClient(WithAPIVersionNegotiation(APIVersionPolicy{
+		BasePath:  "/api",
+		Supported: []string{"v1"},
+	}))
+	err := s.get(client, "/api/models")
+	c.Assert(err, gc.ErrorMatches, `.*negotiating API version with .*: OPTIONS .*/api: 500 Internal Server Error`)
+
+	s.mu.Lock()
+	s.discovery = func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `["v1"]`)
+	}
+	s.mu.Unlock()
+	c.Assert(s.get(client, "/api/models"), jc.ErrorIsNil)
+	c.Check(s.received(), jc.DeepEquals, []string{
+		"OPTIONS /api",
+		"OPTIONS /api",
+		"GET /api/v1/models",
+	})
+}
+
+func (s *apiVersionSuite) TestCancelledNegotiationShared(c *gc.C) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s.discovery = func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Header().Set("X-Api-Versions", "v1")
+	}
+	client := NewClient(WithAPIVersionNegotiation(APIVersionPolicy{
+		BasePath:  "/api",
+		Supported: []string{"v1"},
+	}))
+
+	// The request that starts the negotiation is cancelled while another
+	// waits for it.
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := client.APIVersion(ctx, s.server.URL)
+		first <- err
+	}()
+	select {
+	case <-started:
+	case <-time.After(testing.LongWait):
+		c.Fatalf("negotiation not started")
+	}
+	second := make(chan error, 1)
+	go func() {
+		second <- s.get(client, "/api/models")
+	}()
+	cancel()
+	select {
+	case err := <-first:
+		c.Assert(err, jc.ErrorIs, context.Canceled)
+	case <-time.After(testing.LongWait):
+		c.Fatalf("cancelled request not returned")
+	}
+
+	close(release)
+	select {
+	case err := <-second:
+		c.Assert(err, jc.ErrorIsNil)
+	case <-time.After(testing.LongWait):
+		c.Fatalf("waiting request not returned")
+	}
+	c.Check(s.received(), jc.DeepEquals, []string{
+		"OPTIONS /api",
+		"GET /api/v1/models",
+	})
+}
+
+func (s *apiVersionSuite) TestDiscoveryTimeout(c *gc.C) {
+	release := make(chan struct{})
+	defer close(release)
+	s.discovery = func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}
+	client := NewClient(WithAPIVersionNegotiation(APIVersionPolicy{
+		BasePath:         "/api",
+		Supported:        []string{"v1"},
+		DiscoveryTimeout: 10 * time.Millisecond,
+	}))
+	err := s.get(client, "/api/models")
+	c.Assert(err, gc.ErrorMatches, `.*negotiating API version with .*: context deadline exceeded`)
+}
+
+func (s *apiVersionSuite) TestDiscoveryThroughOuterLayers(c *gc.C) {
+	client := NewClient(
+		WithAPIVersionNegotiation(APIVersionPolicy{
+			BasePath:  "/api",
+			Supported: []string{"v1"},
+		}),
+		WithDeadlineCheck(DeadlineCheckReject),
+		WithAllowedSchemes("http"),
+	)
+	_, ok := client.apiVersions.transport.(schemeAllowListTransport)
+	c.Assert(ok, jc.IsTrue)
+
+	// The discovery request has the deadline of the discovery timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	version, err := client.APIVersion(ctx, s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(version, gc.Equals, "v1")
+}
+
+func (s *apiVersionSuite) TestCacheTTL(c *gc.C) {
+	clk := testclock.NewClock(time.Now())
+	client := NewClient(
+		WithClock(clk),
+		WithAPIVersionNegotiation(APIVersionPolicy{
+			BasePath:  "/api",
+			Supported: []string{"v2", "v1"},
+			CacheTTL:  time.Minute,
+		}),
+	)
+	c.Assert(s.get(client, "/api/models"), jc.ErrorIsNil)
+
+	s.mu.Lock()
+	s.discovery = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Api-Versions", "v1")
+	}
+	s.mu.Unlock()
+	clk.Advance(30 * time.Second)
+	c.Assert(s.get(client, "/api/models"), jc.ErrorIsNil)
+	clk.Advance(30 * time.Second)
+	c.Assert(s.get(client, "/api/models"), jc.ErrorIsNil)
+	c.Check(s.received(), jc.DeepEquals, []string{
+		"OPTIONS /api",
+		"GET /api/v2/models",
+		"GET /api/v2/models",
+		"OPTIONS /api",
+		"GET /api/v1/models",
+	})
+}
+
+func (s *apiVersionSuite) TestAPIVersionWithoutNegotiation(c *gc.C) {
+	_, err := NewClient().APIVersion(context.Background(), s.server.URL)
+	c.Assert(err, jc.ErrorIs, errors.NotSupported)
+}
@@ -0,0 +1,162 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// ErrRetryAfterExceedsMax is matched, using errors.Is, by the error
+// returned for a request that wasn't retried as the delay before the retry
+// exceeds the MaxDelay of the retry policy.
+const ErrRetryAfterExceedsMax = errors.ConstError("retry delay exceeds max delay")
+
+// RetryAfterExceedsMaxError is returned for a request that wasn't retried
+// as the delay before the retry, typically asked for by a Retry-After
+// header, exceeds the MaxDelay of the retry policy.
+type RetryAfterExceedsMaxError struct {
+	// Wait is the delay before the retry.
+	Wait time.Duration
+	// MaxDelay is the MaxDelay of the retry policy.
+	MaxDelay time.Duration
+	// Until is the time the retry would have been made.
+	Until time.Time
+}
+
+// Error implements error.
+func (e *RetryAfterExceedsMaxError) Error() string {
+	return fmt.Sprintf("API request retry is not accepting further requests until %s", e.Until.Format(time.RFC3339))
+}
+
+// Is returns true for ErrRetryAfterExceedsMax.
+func (e *RetryAfterExceedsMaxError) Is(target error) bool {
+	return target == ErrRetryAfterExceedsMax
+}
+
+// BackoffFunc returns the delay before the next attempt of a request,
+// given the previous delay, starting with the Delay of the RetryPolicy,
+// and the number of attempts made so far. A Retry-After header in the
+// response takes precedence, and the delay is capped at the MaxDelay of
+// the policy.
+type BackoffFunc func(delay time.Duration, attempt int) time.Duration
+
+// ConstantBackoff waits the same delay before every retry.
+func ConstantBackoff(delay time.Duration) BackoffFunc {
+	return func(time.Duration, int) time.Duration {
+		return delay
+	}
+}
+
+// ExponentialBackoff waits initial before the first retry, doubling the
+// delay before each further retry, up to max.
+func ExponentialBackoff(initial, max time.Duration) BackoffFunc {
+	return func(_ time.Duration, attempt int) time.Duration {
+		return exponentialDelay(initial, max, attempt)
+	}
+}
+
+// ExponentialWithJitter waits a random delay between zero and the delay of
+// ExponentialBackoff, so that many clients retrying at once, for example
+// agents reconnecting to a restarted controller, spread out their retries
+// rather than all arriving together.
+func ExponentialWithJitter(initial, max time.Duration) BackoffFunc {
+	return func(_ time.Duration, attempt int) time.Duration {
+		delay := exponentialDelay(initial, max, attempt)
+		if delay <= 0 {
+			return 0
+		}
+		return time.Duration(rand.Int63n(int64(delay) + 1))
+	}
+}
+
+// exponentialDelay returns initial doubled for each attempt after the
+// first, up to max.
+func exponentialDelay(initial, max time.Duration, attempt int) time.Duration {
+	delay := initial
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+// RetryDelay returns the delay before the retry that follows the given
+// attempt of a request, as the retry middleware computes it, so that code
+// depending on retry timing can be tested. A Retry-After header in the
+// response to the attempt, if any, takes precedence. Otherwise the delay is
+// computed by the BackoffFunc of the policy from the previous delay, which
+// is the Delay of the policy before the first retry. A
+// *RetryAfterExceedsMaxError is returned if the delay exceeds the MaxDelay
+// of the policy, in which case the request isn't retried.
+func (p RetryPolicy) RetryDelay(resp *http.Response, previous time.Duration, attempt int, now time.Time) (time.Duration, error) {
+	if resp != nil {
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+			return p.clampDelay(delay, now)
+		}
+	}
+	delay := previous
+	if p.BackoffFunc != nil {
+		delay = p.BackoffFunc(previous, attempt)
+		if p.MaxDelay > 0 && delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+	return p.clampDelay(delay, now)
+}
+
+// RetryDelays returns the delays before each retry of a request whose
+// attempts all fail without a Retry-After header, which is the schedule
+// the retry middleware follows for it. With a random BackoffFunc, such as
+// ExponentialWithJitter, each call returns a different schedule.
+func (p RetryPolicy) RetryDelays() []time.Duration {
+	var delays []time.Duration
+	delay := p.Delay
+	for attempt := 1; attempt < p.Attempts; attempt++ {
+		var err error
+		delay, err = p.RetryDelay(nil, delay, attempt, time.Time{})
+		if err != nil {
+			break
+		}
+		delays = append(delays, delay)
+	}
+	return delays
+}
+
+func (p RetryPolicy) clampDelay(delay time.Duration, now time.Time) (time.Duration, error) {
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		return delay, &RetryAfterExceedsMaxError{
+			Wait:     delay,
+			MaxDelay: p.MaxDelay,
+			Until:    now.Add(delay),
+		}
+	}
+	return delay, nil
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is
+// either a delay in seconds or an HTTP date.
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	// Check for delay in seconds first, before checking for a http-date.
+	if seconds, err := strconv.ParseInt(header, 10, 64); err == nil {
+		return time.Second * time.Duration(seconds), true
+	}
+	if date, err := time.Parse(time.RFC1123, header); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
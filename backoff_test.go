@@ -0,0 +1,124 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type backoffSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&backoffSuite{})
+
+func (s *backoffSuite) TestConstantBackoff(c *gc.C) {
+	backoff := ConstantBackoff(time.Second)
+	for attempt := 1; attempt < 5; attempt++ {
+		c.Check(backoff(time.Minute, attempt), gc.Equals, time.Second)
+	}
+}
+
+func (s *backoffSuite) TestExponentialBackoff(c *gc.C) {
+	backoff := ExponentialBackoff(100*time.Millisecond, time.Second)
+	var delays []time.Duration
+	for attempt := 1; attempt <= 6; attempt++ {
+		delays = append(delays, backoff(0, attempt))
+	}
+	c.Assert(delays, gc.DeepEquals, []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	})
+
+	// Large attempt counts don't overflow.
+	c.Assert(backoff(0, 1000), gc.Equals, time.Second)
+}
+
+func (s *backoffSuite) TestExponentialWithJitter(c *gc.C) {
+	backoff := ExponentialWithJitter(100*time.Millisecond, time.Second)
+	for attempt := 1; attempt <= 6; attempt++ {
+		max := exponentialDelay(100*time.Millisecond, time.Second, attempt)
+		for i := 0; i < 100; i++ {
+			delay := backoff(0, attempt)
+			c.Assert(delay >= 0 && delay <= max, jc.IsTrue, gc.Commentf("delay %s, max %s", delay, max))
+		}
+	}
+
+	c.Assert(ExponentialWithJitter(0, 0)(0, 1), gc.Equals, time.Duration(0))
+}
+
+func (s *backoffSuite) TestRetryDelays(c *gc.C) {
+	policy := RetryPolicy{
+		Attempts:    5,
+		Delay:       time.Second,
+		MaxDelay:    5 * time.Second,
+		BackoffFunc: ExponentialBackoff(time.Second, time.Minute),
+	}
+	c.Check(policy.RetryDelays(), jc.DeepEquals, []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		5 * time.Second,
+	})
+
+	// Without a BackoffFunc, the Delay is used for every retry.
+	policy = RetryPolicy{Attempts: 3, Delay: time.Second, MaxDelay: time.Minute}
+	c.Check(policy.RetryDelays(), jc.DeepEquals, []time.Duration{time.Second, time.Second})
+
+	// A single attempt is never retried.
+	policy = RetryPolicy{Attempts: 1, Delay: time.Second, MaxDelay: time.Minute}
+	c.Check(policy.RetryDelays(), gc.HasLen, 0)
+}
+
+func (s *backoffSuite) TestRetryDelayRetryAfter(c *gc.C) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	policy := RetryPolicy{
+		Attempts:    3,
+		Delay:       time.Second,
+		MaxDelay:    time.Hour,
+		BackoffFunc: ExponentialBackoff(time.Second, time.Minute),
+	}
+	resp := func(retryAfter string) *http.Response {
+		header := make(http.Header)
+		header.Set("Retry-After", retryAfter)
+		return &http.Response{Header: header}
+	}
+
+	delay, err := policy.RetryDelay(resp("42"), time.Second, 1, now)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(delay, gc.Equals, 42*time.Second)
+
+	delay, err = policy.RetryDelay(resp(now.Add(time.Minute).Format(http.TimeFormat)), time.Second, 1, now)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(delay, gc.Equals, time.Minute)
+
+	// A date in the past retries immediately.
+	delay, err = policy.RetryDelay(resp(now.Add(-time.Minute).Format(http.TimeFormat)), time.Second, 1, now)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(delay, gc.Equals, time.Duration(0))
+
+	// An invalid header falls back to the BackoffFunc.
+	delay, err = policy.RetryDelay(resp("soon"), time.Second, 2, now)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(delay, gc.Equals, 2*time.Second)
+
+	// A delay beyond the MaxDelay stops retrying.
+	_, err = policy.RetryDelay(resp("7200"), time.Second, 1, now)
+	c.Check(err, gc.ErrorMatches, `API request retry is not accepting further requests until 2024-01-01T02:00:00Z`)
+	c.Check(err, jc.ErrorIs, ErrRetryAfterExceedsMax)
+	exceedsErr, ok := errors.AsType[*RetryAfterExceedsMaxError](err)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(exceedsErr.Wait, gc.Equals, 2*time.Hour)
+	c.Check(exceedsErr.MaxDelay, gc.Equals, policy.MaxDelay)
+}
@@ -0,0 +1,213 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Digest identifies content by a hash algorithm and the hex encoded sum,
+// for example "sha256:e3b0c442...".
+type Digest struct {
+	Algorithm string
+	Hex       string
+}
+
+// ParseDigest parses a digest in the form "<algorithm>:<hex>". The supported
+// algorithms are sha256, sha384 and sha512.
+func ParseDigest(value string) (Digest, error) {
+	algorithm, sum, ok := strings.Cut(value, ":")
+	if !ok {
+		return Digest{}, errors.NotValidf("digest %q", value)
+	}
+	digest := Digest{
+		Algorithm: strings.ToLower(algorithm),
+		Hex:       strings.ToLower(sum),
+	}
+	if err := digest.Validate(); err != nil {
+		return Digest{}, errors.Trace(err)
+	}
+	return digest, nil
+}
+
+// Validate validates the Digest for any issues.
+func (d Digest) Validate() error {
+	h, err := d.newHash()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if decoded, err := hex.DecodeString(d.Hex); err != nil || len(decoded) != h.Size() {
+		return errors.NotValidf("%s digest %q", d.Algorithm, d.Hex)
+	}
+	return nil
+}
+
+// String implements fmt.Stringer.
+func (d Digest) String() string {
+	return d.Algorithm + ":" + d.Hex
+}
+
+func (d Digest) newHash() (hash.Hash, error) {
+	switch d.Algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha384":
+		return sha512.New384(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, errors.NotSupportedf("digest algorithm %q", d.Algorithm)
+}
+
+// DigestMismatchError is returned when content does not match the expected
+// digest.
+type DigestMismatchError struct {
+	Expected Digest
+	Actual   Digest
+}
+
+// Error implements error.
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("digest mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
+// BlobFetcher fetches blobs identified by URL and digest, storing them in a
+// local content addressed directory. Repeat fetches of the same digest are
+// served from disk, and the digest of stored blobs is validated as they are
+// read.
+type BlobFetcher struct {
+	client HTTPClient
+	dir    string
+}
+
+// NewBlobFetcher creates a BlobFetcher using the client to download blobs
+// into dir, which is created if it doesn't exist.
+func NewBlobFetcher(client HTTPClient, dir string) (*BlobFetcher, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &BlobFetcher{
+		client: client,
+		dir:    dir,
+	}, nil
+}
+
+// Path returns the path where the blob with the given digest is stored.
+func (f *BlobFetcher) Path(digest Digest) string {
+	return filepath.Join(f.dir, digest.Algorithm, digest.Hex)
+}
+
+// Fetch returns a reader for the blob with the given digest, downloading it
+// from url if it isn't already stored. Reading the blob to the end returns a
+// *DigestMismatchError if the stored content has been corrupted, in which
+// case the blob is removed so that the next Fetch downloads it again.
+func (f *BlobFetcher) Fetch(ctx context.Context, url string, digest Digest) (io.ReadCloser, error) {
+	if err := digest.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	path := f.Path(digest)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		if err := f.download(ctx, url, digest); err != nil {
+			return nil, errors.Trace(err)
+		}
+		file, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	h, _ := digest.newHash()
+	return &verifyingReader{
+		file:   file,
+		hash:   h,
+		digest: digest,
+	}, nil
+}
+
+// download fetches the blob into a temporary file, verifies its digest and
+// then moves it into place.
+func (f *BlobFetcher) download(ctx context.Context, url string, digest Digest) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("cannot fetch blob %s from %s: %s", digest, url, resp.Status)
+	}
+
+	dir := filepath.Dir(f.Path(digest))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return errors.Trace(err)
+	}
+	tmp, err := os.CreateTemp(dir, "download-*")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	h, _ := digest.newHash()
+	if _, err := copyBuffer(io.MultiWriter(tmp, h), resp.Body); err != nil {
+		return errors.Annotatef(err, "cannot fetch blob %s from %s", digest, url)
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != digest.Hex {
+		return &DigestMismatchError{
+			Expected: digest,
+			Actual:   Digest{Algorithm: digest.Algorithm, Hex: actual},
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(tmp.Name(), f.Path(digest)))
+}
+
+// verifyingReader hashes a stored blob as it is read, and checks the digest
+// once the end of the file is reached.
+type verifyingReader struct {
+	file   *os.File
+	hash   hash.Hash
+	digest Digest
+}
+
+// Read implements io.Reader.
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	_, _ = r.hash.Write(p[:n])
+	if err != io.EOF {
+		return n, err
+	}
+	if actual := hex.EncodeToString(r.hash.Sum(nil)); actual != r.digest.Hex {
+		_ = os.Remove(r.file.Name())
+		return n, &DigestMismatchError{
+			Expected: r.digest,
+			Actual:   Digest{Algorithm: r.digest.Algorithm, Hex: actual},
+		}
+	}
+	return n, io.EOF
+}
+
+// Close implements io.Closer.
+func (r *verifyingReader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type blobSuite struct {
+	testing.IsolationSuite
+	requests int
+	server   *httptest.Server
+	digest   Digest
+}
+
+var _ = gc.Suite(&blobSuite{})
+
+const blobContent = "charm archive contents"
+
+func (s *blobSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.requests = 0
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests++
+		_, _ = io.WriteString(w, blobContent)
+	}))
+	sum := sha256.Sum256([]byte(blobContent))
+	s.digest = Digest{Algorithm: "sha256", Hex: hex.EncodeToString(sum[:])}
+}
+
+func (s *blobSuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+	s.IsolationSuite.TearDownTest(c)
+}
+
+func (s *blobSuite) read(c *gc.C, fetcher *BlobFetcher, digest Digest) (string, error) {
+	reader, err := fetcher.Fetch(context.TODO(), s.server.URL, digest)
+	c.Assert(err, jc.ErrorIsNil)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	return string(data), err
+}
+
+func (s *blobSuite) TestParseDigest(c *gc.C) {
+	digest, err := ParseDigest(s.digest.String())
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(digest, gc.Equals, s.digest)
+
+	_, err = ParseDigest("sha256")
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+	_, err = ParseDigest("sha256:abc")
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+	_, err = ParseDigest("md5:d41d8cd98f00b204e9800998ecf8427e")
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+}
+
+func (s *blobSuite) TestFetchCaches(c *gc.C) {
+	fetcher, err := NewBlobFetcher(NewClient(), c.MkDir())
+	c.Assert(err, jc.ErrorIsNil)
+
+	for i := 0; i < 2; i++ {
+		data, err := s.read(c, fetcher, s.digest)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(data, gc.Equals, blobContent)
+	}
+	c.Assert(s.requests, gc.Equals, 1)
+}
+
+func (s *blobSuite) TestFetchDigestMismatch(c *gc.C) {
+	fetcher, err := NewBlobFetcher(NewClient(), c.MkDir())
+	c.Assert(err, jc.ErrorIsNil)
+
+	sum := sha256.Sum256([]byte("something else"))
+	digest := Digest{Algorithm: "sha256", Hex: hex.EncodeToString(sum[:])}
+	_, err = fetcher.Fetch(context.TODO(), s.server.URL, digest)
+	_, ok := errors.AsType[*DigestMismatchError](err)
+	c.Assert(ok, jc.IsTrue)
+	_, err = os.Stat(fetcher.Path(digest))
+	c.Assert(os.IsNotExist(err), jc.IsTrue)
+}
+
+func (s *blobSuite) TestCorruptedBlobRefetched(c *gc.C) {
+	fetcher, err := NewBlobFetcher(NewClient(), c.MkDir())
+	c.Assert(err, jc.ErrorIsNil)
+
+	_, err = s.read(c, fetcher, s.digest)
+	c.Assert(err, jc.ErrorIsNil)
+
+	err = os.WriteFile(fetcher.Path(s.digest), []byte("corrupted"), 0o644)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = s.read(c, fetcher, s.digest)
+	_, ok := errors.AsType[*DigestMismatchError](err)
+	c.Assert(ok, jc.IsTrue)
+
+	data, err := s.read(c, fetcher, s.digest)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(data, gc.Equals, blobContent)
+	c.Assert(s.requests, gc.Equals, 2)
+}
@@ -0,0 +1,175 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+)
+
+// BodyStats describes how a response body was consumed.
+type BodyStats struct {
+	// BytesRead is the number of bytes read from the body.
+	BytesRead int64
+	// TimeToLastByte is the time from the start of the request until the
+	// end of the body was read, or zero if the end was never reached.
+	TimeToLastByte time.Duration
+	// Complete is true if the body was read to the end.
+	Complete bool
+	// Closed is false if the body was abandoned without being closed,
+	// which leaks the underlying connection.
+	Closed bool
+	// Stack is the stack of the goroutine that made the request, if the
+	// body was abandoned and stack capture is enabled.
+	Stack string
+}
+
+// BodyRecorder is an optional extension of RequestRecorder. If the client's
+// RequestRecorder implements it, every response body is instrumented and
+// recorded once it is closed, or found to have been abandoned.
+type BodyRecorder interface {
+	// RecordBody records how the response body of a request was consumed.
+	RecordBody(method string, url *url.URL, stats BodyStats)
+}
+
+// WithBodyLeakDetection logs an error for every response body that is
+// garbage collected without having been closed, a common cause of
+// connection leaks. If captureStacks is true, the stack of the goroutine
+// that made the request is captured and logged too, which is expensive and
+// intended for debugging only.
+func WithBodyLeakDetection(captureStacks bool) Option {
+	return func(opt *options) {
+		opt.bodyLeakDetection = true
+		opt.bodyLeakStacks = captureStacks
+	}
+}
+
+// bodyTrackingTransport instruments response bodies to report how they were
+// consumed.
+type bodyTrackingTransport struct {
+	wrappedRoundTripper http.RoundTripper
+	snapshot            *clientSnapshot
+	clock               clock.Clock
+	hooks               hookRunner
+	detectLeaks         bool
+	captureStacks       bool
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t bodyTrackingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	state := t.snapshot.load()
+	recorder, _ := requestRecorder(req.Context(), state.recorder).(BodyRecorder)
+	if recorder == nil && !t.detectLeaks {
+		return t.wrappedRoundTripper.RoundTrip(req)
+	}
+
+	start := t.clock.Now()
+	res, err := t.wrappedRoundTripper.RoundTrip(req)
+	if err != nil || res.Body == nil {
+		return res, err
+	}
+
+	body := &trackedBody{
+		body:     res.Body,
+		start:    start,
+		clock:    t.clock,
+		method:   req.Method,
+		url:      req.URL,
+		recorder: recorder,
+		logger:   state.logger,
+		hooks:    t.hooks,
+	}
+	if t.captureStacks {
+		body.stack = string(debug.Stack())
+	}
+	runtime.SetFinalizer(body, (*trackedBody).abandoned)
+
+	// The transport keeps hold of the original response until the body is
+	// consumed, so return a copy to allow an abandoned body to be collected.
+	tracked := *res
+	tracked.Body = body
+	return &tracked, nil
+}
+
+// trackedBody wraps a response body, counting the bytes read and reporting
+// to the recorder when it is closed or garbage collected.
+type trackedBody struct {
+	body   io.ReadCloser
+	start  time.Time
+	clock  clock.Clock
+	method string
+	url    *url.URL
+	stack  string
+
+	recorder BodyRecorder
+	logger   Logger
+	hooks    hookRunner
+
+	mu    sync.Mutex
+	stats BodyStats
+	done  bool
+}
+
+// Read implements io.Reader.
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+
+	b.mu.Lock()
+	b.stats.BytesRead += int64(n)
+	if err == io.EOF && !b.stats.Complete {
+		b.stats.Complete = true
+		b.stats.TimeToLastByte = b.clock.Now().Sub(b.start)
+	}
+	b.mu.Unlock()
+
+	return n, err
+}
+
+// Close implements io.Closer.
+func (b *trackedBody) Close() error {
+	err := b.body.Close()
+	runtime.SetFinalizer(b, nil)
+	b.report(true)
+	return err
+}
+
+func (b *trackedBody) abandoned() {
+	b.report(false)
+	if b.stack != "" {
+		b.logger.Errorf("response body of %s %s was never closed, request made from:\n%s", b.method, b.url, b.stack)
+	} else {
+		b.logger.Errorf("response body of %s %s was never closed", b.method, b.url)
+	}
+	// Release the connection.
+	_ = b.body.Close()
+}
+
+func (b *trackedBody) report(closed bool) {
+	b.mu.Lock()
+	if b.done {
+		b.mu.Unlock()
+		return
+	}
+	b.done = true
+	stats := b.stats
+	stats.Closed = closed
+	if !closed {
+		stats.Stack = b.stack
+	}
+	b.mu.Unlock()
+
+	if b.recorder == nil {
+		return
+	}
+	b.hooks.run(b.logger, "body recorder", func() {
+		b.recorder.RecordBody(b.method, b.url, stats)
+	})
+}
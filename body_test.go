@@ -0,0 +1,123 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type bodySuite struct {
+	testing.IsolationSuite
+	server *httptest.Server
+}
+
+var _ = gc.Suite(&bodySuite{})
+
+func (s *bodySuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "0123456789")
+	}))
+}
+
+func (s *bodySuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+	s.IsolationSuite.TearDownTest(c)
+}
+
+type bodyRecorder struct {
+	bodies chan BodyStats
+}
+
+func (r *bodyRecorder) Record(string, *url.URL, *http.Response, time.Duration) {}
+
+func (r *bodyRecorder) RecordError(string, *url.URL, error) {}
+
+func (r *bodyRecorder) RecordBody(_ string, _ *url.URL, stats BodyStats) {
+	r.bodies <- stats
+}
+
+func (s *bodySuite) TestBodyRecorded(c *gc.C) {
+	recorder := &bodyRecorder{bodies: make(chan BodyStats, 1)}
+	client := NewClient(WithRequestRecorder(recorder))
+
+	resp, err := client.Get(context.TODO(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = io.ReadAll(resp.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+
+	stats := <-recorder.bodies
+	c.Assert(stats.BytesRead, gc.Equals, int64(10))
+	c.Assert(stats.Complete, jc.IsTrue)
+	c.Assert(stats.Closed, jc.IsTrue)
+	c.Assert(stats.TimeToLastByte > 0, jc.IsTrue)
+}
+
+func (s *bodySuite) TestBodyClosedEarly(c *gc.C) {
+	recorder := &bodyRecorder{bodies: make(chan BodyStats, 1)}
+	client := NewClient(WithRequestRecorder(recorder))
+
+	resp, err := client.Get(context.TODO(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = resp.Body.Read(make([]byte, 2))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+
+	stats := <-recorder.bodies
+	c.Assert(stats.BytesRead, gc.Equals, int64(2))
+	c.Assert(stats.Complete, jc.IsFalse)
+	c.Assert(stats.Closed, jc.IsTrue)
+}
+
+func (s *bodySuite) TestAbandonedBody(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	logged := make(chan string, 1)
+	logger := NewMockLogger(ctrl)
+	logger.EXPECT().IsTraceEnabled().Return(false).AnyTimes()
+	logger.EXPECT().Errorf(gomock.Any(), gomock.Any()).Do(func(message string, args ...interface{}) {
+		logged <- args[2].(string)
+	})
+
+	recorder := &bodyRecorder{bodies: make(chan BodyStats, 1)}
+	client := NewClient(
+		WithRequestRecorder(recorder),
+		WithLogger(logger),
+		WithBodyLeakDetection(true),
+	)
+	func() {
+		_, err := client.Get(context.TODO(), s.server.URL)
+		c.Assert(err, jc.ErrorIsNil)
+	}()
+
+	var stats BodyStats
+	timeout := time.After(testing.LongWait)
+	for found := false; !found; {
+		runtime.GC()
+		select {
+		case stats = <-recorder.bodies:
+			found = true
+		case <-time.After(testing.ShortWait):
+		case <-timeout:
+			c.Fatalf("abandoned body not detected")
+		}
+	}
+	c.Assert(stats.Closed, jc.IsFalse)
+	c.Assert(strings.Contains(stats.Stack, "TestAbandonedBody"), jc.IsTrue)
+	c.Assert(strings.Contains(<-logged, "TestAbandonedBody"), jc.IsTrue)
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package boltstore provides a KVStore backed by a bbolt database, for
+// persisting client state such as cookies across restarts.
+package boltstore
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+	bolt "go.etcd.io/bbolt"
+
+	jujuhttp "github.com/juju/http/v2"
+)
+
+var _ jujuhttp.KVStore = (*Store)(nil)
+
+// expiryLen is the length of the expiry time stored before each value.
+const expiryLen = 8
+
+// Store is a KVStore that keeps its entries in a bucket of a bbolt
+// database. Expired entries are removed when they are next read.
+type Store struct {
+	db     *bolt.DB
+	bucket []byte
+	clock  clock.Clock
+}
+
+// New creates a Store using the named bucket of the database, creating the
+// bucket if it doesn't exist. The database is owned by the caller, who must
+// close it once the store is no longer used.
+func New(db *bolt.DB, bucket string, clk clock.Clock) (*Store, error) {
+	if bucket == "" {
+		return nil, errors.NotValidf("empty bucket name")
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		return err
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "creating bucket %q", bucket)
+	}
+	return &Store{
+		db:     db,
+		bucket: []byte(bucket),
+		clock:  clk,
+	}, nil
+}
+
+// Get implements KVStore.
+func (s *Store) Get(key string) ([]byte, error) {
+	var (
+		value   []byte
+		expired bool
+	)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(s.bucket).Get([]byte(key))
+		if len(data) < expiryLen {
+			return errors.NotFoundf("key %q", key)
+		}
+		if expires := int64(binary.BigEndian.Uint64(data)); expires != 0 && s.clock.Now().UnixNano() >= expires {
+			expired = true
+			return errors.NotFoundf("key %q", key)
+		}
+		// The data is only valid for the life of the transaction.
+		value = append([]byte(nil), data[expiryLen:]...)
+		return nil
+	})
+	if expired {
+		if err := s.Delete(key); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return value, errors.Trace(err)
+}
+
+// Set implements KVStore.
+func (s *Store) Set(key string, value []byte, ttl time.Duration) error {
+	data := make([]byte, expiryLen+len(value))
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(data, uint64(s.clock.Now().Add(ttl).UnixNano()))
+	}
+	copy(data[expiryLen:], value)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Put([]byte(key), data)
+	})
+	return errors.Annotatef(err, "setting key %q", key)
+}
+
+// Delete implements KVStore.
+func (s *Store) Delete(key string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Delete([]byte(key))
+	})
+	return errors.Annotatef(err, "deleting key %q", key)
+}
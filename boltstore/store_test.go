@@ -0,0 +1,99 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package boltstore_test
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/juju/clock/testclock"
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	bolt "go.etcd.io/bbolt"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/http/v2/boltstore"
+)
+
+type storeSuite struct {
+	testing.IsolationSuite
+
+	path string
+	db   *bolt.DB
+}
+
+var _ = gc.Suite(&storeSuite{})
+
+func (s *storeSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.path = filepath.Join(c.MkDir(), "state.db")
+	s.open(c)
+}
+
+func (s *storeSuite) TearDownTest(c *gc.C) {
+	c.Assert(s.db.Close(), jc.ErrorIsNil)
+	s.IsolationSuite.TearDownTest(c)
+}
+
+func (s *storeSuite) open(c *gc.C) {
+	db, err := bolt.Open(s.path, 0600, &bolt.Options{Timeout: time.Second})
+	c.Assert(err, jc.ErrorIsNil)
+	s.db = db
+}
+
+func (s *storeSuite) TestGetSetDelete(c *gc.C) {
+	store, err := boltstore.New(s.db, "http", testclock.NewClock(time.Now()))
+	c.Assert(err, jc.ErrorIsNil)
+
+	_, err = store.Get("key")
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+
+	c.Assert(store.Set("key", []byte("value"), 0), jc.ErrorIsNil)
+	value, err := store.Get("key")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(value), gc.Equals, "value")
+
+	c.Assert(store.Delete("key"), jc.ErrorIsNil)
+	_, err = store.Get("key")
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+
+	c.Assert(store.Delete("missing"), jc.ErrorIsNil)
+}
+
+func (s *storeSuite) TestTTL(c *gc.C) {
+	clk := testclock.NewClock(time.Now())
+	store, err := boltstore.New(s.db, "http", clk)
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Assert(store.Set("key", []byte("value"), time.Minute), jc.ErrorIsNil)
+	clk.Advance(59 * time.Second)
+	_, err = store.Get("key")
+	c.Assert(err, jc.ErrorIsNil)
+
+	clk.Advance(time.Second)
+	_, err = store.Get("key")
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+}
+
+func (s *storeSuite) TestPersisted(c *gc.C) {
+	clk := testclock.NewClock(time.Now())
+	store, err := boltstore.New(s.db, "http", clk)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(store.Set("key", []byte("value"), time.Hour), jc.ErrorIsNil)
+
+	c.Assert(s.db.Close(), jc.ErrorIsNil)
+	s.open(c)
+
+	store, err = boltstore.New(s.db, "http", clk)
+	c.Assert(err, jc.ErrorIsNil)
+	value, err := store.Get("key")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(value), gc.Equals, "value")
+}
+
+func (s *storeSuite) TestEmptyBucket(c *gc.C) {
+	_, err := boltstore.New(s.db, "", testclock.NewClock(time.Now()))
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"io"
+	"sync"
+)
+
+// bufferSizes are the size classes of pooled buffers, smallest first. A
+// buffer is taken from the smallest class that fits, so that small dumps
+// don't hold on to large buffers.
+var bufferSizes = [...]int{2 << 10, 8 << 10, 32 << 10, 256 << 10}
+
+// copyBufferSize is the size of the buffers used to copy bodies, as used
+// by io.Copy.
+const copyBufferSize = 32 << 10
+
+// bufferPool pools buffers by size class, to cut the garbage from reading
+// and copying bodies under load.
+type bufferPool struct {
+	pools [len(bufferSizes)]sync.Pool
+}
+
+var buffers = newBufferPool()
+
+func newBufferPool() *bufferPool {
+	p := &bufferPool{}
+	for i, size := range bufferSizes {
+		size := size
+		p.pools[i].New = func() interface{} {
+			buf := make([]byte, size)
+			return &buf
+		}
+	}
+	return p
+}
+
+// get returns a buffer of at least size bytes, or nil if size is larger
+// than the largest size class. The buffer should be returned with put once
+// it is no longer used.
+func (p *bufferPool) get(size int) *[]byte {
+	for i, classSize := range bufferSizes {
+		if size <= classSize {
+			return p.pools[i].Get().(*[]byte)
+		}
+	}
+	return nil
+}
+
+// put returns a buffer taken with get to its pool.
+func (p *bufferPool) put(buf *[]byte) {
+	if buf == nil {
+		return
+	}
+	for i, classSize := range bufferSizes {
+		if cap(*buf) == classSize {
+			*buf = (*buf)[:classSize]
+			p.pools[i].Put(buf)
+			return
+		}
+	}
+}
+
+// copyBuffer copies from src to dst, as io.Copy does, with a pooled buffer.
+func copyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	buf := buffers.get(copyBufferSize)
+	defer buffers.put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+// pooledPrefix is the prefix of a body, read into a pooled buffer if it
+// fits in one.
+type pooledPrefix struct {
+	buf  *[]byte
+	data []byte
+}
+
+// readPrefix reads up to limit bytes from the reader. A negative limit
+// reads all of it.
+func readPrefix(r io.Reader, limit int64) (*pooledPrefix, error) {
+	if limit < 0 || limit > int64(bufferSizes[len(bufferSizes)-1]) {
+		if limit >= 0 {
+			r = io.LimitReader(r, limit)
+		}
+		data, err := io.ReadAll(r)
+		return &pooledPrefix{data: data}, err
+	}
+	buf := buffers.get(int(limit))
+	n, err := io.ReadFull(r, (*buf)[:limit])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return &pooledPrefix{buf: buf, data: (*buf)[:n]}, err
+}
+
+// bytes returns the prefix, which is only valid until it has been read to
+// the end through reader.
+func (p *pooledPrefix) bytes() []byte {
+	return p.data
+}
+
+// reader returns a reader of the prefix, which returns the buffer to its
+// pool once it has been read to the end. A reader that isn't read to the
+// end leaves the buffer to the garbage collector, so that a body closed
+// while it is being read never shares its buffer.
+func (p *pooledPrefix) reader() io.Reader {
+	return &prefixReader{prefix: p}
+}
+
+type prefixReader struct {
+	prefix *pooledPrefix
+	offset int
+}
+
+// Read implements io.Reader.
+func (r *prefixReader) Read(b []byte) (int, error) {
+	if r.prefix == nil {
+		return 0, io.EOF
+	}
+	n := copy(b, r.prefix.data[r.offset:])
+	r.offset += n
+	if r.offset == len(r.prefix.data) {
+		buffers.put(r.prefix.buf)
+		r.prefix = nil
+	}
+	return n, nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type bufferPoolSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&bufferPoolSuite{})
+
+func (s *bufferPoolSuite) TestGetSizeClasses(c *gc.C) {
+	pool := newBufferPool()
+	tests := []struct {
+		size     int
+		expected int
+	}{
+		{0, 2 << 10},
+		{2 << 10, 2 << 10},
+		{2<<10 + 1, 8 << 10},
+		{4097, 8 << 10},
+		{copyBufferSize, 32 << 10},
+		{256 << 10, 256 << 10},
+	}
+	for i, test := range tests {
+		c.Logf("test %d: %d", i, test.size)
+		buf := pool.get(test.size)
+		c.Assert(buf, gc.NotNil)
+		c.Check(len(*buf), gc.Equals, test.expected)
+		pool.put(buf)
+	}
+	c.Check(pool.get(256<<10+1), gc.IsNil)
+}
+
+func (s *bufferPoolSuite) TestPutRestoresLength(c *gc.C) {
+	pool := newBufferPool()
+	buf := pool.get(1)
+	*buf = (*buf)[:10]
+	pool.put(buf)
+
+	// Buffers that aren't from a size class, and nil, are dropped.
+	other := make([]byte, 100)
+	pool.put(&other)
+	pool.put(nil)
+
+	for i := 0; i < 10; i++ {
+		c.Check(len(*pool.get(1)), gc.Equals, 2<<10)
+	}
+}
+
+func (s *bufferPoolSuite) TestCopyBuffer(c *gc.C) {
+	data := strings.Repeat("juju", 100<<10)
+	var out bytes.Buffer
+	n, err := copyBuffer(&out, strings.NewReader(data))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(n, gc.Equals, int64(len(data)))
+	c.Check(out.String(), gc.Equals, data)
+}
+
+func (s *bufferPoolSuite) TestReadPrefix(c *gc.C) {
+	body := strings.NewReader("hello world")
+	prefix, err := readPrefix(body, 5)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(prefix.bytes()), gc.Equals, "hello")
+	c.Check(prefix.buf, gc.NotNil)
+
+	rest, err := io.ReadAll(io.MultiReader(prefix.reader(), body))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(rest), gc.Equals, "hello world")
+}
+
+func (s *bufferPoolSuite) TestReadPrefixShort(c *gc.C) {
+	prefix, err := readPrefix(strings.NewReader("hello"), 4097)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(prefix.bytes()), gc.Equals, "hello")
+}
+
+func (s *bufferPoolSuite) TestReadPrefixUnpooled(c *gc.C) {
+	data := strings.Repeat("x", 300<<10)
+
+	// Prefixes larger than the largest size class, or without a limit,
+	// aren't pooled.
+	prefix, err := readPrefix(strings.NewReader(data), 257<<10)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(prefix.bytes(), gc.HasLen, 257<<10)
+	c.Check(prefix.buf, gc.IsNil)
+
+	prefix, err = readPrefix(strings.NewReader(data), -1)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(prefix.bytes(), gc.HasLen, len(data))
+	c.Check(prefix.buf, gc.IsNil)
+}
+
+func (s *bufferPoolSuite) TestPrefixReaderReleases(c *gc.C) {
+	prefix, err := readPrefix(strings.NewReader("hello"), 10)
+	c.Assert(err, jc.ErrorIsNil)
+	reader := prefix.reader().(*prefixReader)
+
+	b := make([]byte, 3)
+	n, err := reader.Read(b)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(b[:n]), gc.Equals, "hel")
+	c.Check(reader.prefix, gc.NotNil)
+
+	n, err = reader.Read(b)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(b[:n]), gc.Equals, "lo")
+	// The buffer is released once the prefix has been read.
+	c.Check(reader.prefix, gc.IsNil)
+
+	_, err = reader.Read(b)
+	c.Check(err, gc.Equals, io.EOF)
+}
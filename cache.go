@@ -0,0 +1,354 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// ResponseCacheConfig configures a ResponseCache.
+type ResponseCacheConfig struct {
+	// Store holds the cached responses, which expire after the TTL. It may
+	// be shared with other client state, such as cookies, as the responses
+	// are stored under keys prefixed by "responses/".
+	Store KVStore
+
+	// TTL is how long a response is served from the cache.
+	TTL time.Duration
+
+	// MaxEntries is the most responses cached at once. Once it is reached,
+	// further responses aren't cached until others expire or are
+	// invalidated.
+	MaxEntries int
+
+	// MaxBodySize is the largest body of a cached response. Larger
+	// responses are returned without being cached.
+	MaxBodySize int64
+}
+
+// Validate validates the ResponseCacheConfig for any issues.
+func (c ResponseCacheConfig) Validate() error {
+	if c.Store == nil {
+		return errors.NotValidf("nil store")
+	}
+	if c.TTL <= 0 {
+		return errors.NotValidf("ttl %s", c.TTL)
+	}
+	if c.MaxEntries <= 0 {
+		return errors.NotValidf("max entries %d", c.MaxEntries)
+	}
+	if c.MaxBodySize <= 0 {
+		return errors.NotValidf("max body size %d", c.MaxBodySize)
+	}
+	return nil
+}
+
+// ResponseCache caches the 200 OK responses of GET requests in a KVStore,
+// for the clients configured to use it with WithResponseCache, so that the
+// invalidation hooks of a client apply to them. A response is served from
+// the cache until it expires, or it is invalidated by a write through the
+// client or by Client.InvalidateCache.
+//
+// A cached response is only served to a request with the same Accept and
+// Accept-Language headers, and the same values of the headers it varies
+// by. Requests with an Authorization, Cookie or Range header, responses
+// that set cookies or vary by any header, and requests or responses with a
+// Cache-Control of no-store or no-cache are never cached.
+//
+// The URLs of the cached responses are indexed in memory, so that they can
+// be invalidated, and only the responses cached by the ResponseCache are
+// served, never those left in a persistent store by an earlier process.
+//
+// ResponseCache is safe for concurrent use.
+type ResponseCache struct {
+	config ResponseCacheConfig
+
+	mu   sync.Mutex
+	urls map[string]*url.URL
+}
+
+var _ CacheInvalidator = (*ResponseCache)(nil)
+
+// cachedResponse is a response held in the store of a ResponseCache.
+type cachedResponse struct {
+	Status     string      `json:"status"`
+	StatusCode int         `json:"status-code"`
+	Proto      string      `json:"proto"`
+	Header     http.Header `json:"header"`
+	// Request holds the values of the request headers the response
+	// varies by.
+	Request http.Header `json:"request"`
+	Body    []byte      `json:"body"`
+}
+
+// NewResponseCache returns a ResponseCache configured by the config.
+func NewResponseCache(config ResponseCacheConfig) (*ResponseCache, error) {
+	if err := config.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &ResponseCache{
+		config: config,
+		urls:   make(map[string]*url.URL),
+	}, nil
+}
+
+// WithResponseCache serves the responses of GET requests from the cache,
+// and registers it with the client, so that Client.InvalidateCache drops
+// its responses made stale by a write to another URL. A successful request
+// with a method other than GET, HEAD, OPTIONS or TRACE invalidates the
+// cached response of its own URL.
+func WithResponseCache(value *ResponseCache) Option {
+	return func(opt *options) {
+		opt.responseCache = value
+	}
+}
+
+// InvalidateMatching implements CacheInvalidator, removing the cached
+// responses of GET requests matching the matcher.
+func (c *ResponseCache) InvalidateMatching(matcher RequestMatcher) {
+	c.mu.Lock()
+	var keys []string
+	for key, u := range c.urls {
+		if matcher.MatchesURL("GET", u) {
+			delete(c.urls, key)
+			keys = append(keys, key)
+		}
+	}
+	c.mu.Unlock()
+
+	// The responses are no longer indexed, so they aren't served even if
+	// they can't be deleted from the store.
+	for _, key := range keys {
+		_ = c.config.Store.Delete(key)
+	}
+}
+
+// get returns the cached response of the request, if there is one that
+// hasn't expired and matches the headers of the request.
+func (c *ResponseCache) get(req *http.Request) (*http.Response, bool) {
+	key := responseKey(req.URL)
+	c.mu.Lock()
+	_, ok := c.urls[key]
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	data, err := c.config.Store.Get(key)
+	if err != nil {
+		c.forget(key)
+		return nil, false
+	}
+	var cached cachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, false
+	}
+	for name, values := range cached.Request {
+		if !equalValues(req.Header.Values(name), values) {
+			return nil, false
+		}
+	}
+	return &http.Response{
+		Status:        cached.Status,
+		StatusCode:    cached.StatusCode,
+		Proto:         cached.Proto,
+		Header:        cached.Header,
+		Body:          io.NopCloser(bytes.NewReader(cached.Body)),
+		ContentLength: int64(len(cached.Body)),
+		Request:       req,
+	}, true
+}
+
+// put caches the response of the request, with the body read from it.
+func (c *ResponseCache) put(req *http.Request, resp *http.Response, body []byte) {
+	key := responseKey(req.URL)
+	if !c.reserve(key, req.URL) {
+		return
+	}
+	cached := cachedResponse{
+		Status:     resp.Status,
+		StatusCode: resp.StatusCode,
+		Proto:      resp.Proto,
+		Header:     resp.Header.Clone(),
+		Request:    make(http.Header),
+		Body:       body,
+	}
+	for _, name := range append([]string{"Accept", "Accept-Language"}, varyHeaders(resp.Header)...) {
+		cached.Request[http.CanonicalHeaderKey(name)] = req.Header.Values(name)
+	}
+	data, err := json.Marshal(cached)
+	if err == nil {
+		err = c.config.Store.Set(key, data, c.config.TTL)
+	}
+	if err != nil {
+		c.forget(key)
+	}
+}
+
+// reserve indexes the URL under the key, returning false if the cache is
+// full of responses that haven't expired.
+func (c *ResponseCache) reserve(key string, u *url.URL) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.urls[key]; !ok && len(c.urls) >= c.config.MaxEntries {
+		for indexed := range c.urls {
+			if _, err := c.config.Store.Get(indexed); err != nil {
+				delete(c.urls, indexed)
+			}
+		}
+		if len(c.urls) >= c.config.MaxEntries {
+			return false
+		}
+	}
+	c.urls[key] = u
+	return true
+}
+
+// remove removes the cached response of the URL, if any.
+func (c *ResponseCache) remove(u *url.URL) {
+	key := responseKey(u)
+	c.forget(key)
+	_ = c.config.Store.Delete(key)
+}
+
+// forget removes the key from the index.
+func (c *ResponseCache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.urls, key)
+}
+
+// responseKey returns the key of the cached response of the URL.
+func responseKey(u *url.URL) string {
+	return "responses/" + u.String()
+}
+
+// responseCacheTransport serves the responses of GET requests from a
+// ResponseCache, and invalidates them on writes.
+type responseCacheTransport struct {
+	wrappedRoundTripper http.RoundTripper
+	cache               *ResponseCache
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t responseCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "" && req.Method != "GET" {
+		resp, err := t.wrappedRoundTripper.RoundTrip(req)
+		if err == nil && !isSafe(req) && resp.StatusCode < 400 {
+			t.cache.remove(req.URL)
+		}
+		return resp, err
+	}
+	if !cacheableRequest(req) {
+		return t.wrappedRoundTripper.RoundTrip(req)
+	}
+	if resp, ok := t.cache.get(req); ok {
+		return resp, nil
+	}
+	resp, err := t.wrappedRoundTripper.RoundTrip(req)
+	if err != nil || !cacheableResponse(resp) || resp.ContentLength > t.cache.config.MaxBodySize {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, t.cache.config.MaxBodySize+1))
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, errors.Annotate(err, "reading response to cache")
+	}
+	if int64(len(body)) > t.cache.config.MaxBodySize {
+		// Return the body read so far followed by the rest of it, uncached.
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	_ = resp.Body.Close()
+	t.cache.put(req, resp, body)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// isSafe returns true if the request is read-only, as defined by RFC 9110.
+func isSafe(req *http.Request) bool {
+	switch req.Method {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// cacheableRequest returns true if the response of the GET request may be
+// served from, and stored in, the cache. A request with credentials or
+// cookies may be answered for its caller only.
+func cacheableRequest(req *http.Request) bool {
+	for _, name := range []string{"Authorization", "Cookie", "Range"} {
+		if req.Header.Get(name) != "" {
+			return false
+		}
+	}
+	return !noStore(req.Header)
+}
+
+// cacheableResponse returns true if the response may be stored in the
+// cache.
+func cacheableResponse(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK || noStore(resp.Header) || len(resp.Header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	for _, name := range varyHeaders(resp.Header) {
+		if name == "*" {
+			return false
+		}
+	}
+	return true
+}
+
+// varyHeaders returns the names of the request headers listed by the Vary
+// header of the response.
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// equalValues returns true if the header values are the same.
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// noStore returns true if the Cache-Control header forbids caching.
+func noStore(header http.Header) bool {
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			if directive == "no-store" || directive == "no-cache" {
+				return true
+			}
+		}
+	}
+	return false
+}
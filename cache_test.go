@@ -0,0 +1,209 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/clock/testclock"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type responseCacheSuite struct {
+	testing.IsolationSuite
+
+	server *httptest.Server
+
+	mu       sync.Mutex
+	revision int
+	reads    int
+}
+
+var _ = gc.Suite(&responseCacheSuite{})
+
+func (s *responseCacheSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.revision = 1
+	s.reads = 0
+	// The server serves the revision of the charm, which is incremented by
+	// an upload.
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch {
+		case r.Method == "POST":
+			s.revision++
+		case r.URL.Path == "/large":
+			s.reads++
+			_, _ = io.WriteString(w, strings.Repeat("x", 100))
+		default:
+			s.reads++
+			query := r.URL.Query()
+			w.Header().Set("Cache-Control", query.Get("cache-control"))
+			if query.Get("cookie") != "" {
+				http.SetCookie(w, &http.Cookie{Name: "session", Value: query.Get("cookie")})
+			}
+			if vary := query.Get("vary"); vary != "" {
+				w.Header().Set("Vary", vary)
+				fmt.Fprintf(w, "%s ", r.Header.Get(vary))
+			}
+			fmt.Fprintf(w, "revision %d", s.revision)
+		}
+	}))
+	s.AddCleanup(func(*gc.C) { s.server.Close() })
+}
+
+func (s *responseCacheSuite) newCache(c *gc.C, clk clock.Clock) *ResponseCache {
+	if clk == nil {
+		clk = clock.WallClock
+	}
+	cache, err := NewResponseCache(ResponseCacheConfig{
+		Store:       NewMemoryStore(clk),
+		TTL:         time.Minute,
+		MaxEntries:  2,
+		MaxBodySize: 64,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	return cache
+}
+
+func (s *responseCacheSuite) readCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reads
+}
+
+func (s *responseCacheSuite) get(c *gc.C, client *Client, path string, header ...string) string {
+	req, err := http.NewRequestWithContext(context.Background(), "GET", s.server.URL+path, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	for i := 0; i < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := client.Do(req)
+	c.Assert(err, jc.ErrorIsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	return string(body)
+}
+
+func (s *responseCacheSuite) post(c *gc.C, client *Client, path string) {
+	resp, err := client.Post(context.Background(), s.server.URL+path, "text/plain", strings.NewReader("mysql"))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+}
+
+func (s *responseCacheSuite) TestWriteInvalidatesCachedRead(c *gc.C) {
+	client := NewClient(WithResponseCache(s.newCache(c, nil)))
+
+	c.Check(s.get(c, client, "/v2/charms/info/mysql"), gc.Equals, "revision 1")
+	c.Check(s.get(c, client, "/v2/charms/info/mysql"), gc.Equals, "revision 1")
+	c.Check(s.readCount(), gc.Equals, 1)
+
+	s.post(c, client, "/v2/charms/info/mysql")
+	c.Check(s.get(c, client, "/v2/charms/info/mysql"), gc.Equals, "revision 2")
+	c.Check(s.readCount(), gc.Equals, 2)
+}
+
+func (s *responseCacheSuite) TestInvalidateCache(c *gc.C) {
+	client := NewClient(WithResponseCache(s.newCache(c, nil)))
+
+	c.Check(s.get(c, client, "/v2/charms/info/mysql"), gc.Equals, "revision 1")
+
+	// An upload to another URL leaves the cached metadata stale, until it
+	// is invalidated.
+	s.post(c, client, "/v2/charms")
+	c.Check(s.get(c, client, "/v2/charms/info/mysql"), gc.Equals, "revision 1")
+
+	err := client.InvalidateCache(RequestMatcher{Path: "/v2/charms/info/mysql/..."})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(s.get(c, client, "/v2/charms/info/mysql"), gc.Equals, "revision 2")
+	c.Check(s.readCount(), gc.Equals, 2)
+}
+
+func (s *responseCacheSuite) TestExpiry(c *gc.C) {
+	clk := testclock.NewClock(time.Now())
+	client := NewClient(WithResponseCache(s.newCache(c, clk)))
+
+	c.Check(s.get(c, client, "/v2/charms/info/mysql"), gc.Equals, "revision 1")
+	clk.Advance(59 * time.Second)
+	c.Check(s.get(c, client, "/v2/charms/info/mysql"), gc.Equals, "revision 1")
+	c.Check(s.readCount(), gc.Equals, 1)
+
+	clk.Advance(time.Second)
+	c.Check(s.get(c, client, "/v2/charms/info/mysql"), gc.Equals, "revision 1")
+	c.Check(s.readCount(), gc.Equals, 2)
+}
+
+func (s *responseCacheSuite) TestNotCached(c *gc.C) {
+	client := NewClient(WithResponseCache(s.newCache(c, nil)))
+
+	for i := 0; i < 2; i++ {
+		s.get(c, client, "/v2/charms/info/mysql", "Authorization", "Bearer token")
+		s.get(c, client, "/v2/charms/info/mysql", "Cache-Control", "no-cache")
+		s.get(c, client, "/v2/charms/info/mysql?cache-control=no-store")
+		s.get(c, client, "/v2/charms/info/mysql", "Cookie", "session=alice")
+		s.get(c, client, "/v2/charms/info/mysql?cookie=bob")
+		s.get(c, client, "/v2/charms/info/mysql?vary=*")
+		c.Check(s.get(c, client, "/large"), gc.Equals, strings.Repeat("x", 100))
+	}
+	c.Check(s.readCount(), gc.Equals, 14)
+}
+
+func (s *responseCacheSuite) TestVary(c *gc.C) {
+	client := NewClient(WithResponseCache(s.newCache(c, nil)))
+
+	// The response is only served to requests with the same value of the
+	// header it varies by, and of the Accept and Accept-Language headers.
+	path := "/v2/charms/info/mysql?vary=X-Juju-Model"
+	c.Check(s.get(c, client, path, "X-Juju-Model", "foo"), gc.Equals, "foo revision 1")
+	c.Check(s.get(c, client, path, "X-Juju-Model", "foo"), gc.Equals, "foo revision 1")
+	c.Check(s.get(c, client, path, "X-Juju-Model", "bar"), gc.Equals, "bar revision 1")
+	c.Check(s.get(c, client, path, "X-Juju-Model", "bar", "Accept-Language", "fr"), gc.Equals, "bar revision 1")
+	c.Check(s.readCount(), gc.Equals, 3)
+}
+
+func (s *responseCacheSuite) TestMaxEntries(c *gc.C) {
+	client := NewClient(WithResponseCache(s.newCache(c, nil)))
+
+	for i := 0; i < 2; i++ {
+		s.get(c, client, "/v2/charms/info/mysql")
+		s.get(c, client, "/v2/charms/info/postgresql")
+		s.get(c, client, "/v2/charms/info/redis")
+	}
+	c.Check(s.readCount(), gc.Equals, 4)
+}
+
+func (s *responseCacheSuite) TestValidate(c *gc.C) {
+	config := ResponseCacheConfig{Store: NewMemoryStore(clock.WallClock), TTL: time.Minute, MaxEntries: 1, MaxBodySize: 1}
+	c.Check(config.Validate(), jc.ErrorIsNil)
+
+	config.Store = nil
+	c.Check(config.Validate(), gc.ErrorMatches, `nil store not valid`)
+
+	config.Store = NewMemoryStore(clock.WallClock)
+
+	config.TTL = 0
+	_, err := NewResponseCache(config)
+	c.Check(err, gc.ErrorMatches, `ttl 0s not valid`)
+
+	config.TTL = time.Minute
+	config.MaxEntries = 0
+	c.Check(config.Validate(), gc.ErrorMatches, `max entries 0 not valid`)
+
+	config.MaxEntries = 1
+	config.MaxBodySize = -1
+	c.Check(config.Validate(), gc.ErrorMatches, `max body size -1 not valid`)
+}
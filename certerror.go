@@ -0,0 +1,97 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// CertificateError wraps the error of a server certificate that failed
+// verification, adding details of the certificate presented by the server,
+// see WithCertificateErrorDetails. It is obtained from the returned error
+// with errors.As.
+type CertificateError struct {
+	// Subject is the subject of the certificate.
+	Subject string
+	// DNSNames are the DNS subject alternative names of the certificate.
+	DNSNames []string
+	// IPAddresses are the IP address subject alternative names of the
+	// certificate.
+	IPAddresses []string
+	// Issuer is the issuer of the certificate.
+	Issuer string
+	// NotBefore is the start of the validity window of the certificate.
+	NotBefore time.Time
+	// NotAfter is the end of the validity window of the certificate.
+	NotAfter time.Time
+	// Err is the underlying error.
+	Err error
+}
+
+// newCertificateError returns a CertificateError for the certificate.
+func newCertificateError(cert *x509.Certificate, err error) *CertificateError {
+	certErr := &CertificateError{
+		Subject:   cert.Subject.String(),
+		DNSNames:  cert.DNSNames,
+		Issuer:    cert.Issuer.String(),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		Err:       err,
+	}
+	for _, ip := range cert.IPAddresses {
+		certErr.IPAddresses = append(certErr.IPAddresses, ip.String())
+	}
+	return certErr
+}
+
+// Error implements error.
+func (e *CertificateError) Error() string {
+	sans := append(append([]string(nil), e.DNSNames...), e.IPAddresses...)
+	return fmt.Sprintf("%s (certificate subject %q, SANs [%s], issuer %q, valid from %s until %s)",
+		e.Err, e.Subject, strings.Join(sans, ", "), e.Issuer,
+		e.NotBefore.UTC().Format(time.RFC3339), e.NotAfter.UTC().Format(time.RFC3339))
+}
+
+// Unwrap returns the underlying error.
+func (e *CertificateError) Unwrap() error {
+	return e.Err
+}
+
+// WithCertificateErrorDetails adds the details of the certificate presented
+// by a server that failed verification to the returned error, as a
+// *CertificateError, so that an error such as "x509: certificate signed by
+// unknown authority" can be acted on from the logs. The certificate is
+// taken from the failed handshake, so verification doesn't need to be
+// skipped to inspect it.
+func WithCertificateErrorDetails(value bool) Option {
+	return func(opt *options) {
+		opt.certificateErrorDetails = value
+	}
+}
+
+// certificateErrorTransport adds the details of the server certificate to
+// verification errors.
+type certificateErrorTransport struct {
+	wrappedRoundTripper http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t certificateErrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.wrappedRoundTripper.RoundTrip(req)
+	if err == nil {
+		return resp, nil
+	}
+	verifyErr, ok := errors.AsType[*tls.CertificateVerificationError](err)
+	if !ok || len(verifyErr.UnverifiedCertificates) == 0 {
+		return resp, err
+	}
+	return resp, newCertificateError(verifyErr.UnverifiedCertificates[0], err)
+}
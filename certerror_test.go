@@ -0,0 +1,76 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type certificateErrorSuite struct {
+	testing.IsolationSuite
+
+	server *httptest.Server
+}
+
+var _ = gc.Suite(&certificateErrorSuite{})
+
+func (s *certificateErrorSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+}
+
+func (s *certificateErrorSuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+	s.IsolationSuite.TearDownTest(c)
+}
+
+func (s *certificateErrorSuite) TestCertificateErrorDetails(c *gc.C) {
+	client := NewClient(WithCertificateErrorDetails(true))
+
+	_, err := client.Get(context.Background(), s.server.URL)
+	c.Assert(err, gc.NotNil)
+	certErr, ok := errors.AsType[*CertificateError](err)
+	c.Assert(ok, jc.IsTrue)
+	cert := s.server.Certificate()
+	c.Check(certErr.Subject, gc.Equals, cert.Subject.String())
+	c.Check(certErr.Issuer, gc.Equals, cert.Issuer.String())
+	c.Check(certErr.DNSNames, jc.DeepEquals, cert.DNSNames)
+	c.Check(certErr.IPAddresses, jc.DeepEquals, []string{"127.0.0.1", "::1"})
+	c.Check(certErr.NotBefore.Equal(cert.NotBefore), jc.IsTrue)
+	c.Check(certErr.NotAfter.Equal(cert.NotAfter), jc.IsTrue)
+	c.Check(err, gc.ErrorMatches, `.*x509: certificate signed by unknown authority `+
+		`\(certificate subject "O=Acme Co", SANs \[example.com, \*.example.com, 127.0.0.1, ::1\], issuer "O=Acme Co", valid from .* until .*\)`)
+
+	// The underlying error is preserved.
+	_, ok = errors.AsType[x509.UnknownAuthorityError](err)
+	c.Check(ok, jc.IsTrue)
+	c.Check(ClassifyError(err), gc.Equals, FaultTLS)
+}
+
+func (s *certificateErrorSuite) TestCertificateErrorDetailsFromSource(c *gc.C) {
+	client := NewClient(
+		WithCertificateErrorDetails(true),
+		WithCACertificateSource(func() []string { return nil }),
+	)
+
+	_, err := client.Get(context.Background(), s.server.URL)
+	certErr, ok := errors.AsType[*CertificateError](err)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(certErr.Subject, gc.Equals, "O=Acme Co")
+}
+
+func (s *certificateErrorSuite) TestCertificateErrorDetailsDisabled(c *gc.C) {
+	_, err := NewClient().Get(context.Background(), s.server.URL)
+	c.Assert(err, gc.NotNil)
+	_, ok := errors.AsType[*CertificateError](err)
+	c.Check(ok, jc.IsFalse)
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// CertificateSource returns the current PEM encoded CA certificates, used
+// to verify the certificates of servers, see WithCACertificateSource.
+type CertificateSource func() []string
+
+// WithCACertificateSource verifies the certificates of servers against the
+// CA certificates returned by the source, which is consulted on every TLS
+// handshake, so that a rotated CA takes effect on a live client without
+// constructing a new one. Connections kept alive keep the certificates
+// they were verified with. The source is called concurrently, so must be
+// safe to do so, and should be cheap, such as returning certificates held
+// in memory by a watcher. The system CA certificates are trusted too,
+// unless disabled with WithSystemCAs. WithSkipHostnameVerification skips
+// the check of the host name only, and the chain is still verified, while
+// requests made with ContextWithSkipVerify aren't verified at all. It can't
+// be combined with WithCACertificates or WithTLSConfig.
+func WithCACertificateSource(value CertificateSource) Option {
+	return func(opt *options) {
+		opt.caCertificateSource = value
+	}
+}
+
+// certificateSourcePool caches the pool of the certificates returned by a
+// source, so that it is only rebuilt when they change.
+type certificateSourcePool struct {
+	source CertificateSource
+	// base is the pool the certificates of the source are added to.
+	base *x509.CertPool
+
+	mu    sync.Mutex
+	certs string
+	pool  *x509.CertPool
+}
+
+// load returns the pool of the current certificates of the source.
+func (p *certificateSourcePool) load() *x509.CertPool {
+	certs := strings.Join(p.source(), "\n")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pool == nil || certs != p.certs {
+		pool := p.base.Clone()
+		pool.AppendCertsFromPEM([]byte(certs))
+		p.certs = certs
+		p.pool = pool
+	}
+	return p.pool
+}
+
+func transportWithCertificateSource(defaultTransport *http.Transport, base *x509.CertPool, source CertificateSource, skipHostnameVerify bool) *http.Transport {
+	if source == nil {
+		return defaultTransport
+	}
+
+	transport := defaultTransport
+	pool := &certificateSourcePool{source: source, base: base}
+	config := ownTLSConfig(transport)
+	// The chain is verified against the current certificates of the source
+	// by VerifyConnection, in place of the static RootCAs.
+	config.InsecureSkipVerify = true
+	verify := config.VerifyConnection
+	// VerifyConnection is used rather than VerifyPeerCertificate, as it is
+	// also called for resumed sessions.
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.NotValidf("server %q without certificate", state.ServerName)
+		}
+		opts := x509.VerifyOptions{
+			Roots:         pool.load(),
+			Intermediates: x509.NewCertPool(),
+		}
+		if !skipHostnameVerify {
+			opts.DNSName = state.ServerName
+		}
+		for _, cert := range state.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		if _, err := state.PeerCertificates[0].Verify(opts); err != nil {
+			return &tls.CertificateVerificationError{
+				UnverifiedCertificates: state.PeerCertificates,
+				Err:                    err,
+			}
+		}
+		if verify != nil {
+			return verify(state)
+		}
+		return nil
+	}
+
+	// We're creating a new tls.Config, HTTP/2 requests will not work, force the
+	// client to create a HTTP/2 requests.
+	transport.ForceAttemptHTTP2 = true
+	return transport
+}
@@ -0,0 +1,160 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type certificateSourceSuite struct {
+	testing.IsolationSuite
+
+	server *httptest.Server
+	caPEM  string
+
+	mu    sync.Mutex
+	certs []string
+}
+
+var _ = gc.Suite(&certificateSourceSuite{})
+
+func (s *certificateSourceSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	s.caPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.server.Certificate().Raw}))
+	s.certs = nil
+}
+
+func (s *certificateSourceSuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+	s.IsolationSuite.TearDownTest(c)
+}
+
+func (s *certificateSourceSuite) source() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.certs
+}
+
+func (s *certificateSourceSuite) setCertificates(certs ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.certs = certs
+}
+
+func (s *certificateSourceSuite) get(client *Client, rawURL string) error {
+	resp, err := client.Get(context.Background(), rawURL)
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+	return err
+}
+
+func (s *certificateSourceSuite) TestCertificatesReloaded(c *gc.C) {
+	client := NewClient(
+		WithCACertificateSource(s.source),
+		WithDisableKeepAlives(true),
+	)
+
+	err := s.get(client, s.server.URL)
+	c.Assert(err, gc.NotNil)
+	_, ok := errors.AsType[x509.UnknownAuthorityError](err)
+	c.Check(ok, jc.IsTrue)
+	c.Check(ClassifyError(err), gc.Equals, FaultTLS)
+
+	// The new certificates are used by the next handshake of the same
+	// client.
+	s.setCertificates(s.caPEM)
+	c.Assert(s.get(client, s.server.URL), jc.ErrorIsNil)
+
+	// As are rotated certificates.
+	otherPEM, _ := clientCertificate(c)
+	s.setCertificates(otherPEM)
+	err = s.get(client, s.server.URL)
+	c.Check(ClassifyError(err), gc.Equals, FaultTLS)
+
+	s.setCertificates(otherPEM, s.caPEM)
+	c.Assert(s.get(client, s.server.URL), jc.ErrorIsNil)
+}
+
+func (s *certificateSourceSuite) TestHostnameVerification(c *gc.C) {
+	s.setCertificates(s.caPEM)
+	serverURL, err := url.Parse(s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	// The certificate of the test server isn't valid for localhost.
+	localhostURL := "https://localhost:" + serverURL.Port()
+
+	err = s.get(NewClient(WithCACertificateSource(s.source)), localhostURL)
+	c.Assert(err, gc.NotNil)
+	_, ok := errors.AsType[x509.HostnameError](err)
+	c.Check(ok, jc.IsTrue)
+
+	// Skipping host name verification still verifies the chain.
+	client := NewClient(
+		WithCACertificateSource(s.source),
+		WithSkipHostnameVerification(true),
+		WithDisableKeepAlives(true),
+	)
+	c.Assert(s.get(client, localhostURL), jc.ErrorIsNil)
+
+	s.setCertificates()
+	err = s.get(client, localhostURL)
+	_, ok = errors.AsType[x509.UnknownAuthorityError](err)
+	c.Check(ok, jc.IsTrue)
+}
+
+func (s *certificateSourceSuite) TestSkipVerifyPerRequest(c *gc.C) {
+	client := NewClient(
+		WithCACertificateSource(s.source),
+		WithPerRequestSkipVerify(true),
+	)
+
+	err := s.get(client, s.server.URL)
+	c.Assert(err, gc.ErrorMatches, `.*certificate signed by unknown authority`)
+
+	resp, err := client.Get(ContextWithSkipVerify(context.Background()), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+}
+
+func (s *certificateSourceSuite) TestInvalidCombinations(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	tests := []struct {
+		option Option
+		err    string
+	}{{
+		option: WithCACertificates(s.caPEM),
+		err:    `.*CA certificate source with CA certificates or TLS config not valid`,
+	}, {
+		option: WithTLSConfig(&tls.Config{}),
+		err:    `.*CA certificate source with CA certificates or TLS config not valid`,
+	}, {
+		option: WithBaseRoundTripper(NewMockRoundTripper(ctrl)),
+		err:    `.*TLS options with a base round tripper that is not an \*http.Transport not valid`,
+	}}
+	for i, test := range tests {
+		c.Logf("test %d", i)
+		client := NewClient(
+			WithLogger(logger(ctrl)),
+			WithCACertificateSource(s.source),
+			test.option,
+		)
+		c.Check(s.get(client, s.server.URL), gc.ErrorMatches, test.err)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net/http"
+	"sync"
+)
+
+// ChainMiddlewares combines the middlewares into one, applying them in
+// order.
+func ChainMiddlewares(middlewares ...TransportMiddleware) TransportMiddleware {
+	return func(transport *http.Transport) *http.Transport {
+		for _, middleware := range middlewares {
+			transport = middleware(transport)
+		}
+		return transport
+	}
+}
+
+// When returns a middleware that applies the given middleware only if cond
+// returns true. The condition is evaluated when the transport is built, not
+// per request.
+func When(cond func() bool, middleware TransportMiddleware) TransportMiddleware {
+	return func(transport *http.Transport) *http.Transport {
+		if !cond() {
+			return transport
+		}
+		return middleware(transport)
+	}
+}
+
+// Named gives the middleware a name, which is reported by
+// DescribeMiddlewares when the middleware is applied.
+func Named(name string, middleware TransportMiddleware) TransportMiddleware {
+	return func(transport *http.Transport) *http.Transport {
+		result := middleware(transport)
+		describing.record(transport, result, name)
+		return result
+	}
+}
+
+// DescribeMiddlewares returns the names of the middlewares that are applied,
+// in order, when the middlewares are applied to a transport. Only
+// middlewares wrapped with Named are reported, and middlewares excluded by
+// When are not reported. The middlewares are applied to a throwaway
+// transport to find out, so must not have side effects beyond modifying the
+// transport.
+func DescribeMiddlewares(middlewares ...TransportMiddleware) []string {
+	probe := &http.Transport{}
+	names := describing.start(probe)
+	defer describing.stop(probe)
+
+	ChainMiddlewares(middlewares...)(probe)
+	return names()
+}
+
+// describing tracks the transports being described by DescribeMiddlewares.
+var describing = &middlewareDescriptions{
+	names: make(map[*http.Transport]*[]string),
+}
+
+type middlewareDescriptions struct {
+	mu    sync.Mutex
+	names map[*http.Transport]*[]string
+}
+
+func (d *middlewareDescriptions) start(transport *http.Transport) func() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	names := &[]string{}
+	d.names[transport] = names
+	return func() []string {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return *names
+	}
+}
+
+func (d *middlewareDescriptions) stop(transport *http.Transport) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	names := d.names[transport]
+	for t, n := range d.names {
+		if n == names {
+			delete(d.names, t)
+		}
+	}
+}
+
+// record records a named middleware being applied to a transport that is
+// being described, following the description to the resulting transport if
+// the middleware replaced it.
+func (d *middlewareDescriptions) record(transport, result *http.Transport, name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	names, ok := d.names[transport]
+	if !ok {
+		return
+	}
+	*names = append(*names, name)
+	if result != nil && result != transport {
+		d.names[result] = names
+	}
+}
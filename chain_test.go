@@ -0,0 +1,69 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type chainSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&chainSuite{})
+
+func setMaxIdleConns(n int) TransportMiddleware {
+	return func(transport *http.Transport) *http.Transport {
+		transport.MaxIdleConns = n
+		return transport
+	}
+}
+
+func (s *chainSuite) TestChainMiddlewares(c *gc.C) {
+	transport := ChainMiddlewares(
+		setMaxIdleConns(1),
+		ForceAttemptHTTP2Middleware,
+		setMaxIdleConns(2),
+	)(&http.Transport{})
+	c.Assert(transport.MaxIdleConns, gc.Equals, 2)
+	c.Assert(transport.ForceAttemptHTTP2, jc.IsTrue)
+}
+
+func (s *chainSuite) TestWhen(c *gc.C) {
+	enabled := false
+	middleware := When(func() bool { return enabled }, setMaxIdleConns(1))
+
+	c.Assert(middleware(&http.Transport{}).MaxIdleConns, gc.Equals, 0)
+	enabled = true
+	c.Assert(middleware(&http.Transport{}).MaxIdleConns, gc.Equals, 1)
+}
+
+func (s *chainSuite) TestDescribeMiddlewares(c *gc.C) {
+	replace := func(transport *http.Transport) *http.Transport {
+		return transport.Clone()
+	}
+	names := DescribeMiddlewares(
+		Named("proxy", ProxyMiddleware),
+		setMaxIdleConns(1),
+		When(func() bool { return false }, Named("skipped", ForceAttemptHTTP2Middleware)),
+		Named("clone", replace),
+		Named("group", ChainMiddlewares(
+			Named("idle", setMaxIdleConns(2)),
+			Named("http2", ForceAttemptHTTP2Middleware),
+		)),
+	)
+	c.Assert(names, jc.DeepEquals, []string{"proxy", "clone", "idle", "http2", "group"})
+	c.Assert(describing.names, gc.HasLen, 0)
+}
+
+func (s *chainSuite) TestNamedNotDescribed(c *gc.C) {
+	transport := Named("idle", setMaxIdleConns(1))(&http.Transport{IdleConnTimeout: time.Second})
+	c.Assert(transport.MaxIdleConns, gc.Equals, 1)
+	c.Assert(describing.names, gc.HasLen, 0)
+}
@@ -0,0 +1,200 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+)
+
+// ErrCircuitOpen is matched, using errors.Is, by the error returned for a
+// request rejected by an open circuit breaker, see CircuitBreakerMiddleware.
+const ErrCircuitOpen = errors.ConstError("circuit breaker open")
+
+// CircuitOpenError is returned for a request to a host whose circuit
+// breaker is open.
+type CircuitOpenError struct {
+	// Host is the host the request was made to.
+	Host string
+	// Until is the time at which the breaker lets a probe request through.
+	Until time.Time
+}
+
+// Error implements error.
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s for %q until %s", ErrCircuitOpen, e.Host, e.Until.Format(time.RFC3339))
+}
+
+// Is returns true for ErrCircuitOpen.
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CircuitBreakerConfig configures a circuit breaker.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed requests to a
+	// host that opens its breaker.
+	FailureThreshold int
+
+	// OpenTimeout is how long a breaker stays open before it lets a
+	// single probe request through. The breaker closes if the probe
+	// succeeds, and opens again if it fails.
+	OpenTimeout time.Duration
+
+	// IsFailure reports whether the outcome of a request counts as a
+	// failure of the host. If nil, errors other than the cancellation of
+	// the request, and 5xx responses, are failures.
+	IsFailure func(resp *http.Response, err error) bool
+
+	// Clock is used to time the open state. If nil, the wall clock is used.
+	Clock clock.Clock
+}
+
+// Validate validates the CircuitBreakerConfig for any issues.
+func (c CircuitBreakerConfig) Validate() error {
+	if c.FailureThreshold <= 0 {
+		return errors.NotValidf("failure threshold %d", c.FailureThreshold)
+	}
+	if c.OpenTimeout <= 0 {
+		return errors.NotValidf("open timeout %s", c.OpenTimeout)
+	}
+	return nil
+}
+
+// CircuitBreakerMiddleware returns a RoundTripperMiddleware that tracks the
+// consecutive failures of requests to each host, and once they reach the
+// threshold, rejects requests to the host with a *CircuitOpenError without
+// sending them, until the open timeout passes. A host that keeps timing
+// out then fails callers fast, rather than tying each of them up for the
+// full timeout.
+//
+// The middleware is used with WithRoundTripperMiddlewares, so it sees every
+// attempt of a retried request. If the config isn't valid, every request
+// fails with the validation error.
+func CircuitBreakerMiddleware(config CircuitBreakerConfig) RoundTripperMiddleware {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		if err := config.Validate(); err != nil {
+			return invalidConfigTransport{err: errors.Annotate(err, "circuit breaker")}
+		}
+		if config.IsFailure == nil {
+			config.IsFailure = defaultIsFailure
+		}
+		if config.Clock == nil {
+			config.Clock = clock.WallClock
+		}
+		return &circuitBreakerTransport{
+			wrappedRoundTripper: rt,
+			config:              config,
+			breakers:            make(map[string]*circuitBreaker),
+		}
+	}
+}
+
+func defaultIsFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return resp.StatusCode >= 500
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker is the breaker state of a host.
+type circuitBreaker struct {
+	state    circuitState
+	failures int
+	until    time.Time
+}
+
+// circuitBreakerTransport rejects requests to hosts with an open breaker.
+type circuitBreakerTransport struct {
+	wrappedRoundTripper http.RoundTripper
+	config              CircuitBreakerConfig
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *circuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Host)
+	if err := t.allow(host); err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	resp, err := t.wrappedRoundTripper.RoundTrip(req)
+	if t.record(host, t.config.IsFailure(resp, err)) {
+		emitEvent(req.Context(), Event{
+			Kind: EventCircuitOpen,
+			Host: host,
+			Err:  err,
+		})
+	}
+	return resp, err
+}
+
+// allow returns an error if the breaker of the host rejects a request,
+// moving an open breaker whose timeout has passed to half-open, and letting
+// the request through as its probe.
+func (t *circuitBreakerTransport) allow(host string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b, ok := t.breakers[host]
+	if !ok {
+		return nil
+	}
+	switch b.state {
+	case circuitOpen:
+		if t.config.Clock.Now().Before(b.until) {
+			return &CircuitOpenError{Host: host, Until: b.until}
+		}
+		b.state = circuitHalfOpen
+	case circuitHalfOpen:
+		// A probe is already in flight.
+		return &CircuitOpenError{Host: host, Until: b.until}
+	}
+	return nil
+}
+
+// record records the outcome of a request to the host, returning true if
+// it opened the breaker.
+func (t *circuitBreakerTransport) record(host string, failed bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b, ok := t.breakers[host]
+	if !failed {
+		// Forget hosts that succeed, so the map only holds failing hosts.
+		if ok {
+			delete(t.breakers, host)
+		}
+		return false
+	}
+	if !ok {
+		b = &circuitBreaker{}
+		t.breakers[host] = b
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= t.config.FailureThreshold {
+		opened := b.state != circuitOpen
+		b.state = circuitOpen
+		b.until = t.config.Clock.Now().Add(t.config.OpenTimeout)
+		return opened
+	}
+	return false
+}
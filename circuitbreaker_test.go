@@ -0,0 +1,205 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/juju/clock/testclock"
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type circuitBreakerSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&circuitBreakerSuite{})
+
+func (s *circuitBreakerSuite) request(c *gc.C, rawURL string) *http.Request {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	return req
+}
+
+func (s *circuitBreakerSuite) TestOpensAfterThreshold(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	clk := testclock.NewClock(time.Now())
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(gomock.Any()).Return(nil, errors.New("i/o timeout")).Times(3)
+
+	rt := CircuitBreakerMiddleware(CircuitBreakerConfig{
+		FailureThreshold: 3,
+		OpenTimeout:      time.Minute,
+		Clock:            clk,
+	})(transport)
+
+	for i := 0; i < 3; i++ {
+		_, err := rt.RoundTrip(s.request(c, "https://example.com"))
+		c.Assert(err, gc.ErrorMatches, "i/o timeout")
+	}
+	_, err := rt.RoundTrip(s.request(c, "https://example.com"))
+	c.Assert(err, jc.ErrorIs, ErrCircuitOpen)
+	openErr, ok := errors.AsType[*CircuitOpenError](err)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(openErr.Host, gc.Equals, "example.com")
+	c.Check(openErr.Until, gc.Equals, clk.Now().Add(time.Minute))
+}
+
+func (s *circuitBreakerSuite) TestPerHost(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host == "bad.example.com" {
+			return &http.Response{StatusCode: http.StatusBadGateway}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}).Times(2)
+
+	rt := CircuitBreakerMiddleware(CircuitBreakerConfig{
+		FailureThreshold: 1,
+		OpenTimeout:      time.Minute,
+	})(transport)
+
+	resp, err := rt.RoundTrip(s.request(c, "https://bad.example.com"))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(resp.StatusCode, gc.Equals, http.StatusBadGateway)
+	_, err = rt.RoundTrip(s.request(c, "https://bad.example.com"))
+	c.Assert(err, jc.ErrorIs, ErrCircuitOpen)
+
+	resp, err = rt.RoundTrip(s.request(c, "https://good.example.com"))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(resp.StatusCode, gc.Equals, http.StatusOK)
+}
+
+func (s *circuitBreakerSuite) TestSuccessResetsFailures(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	transport := NewMockRoundTripper(ctrl)
+	gomock.InOrder(
+		transport.EXPECT().RoundTrip(gomock.Any()).Return(nil, errors.New("boom")),
+		transport.EXPECT().RoundTrip(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK}, nil),
+		transport.EXPECT().RoundTrip(gomock.Any()).Return(nil, errors.New("boom")),
+		transport.EXPECT().RoundTrip(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK}, nil),
+	)
+
+	rt := CircuitBreakerMiddleware(CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Minute,
+	})(transport)
+
+	for i := 0; i < 4; i++ {
+		_, err := rt.RoundTrip(s.request(c, "https://example.com"))
+		c.Assert(errors.Is(err, ErrCircuitOpen), jc.IsFalse)
+	}
+}
+
+func (s *circuitBreakerSuite) TestHalfOpen(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	clk := testclock.NewClock(time.Now())
+	transport := NewMockRoundTripper(ctrl)
+	gomock.InOrder(
+		transport.EXPECT().RoundTrip(gomock.Any()).Return(nil, errors.New("boom")),
+		// The first probe fails, opening the breaker again.
+		transport.EXPECT().RoundTrip(gomock.Any()).Return(nil, errors.New("boom")),
+		// The second probe succeeds, closing the breaker.
+		transport.EXPECT().RoundTrip(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK}, nil).Times(2),
+	)
+
+	rt := CircuitBreakerMiddleware(CircuitBreakerConfig{
+		FailureThreshold: 1,
+		OpenTimeout:      time.Minute,
+		Clock:            clk,
+	})(transport)
+
+	_, err := rt.RoundTrip(s.request(c, "https://example.com"))
+	c.Assert(err, gc.ErrorMatches, "boom")
+	_, err = rt.RoundTrip(s.request(c, "https://example.com"))
+	c.Assert(err, jc.ErrorIs, ErrCircuitOpen)
+
+	clk.Advance(time.Minute)
+	_, err = rt.RoundTrip(s.request(c, "https://example.com"))
+	c.Assert(err, gc.ErrorMatches, "boom")
+	_, err = rt.RoundTrip(s.request(c, "https://example.com"))
+	c.Assert(err, jc.ErrorIs, ErrCircuitOpen)
+
+	clk.Advance(time.Minute)
+	for i := 0; i < 2; i++ {
+		_, err = rt.RoundTrip(s.request(c, "https://example.com"))
+		c.Assert(err, jc.ErrorIsNil)
+	}
+}
+
+func (s *circuitBreakerSuite) TestHalfOpenSingleProbe(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	clk := testclock.NewClock(time.Now())
+	transport := NewMockRoundTripper(ctrl)
+	rt := CircuitBreakerMiddleware(CircuitBreakerConfig{
+		FailureThreshold: 1,
+		OpenTimeout:      time.Minute,
+		Clock:            clk,
+	})(transport)
+
+	gomock.InOrder(
+		transport.EXPECT().RoundTrip(gomock.Any()).Return(nil, errors.New("boom")),
+		transport.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+			// Requests made while the probe is in flight are rejected.
+			_, err := rt.RoundTrip(s.request(c, "https://example.com"))
+			c.Check(err, jc.ErrorIs, ErrCircuitOpen)
+			return &http.Response{StatusCode: http.StatusOK}, nil
+		}),
+	)
+
+	_, err := rt.RoundTrip(s.request(c, "https://example.com"))
+	c.Assert(err, gc.ErrorMatches, "boom")
+	clk.Advance(time.Minute)
+	_, err = rt.RoundTrip(s.request(c, "https://example.com"))
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *circuitBreakerSuite) TestCanceledIsNotFailure(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(gomock.Any()).Return(nil, context.Canceled).Times(2)
+
+	rt := CircuitBreakerMiddleware(CircuitBreakerConfig{
+		FailureThreshold: 1,
+		OpenTimeout:      time.Minute,
+	})(transport)
+
+	for i := 0; i < 2; i++ {
+		_, err := rt.RoundTrip(s.request(c, "https://example.com"))
+		c.Assert(err, jc.ErrorIs, context.Canceled)
+	}
+}
+
+func (s *circuitBreakerSuite) TestInvalidConfig(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	client := NewClient(
+		WithBaseRoundTripper(NewMockRoundTripper(ctrl)),
+		WithRoundTripperMiddlewares(CircuitBreakerMiddleware(CircuitBreakerConfig{
+			OpenTimeout: time.Minute,
+		})),
+	)
+	_, err := client.Get(context.Background(), "https://example.com")
+	c.Assert(err, gc.ErrorMatches, `.*circuit breaker: failure threshold 0 not valid`)
+}
@@ -7,9 +7,12 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptrace"
-	"net/http/httputil"
+	"net/url"
+	"slices"
 	"time"
 
 	"github.com/juju/clock"
@@ -30,7 +33,7 @@ func init() {
 	defaultTransport := http.DefaultTransport.(*http.Transport)
 	// Call the DialContextMiddleware for the DefaultTransport to
 	// facilitate testing use of allowOutgoingAccess.
-	defaultTransport = DialContextMiddleware(NewLocalDialBreaker(true))(defaultTransport)
+	defaultTransport = DialContextMiddleware(DefaultDialBreaker)(defaultTransport)
 	// Call our own proxy function with the DefaultTransport.
 	http.DefaultTransport = ProxyMiddleware(defaultTransport)
 }
@@ -53,6 +56,8 @@ type Option func(*options)
 
 type options struct {
 	caCertificates           []string
+	caCertificateSource      CertificateSource
+	systemCAs                bool
 	cookieJar                http.CookieJar
 	disableKeepAlives        bool
 	skipHostnameVerification bool
@@ -62,17 +67,87 @@ type options struct {
 	logger                   Logger
 	requestRecorder          RequestRecorder
 	retryPolicy              *RetryPolicy
+	dialBreaker              DialBreaker
+	routes                   []Route
+	clock                    clock.Clock
+	runtimeTrace             bool
+	hookTimeout              time.Duration
+	dumpConfig               DumpConfig
+	bodyLeakDetection        bool
+	bodyLeakStacks           bool
+	tlsMinVersion            uint16
+	tlsMaxVersion            uint16
+	cipherSuites             []uint16
+	perRequestSkipVerify     bool
+	baseRoundTripper         http.RoundTripper
+	roundTripperMiddlewares  []RoundTripperMiddleware
+	samplingPolicy           *SamplingPolicy
+	acceptLanguage           []string
+	allowedSchemes           []string
+	concurrencyLimit         int
+	clockSkewThreshold       time.Duration
+	onClockSkew              ClockSkewFunc
+	requestCompression       *RequestCompression
+	compression              *CompressionConfig
+	maintenancePolicy        *MaintenancePolicy
+	dnsRebindingProtection   bool
+	redirectPolicy           *RedirectPolicy
+	connectionAffinity       AffinityFunc
+	perAddressDialTimeout    time.Duration
+	curlCommandLogging       bool
+	proxyFunc                func(*http.Request) (*url.URL, error)
+	proxyLogging             bool
+	clientCertificates       []tls.Certificate
+	clientCertificateErr     error
+	responseHeaderLimits     *ResponseHeaderLimits
+	tlsConfig                *tls.Config
+	certificatePins          certificatePins
+	eventSubscribers         []EventFunc
+	slowRequestThreshold     time.Duration
+	certificateErrorDetails  bool
+	informationalHook        InformationalResponseFunc
+	profileErr               error
+	retryStrategyErr         error
+	activeProfiles           []string
+	timeout                  time.Duration
+	dialOptions              DialOptions
+	connectionPool           ConnectionPoolConfig
+	resolver                 *net.Resolver
+	hostMapping              map[string]string
+	dnsCacheTTL              time.Duration
+	addressFamily            AddressFamily
+	rawHeaders               bool
+	egressPolicy             AddressPolicy
+	apiVersionPolicy         *APIVersionPolicy
+	deadlineCheck            DeadlineCheck
+	failedTargets            *FailedTargets
+	stallPolicy              *StallPolicy
+	maxMessageSize           int64
+	responseCache            *ResponseCache
 }
 
 // WithCACertificates contains Authority certificates to be used to validate
 // certificates of cloud infrastructure components.
-// The contents are Base64 encoded x.509 certs.
+// The contents are Base64 encoded x.509 certs. The system CA certificates
+// are trusted too, unless disabled with WithSystemCAs.
 func WithCACertificates(value ...string) Option {
 	return func(opt *options) {
 		opt.caCertificates = value
 	}
 }
 
+// WithSystemCAs trusts the system CA certificates as well as those given
+// by WithCACertificates or WithCACertificateSource, so that requests to
+// public endpoints keep working through a client that also talks to a
+// controller with its own CA. It is on by default. Where the system
+// certificates are unavailable, the error is logged and only the given CA
+// certificates are trusted.
+func WithSystemCAs(value bool) Option {
+	return func(opt *options) {
+		opt.systemCAs = value
+	}
+}
+
 // WithCookieJar is used to insert relevant cookies into every
 // outbound Request and is updated with the cookie values
 // of every inbound Response. The Jar is consulted for every
@@ -122,7 +197,9 @@ func WithTransportMiddlewares(middlewares ...TransportMiddleware) Option {
 	}
 }
 
-// WithHTTPClient allows to define the http.Client to use.
+// WithHTTPClient allows to define the http.Client to use. The client is
+// copied before it is configured, so the same http.Client may be given to
+// several clients.
 func WithHTTPClient(value *http.Client) Option {
 	return func(opt *options) {
 		opt.httpClient = value
@@ -147,13 +224,112 @@ func WithRequestRecorder(value RequestRecorder) Option {
 	}
 }
 
-// WithRequestRetrier specifies a request retrying policy.
+// WithRequestRetrier specifies a request retrying policy. Requests answered
+// with a 429, 502, 503 or 504 status code are retried according to the
+// policy, using the clock and logger of the client, so consumers
+// don't need to implement retries on top of Do. The policy can be changed
+// after the client is created with SetRetryPolicy.
 func WithRequestRetrier(value RetryPolicy) Option {
 	return func(opt *options) {
 		opt.retryPolicy = &value
 	}
 }
 
+// WithDialBreaker specifies the DialBreaker consulted before dialing any
+// address. This replaces the DefaultDialBreaker for the client, allowing
+// outgoing access to be controlled without modifying package level state.
+//
+// The DialBreaker is only consulted by the default transport middlewares, it
+// has no effect if WithTransportMiddlewares is used. WithEgressPolicy
+// restricts connections by address, port and host name.
+func WithDialBreaker(value DialBreaker) Option {
+	return func(opt *options) {
+		opt.dialBreaker = value
+	}
+}
+
+// WithClock specifies the clock used by all time dependent parts of the
+// client, such as retry backoff, retry budgets and request timing. Tests can
+// supply a test clock to avoid real sleeps.
+func WithClock(value clock.Clock) Option {
+	return func(opt *options) {
+		opt.clock = value
+	}
+}
+
+// WithRoutes dispatches requests matching any of the given routes to the
+// route's transport instead of the client transport. Routes are evaluated in
+// order, requests that match no route use the client transport.
+func WithRoutes(routes ...Route) Option {
+	return func(opt *options) {
+		opt.routes = routes
+	}
+}
+
+// WithMinimumTLSVersion sets the minimum TLS version the client will
+// negotiate. Versions below TLS 1.2 are rejected.
+func WithMinimumTLSVersion(value uint16) Option {
+	return func(opt *options) {
+		opt.tlsMinVersion = value
+	}
+}
+
+// WithMaximumTLSVersion sets the maximum TLS version the client will
+// negotiate. Versions below TLS 1.2 are rejected.
+func WithMaximumTLSVersion(value uint16) Option {
+	return func(opt *options) {
+		opt.tlsMaxVersion = value
+	}
+}
+
+// WithCipherSuites restricts the TLS 1.2 cipher suites the client will
+// negotiate to the given allow-list. Cipher suites that the crypto/tls package
+// considers insecure are rejected. TLS 1.3 cipher suites are not
+// configurable.
+func WithCipherSuites(value ...uint16) Option {
+	return func(opt *options) {
+		opt.cipherSuites = value
+	}
+}
+
+// WithClientCertificates adds a client certificate, presented to servers
+// that request one, such as registries protected by mutual TLS. The
+// certificate and its private key are PEM encoded, and the certificate may
+// be followed by its intermediate certificates. The secure defaults of
+// SecureTLSConfig are kept.
+func WithClientCertificates(certPEM, keyPEM string) Option {
+	return func(opt *options) {
+		cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			opt.clientCertificateErr = errors.NewNotValid(err, "client certificate")
+			return
+		}
+		opt.clientCertificates = append(opt.clientCertificates, cert)
+	}
+}
+
+// WithClientTLSCertificates adds client certificates, presented to servers
+// that request one, as WithClientCertificates does for PEM encoded
+// certificates.
+func WithClientTLSCertificates(value ...tls.Certificate) Option {
+	return func(opt *options) {
+		opt.clientCertificates = append(opt.clientCertificates, value...)
+	}
+}
+
+// WithTLSConfig sets the TLS configuration of the transport, for callers
+// that need settings the other options don't cover, such as ServerName or
+// KeyLogWriter. A clone of the config is used. It can't be combined with
+// WithCACertificates or WithSkipHostnameVerification, which build their
+// own config, but the TLS version, cipher suite and client certificate
+// options are applied on top of it. A config allowing versions below TLS
+// 1.2, or insecure cipher suites, is rejected.
+func WithTLSConfig(value *tls.Config) Option {
+	return func(opt *options) {
+		opt.tlsConfig = value
+	}
+}
+
 // Create a options instance with default values.
 func newOptions() *options {
 	// In this case, use a default http.Client.
@@ -166,74 +342,637 @@ func newOptions() *options {
 	// https://bugs.launchpad.net/juju/+bug/1888888
 	defaultCopy := *http.DefaultClient
 
-	return &options{
+	opts := &options{
 		tlsHandshakeTimeout:      20 * time.Second,
 		skipHostnameVerification: false,
-		middlewares: []TransportMiddleware{
-			DialContextMiddleware(NewLocalDialBreaker(true)),
-			FileProtocolMiddleware,
-			ProxyMiddleware,
-		},
-		httpClient: &defaultCopy,
-		logger:     loggo.GetLogger("http"),
+		systemCAs:                true,
+		httpClient:               &defaultCopy,
+		logger:                   loggo.GetLogger("http"),
+		dialBreaker:              DefaultDialBreaker,
+		clock:                    clock.WallClock,
+		dumpConfig:               DefaultDumpConfig,
+		maxMessageSize:           DefaultMaxMessageSize,
+	}
+	opts.middlewares = []TransportMiddleware{
+		opts.dialBreakerMiddleware(),
+		FileProtocolMiddleware,
+		ProxyMiddleware,
+	}
+	return opts
+}
+
+// dialBreakerMiddleware returns the middleware dialing with the dial
+// breaker and dial options. They are resolved when the transport is built,
+// so that they can be replaced by WithDialBreaker and WithDialOptions.
+func (opts *options) dialBreakerMiddleware() TransportMiddleware {
+	return func(transport *http.Transport) *http.Transport {
+		return dialContextMiddleware(opts.dialBreaker, opts.dialOptions)(transport)
 	}
 }
 
+// validate checks the options for any configuration that would result in an
+// insecure or unusable client.
+func (opts *options) validate() error {
+	if opts.tlsMinVersion != 0 && opts.tlsMinVersion < tls.VersionTLS12 {
+		return errors.NotValidf("minimum TLS version %s", tls.VersionName(opts.tlsMinVersion))
+	}
+	if opts.tlsMaxVersion != 0 && opts.tlsMaxVersion < tls.VersionTLS12 {
+		return errors.NotValidf("maximum TLS version %s", tls.VersionName(opts.tlsMaxVersion))
+	}
+	if opts.tlsMinVersion != 0 && opts.tlsMaxVersion != 0 && opts.tlsMaxVersion < opts.tlsMinVersion {
+		return errors.NotValidf("maximum TLS version %s lower than minimum TLS version %s",
+			tls.VersionName(opts.tlsMaxVersion), tls.VersionName(opts.tlsMinVersion))
+	}
+	for _, route := range opts.routes {
+		if err := route.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if opts.retryPolicy != nil {
+		for _, matcher := range opts.retryPolicy.Exclude {
+			if err := matcher.Validate(); err != nil {
+				return errors.Annotate(err, "retry exclusion")
+			}
+		}
+	}
+	if opts.responseHeaderLimits != nil {
+		if err := opts.responseHeaderLimits.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if opts.tlsConfig != nil {
+		if len(opts.caCertificates) > 0 || opts.skipHostnameVerification {
+			return errors.NotValidf("TLS config with CA certificates or skip hostname verification")
+		}
+		if opts.tlsConfig.MinVersion != 0 && opts.tlsConfig.MinVersion < tls.VersionTLS12 {
+			return errors.NotValidf("TLS config minimum TLS version %s", tls.VersionName(opts.tlsConfig.MinVersion))
+		}
+		for _, id := range opts.tlsConfig.CipherSuites {
+			if !isSecureCipherSuite(id) {
+				return errors.NotValidf("TLS config cipher suite %s", tls.CipherSuiteName(id))
+			}
+		}
+	}
+	if opts.caCertificateSource != nil && (len(opts.caCertificates) > 0 || opts.tlsConfig != nil) {
+		return errors.NotValidf("CA certificate source with CA certificates or TLS config")
+	}
+	if opts.slowRequestThreshold < 0 {
+		return errors.NotValidf("negative slow request threshold")
+	}
+	if opts.maxMessageSize < 0 {
+		return errors.NotValidf("negative max message size")
+	}
+	if opts.certificatePins.err != nil {
+		return errors.Trace(opts.certificatePins.err)
+	}
+	if opts.profileErr != nil {
+		return errors.Trace(opts.profileErr)
+	}
+	if opts.retryStrategyErr != nil {
+		return errors.Trace(opts.retryStrategyErr)
+	}
+	if opts.clientCertificateErr != nil {
+		return errors.Trace(opts.clientCertificateErr)
+	}
+	for _, id := range opts.cipherSuites {
+		if !isSecureCipherSuite(id) {
+			return errors.NotValidf("cipher suite %s", tls.CipherSuiteName(id))
+		}
+	}
+	if opts.redirectPolicy != nil {
+		if err := opts.redirectPolicy.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if opts.apiVersionPolicy != nil {
+		if err := opts.apiVersionPolicy.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if opts.maintenancePolicy != nil {
+		if err := opts.maintenancePolicy.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if opts.requestCompression != nil {
+		if err := opts.requestCompression.Validate(); err != nil {
+			return errors.Annotate(err, "request compression")
+		}
+	}
+	if opts.compression != nil {
+		if err := opts.compression.Validate(); err != nil {
+			return errors.Annotate(err, "compression")
+		}
+	}
+	if opts.clockSkewThreshold < 0 {
+		return errors.NotValidf("negative clock skew threshold")
+	}
+	if opts.concurrencyLimit < 0 {
+		return errors.NotValidf("negative concurrency limit")
+	}
+	if opts.perAddressDialTimeout < 0 {
+		return errors.NotValidf("negative per address dial timeout")
+	}
+	if opts.timeout < 0 {
+		return errors.NotValidf("negative timeout")
+	}
+	if err := opts.dialOptions.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := opts.connectionPool.Validate(); err != nil {
+		return errors.Annotate(err, "connection pool")
+	}
+	if err := validateResolverOptions(opts.hostMapping, opts.dnsCacheTTL); err != nil {
+		return errors.Trace(err)
+	}
+	if err := opts.addressFamily.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	if opts.egressPolicy != nil {
+		if err := validateAddressPolicy(opts.egressPolicy); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := opts.deadlineCheck.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	if opts.rawHeaders && opts.perRequestSkipVerify {
+		return errors.NotValidf("raw headers with per request skip verify")
+	}
+	for _, scheme := range opts.allowedSchemes {
+		if scheme == "" {
+			return errors.NotValidf("empty allowed scheme")
+		}
+	}
+	if opts.acceptLanguage != nil {
+		if _, err := acceptLanguage(opts.acceptLanguage); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if opts.samplingPolicy != nil {
+		if err := opts.samplingPolicy.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if opts.stallPolicy != nil {
+		if err := opts.stallPolicy.Validate(); err != nil {
+			return errors.Annotate(err, "stall policy")
+		}
+	}
+	if _, ok := opts.baseRoundTripper.(*http.Transport); opts.baseRoundTripper != nil && !ok {
+		if len(opts.caCertificates) > 0 || opts.caCertificateSource != nil ||
+			opts.skipHostnameVerification || opts.perRequestSkipVerify ||
+			opts.tlsMinVersion != 0 || opts.tlsMaxVersion != 0 || len(opts.cipherSuites) > 0 ||
+			len(opts.clientCertificates) > 0 || opts.tlsConfig != nil || !opts.certificatePins.empty() {
+			return errors.NotValidf("TLS options with a base round tripper that is not an *http.Transport")
+		}
+		if opts.proxyFunc != nil {
+			return errors.NotValidf("proxy func with a base round tripper that is not an *http.Transport")
+		}
+		if opts.responseHeaderLimits != nil && opts.responseHeaderLimits.MaxBytes > 0 {
+			return errors.NotValidf("max response header bytes with a base round tripper that is not an *http.Transport")
+		}
+		if opts.rawHeaders {
+			return errors.NotValidf("raw headers with a base round tripper that is not an *http.Transport")
+		}
+		if opts.connectionPool != (ConnectionPoolConfig{}) {
+			return errors.NotValidf("connection pool with a base round tripper that is not an *http.Transport")
+		}
+		if opts.egressPolicy != nil {
+			return errors.NotValidf("egress policy with a base round tripper that is not an *http.Transport")
+		}
+		if opts.failedTargets != nil {
+			return errors.NotValidf("failed targets with a base round tripper that is not an *http.Transport")
+		}
+		if opts.stallPolicy != nil {
+			return errors.NotValidf("stall detection with a base round tripper that is not an *http.Transport")
+		}
+	}
+	return nil
+}
+
 // Client represents an http client.
+//
+// A Client is safe for concurrent use by multiple goroutines, including
+// while it is being reconfigured with SetLogger, SetRequestRecorder or
+// SetRetryPolicy. Requests in flight keep using the configuration they
+// started with. The underlying http.Client returned by Client must not be
+// modified once the Client is in use.
 type Client struct {
 	HTTPClient
 
-	logger Logger
+	snapshot       *clientSnapshot
+	stats          *clientStats
+	invalidators   cacheInvalidators
+	clock          clock.Clock
+	dumpConfig     DumpConfig
+	recording      bool
+	retrying       bool
+	pinDNS         bool
+	events         *eventEmitter
+	slowRequest    time.Duration
+	informational  *informationalResponses
+	hooks          hookRunner
+	apiVersions    *apiVersionNegotiator
+	maxMessageSize int64
 }
 
 // NewClient returns a new juju http client defined
 // by the given config.
+//
+// If the options are not valid, the error is logged and every request made
+// by the returned client fails with that error.
 func NewClient(options ...Option) *Client {
 	opts := newOptions()
 	for _, option := range options {
 		option(opts)
 	}
+	// Configure a copy of the http.Client, which may be shared.
+	httpClient := *opts.httpClient
+	if err := opts.validate(); err != nil {
+		opts.logger.Errorf("invalid http client configuration: %v", err)
+		client := &httpClient
+		client.Transport = invalidConfigTransport{err: err}
+		return &Client{
+			HTTPClient: client,
+			snapshot:   newClientSnapshot(clientState{logger: opts.logger}),
+			stats:      &clientStats{},
+			clock:      opts.clock,
+		}
+	}
+	stats := &clientStats{}
+	state := clientState{
+		logger:   opts.logger,
+		recorder: opts.requestRecorder,
+		sampling: opts.samplingPolicy,
+	}
+	if opts.retryPolicy != nil {
+		state.retryPolicy = *opts.retryPolicy
+	}
+	snapshot := newClientSnapshot(state)
 
-	client := opts.httpClient
-	transport := NewHTTPTLSTransport(TransportConfig{
-		DisableKeepAlives:   opts.disableKeepAlives,
-		TLSHandshakeTimeout: opts.tlsHandshakeTimeout,
-		Middlewares:         opts.middlewares,
-	})
-	switch {
-	case len(opts.caCertificates) > 0:
-		transport = transportWithCerts(transport, opts.caCertificates, opts.skipHostnameVerification)
-	case opts.skipHostnameVerification:
-		transport = transportWithSkipVerify(transport, opts.skipHostnameVerification)
+	client := &httpClient
+	client.Transport = opts.baseRoundTripper
+	if transport, ok := opts.baseRoundTripper.(*http.Transport); opts.baseRoundTripper == nil || ok {
+		// Every client is built on a transport of its own, which is safe to
+		// modify, so the base round tripper is cloned.
+		if ok {
+			transport = transport.Clone()
+			opts.connectionPool.apply(transport)
+			transport = applyTransportMiddlewares(transport, opts.middlewares)
+		} else {
+			transport = NewHTTPTLSTransport(TransportConfig{
+				DisableKeepAlives:   opts.disableKeepAlives,
+				TLSHandshakeTimeout: opts.tlsHandshakeTimeout,
+				ConnectionPool:      opts.connectionPool,
+				Middlewares:         opts.middlewares,
+			})
+		}
+		switch {
+		case opts.tlsConfig != nil:
+			transport = transportWithTLSConfig(transport, opts.tlsConfig)
+		case len(opts.caCertificates) > 0:
+			pool := newCACertPool(opts.systemCAs, opts.logger)
+			transport = transportWithCerts(transport, pool, opts.caCertificates, opts.skipHostnameVerification)
+		case opts.caCertificateSource != nil:
+			pool := newCACertPool(opts.systemCAs, opts.logger)
+			transport = transportWithCertificateSource(transport, pool, opts.caCertificateSource, opts.skipHostnameVerification)
+		case opts.skipHostnameVerification:
+			transport = transportWithSkipVerify(transport, opts.skipHostnameVerification)
+		}
+		transport = transportWithTLSSettings(transport, opts.tlsMinVersion, opts.tlsMaxVersion, opts.cipherSuites)
+		transport = transportWithClientCertificates(transport, opts.clientCertificates)
+		transport = transportWithPins(transport, &opts.certificatePins)
+		if opts.responseHeaderLimits != nil && opts.responseHeaderLimits.MaxBytes > 0 {
+			transport.MaxResponseHeaderBytes = opts.responseHeaderLimits.MaxBytes
+		}
+		if opts.proxyFunc != nil {
+			transport.Proxy = recordingProxy(opts.proxyFunc)
+		}
+		if opts.proxyLogging {
+			proxyFunc := transport.Proxy
+			if proxyFunc == nil {
+				proxyFunc = noProxy
+			}
+			transport.Proxy = loggingProxy(proxyFunc, snapshot)
+		}
+		lookup := lookupFunc(defaultLookup)
+		resolver := newHostResolver(opts)
+		if resolver != nil {
+			lookup = resolver.lookup
+		}
+		lookup = opts.addressFamily.lookup(lookup)
+		if opts.failedTargets != nil {
+			transport.DialContext = opts.failedTargets.dialContext(transport.DialContext)
+		}
+		if opts.egressPolicy != nil {
+			transport.DialContext = egressDialContext(transport.DialContext, lookup, opts.egressPolicy)
+		}
+		if resolver != nil || opts.addressFamily != AddressFamilyAny {
+			transport.DialContext = resolvingDialContext(transport.DialContext, lookup,
+				opts.addressFamily, opts.clock, opts.dialOptions.FallbackDelay)
+		}
+		if opts.perAddressDialTimeout > 0 {
+			transport.DialContext = fallbackDialContext(transport.DialContext, lookup, opts.perAddressDialTimeout)
+		}
+		if opts.dnsRebindingProtection {
+			transport.DialContext = pinningDialContext(transport.DialContext, lookup)
+		}
+		if opts.egressPolicy != nil {
+			transport.DialContext = egressHostDialContext(transport.DialContext)
+		}
+		if opts.failedTargets != nil {
+			transport.DialContext = failedTargetsDialContext(transport.DialContext, opts.failedTargets)
+		}
+		transport.DialContext = stats.countingDialContext(transport.DialContext)
+		if opts.stallPolicy != nil {
+			transport.DialContext = stallDialContext(transport.DialContext, *opts.stallPolicy)
+		}
+		if opts.rawHeaders {
+			transportWithRawHeaders(transport)
+		}
+
+		client.Transport = transport
+		if opts.connectionAffinity != nil {
+			client.Transport = newAffinityTransport(transport, opts.connectionAffinity)
+		}
+		if opts.stallPolicy != nil {
+			client.Transport = stallTransport{
+				wrappedRoundTripper: client.Transport,
+			}
+		}
+		if opts.dnsRebindingProtection {
+			client.Transport = dnsPinTransport{
+				wrappedRoundTripper: client.Transport,
+				lookup:              lookup,
+			}
+		}
+		if opts.rawHeaders {
+			client.Transport = rawHeaderTransport{
+				wrappedRoundTripper: client.Transport,
+			}
+		}
+		if opts.perRequestSkipVerify {
+			client.Transport = newSkipVerifyTransport(client.Transport, transport, snapshot)
+		}
+		if opts.requestRecorder != nil && opts.compression == nil && !transport.DisableCompression {
+			client.Transport = decompressingTransport{
+				wrappedRoundTripper: client.Transport,
+				snapshot:            snapshot,
+				hooks: hookRunner{
+					clock:   opts.clock,
+					timeout: opts.hookTimeout,
+				},
+			}
+		}
+	}
+	if opts.compression != nil {
+		transport, _ := newDecompressionTransport(client.Transport, *opts.compression)
+		transport.snapshot = snapshot
+		transport.hooks = hookRunner{
+			clock:   opts.clock,
+			timeout: opts.hookTimeout,
+		}
+		client.Transport = transport
+	}
+	if opts.certificateErrorDetails {
+		client.Transport = certificateErrorTransport{
+			wrappedRoundTripper: client.Transport,
+		}
+	}
+	if opts.responseHeaderLimits != nil &&
+		(opts.responseHeaderLimits.MaxFields > 0 || opts.responseHeaderLimits.MaxFieldBytes > 0) {
+		client.Transport = headerLimitTransport{
+			wrappedRoundTripper: client.Transport,
+			limits:              *opts.responseHeaderLimits,
+		}
+	}
+	for _, middleware := range opts.roundTripperMiddlewares {
+		client.Transport = middleware(client.Transport)
+	}
+	if opts.curlCommandLogging {
+		client.Transport = curlTransport{
+			wrappedRoundTripper: client.Transport,
+			snapshot:            snapshot,
+			dumpConfig:          opts.dumpConfig,
+		}
+	}
+	if opts.requestCompression != nil {
+		client.Transport = newCompressionTransport(client.Transport, *opts.requestCompression)
+	}
+	if opts.concurrencyLimit > 0 {
+		client.Transport = concurrencyLimitTransport{
+			wrappedRoundTripper: client.Transport,
+			limiter:             newPriorityLimiter(opts.concurrencyLimit),
+		}
+	}
+	if len(opts.routes) > 0 {
+		client.Transport = &Router{
+			routes:   opts.routes,
+			fallback: client.Transport,
+		}
+	}
+	if opts.acceptLanguage != nil {
+		value, _ := acceptLanguage(opts.acceptLanguage)
+		client.Transport = acceptLanguageTransport{
+			wrappedRoundTripper: client.Transport,
+			value:               value,
+		}
+	}
+	if opts.clockSkewThreshold > 0 {
+		client.Transport = newClockSkewTransport(
+			client.Transport,
+			snapshot,
+			opts.clock,
+			hookRunner{
+				clock:   opts.clock,
+				timeout: opts.hookTimeout,
+			},
+			opts.clockSkewThreshold,
+			opts.onClockSkew,
+		)
+	}
+	if opts.maintenancePolicy != nil {
+		client.Transport = newMaintenanceTransport(client.Transport, snapshot, opts.clock, *opts.maintenancePolicy)
+	}
+	if opts.runtimeTrace {
+		client.Transport = runtimeTraceTransport{
+			wrappedRoundTripper: client.Transport,
+		}
 	}
 
 	if opts.requestRecorder != nil {
 		client.Transport = roundTripRecorder{
 			requestRecorder:     opts.requestRecorder,
-			wrappedRoundTripper: transport,
+			wrappedRoundTripper: client.Transport,
+			snapshot:            snapshot,
+			clock:               opts.clock,
+			hooks: hookRunner{
+				clock:   opts.clock,
+				timeout: opts.hookTimeout,
+			},
+			sampler: &sampler{},
 		}
-	} else {
-		client.Transport = transport
 	}
 
 	// Ensure we add the retry middleware after request recorder if there is
 	// one, to ensure that we get all the logging at the right level.
 	if opts.retryPolicy != nil {
-		client.Transport = makeRetryMiddleware(
+		retrier := makeRetryMiddleware(
 			client.Transport,
 			*opts.retryPolicy,
-			clock.WallClock,
+			opts.clock,
 			opts.logger,
 		)
+		retrier.stats = stats
+		retrier.snapshot = snapshot
+		client.Transport = retrier
+	}
+
+	// Serve cached responses outside of the retry middleware, so that a
+	// response served from the cache is never retried or recorded.
+	if opts.responseCache != nil {
+		client.Transport = responseCacheTransport{
+			wrappedRoundTripper: client.Transport,
+			cache:               opts.responseCache,
+		}
+	}
+
+	// Track bodies outside of the retry middleware, so that only the body
+	// returned to the caller is tracked.
+	if opts.requestRecorder != nil || opts.bodyLeakDetection {
+		client.Transport = bodyTrackingTransport{
+			wrappedRoundTripper: client.Transport,
+			snapshot:            snapshot,
+			clock:               opts.clock,
+			hooks: hookRunner{
+				clock:   opts.clock,
+				timeout: opts.hookTimeout,
+			},
+			detectLeaks:   opts.bodyLeakDetection,
+			captureStacks: opts.bodyLeakStacks,
+		}
+	}
+
+	// Negotiate API versions outside of the retry middleware. The
+	// negotiation requests are sent through the outermost transport, see
+	// below, so that they are retried, recorded and checked like any other.
+	var apiVersions *apiVersionNegotiator
+	if opts.apiVersionPolicy != nil {
+		apiVersions = newAPIVersionNegotiator(client.Transport, opts.clock, *opts.apiVersionPolicy)
+		client.Transport = apiVersionTransport{
+			wrappedRoundTripper: client.Transport,
+			negotiator:          apiVersions,
+		}
+	}
+
+	if opts.deadlineCheck != DeadlineCheckOff {
+		client.Transport = deadlineCheckTransport{
+			wrappedRoundTripper: client.Transport,
+			snapshot:            snapshot,
+			check:               opts.deadlineCheck,
+		}
+	}
+
+	// Check the scheme before anything else, so that rejected requests are
+	// never recorded or retried.
+	if opts.allowedSchemes != nil {
+		client.Transport = newSchemeAllowListTransport(client.Transport, opts.allowedSchemes)
+	}
+	if apiVersions != nil {
+		apiVersions.transport = client.Transport
 	}
 
 	if opts.cookieJar != nil {
 		client.Jar = opts.cookieJar
 	}
-	return &Client{
-		HTTPClient: client,
-		logger:     opts.logger,
+	if opts.timeout > 0 {
+		client.Timeout = opts.timeout
+	}
+	if opts.redirectPolicy != nil {
+		client.CheckRedirect = chainCheckRedirect(*opts.redirectPolicy, client.CheckRedirect)
+	}
+	var events *eventEmitter
+	if len(opts.eventSubscribers) > 0 {
+		events = &eventEmitter{
+			subscribers: opts.eventSubscribers,
+			snapshot:    snapshot,
+			clock:       opts.clock,
+			hooks: hookRunner{
+				clock:   opts.clock,
+				timeout: opts.hookTimeout,
+			},
+		}
+	}
+	var informational *informationalResponses
+	if opts.informationalHook != nil {
+		informational = &informationalResponses{
+			hook:     opts.informationalHook,
+			snapshot: snapshot,
+			hooks: hookRunner{
+				clock:   opts.clock,
+				timeout: opts.hookTimeout,
+			},
+		}
+	}
+	c := &Client{
+		HTTPClient:     client,
+		snapshot:       snapshot,
+		stats:          stats,
+		clock:          opts.clock,
+		dumpConfig:     opts.dumpConfig,
+		recording:      opts.requestRecorder != nil,
+		retrying:       opts.retryPolicy != nil,
+		pinDNS:         opts.dnsRebindingProtection,
+		events:         events,
+		slowRequest:    opts.slowRequestThreshold,
+		informational:  informational,
+		apiVersions:    apiVersions,
+		maxMessageSize: opts.maxMessageSize,
+		hooks: hookRunner{
+			clock:   opts.clock,
+			timeout: opts.hookTimeout,
+		},
 	}
+	if opts.responseCache != nil {
+		c.RegisterCacheInvalidator(opts.responseCache)
+	}
+	return c
+}
+
+// SetLogger replaces the logger used by the client. It has no effect on a
+// client not built by NewClient.
+func (c *Client) SetLogger(logger Logger) {
+	_ = c.snapshot.update(func(state *clientState) {
+		state.logger = logger
+	})
+}
+
+// SetRequestRecorder replaces the RequestRecorder used by the client. A nil
+// recorder disables recording. The client must have been constructed with
+// WithRequestRecorder, otherwise a NotSupported error is returned.
+func (c *Client) SetRequestRecorder(recorder RequestRecorder) error {
+	if !c.recording {
+		return errors.NotSupportedf("setting request recorder on client constructed without one")
+	}
+	return errors.Trace(c.snapshot.update(func(state *clientState) {
+		state.recorder = recorder
+	}))
+}
+
+// SetRetryPolicy replaces the retry policy used by the client. The client
+// must have been constructed with WithRequestRetrier, otherwise a
+// NotSupported error is returned.
+func (c *Client) SetRetryPolicy(policy RetryPolicy) error {
+	if !c.retrying {
+		return errors.NotSupportedf("setting retry policy on client constructed without one")
+	}
+	if err := policy.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(c.snapshot.update(func(state *clientState) {
+		state.retryPolicy = policy
+	}))
 }
 
 func transportWithSkipVerify(defaultTransport *http.Transport, skipHostnameVerify bool) *http.Transport {
@@ -249,8 +988,34 @@ func transportWithSkipVerify(defaultTransport *http.Transport, skipHostnameVerif
 	return transport
 }
 
-func transportWithCerts(defaultTransport *http.Transport, caCerts []string, skipHostnameVerify bool) *http.Transport {
-	pool := x509.NewCertPool()
+func transportWithTLSConfig(defaultTransport *http.Transport, tlsConfig *tls.Config) *http.Transport {
+	transport := defaultTransport
+	transport.TLSClientConfig = tlsConfig.Clone()
+
+	// We're creating a new tls.Config, HTTP/2 requests will not work, force the
+	// client to create a HTTP/2 requests.
+	transport.ForceAttemptHTTP2 = true
+	return transport
+}
+
+// systemCertPool is patched in tests.
+var systemCertPool = x509.SystemCertPool
+
+// newCACertPool returns the pool the given CA certificates are added to,
+// seeded with the system CA certificates if requested.
+func newCACertPool(systemCAs bool, logger Logger) *x509.CertPool {
+	if !systemCAs {
+		return x509.NewCertPool()
+	}
+	pool, err := systemCertPool()
+	if err != nil {
+		logger.Errorf("system CA certificates unavailable, trusting only the given CA certificates: %v", err)
+		return x509.NewCertPool()
+	}
+	return pool
+}
+
+func transportWithCerts(defaultTransport *http.Transport, pool *x509.CertPool, caCerts []string, skipHostnameVerify bool) *http.Transport {
 	for _, cert := range caCerts {
 		pool.AppendCertsFromPEM([]byte(cert))
 	}
@@ -268,66 +1033,259 @@ func transportWithCerts(defaultTransport *http.Transport, caCerts []string, skip
 	return transport
 }
 
+func transportWithTLSSettings(defaultTransport *http.Transport, minVersion, maxVersion uint16, cipherSuites []uint16) *http.Transport {
+	if minVersion == 0 && maxVersion == 0 && len(cipherSuites) == 0 {
+		return defaultTransport
+	}
+
+	transport := defaultTransport
+	config := ownTLSConfig(transport)
+	if minVersion != 0 {
+		config.MinVersion = minVersion
+	}
+	if maxVersion != 0 {
+		config.MaxVersion = maxVersion
+	}
+	if len(cipherSuites) > 0 {
+		config.CipherSuites = cipherSuites
+	}
+
+	// We're creating a new tls.Config, HTTP/2 requests will not work, force the
+	// client to create a HTTP/2 requests.
+	transport.ForceAttemptHTTP2 = true
+	return transport
+}
+
+func transportWithClientCertificates(defaultTransport *http.Transport, certs []tls.Certificate) *http.Transport {
+	if len(certs) == 0 {
+		return defaultTransport
+	}
+
+	transport := defaultTransport
+	config := ownTLSConfig(transport)
+	config.Certificates = append(slices.Clip(config.Certificates), certs...)
+
+	// We're creating a new tls.Config, HTTP/2 requests will not work, force the
+	// client to create a HTTP/2 requests.
+	transport.ForceAttemptHTTP2 = true
+	return transport
+}
+
+// ownTLSConfig returns the TLS config of the transport, to be modified. The
+// config is cloned, as it may be shared with other transports, such as when
+// set by a transport middleware. A transport without a TLS config is given
+// the SecureTLSConfig.
+func ownTLSConfig(transport *http.Transport) *tls.Config {
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = SecureTLSConfig()
+	} else {
+		transport.TLSClientConfig = transport.TLSClientConfig.Clone()
+	}
+	return transport.TLSClientConfig
+}
+
+// invalidConfigTransport is used in place of the transport when the client
+// options failed validation, so that the error is surfaced on use.
+type invalidConfigTransport struct {
+	err error
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t invalidConfigTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, errors.Annotate(t.err, "invalid http client configuration")
+}
+
 // Client returns the underlying http.Client.  Used in testing
 // only.
 func (c *Client) Client() *http.Client {
 	return c.HTTPClient.(*http.Client)
 }
 
+// Do sends an HTTP request and returns an HTTP response, counting the
+// request in the client statistics. Any error is wrapped in a
+// *RequestError.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	// A Client may be built without NewClient, such as in tests, so every
+	// field set by NewClient is optional.
+	clk := c.clock
+	if clk == nil {
+		clk = clock.WallClock
+	}
+	start := clk.Now()
+	req, info := withRequestInfo(req)
+	if c.pinDNS {
+		req = withDNSPins(req)
+	}
+	withPriorityHeader(req)
+	if c.events != nil {
+		req = c.events.withEvents(req)
+	}
+	if c.informational != nil {
+		req = c.informational.withTrace(req)
+	}
+	req, requestStats := withRequestStats(req, clk, c.snapshot.load(), c.hooks)
+	resp, err := c.HTTPClient.Do(req)
+	c.stats.recordRequest(err)
+	if requestStats != nil {
+		if err != nil || resp.Body == nil {
+			requestStats.finish()
+		} else {
+			resp.Body = requestStats.body(resp.Body)
+		}
+	}
+	if elapsed := clk.Now().Sub(start); c.slowRequest > 0 && elapsed >= c.slowRequest {
+		emitEvent(req.Context(), Event{
+			Kind:    EventSlowRequest,
+			Elapsed: elapsed,
+			Err:     err,
+		})
+	}
+	if err != nil {
+		return resp, info.wrap(req, clk.Now().Sub(start), err)
+	}
+	return resp, nil
+}
+
+// Stats returns a snapshot of the counters of requests made through the
+// client.
+func (c *Client) Stats() Stats {
+	stats := c.stats.snapshot()
+	c.addRetryBudgetStats(&stats)
+	return stats
+}
+
+func (c *Client) addRetryBudgetStats(stats *Stats) {
+	if !c.retrying {
+		return
+	}
+	if budget := c.snapshot.load().retryPolicy.Budget; budget != nil {
+		budgetStats := budget.Stats()
+		stats.RetryBudget = &budgetStats
+	}
+}
+
+// SnapshotAndReset returns the counters of requests made through the client
+// since the last reset, and resets them to zero, so that a collector can
+// report each request exactly once. Gauges, such as ConnectionsOpen, are
+// not reset. Use either SnapshotAndReset, or Stats with Stats.Sub, for a
+// client, not both.
+func (c *Client) SnapshotAndReset() Stats {
+	stats := c.stats.resetCounters()
+	c.addRetryBudgetStats(&stats)
+	return stats
+}
+
 // Get issues a GET to the specified URL.  It mimics the net/http Get,
 // but allows for enhanced debugging.
 //
 // When err is nil, resp always contains a non-nil resp.Body.
 // Caller should close resp.Body when done reading from it.
 func (c *Client) Get(ctx context.Context, path string) (resp *http.Response, err error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", path, nil)
+	return c.send(ctx, "GET", path, nil, "")
+}
+
+// Head issues a HEAD to the specified URL.
+func (c *Client) Head(ctx context.Context, path string) (resp *http.Response, err error) {
+	return c.send(ctx, "HEAD", path, nil, "")
+}
+
+// Post issues a POST to the specified URL, with the body and content type
+// given. An empty content type leaves the Content-Type header unset.
+//
+// When err is nil, resp always contains a non-nil resp.Body.
+// Caller should close resp.Body when done reading from it.
+func (c *Client) Post(ctx context.Context, path string, contentType string, body io.Reader) (resp *http.Response, err error) {
+	return c.send(ctx, "POST", path, body, contentType)
+}
+
+// Put issues a PUT to the specified URL, with the body and content type
+// given. An empty content type leaves the Content-Type header unset.
+//
+// When err is nil, resp always contains a non-nil resp.Body.
+// Caller should close resp.Body when done reading from it.
+func (c *Client) Put(ctx context.Context, path string, contentType string, body io.Reader) (resp *http.Response, err error) {
+	return c.send(ctx, "PUT", path, body, contentType)
+}
+
+// Patch issues a PATCH to the specified URL, with the body and content type
+// given. An empty content type leaves the Content-Type header unset.
+//
+// When err is nil, resp always contains a non-nil resp.Body.
+// Caller should close resp.Body when done reading from it.
+func (c *Client) Patch(ctx context.Context, path string, contentType string, body io.Reader) (resp *http.Response, err error) {
+	return c.send(ctx, "PATCH", path, body, contentType)
+}
+
+// Delete issues a DELETE to the specified URL.
+//
+// When err is nil, resp always contains a non-nil resp.Body.
+// Caller should close resp.Body when done reading from it.
+func (c *Client) Delete(ctx context.Context, path string) (resp *http.Response, err error) {
+	return c.send(ctx, "DELETE", path, nil, "")
+}
+
+// send creates a request for the method and URL, with the optional body,
+// traces it if enabled and sends it.
+func (c *Client) send(ctx context.Context, method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, path, body)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	return c.sendRequest(req, path, contentType)
+}
+
+// sendRequest sets the optional content type of the request, traces it if
+// enabled and sends it.
+func (c *Client) sendRequest(req *http.Request, path string, contentType string) (*http.Response, error) {
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
 
 	if err := c.traceRequest(req, path); err != nil {
 		// No need to fail, but let user know we're
-		// not tracing the client GET.
+		// not tracing the client request.
 		err = errors.Annotatef(err, "setup of http client tracing failed")
-		c.logger.Tracef("%s", err)
+		requestLogger(req.Context(), c.snapshot.load().logger).Tracef("%s", err)
 	}
 	return c.Do(req)
 }
 
 // traceRequest enabled debugging on the http request if
-// log level for ths package is set to Trace.  Otherwise it
+// log level for ths package is set to Trace, or the request
+// context carries a logger with trace enabled.  Otherwise it
 // returns with no change to the request.
 func (c *Client) traceRequest(req *http.Request, url string) error {
-	if !c.logger.IsTraceEnabled() {
+	logger := requestLogger(req.Context(), c.snapshot.load().logger)
+	if !logger.IsTraceEnabled() {
 		return nil
 	}
 
-	dump, err := httputil.DumpRequestOut(req, true)
+	dump, err := dumpRequest(req, c.dumpConfig)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	c.logger.Tracef("request for %q: %q", url, dump)
+	logger.Tracef("request for %q: %q", url, dump)
 	trace := &httptrace.ClientTrace{
 		DNSStart: func(info httptrace.DNSStartInfo) {
-			c.logger.Tracef("%s DNS Start: %q", url, info.Host)
+			logger.Tracef("%s DNS Start: %q", url, info.Host)
 		},
 		DNSDone: func(dnsInfo httptrace.DNSDoneInfo) {
-			c.logger.Tracef("%s DNS Info: %+v\n", url, dnsInfo)
+			logger.Tracef("%s DNS Info: %+v\n", url, dnsInfo)
 		},
 		ConnectDone: func(network, addr string, err error) {
-			c.logger.Tracef("%s Connection Done: network %q, addr %q, err %q", url, network, addr, err)
+			logger.Tracef("%s Connection Done: network %q, addr %q, err %q", url, network, addr, err)
 		},
 		GetConn: func(hostPort string) {
-			c.logger.Tracef("%s Get Conn: %q", url, hostPort)
+			logger.Tracef("%s Get Conn: %q", url, hostPort)
 		},
 		GotConn: func(connInfo httptrace.GotConnInfo) {
-			c.logger.Tracef("%s Got Conn: %+v", url, connInfo)
+			logger.Tracef("%s Got Conn: %+v", url, connInfo)
 		},
 		TLSHandshakeStart: func() {
-			c.logger.Tracef("%s TLS Handshake Start", url)
+			logger.Tracef("%s TLS Handshake Start", url)
 		},
 		TLSHandshakeDone: func(st tls.ConnectionState, err error) {
-			c.logger.Tracef("%s TLS Handshake Done: complete %t, verified chains %d, server name %q",
+			logger.Tracef("%s TLS Handshake Done: complete %t, verified chains %d, server name %q",
 				url,
 				st.HandshakeComplete,
 				len(st.VerifiedChains),
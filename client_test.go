@@ -6,14 +6,25 @@ package http
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
+	"io"
+	"math/big"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
+	"net/http/httptrace"
 	"net/url"
+	"strings"
 	"time"
 
+	"github.com/juju/errors"
 	"github.com/juju/testing"
 	jc "github.com/juju/testing/checkers"
 	"go.uber.org/mock/gomock"
@@ -30,6 +41,27 @@ func (s *clientSuite) TestNewClient(c *gc.C) {
 	c.Assert(client, gc.NotNil)
 }
 
+func (s *clientSuite) TestDoWithoutNewClient(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	req, err := http.NewRequest("GET", "http://meshuggah.rocks", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	httpClient := NewMockHTTPClient(ctrl)
+	gomock.InOrder(
+		httpClient.EXPECT().Do(gomock.Any()).Return(&http.Response{StatusCode: http.StatusOK}, nil),
+		httpClient.EXPECT().Do(gomock.Any()).Return(nil, errors.New("boom")),
+	)
+
+	client := &Client{HTTPClient: httpClient}
+	resp, err := client.Do(req)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(resp.StatusCode, gc.Equals, http.StatusOK)
+	_, err = client.Do(req)
+	c.Check(err, gc.ErrorMatches, "boom")
+	c.Check(client.Stats(), jc.DeepEquals, Stats{})
+}
+
 type httpSuite struct {
 	testing.IsolationSuite
 	server *httptest.Server
@@ -54,6 +86,66 @@ func (s *httpSuite) TestSecureClientAllowAccess(c *gc.C) {
 	c.Assert(err, jc.ErrorIsNil)
 }
 
+func (s *httpSuite) TestVerbs(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		c.Check(err, jc.ErrorIsNil)
+		w.Header().Set("X-Request", fmt.Sprintf("%s %s %s", r.Method, r.Header.Get("Content-Type"), body))
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	for _, test := range []struct {
+		send     func() (*http.Response, error)
+		expected string
+	}{{
+		send: func() (*http.Response, error) {
+			return client.Post(context.TODO(), server.URL, "text/plain", strings.NewReader("post"))
+		},
+		expected: "POST text/plain post",
+	}, {
+		send: func() (*http.Response, error) {
+			return client.Put(context.TODO(), server.URL, "application/json", strings.NewReader("{}"))
+		},
+		expected: "PUT application/json {}",
+	}, {
+		send: func() (*http.Response, error) {
+			return client.Patch(context.TODO(), server.URL, "", strings.NewReader("patch"))
+		},
+		expected: "PATCH  patch",
+	}, {
+		send: func() (*http.Response, error) {
+			return client.Delete(context.TODO(), server.URL)
+		},
+		expected: "DELETE",
+	}, {
+		send: func() (*http.Response, error) {
+			return client.Head(context.TODO(), server.URL)
+		},
+		expected: "HEAD",
+	}} {
+		resp, err := test.send()
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(resp.Header.Get("X-Request"), gc.Equals, test.expected)
+		c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	}
+}
+
+func (s *httpSuite) TestVerbsTraced(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	logger := NewMockLogger(ctrl)
+	logger.EXPECT().IsTraceEnabled().Return(true).AnyTimes()
+	logger.EXPECT().Tracef(`request for %q: %q`, s.server.URL, gomock.Any())
+	logger.EXPECT().Tracef(gomock.Any(), gomock.Any()).AnyTimes()
+
+	client := NewClient(WithLogger(logger))
+	resp, err := client.Post(context.TODO(), s.server.URL, "text/plain", strings.NewReader("traced"))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+}
+
 // NewClient with a default config used to overwrite http.DefaultClient.Jar
 // field; add a regression test for that.
 func (s *httpSuite) TestDefaultClientJarNotOverwritten(c *gc.C) {
@@ -238,6 +330,179 @@ func (s *httpTLSServerSuite) testGetHTTPClientWithCerts(c *gc.C, skip bool) {
 	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
 }
 
+func (s *httpTLSServerSuite) TestSystemCAs(c *gc.C) {
+	// The certificate of the server is trusted by the system.
+	s.PatchValue(&systemCertPool, func() (*x509.CertPool, error) {
+		pool := x509.NewCertPool()
+		pool.AddCert(s.server.Certificate())
+		return pool, nil
+	})
+	otherPEM, _ := clientCertificate(c)
+
+	client := NewClient(WithCACertificates(otherPEM))
+	resp, err := client.Get(context.TODO(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+
+	client = NewClient(WithCACertificateSource(func() []string { return []string{otherPEM} }))
+	resp, err = client.Get(context.TODO(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+
+	client = NewClient(WithCACertificates(otherPEM), WithSystemCAs(false))
+	_, err = client.Get(context.TODO(), s.server.URL)
+	c.Assert(err, gc.ErrorMatches, "(.|\n)*x509: certificate signed by unknown authority")
+}
+
+func (s *httpTLSServerSuite) TestSystemCAsUnavailable(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	poolErr := errors.New("boom")
+	s.PatchValue(&systemCertPool, func() (*x509.CertPool, error) {
+		return nil, poolErr
+	})
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.server.Certificate().Raw})
+
+	logger := NewMockLogger(ctrl)
+	logger.EXPECT().Errorf("system CA certificates unavailable, trusting only the given CA certificates: %v", poolErr)
+	logger.EXPECT().IsTraceEnabled().Return(false).AnyTimes()
+
+	// The given CA certificates are still trusted.
+	client := NewClient(WithLogger(logger), WithCACertificates(string(caPEM)))
+	resp, err := client.Get(context.TODO(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+}
+
+// clientCertificate returns a PEM encoded, self signed client certificate
+// and its key.
+func clientCertificate(c *gc.C) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, jc.ErrorIsNil)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "juju-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	c.Assert(err, jc.ErrorIsNil)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	c.Assert(err, jc.ErrorIsNil)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return string(certPEM), string(keyPEM)
+}
+
+func (s *httpTLSServerSuite) TestClientCertificates(c *gc.C) {
+	s.server.Close()
+	s.server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	s.server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	s.server.StartTLS()
+
+	_, err := NewClient(WithSkipHostnameVerification(true)).Get(context.TODO(), s.server.URL)
+	c.Assert(err, gc.NotNil)
+
+	certPEM, keyPEM := clientCertificate(c)
+	client := NewClient(
+		WithSkipHostnameVerification(true),
+		WithClientCertificates(certPEM, keyPEM),
+	)
+	resp, err := client.Get(context.TODO(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(body), gc.Equals, "juju-client")
+}
+
+func (s *clientSuite) TestClientTLSCertificates(c *gc.C) {
+	certPEM, keyPEM := clientCertificate(c)
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	c.Assert(err, jc.ErrorIsNil)
+
+	client := NewClient(
+		WithMinimumTLSVersion(tls.VersionTLS13),
+		WithClientTLSCertificates(cert),
+	)
+	transport := client.Client().Transport.(*http.Transport)
+	c.Assert(transport.TLSClientConfig.Certificates, gc.HasLen, 1)
+	// The secure defaults are kept.
+	c.Assert(transport.TLSClientConfig.MinVersion, gc.Equals, uint16(tls.VersionTLS13))
+	c.Assert(transport.TLSClientConfig.CipherSuites, jc.DeepEquals, SecureTLSConfig().CipherSuites)
+}
+
+func (s *clientSuite) TestInvalidClientCertificates(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	client := NewClient(
+		WithLogger(logger(ctrl)),
+		WithClientCertificates("not a certificate", "not a key"),
+	)
+	_, err := client.Get(context.TODO(), "http://0.1.2.3:1234")
+	c.Assert(err, gc.ErrorMatches, `.*client certificate: tls: failed to find any PEM data in certificate input`)
+	c.Assert(errors.Is(err, errors.NotValid), jc.IsTrue)
+}
+
+func (s *httpTLSServerSuite) TestTLSConfig(c *gc.C) {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.server.Certificate())
+	var keyLog bytes.Buffer
+	config := &tls.Config{
+		RootCAs:      pool,
+		ServerName:   "example.com",
+		KeyLogWriter: &keyLog,
+	}
+
+	client := NewClient(WithTLSConfig(config), WithMinimumTLSVersion(tls.VersionTLS13))
+	resp, err := client.Get(context.TODO(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	_ = resp.Body.Close()
+	c.Check(resp.TLS.Version, gc.Equals, uint16(tls.VersionTLS13))
+	c.Check(keyLog.Len(), jc.GreaterThan, 0)
+	// The config of the caller isn't modified.
+	c.Check(config.MinVersion, gc.Equals, uint16(0))
+}
+
+func (s *clientSuite) TestInvalidTLSConfig(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	tests := []struct {
+		about   string
+		options []Option
+		err     string
+	}{{
+		about:   "with CA certificates",
+		options: []Option{WithTLSConfig(&tls.Config{}), WithCACertificates("cert")},
+		err:     `.*TLS config with CA certificates or skip hostname verification not valid`,
+	}, {
+		about:   "with skip hostname verification",
+		options: []Option{WithTLSConfig(&tls.Config{}), WithSkipHostnameVerification(true)},
+		err:     `.*TLS config with CA certificates or skip hostname verification not valid`,
+	}, {
+		about:   "minimum below floor",
+		options: []Option{WithTLSConfig(&tls.Config{MinVersion: tls.VersionTLS10})},
+		err:     `.*TLS config minimum TLS version TLS 1.0 not valid`,
+	}, {
+		about:   "insecure cipher suite",
+		options: []Option{WithTLSConfig(&tls.Config{CipherSuites: []uint16{tls.TLS_RSA_WITH_RC4_128_SHA}})},
+		err:     `.*TLS config cipher suite TLS_RSA_WITH_RC4_128_SHA not valid`,
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.about)
+		client := NewClient(append(test.options, WithLogger(logger(ctrl)))...)
+		_, err := client.Get(context.TODO(), "http://0.1.2.3:1234")
+		c.Assert(err, gc.ErrorMatches, test.err)
+		c.Assert(errors.Is(err, errors.NotValid), jc.IsTrue)
+	}
+}
+
 func (s *clientSuite) TestDisableKeepAlives(c *gc.C) {
 	client := NewClient()
 	transport := client.Client().Transport.(*http.Transport)
@@ -251,3 +516,181 @@ func (s *clientSuite) TestDisableKeepAlives(c *gc.C) {
 	transport = client.Client().Transport.(*http.Transport)
 	c.Assert(transport.DisableKeepAlives, gc.Equals, true)
 }
+
+func (s *clientSuite) TestTLSVersionsAndCipherSuites(c *gc.C) {
+	client := NewClient(
+		WithMinimumTLSVersion(tls.VersionTLS12),
+		WithMaximumTLSVersion(tls.VersionTLS13),
+		WithCipherSuites(tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256),
+	)
+	transport := client.Client().Transport.(*http.Transport)
+	c.Assert(transport.TLSClientConfig.MinVersion, gc.Equals, uint16(tls.VersionTLS12))
+	c.Assert(transport.TLSClientConfig.MaxVersion, gc.Equals, uint16(tls.VersionTLS13))
+	c.Assert(transport.TLSClientConfig.CipherSuites, jc.DeepEquals, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256})
+}
+
+func (s *clientSuite) TestSharedMiddlewareTransportNotModified(c *gc.C) {
+	shared := &http.Transport{TLSClientConfig: &tls.Config{ServerName: "controller"}}
+	middleware := func(*http.Transport) *http.Transport {
+		return shared
+	}
+	clients := []*Client{
+		NewClient(WithTransportMiddlewares(middleware), WithMinimumTLSVersion(tls.VersionTLS13)),
+		NewClient(WithTransportMiddlewares(middleware), WithMaximumTLSVersion(tls.VersionTLS12)),
+	}
+	c.Check(shared.DialContext, gc.IsNil)
+	c.Check(shared.TLSClientConfig.MinVersion, gc.Equals, uint16(0))
+	c.Check(shared.TLSClientConfig.MaxVersion, gc.Equals, uint16(0))
+
+	first := clients[0].Client().Transport.(*http.Transport)
+	second := clients[1].Client().Transport.(*http.Transport)
+	c.Assert(first, gc.Not(gc.Equals), second)
+	c.Check(first.TLSClientConfig.ServerName, gc.Equals, "controller")
+	c.Check(first.TLSClientConfig.MinVersion, gc.Equals, uint16(tls.VersionTLS13))
+	c.Check(first.TLSClientConfig.MaxVersion, gc.Equals, uint16(0))
+	c.Check(second.TLSClientConfig.MinVersion, gc.Equals, uint16(0))
+	c.Check(second.TLSClientConfig.MaxVersion, gc.Equals, uint16(tls.VersionTLS12))
+}
+
+func (s *clientSuite) TestSharedTLSConfigNotModified(c *gc.C) {
+	config := &tls.Config{Certificates: make([]tls.Certificate, 0, 2)}
+	middleware := func(transport *http.Transport) *http.Transport {
+		transport.TLSClientConfig = config
+		return transport
+	}
+	certs := []tls.Certificate{{Certificate: [][]byte{[]byte("a")}}}
+	NewClient(WithTransportMiddlewares(middleware), WithClientTLSCertificates(certs...))
+	NewClient(WithTransportMiddlewares(middleware), WithMinimumTLSVersion(tls.VersionTLS13))
+	c.Check(config.MinVersion, gc.Equals, uint16(0))
+	c.Check(config.Certificates, gc.HasLen, 0)
+	c.Check(config.Certificates[:1], jc.DeepEquals, []tls.Certificate{{}})
+}
+
+func (s *clientSuite) TestSharedHTTPClientNotModified(c *gc.C) {
+	shared := &http.Client{}
+	first := NewClient(WithHTTPClient(shared), WithTimeout(time.Second))
+	second := NewClient(WithHTTPClient(shared))
+	c.Check(shared.Transport, gc.IsNil)
+	c.Check(shared.Timeout, gc.Equals, time.Duration(0))
+	c.Check(first.Client().Timeout, gc.Equals, time.Second)
+	c.Check(second.Client().Timeout, gc.Equals, time.Duration(0))
+	c.Check(first.Client().Transport, gc.Not(gc.Equals), second.Client().Transport)
+}
+
+func (s *clientSuite) TestTransportMiddlewaresKeepTransport(c *gc.C) {
+	transport := DefaultHTTPTransport()
+	modify := func(t *http.Transport) *http.Transport {
+		t.MaxIdleConnsPerHost = 42
+		return t
+	}
+	// A transport modified in place isn't cloned, so its connections are
+	// kept.
+	result := applyTransportMiddlewares(transport, []TransportMiddleware{modify, ProxyMiddleware})
+	c.Assert(result, gc.Equals, transport)
+	c.Check(result.MaxIdleConnsPerHost, gc.Equals, 42)
+
+	// Another transport returned by a middleware is cloned, as it may be
+	// shared.
+	shared := DefaultHTTPTransport()
+	result = applyTransportMiddlewares(transport, []TransportMiddleware{
+		func(*http.Transport) *http.Transport { return shared },
+	})
+	c.Check(result, gc.Not(gc.Equals), shared)
+	c.Check(result, gc.Not(gc.Equals), transport)
+}
+
+func (s *clientSuite) TestConnectionReuse(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	client := NewClient(
+		WithBaseRoundTripper(DefaultHTTPTransport()),
+		WithTransportMiddlewares(ProxyMiddleware),
+	)
+	var reused []bool
+	for i := 0; i < 3; i++ {
+		ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				reused = append(reused, info.Reused)
+			},
+		})
+		resp, err := client.Get(ctx, server.URL)
+		c.Assert(err, jc.ErrorIsNil)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	}
+	c.Check(reused, jc.DeepEquals, []bool{false, true, true})
+}
+
+func (s *clientSuite) TestInvalidTLSSettings(c *gc.C) {
+	tests := []struct {
+		about  string
+		option Option
+		err    string
+	}{{
+		about:  "minimum below floor",
+		option: WithMinimumTLSVersion(tls.VersionTLS10),
+		err:    `.*minimum TLS version TLS 1.0 not valid`,
+	}, {
+		about:  "maximum below floor",
+		option: WithMaximumTLSVersion(tls.VersionTLS11),
+		err:    `.*maximum TLS version TLS 1.1 not valid`,
+	}, {
+		about:  "insecure cipher suite",
+		option: WithCipherSuites(tls.TLS_RSA_WITH_RC4_128_SHA),
+		err:    `.*cipher suite TLS_RSA_WITH_RC4_128_SHA not valid`,
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.about)
+		client := NewClient(test.option)
+		_, err := client.Get(context.TODO(), "http://0.1.2.3:1234")
+		c.Assert(err, gc.ErrorMatches, test.err)
+		c.Assert(errors.Is(err, errors.NotValid), jc.IsTrue)
+	}
+}
+
+func (s *clientSuite) TestInvalidTLSVersionRange(c *gc.C) {
+	client := NewClient(
+		WithMinimumTLSVersion(tls.VersionTLS13),
+		WithMaximumTLSVersion(tls.VersionTLS12),
+	)
+	_, err := client.Get(context.TODO(), "http://0.1.2.3:1234")
+	c.Assert(err, gc.ErrorMatches, `.*maximum TLS version TLS 1.2 lower than minimum TLS version TLS 1.3 not valid`)
+}
+
+func (s *httpSuite) TestStatsWithRetryBudget(c *gc.C) {
+	dummyServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusBadGateway)
+	}))
+	defer dummyServer.Close()
+
+	budget, err := NewRetryBudget(RetryBudgetConfig{
+		MinRetries: 2,
+		Window:     time.Minute,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+
+	client := NewClient(
+		WithRequestRetrier(RetryPolicy{
+			Delay:    time.Nanosecond,
+			Attempts: 5,
+			MaxDelay: time.Minute,
+			Budget:   budget,
+		}),
+	)
+	resp, err := client.Get(context.TODO(), dummyServer.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadGateway)
+
+	stats := client.Stats()
+	c.Assert(stats.Requests, gc.Equals, int64(1))
+	c.Assert(stats.Retries, gc.Equals, int64(2))
+	c.Assert(stats.RetryBudget, jc.DeepEquals, &RetryBudgetStats{
+		Requests: 1,
+		Retries:  2,
+		Denied:   1,
+	})
+}
@@ -0,0 +1,179 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// RequestCompression configures the gzip compression of request bodies.
+type RequestCompression struct {
+	// MinSize is the minimum size of a request body to compress. Bodies of
+	// unknown size are not compressed.
+	MinSize int64
+
+	// Hosts are patterns, using path.Match syntax, matching the host names
+	// of servers known to accept gzip encoded request bodies.
+	Hosts []string
+
+	// Probe, if true, checks whether servers not matched by Hosts accept
+	// gzip encoded request bodies, using an OPTIONS request. A server that
+	// lists gzip in the Accept-Encoding header of its response is sent
+	// compressed bodies. The result is cached per host.
+	Probe bool
+}
+
+// Validate validates the RequestCompression for any issues.
+func (c RequestCompression) Validate() error {
+	if c.MinSize < 0 {
+		return errors.NotValidf("negative minimum size")
+	}
+	for _, host := range c.Hosts {
+		if _, err := path.Match(host, ""); err != nil {
+			return errors.NotValidf("host pattern %q", host)
+		}
+	}
+	return nil
+}
+
+// WithRequestCompression gzip compresses the bodies of POST, PUT and PATCH
+// requests to servers that accept it, cutting upload times for large text
+// payloads such as bundle exports and log batches.
+func WithRequestCompression(config RequestCompression) Option {
+	return func(opt *options) {
+		opt.requestCompression = &config
+	}
+}
+
+// compressionTransport compresses request bodies.
+type compressionTransport struct {
+	wrappedRoundTripper http.RoundTripper
+	config              RequestCompression
+
+	mu     *sync.Mutex
+	probed map[string]bool
+}
+
+func newCompressionTransport(transport http.RoundTripper, config RequestCompression) compressionTransport {
+	return compressionTransport{
+		wrappedRoundTripper: transport,
+		config:              config,
+		mu:                  &sync.Mutex{},
+		probed:              make(map[string]bool),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t compressionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.compressible(req) || !t.accepts(req) {
+		return t.wrappedRoundTripper.RoundTrip(req)
+	}
+
+	compressed := req.Clone(req.Context())
+	compressed.Body = gzipReader(req.Body)
+	compressed.ContentLength = -1
+	compressed.Header.Set("Content-Encoding", "gzip")
+	compressed.Header.Del("Content-Length")
+	if req.GetBody != nil {
+		compressed.GetBody = func() (io.ReadCloser, error) {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			return gzipReader(body), nil
+		}
+	}
+	return t.wrappedRoundTripper.RoundTrip(compressed)
+}
+
+// compressible reports whether the request has a body that should be
+// compressed.
+func (t compressionTransport) compressible(req *http.Request) bool {
+	switch req.Method {
+	case "POST", "PUT", "PATCH":
+	default:
+		return false
+	}
+	return req.Body != nil && req.Body != http.NoBody &&
+		req.ContentLength > 0 && req.ContentLength >= t.config.MinSize &&
+		req.Header.Get("Content-Encoding") == ""
+}
+
+// accepts reports whether the server accepts gzip encoded request bodies.
+func (t compressionTransport) accepts(req *http.Request) bool {
+	host := strings.ToLower(req.URL.Hostname())
+	for _, pattern := range t.config.Hosts {
+		if matched, _ := path.Match(strings.ToLower(pattern), host); matched {
+			return true
+		}
+	}
+	if !t.config.Probe {
+		return false
+	}
+
+	t.mu.Lock()
+	accepts, ok := t.probed[req.URL.Host]
+	t.mu.Unlock()
+	if ok {
+		return accepts
+	}
+
+	accepts, err := t.probe(req)
+	if err != nil {
+		// Don't cache the result, the probe is retried by the next request.
+		return false
+	}
+	t.mu.Lock()
+	t.probed[req.URL.Host] = accepts
+	t.mu.Unlock()
+	return accepts
+}
+
+// probe sends an OPTIONS request to the server, checking whether gzip is
+// listed in the Accept-Encoding header of the response.
+func (t compressionTransport) probe(req *http.Request) (bool, error) {
+	probe, err := http.NewRequestWithContext(req.Context(), "OPTIONS", req.URL.String(), nil)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	res, err := t.wrappedRoundTripper.RoundTrip(probe)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	_ = res.Body.Close()
+
+	for _, value := range res.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			coding, _, _ = strings.Cut(coding, ";")
+			if strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// gzipReader returns a reader of the gzip compressed body, compressing it
+// as it is read.
+func gzipReader(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+		zw := gzip.NewWriter(pw)
+		_, err := copyBuffer(zw, body)
+		if err == nil {
+			err = zw.Close()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	return pr
+}
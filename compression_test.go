@@ -0,0 +1,136 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type compressionSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&compressionSuite{})
+
+// compressionServer returns a server that replies with the encoding and
+// the decoded body of each request it receives.
+func compressionServer(c *gc.C, acceptEncoding string, options *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			atomic.AddInt32(options, 1)
+			if acceptEncoding != "" {
+				w.Header().Set("Accept-Encoding", acceptEncoding)
+			}
+			return
+		}
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			c.Check(err, jc.ErrorIsNil)
+			body = zr
+		}
+		data, err := io.ReadAll(body)
+		c.Check(err, jc.ErrorIsNil)
+		_, _ = io.WriteString(w, r.Header.Get("Content-Encoding")+":"+string(data))
+	}))
+}
+
+func (s *compressionSuite) post(c *gc.C, client *Client, url, body string) string {
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	c.Assert(err, jc.ErrorIsNil)
+	resp, err := client.Do(req)
+	c.Assert(err, jc.ErrorIsNil)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	return string(data)
+}
+
+func (s *compressionSuite) TestCompressesKnownHost(c *gc.C) {
+	var options int32
+	server := compressionServer(c, "", &options)
+	defer server.Close()
+
+	client := NewClient(WithRequestCompression(RequestCompression{
+		MinSize: 10,
+		Hosts:   []string{"127.0.0.*"},
+	}))
+	body := strings.Repeat("log line\n", 100)
+	c.Assert(s.post(c, client, server.URL, body), gc.Equals, "gzip:"+body)
+	c.Assert(atomic.LoadInt32(&options), gc.Equals, int32(0))
+}
+
+func (s *compressionSuite) TestSmallBodyNotCompressed(c *gc.C) {
+	var options int32
+	server := compressionServer(c, "", &options)
+	defer server.Close()
+
+	client := NewClient(WithRequestCompression(RequestCompression{
+		MinSize: 1024,
+		Hosts:   []string{"*"},
+	}))
+	c.Assert(s.post(c, client, server.URL, "small"), gc.Equals, ":small")
+}
+
+func (s *compressionSuite) TestUnknownHostNotCompressed(c *gc.C) {
+	var options int32
+	server := compressionServer(c, "gzip", &options)
+	defer server.Close()
+
+	client := NewClient(WithRequestCompression(RequestCompression{
+		Hosts: []string{"example.com"},
+	}))
+	c.Assert(s.post(c, client, server.URL, "body"), gc.Equals, ":body")
+	c.Assert(atomic.LoadInt32(&options), gc.Equals, int32(0))
+}
+
+func (s *compressionSuite) TestProbe(c *gc.C) {
+	var options int32
+	server := compressionServer(c, "deflate, gzip;q=0.5", &options)
+	defer server.Close()
+
+	client := NewClient(WithRequestCompression(RequestCompression{
+		Probe: true,
+	}))
+	c.Assert(s.post(c, client, server.URL, "first"), gc.Equals, "gzip:first")
+	c.Assert(s.post(c, client, server.URL, "second"), gc.Equals, "gzip:second")
+	c.Assert(atomic.LoadInt32(&options), gc.Equals, int32(1))
+}
+
+func (s *compressionSuite) TestProbeNotAccepted(c *gc.C) {
+	var options int32
+	server := compressionServer(c, "identity", &options)
+	defer server.Close()
+
+	client := NewClient(WithRequestCompression(RequestCompression{
+		Probe: true,
+	}))
+	c.Assert(s.post(c, client, server.URL, "first"), gc.Equals, ":first")
+	c.Assert(s.post(c, client, server.URL, "second"), gc.Equals, ":second")
+	c.Assert(atomic.LoadInt32(&options), gc.Equals, int32(1))
+}
+
+func (s *compressionSuite) TestInvalidConfig(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	client := NewClient(
+		WithLogger(logger(ctrl)),
+		WithRequestCompression(RequestCompression{MinSize: -1}),
+	)
+	req, err := http.NewRequest("POST", "http://example.com", strings.NewReader("body"))
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = client.Do(req)
+	c.Assert(err, gc.ErrorMatches, `.*request compression: negative minimum size not valid`)
+}
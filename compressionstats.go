@@ -0,0 +1,157 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// CompressionStats describes the compression of a response body.
+type CompressionStats struct {
+	// Encoding is the content encoding of the response, such as "gzip".
+	Encoding string
+	// CompressedBytes is the number of bytes of the body read from the
+	// wire.
+	CompressedBytes int64
+	// DecompressedBytes is the number of bytes of the body after
+	// decompression.
+	DecompressedBytes int64
+}
+
+// CompressionRecorder is an optional extension of RequestRecorder. If the
+// client's RequestRecorder implements it, the compressed and decompressed
+// sizes of every compressed response body are recorded once it is closed.
+// Only responses compressed because the client asked for it, rather than
+// because the caller set an Accept-Encoding header, are recorded.
+type CompressionRecorder interface {
+	// RecordCompression records the compression of the response body of a
+	// request.
+	RecordCompression(method string, url *url.URL, stats CompressionStats)
+}
+
+// decompressingTransport asks for gzip compressed responses and
+// decompresses them, as http.Transport does, but counting the bytes before
+// and after decompression, which http.Transport doesn't expose.
+type decompressingTransport struct {
+	wrappedRoundTripper http.RoundTripper
+	snapshot            *clientSnapshot
+	hooks               hookRunner
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t decompressingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	state := t.snapshot.load()
+	recorder, _ := requestRecorder(req.Context(), state.recorder).(CompressionRecorder)
+	// The same requests http.Transport asks for compressed responses for.
+	if recorder == nil || req.Method == http.MethodHead ||
+		req.Header.Get("Accept-Encoding") != "" || req.Header.Get("Range") != "" {
+		return t.wrappedRoundTripper.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := t.wrappedRoundTripper.RoundTrip(req)
+	if err != nil || res.Body == nil || res.Header.Get("Content-Encoding") != "gzip" {
+		return res, err
+	}
+
+	decompressed := *res
+	decompressed.Header = res.Header.Clone()
+	decompressed.Header.Del("Content-Encoding")
+	decompressed.Header.Del("Content-Length")
+	decompressed.ContentLength = -1
+	decompressed.Uncompressed = true
+	decompressed.Body = &decompressingBody{
+		body:     res.Body,
+		method:   req.Method,
+		url:      req.URL,
+		encoding: "gzip",
+		decode:   decodeGzip,
+		recorder: recorder,
+		logger:   requestLogger(req.Context(), state.logger),
+		hooks:    t.hooks,
+	}
+	return &decompressed, nil
+}
+
+// decompressingBody decompresses a compressed response body, counting the
+// bytes read from the wire, and reporting to the recorder, if any, when it
+// is closed.
+type decompressingBody struct {
+	body     io.ReadCloser
+	method   string
+	url      *url.URL
+	encoding string
+	decode   ContentDecoder
+
+	recorder CompressionRecorder
+	logger   Logger
+	hooks    hookRunner
+
+	mu     sync.Mutex
+	reader io.ReadCloser
+	stats  CompressionStats
+	done   bool
+}
+
+// Read implements io.Reader.
+func (b *decompressingBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.reader == nil {
+		// The header of the stream is read lazily, as http.Transport does,
+		// so that a failure is reported when the body is read.
+		reader, err := b.decode(wireCounter{body: b.body, stats: &b.stats})
+		if err != nil {
+			return 0, err
+		}
+		b.reader = reader
+	}
+	n, err := b.reader.Read(p)
+	b.stats.DecompressedBytes += int64(n)
+	return n, err
+}
+
+// Close implements io.Closer.
+func (b *decompressingBody) Close() error {
+	err := b.body.Close()
+
+	b.mu.Lock()
+	if b.done {
+		b.mu.Unlock()
+		return err
+	}
+	b.done = true
+	if b.reader != nil {
+		_ = b.reader.Close()
+	}
+	stats := b.stats
+	b.mu.Unlock()
+
+	if b.recorder == nil {
+		return err
+	}
+	stats.Encoding = b.encoding
+	b.hooks.run(b.logger, "compression recorder", func() {
+		b.recorder.RecordCompression(b.method, b.url, stats)
+	})
+	return err
+}
+
+// wireCounter counts the compressed bytes read from a body. It is only
+// used with the lock of its decompressingBody held.
+type wireCounter struct {
+	body  io.Reader
+	stats *CompressionStats
+}
+
+// Read implements io.Reader.
+func (c wireCounter) Read(p []byte) (int, error) {
+	n, err := c.body.Read(p)
+	c.stats.CompressedBytes += int64(n)
+	return n, err
+}
@@ -0,0 +1,132 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type compressionStatsSuite struct {
+	testing.IsolationSuite
+
+	server *httptest.Server
+	body   string
+}
+
+var _ = gc.Suite(&compressionStatsSuite{})
+
+func (s *compressionStatsSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.body = strings.Repeat("juju charm ", 1000)
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			_, _ = io.WriteString(w, s.body)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		_, _ = io.WriteString(zw, s.body)
+		_ = zw.Close()
+	}))
+}
+
+func (s *compressionStatsSuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+	s.IsolationSuite.TearDownTest(c)
+}
+
+type compressionRecorder struct {
+	stats []CompressionStats
+}
+
+func (r *compressionRecorder) Record(string, *url.URL, *http.Response, time.Duration) {}
+
+func (r *compressionRecorder) RecordError(string, *url.URL, error) {}
+
+func (r *compressionRecorder) RecordCompression(_ string, _ *url.URL, stats CompressionStats) {
+	r.stats = append(r.stats, stats)
+}
+
+func (s *compressionStatsSuite) TestRecordCompression(c *gc.C) {
+	recorder := &compressionRecorder{}
+	client := NewClient(WithRequestRecorder(recorder))
+
+	resp, err := client.Get(context.Background(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(resp.Uncompressed, jc.IsTrue)
+	c.Check(resp.Header.Get("Content-Encoding"), gc.Equals, "")
+	c.Check(resp.ContentLength, gc.Equals, int64(-1))
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(body), gc.Equals, s.body)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+
+	c.Assert(recorder.stats, gc.HasLen, 1)
+	stats := recorder.stats[0]
+	c.Check(stats.Encoding, gc.Equals, "gzip")
+	c.Check(stats.DecompressedBytes, gc.Equals, int64(len(s.body)))
+	c.Check(stats.CompressedBytes > 0, jc.IsTrue)
+	c.Check(stats.CompressedBytes < stats.DecompressedBytes, jc.IsTrue)
+
+	// Closing again doesn't record again.
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Check(recorder.stats, gc.HasLen, 1)
+}
+
+func (s *compressionStatsSuite) TestCallerAcceptEncoding(c *gc.C) {
+	recorder := &compressionRecorder{}
+	client := NewClient(WithRequestRecorder(recorder))
+
+	req, err := http.NewRequest("GET", s.server.URL, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := client.Do(req)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(resp.Header.Get("Content-Encoding"), gc.Equals, "gzip")
+	_, _ = io.Copy(io.Discard, resp.Body)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Check(recorder.stats, gc.HasLen, 0)
+}
+
+func (s *compressionStatsSuite) TestWithoutCompressionRecorder(c *gc.C) {
+	client := NewClient(WithRequestRecorder(&bodyRecorder{bodies: make(chan BodyStats, 1)}))
+
+	resp, err := client.Get(context.Background(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	// http.Transport decompresses the body itself.
+	c.Check(string(body), gc.Equals, s.body)
+	c.Check(resp.Uncompressed, jc.IsTrue)
+}
+
+func (s *compressionStatsSuite) TestInvalidGzip(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = io.WriteString(w, "not a gzip stream")
+	}))
+	defer server.Close()
+
+	recorder := &compressionRecorder{}
+	client := NewClient(WithRequestRecorder(recorder))
+	resp, err := client.Get(context.Background(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = io.ReadAll(resp.Body)
+	c.Check(err, gc.ErrorMatches, "gzip: invalid header")
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Assert(recorder.stats, gc.HasLen, 1)
+	c.Check(recorder.stats[0].DecompressedBytes, gc.Equals, int64(0))
+}
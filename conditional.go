@@ -0,0 +1,86 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/juju/errors"
+)
+
+// PreconditionFailedError is returned when a conditional request is rejected
+// by the server with a 412 Precondition Failed, indicating that the resource
+// was modified since the ETag was obtained.
+type PreconditionFailedError struct {
+	// Method is the method of the rejected request.
+	Method string
+	// URL is the URL of the rejected request.
+	URL string
+	// ETag is the entity tag that was sent with the If-Match header.
+	ETag string
+	// CurrentETag is the entity tag of the resource as reported by the
+	// server, if any.
+	CurrentETag string
+}
+
+// Error implements error.
+func (e *PreconditionFailedError) Error() string {
+	return fmt.Sprintf("%s %s: precondition failed for etag %s", e.Method, e.URL, e.ETag)
+}
+
+// IsPreconditionFailed returns true if the error, or any error in its chain,
+// is a PreconditionFailedError.
+func IsPreconditionFailed(err error) bool {
+	_, ok := errors.AsType[*PreconditionFailedError](err)
+	return ok
+}
+
+// ETag returns the entity tag of the response, or an empty string if the
+// response has no ETag header.
+func ETag(resp *http.Response) string {
+	if resp == nil {
+		return ""
+	}
+	return resp.Header.Get("ETag")
+}
+
+// SetIfMatch sets the If-Match header of the request to the given entity tag.
+// An empty etag removes any existing If-Match header.
+func SetIfMatch(req *http.Request, etag string) {
+	if etag == "" {
+		req.Header.Del("If-Match")
+		return
+	}
+	req.Header.Set("If-Match", etag)
+}
+
+// DoIfMatch sends the request with an If-Match header carrying the given
+// entity tag, typically obtained via ETag from a previous GET. If the server
+// rejects the request with a 412 Precondition Failed, the response body is
+// drained and closed and a *PreconditionFailedError is returned.
+func (c *Client) DoIfMatch(req *http.Request, etag string) (*http.Response, error) {
+	if etag == "" {
+		return nil, errors.NotValidf("empty etag")
+	}
+	SetIfMatch(req, etag)
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		return resp, nil
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return nil, &PreconditionFailedError{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		ETag:        etag,
+		CurrentETag: ETag(resp),
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type conditionalSuite struct {
+	testing.IsolationSuite
+	server *httptest.Server
+}
+
+var _ = gc.Suite(&conditionalSuite{})
+
+func (s *conditionalSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v2"`)
+		if r.Method == "PUT" && r.Header.Get("If-Match") != `"v2"` {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func (s *conditionalSuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+	s.IsolationSuite.TearDownTest(c)
+}
+
+func (s *conditionalSuite) TestETagRoundTrip(c *gc.C) {
+	client := NewClient()
+	resp, err := client.Get(context.TODO(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	etag := ETag(resp)
+	c.Assert(etag, gc.Equals, `"v2"`)
+
+	req, err := http.NewRequestWithContext(context.TODO(), "PUT", s.server.URL, strings.NewReader("data"))
+	c.Assert(err, jc.ErrorIsNil)
+	resp, err = client.DoIfMatch(req, etag)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+}
+
+func (s *conditionalSuite) TestPreconditionFailed(c *gc.C) {
+	client := NewClient()
+	req, err := http.NewRequestWithContext(context.TODO(), "PUT", s.server.URL, strings.NewReader("data"))
+	c.Assert(err, jc.ErrorIsNil)
+
+	_, err = client.DoIfMatch(req, `"v1"`)
+	c.Assert(err, gc.ErrorMatches, `PUT .*: precondition failed for etag "v1"`)
+	c.Assert(IsPreconditionFailed(err), jc.IsTrue)
+
+	pErr, ok := errors.AsType[*PreconditionFailedError](err)
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(pErr.CurrentETag, gc.Equals, `"v2"`)
+}
+
+func (s *conditionalSuite) TestEmptyETag(c *gc.C) {
+	client := NewClient()
+	req, err := http.NewRequestWithContext(context.TODO(), "PUT", s.server.URL, nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	_, err = client.DoIfMatch(req, "")
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+}
@@ -0,0 +1,186 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+	"golang.org/x/net/publicsuffix"
+)
+
+// StoreCookieJar is an http.CookieJar that persists cookies in a KVStore,
+// so that they survive a restart of the embedding process. Only cookies
+// with an expiry are persisted, session cookies are kept in memory. The
+// cookies of a host are loaded from the store the first time the host is
+// used.
+type StoreCookieJar struct {
+	jar   *cookiejar.Jar
+	store KVStore
+	clock clock.Clock
+
+	mu     sync.Mutex
+	loaded map[string]bool
+}
+
+// storedCookie is a cookie persisted in the store, with the URL it was set
+// for, so that the default domain and path of the cookie are preserved.
+type storedCookie struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+// NewStoreCookieJar creates a StoreCookieJar that persists cookies in the
+// store, using the public suffix list to prevent cookies being set for a
+// whole top level domain.
+func NewStoreCookieJar(store KVStore, clk clock.Clock) (*StoreCookieJar, error) {
+	jar, err := cookiejar.New(&cookiejar.Options{
+		PublicSuffixList: publicsuffix.List,
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &StoreCookieJar{
+		jar:    jar,
+		store:  store,
+		clock:  clk,
+		loaded: make(map[string]bool),
+	}, nil
+}
+
+// Cookies implements http.CookieJar.
+func (j *StoreCookieJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.load(u)
+	return j.jar.Cookies(u)
+}
+
+// SetCookies implements http.CookieJar.
+func (j *StoreCookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.load(u)
+	j.jar.SetCookies(u, cookies)
+
+	stored := j.read(u)
+	now := j.clock.Now()
+	for _, cookie := range cookies {
+		stored = removeCookie(stored, cookie)
+		if cookie.MaxAge < 0 {
+			continue
+		}
+		persisted := *cookie
+		if persisted.MaxAge > 0 {
+			persisted.Expires = now.Add(time.Duration(persisted.MaxAge) * time.Second)
+			persisted.MaxAge = 0
+		}
+		if persisted.Expires.IsZero() || !persisted.Expires.After(now) {
+			continue
+		}
+		stored = append(stored, storedCookie{
+			URL:    u.String(),
+			Cookie: &persisted,
+		})
+	}
+	j.write(u, stored)
+}
+
+// load loads the cookies of the host of u from the store into the jar, the
+// first time the host is used. It must be called with the mutex held.
+func (j *StoreCookieJar) load(u *url.URL) {
+	key := cookieKey(u)
+	if j.loaded[key] {
+		return
+	}
+	j.loaded[key] = true
+
+	for _, stored := range j.read(u) {
+		storedURL, err := url.Parse(stored.URL)
+		if err != nil {
+			continue
+		}
+		j.jar.SetCookies(storedURL, []*http.Cookie{stored.Cookie})
+	}
+}
+
+// read returns the unexpired cookies persisted for the host of u. Cookies
+// that can't be read are dropped, as they are only a cache of the server
+// state.
+func (j *StoreCookieJar) read(u *url.URL) []storedCookie {
+	data, err := j.store.Get(cookieKey(u))
+	if err != nil {
+		if !errors.Is(err, errors.NotFound) {
+			midLogger.Debugf("reading cookies for %s: %v", u.Hostname(), err)
+		}
+		return nil
+	}
+	var stored []storedCookie
+	if err := json.Unmarshal(data, &stored); err != nil {
+		midLogger.Debugf("decoding cookies for %s: %v", u.Hostname(), err)
+		return nil
+	}
+	now := j.clock.Now()
+	unexpired := stored[:0]
+	for _, cookie := range stored {
+		if cookie.Cookie != nil && cookie.Cookie.Expires.After(now) {
+			unexpired = append(unexpired, cookie)
+		}
+	}
+	return unexpired
+}
+
+// write persists the cookies for the host of u, expiring the entry with
+// the last of the cookies.
+func (j *StoreCookieJar) write(u *url.URL, stored []storedCookie) {
+	key := cookieKey(u)
+	if len(stored) == 0 {
+		if err := j.store.Delete(key); err != nil {
+			midLogger.Debugf("deleting cookies for %s: %v", u.Hostname(), err)
+		}
+		return
+	}
+	var expires time.Time
+	for _, cookie := range stored {
+		if cookie.Cookie.Expires.After(expires) {
+			expires = cookie.Cookie.Expires
+		}
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		midLogger.Debugf("encoding cookies for %s: %v", u.Hostname(), err)
+		return
+	}
+	if err := j.store.Set(key, data, expires.Sub(j.clock.Now())); err != nil {
+		midLogger.Debugf("writing cookies for %s: %v", u.Hostname(), err)
+	}
+}
+
+// removeCookie removes any cookie replaced by the cookie, which has the
+// same name, domain and path.
+func removeCookie(stored []storedCookie, cookie *http.Cookie) []storedCookie {
+	kept := stored[:0]
+	for _, s := range stored {
+		if s.Cookie.Name == cookie.Name &&
+			strings.EqualFold(s.Cookie.Domain, cookie.Domain) &&
+			s.Cookie.Path == cookie.Path {
+			continue
+		}
+		kept = append(kept, s)
+	}
+	return kept
+}
+
+func cookieKey(u *url.URL) string {
+	return "cookies/" + strings.ToLower(u.Hostname())
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/juju/clock/testclock"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type cookieJarSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&cookieJarSuite{})
+
+func (s *cookieJarSuite) TestPersisted(c *gc.C) {
+	clk := testclock.NewClock(time.Now())
+	store := NewMemoryStore(clk)
+	u, err := url.Parse("https://controller.example.com/api")
+	c.Assert(err, jc.ErrorIsNil)
+
+	jar, err := NewStoreCookieJar(store, clk)
+	c.Assert(err, jc.ErrorIsNil)
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "macaroon", Value: "m1", MaxAge: 3600},
+		{Name: "expires", Value: "e1", Expires: clk.Now().Add(time.Minute)},
+		{Name: "session", Value: "s1"},
+	})
+	c.Assert(cookieNames(jar.Cookies(u)), jc.SameContents, []string{"macaroon", "expires", "session"})
+
+	// A new jar, as after a restart, only has the persistent cookies.
+	jar, err = NewStoreCookieJar(store, clk)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cookieNames(jar.Cookies(u)), jc.SameContents, []string{"macaroon", "expires"})
+
+	clk.Advance(2 * time.Minute)
+	jar, err = NewStoreCookieJar(store, clk)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cookieNames(jar.Cookies(u)), jc.SameContents, []string{"macaroon"})
+}
+
+func (s *cookieJarSuite) TestDeleted(c *gc.C) {
+	clk := testclock.NewClock(time.Now())
+	store := NewMemoryStore(clk)
+	u, err := url.Parse("https://controller.example.com/api")
+	c.Assert(err, jc.ErrorIsNil)
+
+	jar, err := NewStoreCookieJar(store, clk)
+	c.Assert(err, jc.ErrorIsNil)
+	jar.SetCookies(u, []*http.Cookie{{Name: "macaroon", Value: "m1", MaxAge: 3600}})
+	jar.SetCookies(u, []*http.Cookie{{Name: "macaroon", MaxAge: -1}})
+	c.Assert(jar.Cookies(u), gc.HasLen, 0)
+
+	jar, err = NewStoreCookieJar(store, clk)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(jar.Cookies(u), gc.HasLen, 0)
+}
+
+func (s *cookieJarSuite) TestClient(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("login"); err != nil {
+			http.SetCookie(w, &http.Cookie{Name: "login", Value: "yes", MaxAge: 60})
+			return
+		}
+		w.Header().Set("X-Logged-In", "true")
+	}))
+	defer server.Close()
+
+	clk := testclock.NewClock(time.Now())
+	store := NewMemoryStore(clk)
+
+	get := func() *http.Response {
+		jar, err := NewStoreCookieJar(store, clk)
+		c.Assert(err, jc.ErrorIsNil)
+		resp, err := NewClient(WithCookieJar(jar)).Get(context.TODO(), server.URL)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+		return resp
+	}
+	c.Assert(get().Header.Get("X-Logged-In"), gc.Equals, "")
+	c.Assert(get().Header.Get("X-Logged-In"), gc.Equals, "true")
+}
+
+func cookieNames(cookies []*http.Cookie) []string {
+	var names []string
+	for _, cookie := range cookies {
+		names = append(names, cookie.Name)
+	}
+	return names
+}
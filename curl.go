@@ -0,0 +1,155 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// redactedValue replaces the value of credentials in curl commands.
+const redactedValue = "REDACTED"
+
+// WithCurlCommandLogging logs an equivalent curl command for every attempt
+// of every request when trace logging is enabled, so that problems with
+// charmhub or cloud APIs reported by users can be reproduced outside juju.
+//
+// Credentials are redacted: the password of the URL, the Authorization,
+// Proxy-Authorization and Cookie headers, and headers whose names mention
+// a token, secret or API key. Bodies are included according to the
+// DumpConfig of the client, but never truncated, as a truncated body
+// doesn't reproduce the request. Omitted bodies are noted in a comment.
+func WithCurlCommandLogging(value bool) Option {
+	return func(opt *options) {
+		opt.curlCommandLogging = value
+	}
+}
+
+// curlTransport logs the curl command of each request.
+type curlTransport struct {
+	wrappedRoundTripper http.RoundTripper
+	snapshot            *clientSnapshot
+	dumpConfig          DumpConfig
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t curlTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	logger := requestLogger(req.Context(), t.snapshot.load().logger)
+	if !logger.IsTraceEnabled() {
+		return t.wrappedRoundTripper.RoundTrip(req)
+	}
+	command, body, err := curlCommand(req, t.dumpConfig)
+	if err != nil {
+		logger.Tracef("curl command for %s: %v", req.URL.Redacted(), err)
+	} else {
+		logger.Tracef("curl command: %s", command)
+	}
+	if body != req.Body {
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+	return t.wrappedRoundTripper.RoundTrip(req)
+}
+
+// curlCommand returns a curl command equivalent to the request, and the
+// body to send the request with, in place of the body partially read to
+// build the command.
+func curlCommand(req *http.Request, config DumpConfig) (string, io.ReadCloser, error) {
+	args := []string{"curl", "-X", req.Method}
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			if isCredentialHeader(name) {
+				value = redactedValue
+			}
+			args = append(args, "-H", shellQuote(name+": "+value))
+		}
+	}
+	if req.Host != "" && req.Host != req.URL.Host {
+		args = append(args, "-H", shellQuote("Host: "+req.Host))
+	}
+
+	body := req.Body
+	var note string
+	if body != nil && body != http.NoBody {
+		var data []byte
+		var err error
+		data, body, note, err = curlBody(req, config)
+		if err != nil {
+			return "", body, errors.Trace(err)
+		}
+		if note == "" {
+			args = append(args, "--data-binary", shellQuote(string(data)))
+		}
+	}
+
+	args = append(args, shellQuote(req.URL.Redacted()))
+	command := strings.Join(args, " ")
+	if note != "" {
+		command += " # body " + note
+	}
+	return command, body, nil
+}
+
+// curlBody returns the body of the request if it should be included in
+// the curl command, or a note on why it isn't, along with the body to send.
+func curlBody(req *http.Request, config DumpConfig) ([]byte, io.ReadCloser, string, error) {
+	contentType := req.Header.Get("Content-Type")
+	switch {
+	case config.MaxBodyBytes == 0:
+		return nil, req.Body, "omitted", nil
+	case !config.DumpBinary && !isTextualContentType(contentType):
+		return nil, req.Body, fmt.Sprintf("omitted, content type %q", contentType), nil
+	case config.MaxBodyBytes > 0 && req.ContentLength > config.MaxBodyBytes:
+		return nil, req.Body, fmt.Sprintf("omitted, %d bytes", req.ContentLength), nil
+	}
+
+	// Read one byte more than the limit so that we know if the body is too
+	// long to include.
+	limit := int64(-1)
+	if config.MaxBodyBytes > 0 {
+		limit = config.MaxBodyBytes + 1
+	}
+	pooled, err := readPrefix(req.Body, limit)
+	body := readCloser{
+		Reader: io.MultiReader(pooled.reader(), req.Body),
+		Closer: req.Body,
+	}
+	prefix := pooled.bytes()
+	if err != nil {
+		return nil, body, "", errors.Trace(err)
+	}
+	if config.MaxBodyBytes > 0 && int64(len(prefix)) > config.MaxBodyBytes {
+		return nil, body, fmt.Sprintf("omitted, longer than %d bytes", config.MaxBodyBytes), nil
+	}
+	return prefix, body, "", nil
+}
+
+// isCredentialHeader returns true for headers whose values are redacted.
+func isCredentialHeader(name string) bool {
+	for _, header := range credentialHeaders {
+		if strings.EqualFold(name, header) {
+			return true
+		}
+	}
+	name = strings.ToLower(name)
+	return strings.Contains(name, "token") ||
+		strings.Contains(name, "secret") ||
+		strings.Contains(name, "api-key")
+}
+
+// shellQuote quotes the value for a POSIX shell.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
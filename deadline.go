@@ -0,0 +1,114 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/juju/errors"
+)
+
+// DeadlineCheck selects how a client treats requests whose context has no
+// deadline, see WithDeadlineCheck.
+type DeadlineCheck int
+
+const (
+	// DeadlineCheckOff sends requests without a deadline. It is the
+	// default.
+	DeadlineCheckOff DeadlineCheck = iota
+	// DeadlineCheckLog logs an error, with the stack of the goroutine that
+	// made the request, for every request without a deadline, and sends
+	// it.
+	DeadlineCheckLog
+	// DeadlineCheckReject fails every request without a deadline with a
+	// *MissingDeadlineError, and is intended for tests.
+	DeadlineCheckReject
+)
+
+var deadlineCheckNames = map[DeadlineCheck]string{
+	DeadlineCheckOff:    "off",
+	DeadlineCheckLog:    "log",
+	DeadlineCheckReject: "reject",
+}
+
+// String returns the name of the deadline check.
+func (d DeadlineCheck) String() string {
+	if name, ok := deadlineCheckNames[d]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Validate validates the DeadlineCheck for any issues.
+func (d DeadlineCheck) Validate() error {
+	if _, ok := deadlineCheckNames[d]; !ok {
+		return errors.NotValidf("deadline check %d", int(d))
+	}
+	return nil
+}
+
+// ErrMissingDeadline is the error a *MissingDeadlineError matches with
+// errors.Is.
+const ErrMissingDeadline = errors.ConstError("request without deadline")
+
+// MissingDeadlineError is returned for a request whose context has no
+// deadline by a client rejecting them, see WithDeadlineCheck.
+type MissingDeadlineError struct {
+	// Method is the method of the rejected request.
+	Method string
+	// URL is the URL of the rejected request.
+	URL string
+}
+
+// Error implements error.
+func (e *MissingDeadlineError) Error() string {
+	return fmt.Sprintf("request %s %s without a context deadline", e.Method, e.URL)
+}
+
+// Is returns true if the target is ErrMissingDeadline.
+func (e *MissingDeadlineError) Is(target error) bool {
+	return target == ErrMissingDeadline
+}
+
+// WithDeadlineCheck checks that every request made by the client has a
+// context with a deadline, to root out unbounded requests, such as those
+// made with context.Background(), which can leave workers stuck forever on
+// an unresponsive server. Requests without a deadline are logged or
+// rejected, depending on the check. The client's own timeout, see
+// WithTimeout, counts as a deadline, and redirects are checked only once,
+// with the request that led to them.
+func WithDeadlineCheck(value DeadlineCheck) Option {
+	return func(opt *options) {
+		opt.deadlineCheck = value
+	}
+}
+
+// deadlineCheckTransport logs or rejects requests whose context has no
+// deadline.
+type deadlineCheckTransport struct {
+	wrappedRoundTripper http.RoundTripper
+	snapshot            *clientSnapshot
+	check               DeadlineCheck
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t deadlineCheckTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if _, ok := req.Context().Deadline(); ok || req.Response != nil {
+		return t.wrappedRoundTripper.RoundTrip(req)
+	}
+	err := &MissingDeadlineError{
+		Method: req.Method,
+		URL:    req.URL.Redacted(),
+	}
+	if t.check == DeadlineCheckReject {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	t.snapshot.load().logger.Errorf("%v\n%s", err, debug.Stack())
+	return t.wrappedRoundTripper.RoundTrip(req)
+}
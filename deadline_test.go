@@ -0,0 +1,112 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type deadlineSuite struct {
+	testing.IsolationSuite
+
+	server *httptest.Server
+	hits   int
+}
+
+var _ = gc.Suite(&deadlineSuite{})
+
+func (s *deadlineSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.hits = 0
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.hits++
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+		}
+	}))
+	s.AddCleanup(func(*gc.C) { s.server.Close() })
+}
+
+func (s *deadlineSuite) get(ctx context.Context, client *Client, path string) error {
+	resp, err := client.Get(ctx, s.server.URL+path)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *deadlineSuite) TestValidate(c *gc.C) {
+	c.Check(DeadlineCheckReject.Validate(), jc.ErrorIsNil)
+	c.Check(DeadlineCheck(7).Validate(), gc.ErrorMatches, `deadline check 7 not valid`)
+	c.Check(DeadlineCheck(7).String(), gc.Equals, "unknown")
+	c.Check(DeadlineCheckLog.String(), gc.Equals, "log")
+
+	opts := newOptions()
+	WithDeadlineCheck(DeadlineCheck(-1))(opts)
+	c.Check(opts.validate(), gc.ErrorMatches, `deadline check -1 not valid`)
+}
+
+func (s *deadlineSuite) TestReject(c *gc.C) {
+	client := NewClient(WithDeadlineCheck(DeadlineCheckReject))
+	err := s.get(context.Background(), client, "/")
+	c.Assert(err, jc.ErrorIs, ErrMissingDeadline)
+	c.Check(err, gc.ErrorMatches, `.*request GET `+s.server.URL+`/ without a context deadline`)
+	deadlineErr, ok := errors.AsType[*MissingDeadlineError](err)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(deadlineErr.Method, gc.Equals, "GET")
+	c.Check(s.hits, gc.Equals, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testing.LongWait)
+	defer cancel()
+	c.Assert(s.get(ctx, client, "/"), jc.ErrorIsNil)
+	c.Check(s.hits, gc.Equals, 1)
+}
+
+func (s *deadlineSuite) TestClientTimeoutIsDeadline(c *gc.C) {
+	client := NewClient(
+		WithDeadlineCheck(DeadlineCheckReject),
+		WithTimeout(testing.LongWait),
+	)
+	c.Assert(s.get(context.Background(), client, "/"), jc.ErrorIsNil)
+	c.Check(s.hits, gc.Equals, 1)
+}
+
+func (s *deadlineSuite) TestLog(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	var logged []string
+	logger := NewMockLogger(ctrl)
+	logger.EXPECT().IsTraceEnabled().Return(false).AnyTimes()
+	logger.EXPECT().Errorf(gomock.Any(), gomock.Any()).Do(func(message string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(message, args...))
+	})
+
+	client := NewClient(
+		WithLogger(logger),
+		WithDeadlineCheck(DeadlineCheckLog),
+	)
+	// The redirect is not logged again.
+	c.Assert(s.get(context.Background(), client, "/redirect"), jc.ErrorIsNil)
+	c.Check(s.hits, gc.Equals, 2)
+	c.Assert(logged, gc.HasLen, 1)
+	c.Check(strings.HasPrefix(logged[0], "request GET "+s.server.URL+"/redirect without a context deadline\n"), jc.IsTrue)
+	c.Check(strings.Contains(logged[0], "TestLog"), jc.IsTrue)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(testing.LongWait))
+	defer cancel()
+	c.Assert(s.get(ctx, client, "/"), jc.ErrorIsNil)
+	c.Check(logged, gc.HasLen, 1)
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Decoder decodes a response body into the value pointed to by v.
+type Decoder func(r io.Reader, v interface{}) error
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]Decoder{
+		"application/json":   decodeJSON,
+		"application/xml":    decodeXML,
+		"text/xml":           decodeXML,
+		"application/yaml":   decodeYAML,
+		"application/x-yaml": decodeYAML,
+		"text/yaml":          decodeYAML,
+	}
+)
+
+// RegisterDecoder registers the decoder for responses with the media type,
+// such as "application/cbor", replacing any decoder already registered for
+// it. JSON, XML and YAML decoders are registered by default, and importing
+// the message package registers CBOR and protocol buffer decoders. It is
+// safe to call concurrently with DecodeResponse.
+func RegisterDecoder(mediaType string, decoder Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[strings.ToLower(mediaType)] = decoder
+}
+
+// DecodeResponse decodes the body of the response into the value pointed
+// to by v, with the decoder registered for the media type of its
+// Content-Type header. A media type with a structured syntax suffix, such
+// as "application/problem+json", uses the decoder of its suffix if it has
+// none of its own. The body is read but not closed, and the status code of
+// the response is not checked.
+func DecodeResponse(resp *http.Response, v interface{}) error {
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return errors.NotValidf("content type %q", contentType)
+	}
+	decoder, ok := lookupDecoder(mediaType)
+	if !ok {
+		return errors.NotSupportedf("decoding content type %q", mediaType)
+	}
+	return errors.Annotatef(decoder(resp.Body, v), "decoding %s response", mediaType)
+}
+
+func lookupDecoder(mediaType string) (Decoder, bool) {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	if decoder, ok := decoders[mediaType]; ok {
+		return decoder, true
+	}
+	if i := strings.LastIndex(mediaType, "+"); i >= 0 {
+		decoder, ok := decoders["application/"+mediaType[i+1:]]
+		return decoder, ok
+	}
+	return nil, false
+}
+
+func decodeJSON(r io.Reader, v interface{}) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+func decodeXML(r io.Reader, v interface{}) error {
+	return xml.NewDecoder(r).Decode(v)
+}
+
+func decodeYAML(r io.Reader, v interface{}) error {
+	return yaml.NewDecoder(r).Decode(v)
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type decoderSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&decoderSuite{})
+
+func response(contentType, body string) *http.Response {
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	if contentType != "" {
+		resp.Header.Set("Content-Type", contentType)
+	}
+	return resp
+}
+
+type charm struct {
+	Name     string `json:"name" xml:"name" yaml:"name"`
+	Revision int    `json:"revision" xml:"revision" yaml:"revision"`
+}
+
+func (s *decoderSuite) TestDecodeResponse(c *gc.C) {
+	tests := []struct {
+		contentType string
+		body        string
+	}{{
+		contentType: "application/json; charset=utf-8",
+		body:        `{"name": "mysql", "revision": 42}`,
+	}, {
+		contentType: "application/vnd.charmhub+json",
+		body:        `{"name": "mysql", "revision": 42}`,
+	}, {
+		contentType: "application/xml",
+		body:        `<charm><name>mysql</name><revision>42</revision></charm>`,
+	}, {
+		contentType: "application/yaml",
+		body:        "name: mysql\nrevision: 42\n",
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.contentType)
+		var result charm
+		err := DecodeResponse(response(test.contentType, test.body), &result)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(result, jc.DeepEquals, charm{Name: "mysql", Revision: 42})
+	}
+}
+
+func (s *decoderSuite) TestDecodeResponseUnsupported(c *gc.C) {
+	var result charm
+	err := DecodeResponse(response("application/cbor", ""), &result)
+	c.Assert(err, jc.ErrorIs, errors.NotSupported)
+	c.Check(err, gc.ErrorMatches, `decoding content type "application/cbor" not supported`)
+}
+
+func (s *decoderSuite) TestDecodeResponseInvalidContentType(c *gc.C) {
+	var result charm
+	err := DecodeResponse(response("", "{}"), &result)
+	c.Assert(err, jc.ErrorIs, errors.NotValid)
+}
+
+func (s *decoderSuite) TestDecodeResponseError(c *gc.C) {
+	var result charm
+	err := DecodeResponse(response("application/json", "{"), &result)
+	c.Assert(err, gc.ErrorMatches, `decoding application/json response: unexpected EOF`)
+}
+
+func (s *decoderSuite) TestRegisterDecoder(c *gc.C) {
+	s.PatchValue(&decoders, map[string]Decoder{})
+
+	RegisterDecoder("Application/CBOR", func(r io.Reader, v interface{}) error {
+		v.(*charm).Name = "cbor"
+		return nil
+	})
+	var result charm
+	err := DecodeResponse(response("application/cbor", ""), &result)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(result.Name, gc.Equals, "cbor")
+}
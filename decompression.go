@@ -0,0 +1,261 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+const (
+	// OriginalContentEncodingHeader is the header of a response decompressed
+	// by the DecompressionMiddleware holding the Content-Encoding it was
+	// sent with.
+	OriginalContentEncodingHeader = "X-Original-Content-Encoding"
+	// OriginalContentLengthHeader is the header of a response decompressed
+	// by the DecompressionMiddleware holding the Content-Length it was sent
+	// with, if it was known.
+	OriginalContentLengthHeader = "X-Original-Content-Length"
+)
+
+// ContentDecoder returns a reader of the decoded content of a response body
+// encoded with a content coding, such as "zstd". Closing the reader
+// releases its resources, not the body.
+type ContentDecoder func(io.Reader) (io.ReadCloser, error)
+
+// preferredEncodings are the content codings asked for, in order of
+// preference, if there is a decoder for them. Any other codings with a
+// decoder are asked for after them.
+var preferredEncodings = []string{"zstd", "br", "gzip", "deflate"}
+
+// CompressionConfig configures the decompression of response bodies.
+type CompressionConfig struct {
+	// Decoders are the decoders of content codings, keyed by the name of the
+	// coding, in addition to the built in gzip and deflate decoders, which
+	// they may replace. The module has no zstd or brotli implementation of
+	// its own, so servers are only asked for "zstd" or "br" encoded
+	// responses if decoders for them are given, for example
+	//
+	//	"br": func(r io.Reader) (io.ReadCloser, error) {
+	//		return io.NopCloser(brotli.NewReader(r)), nil
+	//	},
+	Decoders map[string]ContentDecoder
+}
+
+// Validate validates the CompressionConfig for any issues.
+func (c CompressionConfig) Validate() error {
+	for encoding, decoder := range c.Decoders {
+		if encoding == "" || strings.ContainsAny(encoding, ", \t;") || strings.EqualFold(encoding, "identity") {
+			return errors.NotValidf("content coding %q", encoding)
+		}
+		if decoder == nil {
+			return errors.NotValidf("nil decoder for %q", encoding)
+		}
+	}
+	return nil
+}
+
+// WithCompression asks servers for compressed responses, using every
+// content coding the client has a decoder for, and decompresses them
+// transparently, see DecompressionMiddleware. Unlike the decompression
+// done by http.Transport, which only asks for gzip, it applies to a base
+// round tripper too. Compression recorded by a CompressionRecorder is that
+// of the codings negotiated.
+func WithCompression(config CompressionConfig) Option {
+	return func(opt *options) {
+		opt.compression = &config
+	}
+}
+
+// DecompressionMiddleware returns a RoundTripperMiddleware that asks
+// servers for compressed responses, with an Accept-Encoding header listing
+// zstd, brotli, gzip and deflate, for those of them it has a decoder for,
+// and decompresses the response bodies as they are read.
+//
+// A decompressed response has its Content-Encoding and Content-Length
+// headers removed, its ContentLength set to -1 and Uncompressed set. The
+// original values of the headers are kept in the
+// OriginalContentEncodingHeader and OriginalContentLengthHeader headers.
+//
+// As with http.Transport, requests that set their own Accept-Encoding
+// header, HEAD requests and range requests are sent as they are, and their
+// responses are left encoded. A response with a coding there is no decoder
+// for is returned as it is.
+//
+// If the config isn't valid, every request fails with the validation error.
+func DecompressionMiddleware(config CompressionConfig) RoundTripperMiddleware {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		transport, err := newDecompressionTransport(rt, config)
+		if err != nil {
+			return invalidConfigTransport{err: errors.Annotate(err, "decompression")}
+		}
+		return transport
+	}
+}
+
+// decompressionTransport asks for compressed responses and decompresses
+// them.
+type decompressionTransport struct {
+	wrappedRoundTripper http.RoundTripper
+	decoders            map[string]ContentDecoder
+	acceptEncoding      string
+
+	// snapshot, if set, is that of the client whose CompressionRecorder
+	// the compression of the responses is recorded with.
+	snapshot *clientSnapshot
+	hooks    hookRunner
+}
+
+func newDecompressionTransport(transport http.RoundTripper, config CompressionConfig) (decompressionTransport, error) {
+	if err := config.Validate(); err != nil {
+		return decompressionTransport{}, errors.Trace(err)
+	}
+	decoders := map[string]ContentDecoder{
+		"gzip":    decodeGzip,
+		"deflate": decodeDeflate,
+	}
+	for encoding, decoder := range config.Decoders {
+		decoders[strings.ToLower(encoding)] = decoder
+	}
+
+	var encodings []string
+	for _, encoding := range preferredEncodings {
+		if _, ok := decoders[encoding]; ok {
+			encodings = append(encodings, encoding)
+		}
+	}
+	var others []string
+	for encoding := range decoders {
+		if !slices.Contains(preferredEncodings, encoding) {
+			others = append(others, encoding)
+		}
+	}
+	slices.Sort(others)
+
+	return decompressionTransport{
+		wrappedRoundTripper: transport,
+		decoders:            decoders,
+		acceptEncoding:      strings.Join(append(encodings, others...), ", "),
+	}, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t decompressionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodHead ||
+		req.Header.Get("Accept-Encoding") != "" || req.Header.Get("Range") != "" {
+		return t.wrappedRoundTripper.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", t.acceptEncoding)
+	res, err := t.wrappedRoundTripper.RoundTrip(req)
+	if err != nil || res.Body == nil {
+		return res, err
+	}
+	encoding := strings.Join(res.Header.Values("Content-Encoding"), ", ")
+	decode, ok := t.decoder(encoding)
+	if !ok {
+		return res, nil
+	}
+
+	decompressed := *res
+	decompressed.Header = res.Header.Clone()
+	decompressed.Header.Set(OriginalContentEncodingHeader, encoding)
+	if res.ContentLength >= 0 {
+		decompressed.Header.Set(OriginalContentLengthHeader, strconv.FormatInt(res.ContentLength, 10))
+	}
+	decompressed.Header.Del("Content-Encoding")
+	decompressed.Header.Del("Content-Length")
+	decompressed.ContentLength = -1
+	decompressed.Uncompressed = true
+	body := &decompressingBody{
+		body:     res.Body,
+		method:   req.Method,
+		url:      req.URL,
+		encoding: encoding,
+		decode:   decode,
+	}
+	if t.snapshot != nil {
+		state := t.snapshot.load()
+		body.recorder, _ = requestRecorder(req.Context(), state.recorder).(CompressionRecorder)
+		body.logger = requestLogger(req.Context(), state.logger)
+		body.hooks = t.hooks
+	}
+	decompressed.Body = body
+	return &decompressed, nil
+}
+
+// decoder returns the decoder of a response body with the Content-Encoding,
+// which lists the codings in the order they were applied, and false if any
+// of them has no decoder, or there are none.
+func (t decompressionTransport) decoder(encoding string) (ContentDecoder, bool) {
+	var decoders []ContentDecoder
+	for _, coding := range strings.Split(encoding, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" || coding == "identity" {
+			continue
+		}
+		decoder, ok := t.decoders[coding]
+		if !ok {
+			return nil, false
+		}
+		decoders = append(decoders, decoder)
+	}
+	switch len(decoders) {
+	case 0:
+		return nil, false
+	case 1:
+		return decoders[0], true
+	}
+	return func(r io.Reader) (io.ReadCloser, error) {
+		var readers multiCloser
+		for i := len(decoders) - 1; i >= 0; i-- {
+			reader, err := decoders[i](r)
+			if err != nil {
+				_ = readers.Close()
+				return nil, err
+			}
+			readers = append(readers, reader)
+			r = reader
+		}
+		return readers, nil
+	}, true
+}
+
+// multiCloser reads from the last of its decoded readers, each of which
+// reads from the one before, and closes them all.
+type multiCloser []io.ReadCloser
+
+// Read implements io.Reader.
+func (m multiCloser) Read(p []byte) (int, error) {
+	return m[len(m)-1].Read(p)
+}
+
+// Close implements io.Closer.
+func (m multiCloser) Close() error {
+	var err error
+	for i := len(m) - 1; i >= 0; i-- {
+		if closeErr := m[i].Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func decodeGzip(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// decodeDeflate decodes the deflate coding, which, despite its name, is a
+// zlib stream.
+func decodeDeflate(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
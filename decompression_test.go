@@ -0,0 +1,231 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type decompressionSuite struct {
+	testing.IsolationSuite
+
+	server         *httptest.Server
+	body           string
+	acceptEncoding chan string
+}
+
+var _ = gc.Suite(&decompressionSuite{})
+
+// reverseDecoder decodes the "reverse" content coding of the tests, which
+// reverses the bytes of the body.
+func reverseDecoder(r io.Reader) (io.ReadCloser, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(reverse(content))), nil
+}
+
+func reverse(content []byte) []byte {
+	reversed := make([]byte, len(content))
+	for i, b := range content {
+		reversed[len(content)-1-i] = b
+	}
+	return reversed
+}
+
+func (s *decompressionSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.body = strings.Repeat("juju charm ", 1000)
+	s.acceptEncoding = make(chan string, 1)
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.acceptEncoding <- r.Header.Get("Accept-Encoding")
+
+		var buf bytes.Buffer
+		switch r.URL.Query().Get("encoding") {
+		case "gzip":
+			zw := gzip.NewWriter(&buf)
+			_, _ = io.WriteString(zw, s.body)
+			_ = zw.Close()
+		case "deflate":
+			zw := zlib.NewWriter(&buf)
+			_, _ = io.WriteString(zw, s.body)
+			_ = zw.Close()
+		case "reverse":
+			buf.Write(reverse([]byte(s.body)))
+		case "gzip, reverse":
+			zw := gzip.NewWriter(&buf)
+			_, _ = io.WriteString(zw, s.body)
+			_ = zw.Close()
+			content := reverse(buf.Bytes())
+			buf.Reset()
+			buf.Write(content)
+		default:
+			buf.WriteString(s.body)
+		}
+		if encoding := r.URL.Query().Get("encoding"); encoding != "" {
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		_, _ = w.Write(buf.Bytes())
+	}))
+}
+
+func (s *decompressionSuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+	s.IsolationSuite.TearDownTest(c)
+}
+
+func (s *decompressionSuite) get(c *gc.C, client *Client, encoding string) (*http.Response, string) {
+	resp, err := client.Get(context.Background(), s.server.URL+"?encoding="+url.QueryEscape(encoding))
+	c.Assert(err, jc.ErrorIsNil)
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	return resp, string(body)
+}
+
+func (s *decompressionSuite) TestDecompress(c *gc.C) {
+	client := NewClient(WithCompression(CompressionConfig{}))
+	for _, encoding := range []string{"gzip", "deflate"} {
+		c.Logf("encoding %s", encoding)
+		resp, body := s.get(c, client, encoding)
+		c.Check(<-s.acceptEncoding, gc.Equals, "gzip, deflate")
+		c.Check(body, gc.Equals, s.body)
+		c.Check(resp.Uncompressed, jc.IsTrue)
+		c.Check(resp.ContentLength, gc.Equals, int64(-1))
+		c.Check(resp.Header.Get("Content-Encoding"), gc.Equals, "")
+		c.Check(resp.Header.Get("Content-Length"), gc.Equals, "")
+		c.Check(resp.Header.Get(OriginalContentEncodingHeader), gc.Equals, encoding)
+		c.Check(resp.Header.Get(OriginalContentLengthHeader), gc.Not(gc.Equals), "")
+	}
+}
+
+func (s *decompressionSuite) TestDecoders(c *gc.C) {
+	client := NewClient(WithCompression(CompressionConfig{
+		Decoders: map[string]ContentDecoder{
+			"Reverse": reverseDecoder,
+			"zstd":    reverseDecoder,
+		},
+	}))
+
+	resp, body := s.get(c, client, "reverse")
+	c.Check(<-s.acceptEncoding, gc.Equals, "zstd, gzip, deflate, reverse")
+	c.Check(body, gc.Equals, s.body)
+	c.Check(resp.Header.Get(OriginalContentEncodingHeader), gc.Equals, "reverse")
+	c.Check(resp.Header.Get(OriginalContentLengthHeader), gc.Equals, strconv.Itoa(len(s.body)))
+
+	// Stacked codings are decoded in the reverse of the order applied.
+	_, body = s.get(c, client, "gzip, reverse")
+	<-s.acceptEncoding
+	c.Check(body, gc.Equals, s.body)
+}
+
+func (s *decompressionSuite) TestUnknownEncoding(c *gc.C) {
+	client := NewClient(WithCompression(CompressionConfig{}))
+	resp, body := s.get(c, client, "reverse")
+	<-s.acceptEncoding
+	c.Check(body, gc.Equals, string(reverse([]byte(s.body))))
+	c.Check(resp.Header.Get("Content-Encoding"), gc.Equals, "reverse")
+	c.Check(resp.Header.Get(OriginalContentEncodingHeader), gc.Equals, "")
+
+	resp, body = s.get(c, client, "")
+	<-s.acceptEncoding
+	c.Check(body, gc.Equals, s.body)
+	c.Check(resp.Uncompressed, jc.IsFalse)
+	c.Check(resp.ContentLength, gc.Equals, int64(len(s.body)))
+}
+
+func (s *decompressionSuite) TestCallerAcceptEncoding(c *gc.C) {
+	client := NewClient(WithCompression(CompressionConfig{}))
+	req, err := http.NewRequest("GET", s.server.URL+"?encoding=gzip", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := client.Do(req)
+	c.Assert(err, jc.ErrorIsNil)
+	defer resp.Body.Close()
+	c.Check(<-s.acceptEncoding, gc.Equals, "gzip")
+	c.Check(resp.Header.Get("Content-Encoding"), gc.Equals, "gzip")
+	c.Check(resp.Uncompressed, jc.IsFalse)
+}
+
+func (s *decompressionSuite) TestRecordCompression(c *gc.C) {
+	recorder := &compressionRecorder{}
+	client := NewClient(
+		WithRequestRecorder(recorder),
+		WithCompression(CompressionConfig{}),
+	)
+	_, body := s.get(c, client, "deflate")
+	<-s.acceptEncoding
+	c.Check(body, gc.Equals, s.body)
+
+	c.Assert(recorder.stats, gc.HasLen, 1)
+	stats := recorder.stats[0]
+	c.Check(stats.Encoding, gc.Equals, "deflate")
+	c.Check(stats.DecompressedBytes, gc.Equals, int64(len(s.body)))
+	c.Check(stats.CompressedBytes < stats.DecompressedBytes, jc.IsTrue)
+}
+
+func (s *decompressionSuite) TestMiddleware(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = io.WriteString(zw, s.body)
+	_ = zw.Close()
+
+	rt := NewMockRoundTripper(ctrl)
+	rt.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+		c.Check(req.Header.Get("Accept-Encoding"), gc.Equals, "gzip, deflate")
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Content-Encoding": {"gzip"}},
+			Body:          io.NopCloser(&buf),
+			ContentLength: -1,
+		}, nil
+	})
+	client := NewClient(
+		WithBaseRoundTripper(rt),
+		WithRoundTripperMiddlewares(DecompressionMiddleware(CompressionConfig{})),
+	)
+	resp, err := client.Get(context.Background(), "http://charmhub.example.com")
+	c.Assert(err, jc.ErrorIsNil)
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(body), gc.Equals, s.body)
+	c.Check(resp.Header.Get(OriginalContentEncodingHeader), gc.Equals, "gzip")
+	c.Check(resp.Header.Values(OriginalContentLengthHeader), gc.HasLen, 0)
+}
+
+func (s *decompressionSuite) TestInvalidConfig(c *gc.C) {
+	config := CompressionConfig{Decoders: map[string]ContentDecoder{"identity": reverseDecoder}}
+	c.Check(config.Validate(), gc.ErrorMatches, `content coding "identity" not valid`)
+	config = CompressionConfig{Decoders: map[string]ContentDecoder{"br": nil}}
+	c.Check(config.Validate(), gc.ErrorMatches, `nil decoder for "br" not valid`)
+
+	rt := DecompressionMiddleware(config)(http.DefaultTransport)
+	req, err := http.NewRequest("GET", s.server.URL, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = rt.RoundTrip(req)
+	c.Check(err, gc.ErrorMatches, `invalid http client configuration: decompression: nil decoder for "br" not valid`)
+
+	opts := newOptions()
+	WithCompression(config)(opts)
+	c.Check(opts.validate(), gc.ErrorMatches, `compression: nil decoder for "br" not valid`)
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const (
+	// defaultDialTimeout is the time limit of a dial, unless configured by
+	// WithDialOptions.
+	defaultDialTimeout = 30 * time.Second
+	// defaultDialKeepAlive is the keep-alive period of a connection, unless
+	// configured by WithDialOptions.
+	defaultDialKeepAlive = 30 * time.Second
+)
+
+// DialOptions configures how the client dials connections. A zero field
+// keeps the default.
+type DialOptions struct {
+	// Timeout is the time limit of a dial, including name resolution. The
+	// default is 30 seconds.
+	Timeout time.Duration
+	// KeepAlive is the period of the TCP keep-alive probes of a
+	// connection. The default is 30 seconds. A negative period disables
+	// keep-alive probes.
+	KeepAlive time.Duration
+	// LocalAddr is the local address outgoing connections are bound to,
+	// such as the address of an interface of a multi-homed machine. It
+	// must be a *net.TCPAddr, and a zero port lets the system choose one.
+	// The default lets the system choose the address.
+	LocalAddr net.Addr
+	// FallbackDelay is how long a dial waits for an IPv6 connection to a
+	// dual-stack host, before falling back to IPv4. If the client resolves
+	// host names itself, such as with WithPreferredAddressFamily, it is how
+	// long a dial waits for each address before racing the next. The
+	// default is 300 milliseconds. A negative delay disables the fallback.
+	FallbackDelay time.Duration
+}
+
+// Validate validates the DialOptions for any issues.
+func (o DialOptions) Validate() error {
+	if o.Timeout < 0 {
+		return errors.NotValidf("negative dial timeout")
+	}
+	if o.LocalAddr != nil {
+		if _, ok := o.LocalAddr.(*net.TCPAddr); !ok {
+			return errors.NotValidf("local address %q of type %T", o.LocalAddr, o.LocalAddr)
+		}
+	}
+	return nil
+}
+
+// dialer returns a dialer configured by the options.
+func (o DialOptions) dialer() *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout:       o.Timeout,
+		KeepAlive:     o.KeepAlive,
+		LocalAddr:     o.LocalAddr,
+		FallbackDelay: o.FallbackDelay,
+	}
+	if dialer.Timeout == 0 {
+		dialer.Timeout = defaultDialTimeout
+	}
+	if dialer.KeepAlive == 0 {
+		dialer.KeepAlive = defaultDialKeepAlive
+	}
+	return dialer
+}
+
+// WithDialOptions configures how the client dials connections, for agents
+// on constrained or multi-homed networks, see DialOptions.
+//
+// The options apply to the transport built by the client, not to a base
+// round tripper.
+func WithDialOptions(value DialOptions) Option {
+	return func(opt *options) {
+		opt.dialOptions = value
+	}
+}
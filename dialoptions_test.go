@@ -0,0 +1,79 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type dialOptionsSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&dialOptionsSuite{})
+
+func (s *dialOptionsSuite) TestDefaults(c *gc.C) {
+	dialer := DialOptions{}.dialer()
+	c.Check(dialer.Timeout, gc.Equals, 30*time.Second)
+	c.Check(dialer.KeepAlive, gc.Equals, 30*time.Second)
+	c.Check(dialer.LocalAddr, gc.IsNil)
+	c.Check(dialer.FallbackDelay, gc.Equals, time.Duration(0))
+}
+
+func (s *dialOptionsSuite) TestDialer(c *gc.C) {
+	localAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}
+	dialer := DialOptions{
+		Timeout:       time.Second,
+		KeepAlive:     -1,
+		LocalAddr:     localAddr,
+		FallbackDelay: time.Millisecond,
+	}.dialer()
+	c.Check(dialer.Timeout, gc.Equals, time.Second)
+	c.Check(dialer.KeepAlive, gc.Equals, time.Duration(-1))
+	c.Check(dialer.LocalAddr, gc.Equals, localAddr)
+	c.Check(dialer.FallbackDelay, gc.Equals, time.Millisecond)
+}
+
+func (s *dialOptionsSuite) TestValidate(c *gc.C) {
+	c.Check(DialOptions{}.Validate(), jc.ErrorIsNil)
+	c.Check(DialOptions{Timeout: -time.Second}.Validate(), gc.ErrorMatches, `negative dial timeout not valid`)
+	c.Check(DialOptions{LocalAddr: &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}}.Validate(), gc.ErrorMatches,
+		`local address "127.0.0.1:0" of type \*net.UDPAddr not valid`)
+}
+
+func (s *dialOptionsSuite) TestInvalidDialOptions(c *gc.C) {
+	_, err := NewClient(WithDialOptions(DialOptions{Timeout: -time.Second})).Get(context.Background(), "http://example.com")
+	c.Check(err, gc.ErrorMatches, `.*negative dial timeout not valid`)
+}
+
+func (s *dialOptionsSuite) TestLocalAddr(c *gc.C) {
+	remoteAddrs := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddrs <- r.RemoteAddr
+	}))
+	defer server.Close()
+
+	// Any address of the loopback network can be bound to on Linux, so
+	// the connection is made from an address other than the default.
+	client := NewClient(WithDialOptions(DialOptions{
+		LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")},
+	}))
+	resp, err := client.Get(context.Background(), server.URL)
+	if err != nil {
+		c.Skip("binding to 127.0.0.2 not supported: " + err.Error())
+	}
+	_ = resp.Body.Close()
+
+	host, _, err := net.SplitHostPort(<-remoteAddrs)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(host, gc.Equals, "127.0.0.2")
+}
@@ -0,0 +1,266 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// DownloadOptions configures a download made by Client.DownloadFile.
+type DownloadOptions struct {
+	// Digest, if set, is the expected digest of the content, such as a
+	// sha256 or sha384 digest. The file is only moved into place if the
+	// content matches it.
+	Digest Digest
+
+	// Resume, if true, resumes the partial download left by an earlier call
+	// for the same destination, rather than starting again. The partial
+	// download is kept if the call fails. Without a Digest, nothing guards
+	// against the content changing between the calls.
+	Resume bool
+
+	// MaxResumes is the number of times a download whose response body
+	// fails partway through is resumed, with a Range request from the last
+	// byte written.
+	MaxResumes int
+
+	// Progress, if set, is called with the progress of the download after
+	// each write to the file. Done and Total include any bytes of the
+	// content downloaded earlier.
+	Progress func(Progress)
+}
+
+// Validate validates the DownloadOptions for any issues.
+func (o DownloadOptions) Validate() error {
+	if o.Digest != (Digest{}) {
+		if err := o.Digest.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if o.MaxResumes < 0 {
+		return errors.NotValidf("negative max resumes")
+	}
+	return nil
+}
+
+// DownloadFile downloads the content at the URL to the file at dest,
+// replacing it only once the download completes, and matches the digest
+// of the options, if any. The content is downloaded to dest with a ".part"
+// suffix first.
+//
+// Every request is sent with Do, so it is retried following the client's
+// RetryPolicy. A response body that fails partway through is resumed from
+// the last byte written with a Range request, guarded by the strong ETag of
+// the content, if it has one, up to MaxResumes times. The content is
+// requested without a content coding, so that the ranges are those of the
+// file.
+func (c *Client) DownloadFile(ctx context.Context, url, dest string, opts DownloadOptions) error {
+	if err := opts.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	part := dest + ".part"
+	flags := os.O_CREATE | os.O_RDWR
+	if !opts.Resume {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(part, flags, 0o644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	d := &download{
+		client: c,
+		url:    url,
+		opts:   opts,
+		file:   file,
+	}
+	err = d.run(ctx)
+	if closeErr := file.Close(); err == nil {
+		err = errors.Trace(closeErr)
+	}
+	if err != nil {
+		if _, mismatch := errors.AsType[*DigestMismatchError](err); mismatch || !opts.Resume {
+			_ = os.Remove(part)
+		}
+		return err
+	}
+	return errors.Trace(os.Rename(part, dest))
+}
+
+// download is the state of a download made by Client.DownloadFile.
+type download struct {
+	client *Client
+	url    string
+	opts   DownloadOptions
+	file   *os.File
+
+	// hash is the hash of the content written so far, if there is a
+	// digest to verify.
+	hash hash.Hash
+	// offset is the number of bytes of the content written so far.
+	offset int64
+	// etag is the strong ETag of the content, if it has one, guarding the
+	// resumption of the download.
+	etag string
+
+	// start and tracker track the progress of the current response.
+	start   int64
+	tracker *ProgressTracker
+}
+
+// run downloads the content to the file, resuming from the end of the
+// partial content already in it, and verifies its digest.
+func (d *download) run(ctx context.Context) error {
+	if d.opts.Digest != (Digest{}) {
+		d.hash, _ = d.opts.Digest.newHash()
+		// The partial content is hashed, leaving the file at its end.
+		n, err := copyBuffer(d.hash, d.file)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		d.offset = n
+	} else {
+		n, err := d.file.Seek(0, io.SeekEnd)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		d.offset = n
+	}
+
+	for resumes := 0; ; resumes++ {
+		resumable, err := d.fetch(ctx)
+		if err == nil {
+			break
+		}
+		if !resumable || resumes >= d.opts.MaxResumes || ctx.Err() != nil {
+			return errors.Annotatef(err, "cannot download %s", d.url)
+		}
+		logger := requestLogger(ctx, d.client.snapshot.load().logger)
+		logger.Tracef("resuming download of %s from offset %d after: %v", d.url, d.offset, err)
+	}
+
+	if d.hash == nil {
+		return nil
+	}
+	if actual := hex.EncodeToString(d.hash.Sum(nil)); actual != d.opts.Digest.Hex {
+		return &DigestMismatchError{
+			Expected: d.opts.Digest,
+			Actual:   Digest{Algorithm: d.opts.Digest.Algorithm, Hex: actual},
+		}
+	}
+	return nil
+}
+
+// fetch requests the content from the offset, writing it to the file. It
+// returns true with the error if the response body failed, so that the
+// download can be resumed.
+func (d *download) fetch(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", d.url, nil)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	if d.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", d.offset))
+		if d.etag != "" {
+			req.Header.Set("If-Range", d.etag)
+		}
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && d.offset > 0 &&
+		strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", d.offset)):
+	case resp.StatusCode == http.StatusOK:
+		if d.offset > 0 {
+			// The server ignored the range, or the content changed, so
+			// the download starts again.
+			if err := d.restart(); err != nil {
+				return false, errors.Trace(err)
+			}
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && d.offset > 0 &&
+		resp.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", d.offset):
+		// The partial content is complete.
+		return false, nil
+	default:
+		return false, errors.Errorf("%s", resp.Status)
+	}
+	d.etag = ETag(resp)
+	if strings.HasPrefix(d.etag, "W/") {
+		d.etag = ""
+	}
+
+	d.start = d.offset
+	d.tracker = NewProgressTracker(resp.ContentLength, d.client.clock)
+	body := &downloadBody{body: resp.Body}
+	if _, err := copyBuffer(d, body); err != nil {
+		return body.err != nil, errors.Trace(err)
+	}
+	return false, nil
+}
+
+// restart discards the partial content written so far.
+func (d *download) restart() error {
+	if err := d.file.Truncate(0); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := d.file.Seek(0, io.SeekStart); err != nil {
+		return errors.Trace(err)
+	}
+	if d.hash != nil {
+		d.hash.Reset()
+	}
+	d.offset = 0
+	return nil
+}
+
+// Write implements io.Writer, writing to the file and the hash, and
+// reporting the progress.
+func (d *download) Write(p []byte) (int, error) {
+	n, err := d.file.Write(p)
+	if d.hash != nil {
+		_, _ = d.hash.Write(p[:n])
+	}
+	d.offset += int64(n)
+	if d.opts.Progress != nil && n > 0 {
+		d.tracker.add(int64(n))
+		progress := d.tracker.Progress()
+		progress.Done += d.start
+		if progress.Total >= 0 {
+			progress.Total += d.start
+		}
+		d.opts.Progress(progress)
+	}
+	return n, err
+}
+
+// downloadBody records the error of a failed read of a response body, as
+// opposed to a failed write of the file it is copied to.
+type downloadBody struct {
+	body io.Reader
+	err  error
+}
+
+// Read implements io.Reader.
+func (b *downloadBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,247 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type downloadSuite struct {
+	testing.IsolationSuite
+
+	server  *httptest.Server
+	dest    string
+	content string
+
+	mu       sync.Mutex
+	cutOff   int
+	noRanges bool
+	requests []string
+}
+
+var _ = gc.Suite(&downloadSuite{})
+
+func (s *downloadSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.dest = filepath.Join(c.MkDir(), "juju-3.5.0-linux-amd64.tar.xz")
+	s.content = strings.Repeat("jujud agent binary ", 1000)
+	s.cutOff = 0
+	s.noRanges = false
+	s.requests = nil
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Header.Get("Range"))
+		cutOff := s.cutOff
+		s.cutOff = 0
+		noRanges := s.noRanges
+		s.mu.Unlock()
+
+		if r.URL.Path != "/agent" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(s.content))))
+		if cutOff > 0 {
+			// Send part of the content, then drop the connection.
+			w.Header().Set("Content-Length", strconv.Itoa(len(s.content)))
+			_, _ = w.Write([]byte(s.content[:cutOff]))
+			return
+		}
+		if noRanges {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(s.content))
+	}))
+	s.AddCleanup(func(*gc.C) { s.server.Close() })
+}
+
+func (s *downloadSuite) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+func (s *downloadSuite) digest() Digest {
+	sum := sha256.Sum256([]byte(s.content))
+	return Digest{Algorithm: "sha256", Hex: hex.EncodeToString(sum[:])}
+}
+
+func (s *downloadSuite) checkDownloaded(c *gc.C) {
+	content, err := os.ReadFile(s.dest)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(content) == s.content, jc.IsTrue)
+	_, err = os.Stat(s.dest + ".part")
+	c.Check(os.IsNotExist(err), jc.IsTrue)
+}
+
+func (s *downloadSuite) TestDownloadFile(c *gc.C) {
+	var progress []Progress
+	err := NewClient().DownloadFile(context.Background(), s.server.URL+"/agent", s.dest, DownloadOptions{
+		Digest: s.digest(),
+		Progress: func(p Progress) {
+			progress = append(progress, p)
+		},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	s.checkDownloaded(c)
+	c.Check(s.received(), jc.DeepEquals, []string{""})
+
+	c.Assert(progress, gc.Not(gc.HasLen), 0)
+	last := progress[len(progress)-1]
+	c.Check(last.Done, gc.Equals, int64(len(s.content)))
+	c.Check(last.Total, gc.Equals, int64(len(s.content)))
+}
+
+func (s *downloadSuite) TestSHA384(c *gc.C) {
+	sum := sha512.Sum384([]byte(s.content))
+	digest, err := ParseDigest("sha384:" + hex.EncodeToString(sum[:]))
+	c.Assert(err, jc.ErrorIsNil)
+	err = NewClient().DownloadFile(context.Background(), s.server.URL+"/agent", s.dest, DownloadOptions{
+		Digest: digest,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	s.checkDownloaded(c)
+}
+
+func (s *downloadSuite) TestDigestMismatch(c *gc.C) {
+	sum := sha256.Sum256([]byte("something else"))
+	expected := Digest{Algorithm: "sha256", Hex: hex.EncodeToString(sum[:])}
+	err := NewClient().DownloadFile(context.Background(), s.server.URL+"/agent", s.dest, DownloadOptions{
+		Digest: expected,
+		Resume: true,
+	})
+	c.Assert(err, gc.FitsTypeOf, &DigestMismatchError{})
+	c.Check(err.(*DigestMismatchError).Expected, gc.Equals, expected)
+	c.Check(err.(*DigestMismatchError).Actual, gc.Equals, s.digest())
+
+	// A download that doesn't match isn't kept, even to be resumed.
+	for _, path := range []string{s.dest, s.dest + ".part"} {
+		_, err = os.Stat(path)
+		c.Check(os.IsNotExist(err), jc.IsTrue)
+	}
+}
+
+func (s *downloadSuite) TestResumePartial(c *gc.C) {
+	err := os.WriteFile(s.dest+".part", []byte(s.content[:100]), 0o644)
+	c.Assert(err, jc.ErrorIsNil)
+
+	var progress []Progress
+	err = NewClient().DownloadFile(context.Background(), s.server.URL+"/agent", s.dest, DownloadOptions{
+		Digest: s.digest(),
+		Resume: true,
+		Progress: func(p Progress) {
+			progress = append(progress, p)
+		},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	s.checkDownloaded(c)
+	c.Check(s.received(), jc.DeepEquals, []string{"bytes=100-"})
+	c.Assert(progress, gc.Not(gc.HasLen), 0)
+	c.Check(progress[0].Done > 100, jc.IsTrue)
+	c.Check(progress[0].Total, gc.Equals, int64(len(s.content)))
+}
+
+func (s *downloadSuite) TestResumeComplete(c *gc.C) {
+	err := os.WriteFile(s.dest+".part", []byte(s.content), 0o644)
+	c.Assert(err, jc.ErrorIsNil)
+
+	err = NewClient().DownloadFile(context.Background(), s.server.URL+"/agent", s.dest, DownloadOptions{
+		Digest: s.digest(),
+		Resume: true,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	s.checkDownloaded(c)
+	c.Check(s.received(), jc.DeepEquals, []string{fmt.Sprintf("bytes=%d-", len(s.content))})
+}
+
+func (s *downloadSuite) TestRangeIgnored(c *gc.C) {
+	s.noRanges = true
+	err := os.WriteFile(s.dest+".part", []byte("stale content"), 0o644)
+	c.Assert(err, jc.ErrorIsNil)
+
+	err = NewClient().DownloadFile(context.Background(), s.server.URL+"/agent", s.dest, DownloadOptions{
+		Digest: s.digest(),
+		Resume: true,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	s.checkDownloaded(c)
+}
+
+func (s *downloadSuite) TestNoResume(c *gc.C) {
+	err := os.WriteFile(s.dest+".part", []byte(s.content[:100]), 0o644)
+	c.Assert(err, jc.ErrorIsNil)
+
+	err = NewClient().DownloadFile(context.Background(), s.server.URL+"/agent", s.dest, DownloadOptions{})
+	c.Assert(err, jc.ErrorIsNil)
+	s.checkDownloaded(c)
+	c.Check(s.received(), jc.DeepEquals, []string{""})
+}
+
+func (s *downloadSuite) TestResumeInterrupted(c *gc.C) {
+	s.cutOff = 1000
+	err := NewClient().DownloadFile(context.Background(), s.server.URL+"/agent", s.dest, DownloadOptions{
+		Digest:     s.digest(),
+		MaxResumes: 1,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	s.checkDownloaded(c)
+	c.Check(s.received(), jc.DeepEquals, []string{"", "bytes=1000-"})
+}
+
+func (s *downloadSuite) TestInterruptedKeptToResume(c *gc.C) {
+	s.cutOff = 1000
+	err := NewClient().DownloadFile(context.Background(), s.server.URL+"/agent", s.dest, DownloadOptions{
+		Resume: true,
+	})
+	c.Assert(err, gc.ErrorMatches, `cannot download .*/agent: unexpected EOF`)
+	info, err := os.Stat(s.dest + ".part")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(info.Size(), gc.Equals, int64(1000))
+
+	err = NewClient().DownloadFile(context.Background(), s.server.URL+"/agent", s.dest, DownloadOptions{
+		Digest: s.digest(),
+		Resume: true,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	s.checkDownloaded(c)
+	c.Check(s.received(), jc.DeepEquals, []string{"", "bytes=1000-"})
+}
+
+func (s *downloadSuite) TestNotFound(c *gc.C) {
+	err := NewClient().DownloadFile(context.Background(), s.server.URL+"/missing", s.dest, DownloadOptions{})
+	c.Assert(err, gc.ErrorMatches, `cannot download .*/missing: 404 Not Found`)
+	for _, path := range []string{s.dest, s.dest + ".part"} {
+		_, err = os.Stat(path)
+		c.Check(os.IsNotExist(err), jc.IsTrue)
+	}
+}
+
+func (s *downloadSuite) TestValidate(c *gc.C) {
+	c.Check(DownloadOptions{}.Validate(), jc.ErrorIsNil)
+	c.Check(DownloadOptions{MaxResumes: -1}.Validate(), gc.ErrorMatches, `negative max resumes not valid`)
+	c.Check(DownloadOptions{Digest: Digest{Algorithm: "md5", Hex: "00"}}.Validate(),
+		gc.ErrorMatches, `digest algorithm "md5" not supported`)
+
+	err := NewClient().DownloadFile(context.Background(), s.server.URL+"/agent", s.dest, DownloadOptions{MaxResumes: -1})
+	c.Check(err, gc.ErrorMatches, `negative max resumes not valid`)
+	c.Check(s.received(), gc.HasLen, 0)
+}
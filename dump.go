@@ -0,0 +1,138 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// DumpConfig controls how request bodies are included in the request dumps
+// logged at trace level.
+type DumpConfig struct {
+	// MaxBodyBytes is the maximum number of body bytes included in a dump,
+	// longer bodies are truncated. Zero omits bodies entirely and a negative
+	// value dumps bodies in full, which buffers the entire body in memory.
+	MaxBodyBytes int64
+
+	// DumpBinary includes bodies with a non-textual content type in the
+	// dump. By default only textual bodies are dumped.
+	DumpBinary bool
+}
+
+// DefaultDumpConfig is the DumpConfig used unless WithRequestDump is given.
+var DefaultDumpConfig = DumpConfig{
+	MaxBodyBytes: 4096,
+}
+
+// WithRequestDump configures how request bodies are dumped when trace
+// logging is enabled.
+func WithRequestDump(value DumpConfig) Option {
+	return func(opt *options) {
+		opt.dumpConfig = value
+	}
+}
+
+// dumpRequest dumps the outgoing request according to the config, without
+// reading more of the body than will be dumped. The unread remainder of the
+// body is still sent with the request.
+func dumpRequest(req *http.Request, config DumpConfig) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return httputil.DumpRequestOut(req, true)
+	}
+	if config.MaxBodyBytes == 0 {
+		return dumpWithoutBody(req, "omitted")
+	}
+	if !config.DumpBinary && !isTextualContentType(req.Header.Get("Content-Type")) {
+		return dumpWithoutBody(req, fmt.Sprintf("omitted, content type %q", req.Header.Get("Content-Type")))
+	}
+	if config.MaxBodyBytes < 0 {
+		return httputil.DumpRequestOut(req, true)
+	}
+
+	// Read one byte more than the limit so that we know if the body is
+	// truncated.
+	body := req.Body
+	pooled, err := readPrefix(body, config.MaxBodyBytes+1)
+	// The bytes read are sent before the rest of the body, even if reading
+	// failed, so that the request is sent whole or fails reading its body,
+	// never truncated.
+	req.Body = readCloser{
+		Reader: io.MultiReader(pooled.reader(), body),
+		Closer: body,
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// The prefix is only valid until the body is sent, so is used for the
+	// dump before returning.
+	prefix := pooled.bytes()
+	truncated := int64(len(prefix)) > config.MaxBodyBytes
+	if truncated {
+		prefix = prefix[:config.MaxBodyBytes]
+	}
+	clone := req.Clone(req.Context())
+	clone.Body = io.NopCloser(bytes.NewReader(prefix))
+	clone.ContentLength = int64(len(prefix))
+	clone.TransferEncoding = nil
+	dump, err := httputil.DumpRequestOut(clone, true)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if truncated && req.ContentLength > 0 {
+		dump = append(dump, fmt.Sprintf("\n[body truncated to %d of %d bytes]", config.MaxBodyBytes, req.ContentLength)...)
+	} else if truncated {
+		dump = append(dump, fmt.Sprintf("\n[body truncated to %d bytes]", config.MaxBodyBytes)...)
+	}
+	return dump, nil
+}
+
+func dumpWithoutBody(req *http.Request, reason string) ([]byte, error) {
+	dump, err := httputil.DumpRequestOut(req, false)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return append(dump, fmt.Sprintf("[body %s]", reason)...), nil
+}
+
+// isTextualContentType returns true for content types that are safe and
+// useful to include in logs. An empty content type is considered textual.
+func isTextualContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json",
+		"application/xml",
+		"application/yaml",
+		"application/x-yaml",
+		"application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
+
+// readCloser combines a reader with the closer of another body.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type dumpSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&dumpSuite{})
+
+func (s *dumpSuite) newRequest(c *gc.C, body, contentType string) *http.Request {
+	req, err := http.NewRequest("PUT", "http://example.com/blob", strings.NewReader(body))
+	c.Assert(err, jc.ErrorIsNil)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req
+}
+
+func (s *dumpSuite) readBody(c *gc.C, req *http.Request) string {
+	body, err := io.ReadAll(req.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	return string(body)
+}
+
+func (s *dumpSuite) TestTruncated(c *gc.C) {
+	req := s.newRequest(c, "0123456789", "application/json")
+	dump, err := dumpRequest(req, DumpConfig{MaxBodyBytes: 4})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(dump), jc.HasSuffix, "\r\n\r\n0123\n[body truncated to 4 of 10 bytes]")
+	c.Assert(req.ContentLength, gc.Equals, int64(10))
+
+	// The whole body is still sent.
+	c.Assert(s.readBody(c, req), gc.Equals, "0123456789")
+}
+
+// flakyReader returns its reads in turn, the first of them with err.
+type flakyReader struct {
+	reads []string
+	err   error
+}
+
+func (r *flakyReader) Read(b []byte) (int, error) {
+	if len(r.reads) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, r.reads[0])
+	r.reads = r.reads[1:]
+	if r.err != nil {
+		err := r.err
+		r.err = nil
+		return n, err
+	}
+	return n, nil
+}
+
+func (s *dumpSuite) TestReadError(c *gc.C) {
+	req, err := http.NewRequest("PUT", "http://example.com/blob", io.NopCloser(&flakyReader{
+		reads: []string{"0123", "4567", "89"},
+		err:   errors.New("boom"),
+	}))
+	c.Assert(err, jc.ErrorIsNil)
+	req.Header.Set("Content-Type", "text/plain")
+
+	_, err = dumpRequest(req, DumpConfig{MaxBodyBytes: 8})
+	c.Assert(err, gc.ErrorMatches, `boom`)
+
+	// The bytes read for the dump are still sent.
+	c.Assert(s.readBody(c, req), gc.Equals, "0123456789")
+}
+
+func (s *dumpSuite) TestWithinLimit(c *gc.C) {
+	req := s.newRequest(c, "0123", "text/plain; charset=utf-8")
+	dump, err := dumpRequest(req, DumpConfig{MaxBodyBytes: 4})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(dump), jc.HasSuffix, "\r\n\r\n0123")
+	c.Assert(s.readBody(c, req), gc.Equals, "0123")
+}
+
+func (s *dumpSuite) TestBinarySkipped(c *gc.C) {
+	req := s.newRequest(c, "\x00\x01\x02", "application/octet-stream")
+	dump, err := dumpRequest(req, DefaultDumpConfig)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(dump), jc.HasSuffix, `[body omitted, content type "application/octet-stream"]`)
+	c.Assert(s.readBody(c, req), gc.Equals, "\x00\x01\x02")
+
+	req = s.newRequest(c, "\x00\x01\x02", "application/octet-stream")
+	dump, err = dumpRequest(req, DumpConfig{MaxBodyBytes: 10, DumpBinary: true})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(dump), jc.HasSuffix, "\x00\x01\x02")
+}
+
+func (s *dumpSuite) TestBodyOmitted(c *gc.C) {
+	req := s.newRequest(c, "secret", "")
+	dump, err := dumpRequest(req, DumpConfig{})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(dump), jc.HasSuffix, "[body omitted]")
+	c.Assert(string(dump), gc.Not(jc.Contains), "secret")
+}
+
+func (s *dumpSuite) TestUnlimited(c *gc.C) {
+	req := s.newRequest(c, "0123456789", "")
+	dump, err := dumpRequest(req, DumpConfig{MaxBodyBytes: -1})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(dump), jc.HasSuffix, "0123456789")
+}
@@ -0,0 +1,373 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// EgressDestination describes a connection the client is about to make,
+// for an AddressPolicy to decide on.
+type EgressDestination struct {
+	// Network is the network being dialed, such as "tcp".
+	Network string
+	// Host is the host name being dialed, as it appears in the URL of the
+	// request, or the address itself if it was dialed directly. For
+	// networks without addresses, such as "unix", it is the address being
+	// dialed.
+	Host string
+	// IP is the address being dialed, after the host name was resolved.
+	// It is nil for networks without IP addresses.
+	IP net.IP
+	// Port is the port being dialed, or zero for networks without ports.
+	Port int
+}
+
+// String returns the destination as it would be dialed.
+func (d EgressDestination) String() string {
+	if d.IP == nil {
+		return d.Host
+	}
+	addr := net.JoinHostPort(d.IP.String(), strconv.Itoa(d.Port))
+	if d.Host == "" || d.Host == d.IP.String() {
+		return addr
+	}
+	return fmt.Sprintf("%s (%s)", d.Host, addr)
+}
+
+// AddressPolicy decides which destinations a client may connect to, see
+// WithEgressPolicy. If a policy also has a Validate method returning an
+// error, it is called when the client is created.
+type AddressPolicy interface {
+	// Check returns nil if the client may connect to the destination, or
+	// an error giving the reason it may not.
+	Check(ctx context.Context, dest EgressDestination) error
+}
+
+// ErrEgressDenied is matched, using errors.Is, by the error returned for a
+// connection denied by the egress policy of a client, see WithEgressPolicy.
+const ErrEgressDenied = errors.ConstError("egress denied by policy")
+
+// EgressPolicyError is returned for a request that the egress policy of
+// the client didn't allow a connection for.
+type EgressPolicyError struct {
+	// Destination is the denied destination.
+	Destination EgressDestination
+	// Err is the reason given by the policy.
+	Err error
+}
+
+// Error implements error.
+func (e *EgressPolicyError) Error() string {
+	return fmt.Sprintf("%s: %s: %v", ErrEgressDenied, e.Destination, e.Err)
+}
+
+// Unwrap returns the reason given by the policy.
+func (e *EgressPolicyError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true for ErrEgressDenied.
+func (e *EgressPolicyError) Is(target error) bool {
+	return target == ErrEgressDenied
+}
+
+// WithEgressPolicy restricts the connections the client makes to those
+// the policy allows, such as to let a controller talk only to the endpoints
+// of its cloud provider. A denied connection fails the request with an
+// *EgressPolicyError. Combine several policies with AllAddressPolicies.
+//
+// A host name is resolved before the policy is consulted, and only the
+// addresses the policy allows are dialed, so the name can't be resolved
+// again to a different address. The policy applies to the transport built
+// by the client, not to a base round tripper. A request sent through a
+// proxy is checked against the address of the proxy, not the host of its
+// URL. Unlike the dial breaker, the policy applies even if
+// WithTransportMiddlewares replaces the default middlewares.
+func WithEgressPolicy(policy AddressPolicy) Option {
+	return func(opt *options) {
+		opt.egressPolicy = policy
+	}
+}
+
+// validateAddressPolicy calls the Validate method of the policy, if it has
+// one.
+func validateAddressPolicy(policy AddressPolicy) error {
+	if v, ok := policy.(interface{ Validate() error }); ok {
+		return errors.Annotate(v.Validate(), "egress policy")
+	}
+	return nil
+}
+
+// AllAddressPolicies returns a policy allowing a destination only if every
+// one of the policies allows it.
+func AllAddressPolicies(policies ...AddressPolicy) AddressPolicy {
+	return allAddressPolicies(policies)
+}
+
+type allAddressPolicies []AddressPolicy
+
+// Validate validates each of the policies.
+func (p allAddressPolicies) Validate() error {
+	for _, policy := range p {
+		if policy == nil {
+			return errors.NotValidf("nil address policy")
+		}
+		if v, ok := policy.(interface{ Validate() error }); ok {
+			if err := v.Validate(); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	return nil
+}
+
+// Check implements AddressPolicy, returning the reason given by the first
+// policy that denies the destination.
+func (p allAddressPolicies) Check(ctx context.Context, dest EgressDestination) error {
+	for _, policy := range p {
+		if err := policy.Check(ctx, dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CIDRPolicy allows or denies destinations by their IP address.
+type CIDRPolicy struct {
+	// Allow lists the CIDRs, such as "10.0.0.0/8", of the addresses that
+	// may be dialed. If it is empty, any address that isn't denied may be
+	// dialed.
+	Allow []string
+	// Deny lists the CIDRs of the addresses that must not be dialed, even
+	// if they are allowed.
+	Deny []string
+}
+
+// Validate validates the CIDRPolicy for any issues.
+func (p CIDRPolicy) Validate() error {
+	for _, cidr := range append(append([]string(nil), p.Allow...), p.Deny...) {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return errors.NotValidf("CIDR %q", cidr)
+		}
+	}
+	return nil
+}
+
+// Check implements AddressPolicy. Destinations without an IP address are
+// only allowed if the allow list is empty.
+func (p CIDRPolicy) Check(_ context.Context, dest EgressDestination) error {
+	var addr netip.Addr
+	if dest.IP != nil {
+		addr, _ = netip.AddrFromSlice(dest.IP)
+		addr = addr.Unmap()
+	}
+	if cidr, ok := matchCIDR(p.Deny, addr); ok {
+		return errors.Errorf("address in denied CIDR %s", cidr)
+	}
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	if _, ok := matchCIDR(p.Allow, addr); !ok {
+		return errors.Errorf("address not in allowed CIDRs")
+	}
+	return nil
+}
+
+// matchCIDR returns the first of the CIDRs containing the address.
+func matchCIDR(cidrs []string, addr netip.Addr) (string, bool) {
+	if !addr.IsValid() {
+		return "", false
+	}
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err == nil && prefix.Contains(addr) {
+			return cidr, true
+		}
+	}
+	return "", false
+}
+
+// PortPolicy allows or denies destinations by their port.
+type PortPolicy struct {
+	// Allow lists the ports that may be dialed. If it is empty, any port
+	// that isn't denied may be dialed.
+	Allow []int
+	// Deny lists the ports that must not be dialed, even if they are
+	// allowed.
+	Deny []int
+}
+
+// Validate validates the PortPolicy for any issues.
+func (p PortPolicy) Validate() error {
+	for _, port := range append(append([]int(nil), p.Allow...), p.Deny...) {
+		if port < 1 || port > 65535 {
+			return errors.NotValidf("port %d", port)
+		}
+	}
+	return nil
+}
+
+// Check implements AddressPolicy. Destinations without a port are only
+// allowed if the allow list is empty.
+func (p PortPolicy) Check(_ context.Context, dest EgressDestination) error {
+	if containsPort(p.Deny, dest.Port) {
+		return errors.Errorf("port %d denied", dest.Port)
+	}
+	if len(p.Allow) > 0 && !containsPort(p.Allow, dest.Port) {
+		return errors.Errorf("port %d not allowed", dest.Port)
+	}
+	return nil
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// HostPolicy allows or denies destinations by their host name. Patterns
+// use path.Match syntax, such as "*.amazonaws.com", and are matched case
+// insensitively.
+type HostPolicy struct {
+	// Allow lists the patterns of the host names that may be dialed. If it
+	// is empty, any host name that isn't denied may be dialed.
+	Allow []string
+	// Deny lists the patterns of the host names that must not be dialed,
+	// even if they are allowed.
+	Deny []string
+}
+
+// Validate validates the HostPolicy for any issues.
+func (p HostPolicy) Validate() error {
+	for _, pattern := range append(append([]string(nil), p.Allow...), p.Deny...) {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return errors.NotValidf("host pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// Check implements AddressPolicy. An address dialed directly, rather than
+// by host name, is matched as it is, so it is only allowed by a pattern
+// matching it, such as "10.0.0.*".
+func (p HostPolicy) Check(_ context.Context, dest EgressDestination) error {
+	host := strings.ToLower(strings.TrimSuffix(dest.Host, "."))
+	if pattern, ok := matchHost(p.Deny, host); ok {
+		return errors.Errorf("host %q matches denied pattern %q", dest.Host, pattern)
+	}
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	if _, ok := matchHost(p.Allow, host); !ok {
+		return errors.Errorf("host %q not allowed", dest.Host)
+	}
+	return nil
+}
+
+// matchHost returns the first of the patterns matching the host.
+func matchHost(patterns []string, host string) (string, bool) {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), host); matched {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+type egressHostKey struct{}
+
+// egressHostDialContext records the host name of addr in the context, for
+// the egress policy to be consulted with once it has been resolved by the
+// dial functions wrapped by it.
+func egressHostDialContext(dial dialContextFunc) dialContextFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(addr); err == nil && parseIP(host) == nil {
+			ctx = context.WithValue(ctx, egressHostKey{}, host)
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// egressDialContext consults the policy before dialing. A host name is
+// resolved with the lookup, and only the addresses the policy allows are
+// dialed.
+func egressDialContext(dial dialContextFunc, lookup lookupFunc, policy AddressPolicy) dialContextFunc {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			// Networks without ports, such as unix sockets.
+			dest := EgressDestination{Network: network, Host: addr}
+			if err := checkEgress(ctx, policy, dest); err != nil {
+				return nil, err
+			}
+			return dial(ctx, network, addr)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, errors.NotValidf("port of %s address %q", network, addr)
+		}
+		if ip := parseIP(host); ip != nil {
+			dest := EgressDestination{Network: network, Host: host, IP: ip, Port: port}
+			if name, ok := ctx.Value(egressHostKey{}).(string); ok {
+				dest.Host = name
+			}
+			if err := checkEgress(ctx, policy, dest); err != nil {
+				return nil, err
+			}
+			return dial(ctx, network, addr)
+		}
+
+		ipAddrs, err := lookup(ctx, host)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(ipAddrs) == 0 {
+			return nil, errors.NotFoundf("address for %q", host)
+		}
+		var (
+			addrs    []string
+			firstErr error
+		)
+		for _, ipAddr := range ipAddrs {
+			dest := EgressDestination{Network: network, Host: host, IP: ipAddr.IP, Port: port}
+			if err := checkEgress(ctx, policy, dest); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			addrs = append(addrs, net.JoinHostPort(ipAddr.IP.String(), portStr))
+		}
+		if len(addrs) == 0 {
+			return nil, firstErr
+		}
+		return dialAddrs(ctx, dial, network, addrs)
+	}
+}
+
+// checkEgress returns an *EgressPolicyError if the policy denies the
+// destination.
+func checkEgress(ctx context.Context, policy AddressPolicy, dest EgressDestination) error {
+	err := policy.Check(ctx, dest)
+	if err == nil {
+		return nil
+	}
+	midLogger.Debugf("dial to %s denied by egress policy: %v", dest, err)
+	return &EgressPolicyError{Destination: dest, Err: err}
+}
@@ -0,0 +1,241 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type egressSuite struct {
+	testing.IsolationSuite
+
+	lookups map[string][]string
+	port    string
+	hits    int
+}
+
+var _ = gc.Suite(&egressSuite{})
+
+func (s *egressSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.lookups = make(map[string][]string)
+	s.PatchValue(&lookupIPAddr, func(_ context.Context, host string) ([]net.IPAddr, error) {
+		addrs, ok := s.lookups[host]
+		if !ok {
+			return nil, errors.NotFoundf("host %q", host)
+		}
+		ipAddrs := make([]net.IPAddr, len(addrs))
+		for i, addr := range addrs {
+			ipAddrs[i] = net.IPAddr{IP: net.ParseIP(addr)}
+		}
+		return ipAddrs, nil
+	})
+
+	s.hits = 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.hits++
+	}))
+	s.AddCleanup(func(*gc.C) { server.Close() })
+	serverURL, err := url.Parse(server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	s.port = serverURL.Port()
+}
+
+func (s *egressSuite) get(client *Client, host string) error {
+	resp, err := client.Get(context.Background(), "http://"+net.JoinHostPort(host, s.port))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *egressSuite) TestCIDRPolicy(c *gc.C) {
+	policy := CIDRPolicy{
+		Allow: []string{"10.0.0.0/8", "2001:db8::/32"},
+		Deny:  []string{"10.1.0.0/16"},
+	}
+	c.Assert(policy.Validate(), jc.ErrorIsNil)
+	tests := []struct {
+		ip  string
+		err string
+	}{
+		{ip: "10.0.0.1"},
+		{ip: "::ffff:10.0.0.1"},
+		{ip: "2001:db8::1"},
+		{ip: "10.1.0.1", err: `address in denied CIDR 10.1.0.0/16`},
+		{ip: "192.0.2.1", err: `address not in allowed CIDRs`},
+		{err: `address not in allowed CIDRs`},
+	}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.ip)
+		err := policy.Check(context.Background(), EgressDestination{IP: net.ParseIP(test.ip)})
+		if test.err == "" {
+			c.Check(err, jc.ErrorIsNil)
+		} else {
+			c.Check(err, gc.ErrorMatches, test.err)
+		}
+	}
+
+	// Without an allow list, anything not denied is allowed.
+	policy = CIDRPolicy{Deny: []string{"169.254.169.254/32"}}
+	c.Check(policy.Check(context.Background(), EgressDestination{IP: net.ParseIP("192.0.2.1")}), jc.ErrorIsNil)
+	c.Check(policy.Check(context.Background(), EgressDestination{Host: "/var/run/socket"}), jc.ErrorIsNil)
+	c.Check(policy.Check(context.Background(), EgressDestination{IP: net.ParseIP("169.254.169.254")}),
+		gc.ErrorMatches, `address in denied CIDR 169.254.169.254/32`)
+
+	c.Check(CIDRPolicy{Deny: []string{"10.0.0.0"}}.Validate(), gc.ErrorMatches, `CIDR "10.0.0.0" not valid`)
+}
+
+func (s *egressSuite) TestPortPolicy(c *gc.C) {
+	policy := PortPolicy{Allow: []int{80, 443}, Deny: []int{80}}
+	c.Assert(policy.Validate(), jc.ErrorIsNil)
+	c.Check(policy.Check(context.Background(), EgressDestination{Port: 443}), jc.ErrorIsNil)
+	c.Check(policy.Check(context.Background(), EgressDestination{Port: 80}), gc.ErrorMatches, `port 80 denied`)
+	c.Check(policy.Check(context.Background(), EgressDestination{Port: 8080}), gc.ErrorMatches, `port 8080 not allowed`)
+
+	c.Check(PortPolicy{Deny: []int{22}}.Check(context.Background(), EgressDestination{Port: 8080}), jc.ErrorIsNil)
+	c.Check(PortPolicy{Allow: []int{0}}.Validate(), gc.ErrorMatches, `port 0 not valid`)
+	c.Check(PortPolicy{Deny: []int{65536}}.Validate(), gc.ErrorMatches, `port 65536 not valid`)
+}
+
+func (s *egressSuite) TestHostPolicy(c *gc.C) {
+	policy := HostPolicy{
+		Allow: []string{"*.amazonaws.com", "charmhub.io"},
+		Deny:  []string{"metadata.*.amazonaws.com"},
+	}
+	c.Assert(policy.Validate(), jc.ErrorIsNil)
+	tests := []struct {
+		host string
+		err  string
+	}{
+		{host: "ec2.amazonaws.com"},
+		{host: "EC2.AmazonAWS.com."},
+		{host: "charmhub.io"},
+		{host: "metadata.ec2.amazonaws.com", err: `host "metadata.ec2.amazonaws.com" matches denied pattern "metadata.\*.amazonaws.com"`},
+		{host: "api.charmhub.io", err: `host "api.charmhub.io" not allowed`},
+		{host: "10.0.0.1", err: `host "10.0.0.1" not allowed`},
+	}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.host)
+		err := policy.Check(context.Background(), EgressDestination{Host: test.host})
+		if test.err == "" {
+			c.Check(err, jc.ErrorIsNil)
+		} else {
+			c.Check(err, gc.ErrorMatches, test.err)
+		}
+	}
+
+	c.Check(HostPolicy{Allow: []string{"[a-"}}.Validate(), gc.ErrorMatches, `host pattern "\[a-" not valid`)
+	c.Check(HostPolicy{Deny: []string{""}}.Validate(), gc.ErrorMatches, `host pattern "" not valid`)
+}
+
+func (s *egressSuite) TestAllAddressPolicies(c *gc.C) {
+	policy := AllAddressPolicies(
+		HostPolicy{Allow: []string{"*.charmhub.io"}},
+		PortPolicy{Allow: []int{443}},
+	)
+	c.Check(policy.Check(context.Background(), EgressDestination{Host: "api.charmhub.io", Port: 443}), jc.ErrorIsNil)
+	c.Check(policy.Check(context.Background(), EgressDestination{Host: "api.charmhub.io", Port: 80}),
+		gc.ErrorMatches, `port 80 not allowed`)
+	c.Check(policy.Check(context.Background(), EgressDestination{Host: "example.com", Port: 443}),
+		gc.ErrorMatches, `host "example.com" not allowed`)
+
+	opts := newOptions()
+	WithEgressPolicy(AllAddressPolicies(HostPolicy{}, PortPolicy{Allow: []int{-1}}))(opts)
+	c.Check(opts.validate(), gc.ErrorMatches, `egress policy: port -1 not valid`)
+
+	opts = newOptions()
+	WithEgressPolicy(AllAddressPolicies(nil))(opts)
+	c.Check(opts.validate(), gc.ErrorMatches, `egress policy: nil address policy not valid`)
+}
+
+func (s *egressSuite) TestEgressDenied(c *gc.C) {
+	client := NewClient(WithEgressPolicy(CIDRPolicy{Deny: []string{"127.0.0.0/8"}}))
+	err := s.get(client, "127.0.0.1")
+	c.Assert(err, jc.ErrorIs, ErrEgressDenied)
+	c.Check(err, gc.ErrorMatches, `.*egress denied by policy: 127.0.0.1:`+s.port+`: address in denied CIDR 127.0.0.0/8`)
+	policyErr, ok := errors.AsType[*EgressPolicyError](err)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(policyErr.Destination.Network, gc.Equals, "tcp")
+	c.Check(policyErr.Destination.Host, gc.Equals, "127.0.0.1")
+	c.Check(policyErr.Destination.IP.String(), gc.Equals, "127.0.0.1")
+	c.Check(policyErr.Destination.Port, gc.Not(gc.Equals), 0)
+	c.Check(s.hits, gc.Equals, 0)
+}
+
+func (s *egressSuite) TestEgressAllowedAddressesDialed(c *gc.C) {
+	// Only the allowed address of the host is dialed.
+	s.lookups["charmhub.io"] = []string{"192.0.2.1", "127.0.0.1"}
+	client := NewClient(WithEgressPolicy(AllAddressPolicies(
+		HostPolicy{Allow: []string{"charmhub.io"}},
+		CIDRPolicy{Allow: []string{"127.0.0.0/8"}},
+	)))
+	c.Assert(s.get(client, "charmhub.io"), jc.ErrorIsNil)
+	c.Check(s.hits, gc.Equals, 1)
+
+	err := s.get(client, "127.0.0.1")
+	c.Assert(err, jc.ErrorIs, ErrEgressDenied)
+	c.Check(err, gc.ErrorMatches, `.*host "127.0.0.1" not allowed`)
+	c.Check(s.hits, gc.Equals, 1)
+}
+
+func (s *egressSuite) TestEgressNoAddressAllowed(c *gc.C) {
+	s.lookups["charmhub.io"] = []string{"192.0.2.1", "127.0.0.1"}
+	client := NewClient(WithEgressPolicy(CIDRPolicy{Allow: []string{"10.0.0.0/8"}}))
+	err := s.get(client, "charmhub.io")
+	c.Assert(err, jc.ErrorIs, ErrEgressDenied)
+	c.Check(err, gc.ErrorMatches, `.*egress denied by policy: charmhub.io \(192.0.2.1:`+s.port+`\): address not in allowed CIDRs`)
+	c.Check(s.hits, gc.Equals, 0)
+}
+
+func (s *egressSuite) TestEgressHostNameAfterResolving(c *gc.C) {
+	// The policy is consulted with the host name of the request, even when
+	// it is resolved by the client before reaching the policy.
+	client := NewClient(
+		WithHostMapping(map[string]string{"charmhub.io": "127.0.0.1"}),
+		WithEgressPolicy(HostPolicy{Allow: []string{"charmhub.io"}}),
+	)
+	c.Assert(s.get(client, "charmhub.io"), jc.ErrorIsNil)
+	c.Check(s.hits, gc.Equals, 1)
+
+	client = NewClient(
+		WithHostMapping(map[string]string{"charmhub.io": "127.0.0.1"}),
+		WithEgressPolicy(HostPolicy{Deny: []string{"charmhub.io"}}),
+	)
+	err := s.get(client, "charmhub.io")
+	c.Assert(err, jc.ErrorIs, ErrEgressDenied)
+	c.Check(err, gc.ErrorMatches, `.*charmhub.io \(127.0.0.1:`+s.port+`\): host "charmhub.io" matches denied pattern "charmhub.io"`)
+	c.Check(s.hits, gc.Equals, 1)
+}
+
+func (s *egressSuite) TestEgressWithTransportMiddlewares(c *gc.C) {
+	// The policy applies even without the default middlewares.
+	client := NewClient(
+		WithTransportMiddlewares(),
+		WithEgressPolicy(PortPolicy{Allow: []int{443}}),
+	)
+	err := s.get(client, "127.0.0.1")
+	c.Assert(err, jc.ErrorIs, ErrEgressDenied)
+	c.Check(s.hits, gc.Equals, 0)
+}
+
+func (s *egressSuite) TestEgressPolicyWithBaseRoundTripper(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	opts := newOptions()
+	WithBaseRoundTripper(NewMockRoundTripper(ctrl))(opts)
+	WithEgressPolicy(PortPolicy{})(opts)
+	c.Check(opts.validate(), gc.ErrorMatches, `egress policy with a base round tripper that is not an \*http.Transport not valid`)
+}
@@ -0,0 +1,153 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/juju/clock"
+)
+
+// EventKind is the kind of a client Event.
+type EventKind int
+
+const (
+	// EventRetry is emitted before a request is retried, with the number
+	// of the next attempt, the delay before it, and the status code or
+	// error of the failed attempt.
+	EventRetry EventKind = iota + 1
+	// EventCircuitOpen is emitted when a circuit breaker opens for a host,
+	// see CircuitBreakerMiddleware.
+	EventCircuitOpen
+	// EventProxy is emitted when the proxy for a request is chosen, with
+	// the proxy, or an empty proxy if the request is sent directly.
+	EventProxy
+	// EventDialFailover is emitted when a dial to an address of a host
+	// fails and the next address is tried, see WithPerAddressDialTimeout.
+	EventDialFailover
+	// EventSlowRequest is emitted for a request that took at least the
+	// slow request threshold, see WithSlowRequestThreshold.
+	EventSlowRequest
+)
+
+var eventKindNames = map[EventKind]string{
+	EventRetry:        "retry",
+	EventCircuitOpen:  "circuit-open",
+	EventProxy:        "proxy",
+	EventDialFailover: "dial-failover",
+	EventSlowRequest:  "slow-request",
+}
+
+// String returns the name of the event kind.
+func (k EventKind) String() string {
+	if name, ok := eventKindNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Event describes something that happened to a request made through a
+// client. Only the fields relevant to the kind of event are set.
+type Event struct {
+	// Kind is the kind of the event.
+	Kind EventKind
+	// Time is the time of the event.
+	Time time.Time
+	// Method is the method of the request.
+	Method string
+	// URL is the URL of the request, with any password redacted.
+	URL string
+
+	// Attempt is the number of the next attempt, for EventRetry.
+	Attempt int
+	// Delay is the delay before the next attempt, for EventRetry.
+	Delay time.Duration
+	// StatusCode is the status code of the failed attempt, for EventRetry.
+	StatusCode int
+	// Elapsed is the duration of the request, for EventSlowRequest.
+	Elapsed time.Duration
+	// Host is the host of the circuit breaker, for EventCircuitOpen.
+	Host string
+	// Proxy is the URL of the proxy, with any password redacted, for
+	// EventProxy.
+	Proxy string
+	// Addr is the address that failed, for EventDialFailover.
+	Addr string
+	// Err is the error that caused the event, if any.
+	Err error
+}
+
+// EventFunc is called with each event of the requests made through a
+// client. It is called synchronously on the request path, so should not
+// block, and is isolated from the request like other hooks, see
+// WithHookTimeout.
+type EventFunc func(Event)
+
+// WithEventSubscriber adds a subscriber to the events of the requests made
+// through the client, so that embedders can build consistent telemetry
+// from a single stream, rather than a hook for each feature. It can be
+// given more than once.
+func WithEventSubscriber(value EventFunc) Option {
+	return func(opt *options) {
+		opt.eventSubscribers = append(opt.eventSubscribers, value)
+	}
+}
+
+// WithSlowRequestThreshold emits an EventSlowRequest for requests that
+// take at least the threshold, until their response headers are received,
+// including any retries.
+func WithSlowRequestThreshold(value time.Duration) Option {
+	return func(opt *options) {
+		opt.slowRequestThreshold = value
+	}
+}
+
+type eventsKey struct{}
+
+// eventEmitter delivers events to the subscribers of a client.
+type eventEmitter struct {
+	subscribers []EventFunc
+	snapshot    *clientSnapshot
+	clock       clock.Clock
+	hooks       hookRunner
+}
+
+// requestEvents is the emitter of a request, carried by its context so
+// that any layer of the transport, including middlewares, can emit events.
+type requestEvents struct {
+	*eventEmitter
+	method string
+	url    string
+}
+
+// withEvents returns the request with a context carrying the emitter.
+func (e *eventEmitter) withEvents(req *http.Request) *http.Request {
+	events := &requestEvents{
+		eventEmitter: e,
+		method:       req.Method,
+		url:          req.URL.Redacted(),
+	}
+	return req.WithContext(context.WithValue(req.Context(), eventsKey{}, events))
+}
+
+// emitEvent delivers the event to the subscribers of the client that made
+// the request of the context, if any.
+func emitEvent(ctx context.Context, event Event) {
+	events, ok := ctx.Value(eventsKey{}).(*requestEvents)
+	if !ok {
+		return
+	}
+	event.Time = events.clock.Now()
+	event.Method = events.method
+	event.URL = events.url
+	logger := requestLogger(ctx, events.snapshot.load().logger)
+	for _, subscriber := range events.subscribers {
+		subscriber := subscriber
+		events.hooks.run(logger, "event subscriber", func() {
+			subscriber(event)
+		})
+	}
+}
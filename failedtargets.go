@@ -0,0 +1,248 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+)
+
+// ErrTargetFailing is matched, using errors.Is, by the error returned for a
+// dial rejected because its target failed recently, see FailedTargets.
+const ErrTargetFailing = errors.ConstError("target failing")
+
+// TargetFailingError is returned for a dial to a target that failed
+// recently, by a client sharing FailedTargets configured to fail fast.
+type TargetFailingError struct {
+	// Address is the address that was dialed.
+	Address string
+	// Until is the time at which the target is dialed again.
+	Until time.Time
+}
+
+// Error implements error.
+func (e *TargetFailingError) Error() string {
+	return fmt.Sprintf("%s: %q failed recently, avoided until %s", ErrTargetFailing, e.Address, e.Until.Format(time.RFC3339))
+}
+
+// Is returns true for ErrTargetFailing.
+func (e *TargetFailingError) Is(target error) bool {
+	return target == ErrTargetFailing
+}
+
+// FailedTargetsConfig configures FailedTargets.
+type FailedTargetsConfig struct {
+	// Penalty is how long a target is avoided after it fails. The penalty
+	// doubles with each further failure of the target, as counted by its
+	// decaying failure score.
+	Penalty time.Duration
+
+	// MaxPenalty is the longest a target is avoided for.
+	MaxPenalty time.Duration
+
+	// HalfLife is the time it takes for the failure score of a target to
+	// halve. The score of a target is incremented when it fails, and
+	// reset when it succeeds.
+	HalfLife time.Duration
+
+	// FastFail, if set, fails a dial to a target being avoided at once
+	// with a *TargetFailingError. Otherwise the target is only dialed
+	// after every other address of its host.
+	FastFail bool
+
+	// Clock is used to time the penalties. If nil, the wall clock is used.
+	Clock clock.Clock
+}
+
+// Validate validates the FailedTargetsConfig for any issues.
+func (c FailedTargetsConfig) Validate() error {
+	if c.Penalty <= 0 {
+		return errors.NotValidf("penalty %s", c.Penalty)
+	}
+	if c.MaxPenalty < c.Penalty {
+		return errors.NotValidf("max penalty %s less than penalty %s", c.MaxPenalty, c.Penalty)
+	}
+	if c.HalfLife <= 0 {
+		return errors.NotValidf("half life %s", c.HalfLife)
+	}
+	return nil
+}
+
+// FailedTargets tracks the dial targets, host and port pairs, that failed
+// recently, whether the name of the host didn't resolve or the connection
+// couldn't be made. Clients sharing it avoid a target for a penalty after
+// it fails, growing exponentially with its decaying failure score, so that
+// the workers of a process don't each time out against the same dead
+// controller address in turn. See WithFailedTargets.
+//
+// FailedTargets is safe for concurrent use, and is meant to be shared by
+// the clients of a process.
+type FailedTargets struct {
+	config FailedTargetsConfig
+
+	mu      sync.Mutex
+	targets map[string]*failedTarget
+}
+
+// failedTarget is the failure state of a target.
+type failedTarget struct {
+	score   float64
+	updated time.Time
+	until   time.Time
+}
+
+// NewFailedTargets returns FailedTargets configured by the config.
+func NewFailedTargets(config FailedTargetsConfig) (*FailedTargets, error) {
+	if err := config.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if config.Clock == nil {
+		config.Clock = clock.WallClock
+	}
+	return &FailedTargets{
+		config:  config,
+		targets: make(map[string]*failedTarget),
+	}, nil
+}
+
+// WithFailedTargets records the outcome of the dials made by the client in
+// the targets, which may be shared with other clients, and avoids the
+// targets that failed recently. A host that resolves to several addresses
+// has its failing addresses dialed last, or, if the targets are configured
+// to fail fast, not at all.
+//
+// Like WithResolver, it applies to the transport built by the client, not
+// to a base round tripper.
+func WithFailedTargets(value *FailedTargets) Option {
+	return func(opt *options) {
+		opt.failedTargets = value
+	}
+}
+
+// Failing returns true, and the time until which the target is avoided, if
+// the target at the address failed recently.
+func (t *FailedTargets) Failing(addr string) (time.Time, bool) {
+	addr = strings.ToLower(addr)
+	now := t.config.Clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	target, ok := t.targets[addr]
+	if !ok || !now.Before(target.until) {
+		return time.Time{}, false
+	}
+	return target.until, true
+}
+
+// record records the outcome of a dial to the target at the address.
+func (t *FailedTargets) record(addr string, failed bool) {
+	addr = strings.ToLower(addr)
+	now := t.config.Clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !failed {
+		delete(t.targets, addr)
+		return
+	}
+	t.prune(now)
+	target, ok := t.targets[addr]
+	if !ok {
+		target = &failedTarget{}
+		t.targets[addr] = target
+	}
+	target.score = t.decay(target, now) + 1
+	target.updated = now
+	target.until = now.Add(t.penalty(target.score))
+}
+
+// decay returns the score of the target, decayed since it was last updated.
+func (t *FailedTargets) decay(target *failedTarget, now time.Time) float64 {
+	elapsed := now.Sub(target.updated)
+	return target.score * math.Pow(0.5, float64(elapsed)/float64(t.config.HalfLife))
+}
+
+// penalty returns the penalty of a target with the score, doubling for
+// every failure beyond the first.
+func (t *FailedTargets) penalty(score float64) time.Duration {
+	penalty := float64(t.config.Penalty) * math.Pow(2, score-1)
+	if penalty >= float64(t.config.MaxPenalty) {
+		return t.config.MaxPenalty
+	}
+	return time.Duration(penalty)
+}
+
+// prune forgets the targets whose penalty has passed and whose score has
+// decayed to insignificance, so that the map only holds failing targets.
+func (t *FailedTargets) prune(now time.Time) {
+	for addr, target := range t.targets {
+		if !now.Before(target.until) && t.decay(target, now) < 0.1 {
+			delete(t.targets, addr)
+		}
+	}
+}
+
+// order returns the addresses with those that are failing moved after the
+// others, keeping their order otherwise.
+func (t *FailedTargets) order(addrs []string) []string {
+	ordered := make([]string, 0, len(addrs))
+	var failing []string
+	for _, addr := range addrs {
+		if _, ok := t.Failing(addr); ok {
+			failing = append(failing, addr)
+			continue
+		}
+		ordered = append(ordered, addr)
+	}
+	return append(ordered, failing...)
+}
+
+// dialContext records the outcome of each dial in the targets, failing
+// dials to failing targets at once if they are configured to fail fast.
+func (t *FailedTargets) dialContext(dial dialContextFunc) dialContextFunc {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if t.config.FastFail {
+			if until, ok := t.Failing(addr); ok {
+				return nil, &TargetFailingError{Address: addr, Until: until}
+			}
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil && (errors.Is(err, context.Canceled) || errors.Is(err, ErrAccessNotAllowed)) {
+			// Neither the caller giving up, nor the dial breaker denying
+			// the dial, is a failure of the target.
+			return nil, err
+		}
+		t.record(addr, err != nil)
+		return conn, err
+	}
+}
+
+type failedTargetsKey struct{}
+
+// failedTargetsDialContext records the targets in the context, for the
+// dial functions wrapped by it to order the addresses they dial with.
+func failedTargetsDialContext(dial dialContextFunc, targets *FailedTargets) dialContextFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dial(context.WithValue(ctx, failedTargetsKey{}, targets), network, addr)
+	}
+}
+
+// orderFailedTargets returns the addresses ordered by the failed targets in
+// the context, if any, so that failing addresses are dialed last.
+func orderFailedTargets(ctx context.Context, addrs []string) []string {
+	targets, ok := ctx.Value(failedTargetsKey{}).(*FailedTargets)
+	if !ok {
+		return addrs
+	}
+	return targets.order(addrs)
+}
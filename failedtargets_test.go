@@ -0,0 +1,231 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"time"
+
+	"github.com/juju/clock/testclock"
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type failedTargetsSuite struct {
+	testing.IsolationSuite
+
+	clock *testclock.Clock
+	addrs []string
+}
+
+var _ = gc.Suite(&failedTargetsSuite{})
+
+func (s *failedTargetsSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.clock = testclock.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	s.addrs = nil
+	s.PatchValue(&lookupIPAddr, func(_ context.Context, host string) ([]net.IPAddr, error) {
+		var ipAddrs []net.IPAddr
+		for _, addr := range s.addrs {
+			ipAddrs = append(ipAddrs, net.IPAddr{IP: net.ParseIP(addr)})
+		}
+		return ipAddrs, nil
+	})
+}
+
+func (s *failedTargetsSuite) newTargets(c *gc.C, fastFail bool) *FailedTargets {
+	targets, err := NewFailedTargets(FailedTargetsConfig{
+		Penalty:    time.Second,
+		MaxPenalty: 10 * time.Second,
+		HalfLife:   time.Minute,
+		FastFail:   fastFail,
+		Clock:      s.clock,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	return targets
+}
+
+func refused(dialed *[]string, addrs ...string) dialContextFunc {
+	next := dial(dialed)
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		for _, refused := range addrs {
+			if addr == refused {
+				*dialed = append(*dialed, addr)
+				return nil, &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}
+			}
+		}
+		return next(ctx, network, addr)
+	}
+}
+
+func (s *failedTargetsSuite) TestValidate(c *gc.C) {
+	valid := FailedTargetsConfig{Penalty: time.Second, MaxPenalty: time.Minute, HalfLife: time.Minute}
+	c.Assert(valid.Validate(), jc.ErrorIsNil)
+	tests := []struct {
+		update func(*FailedTargetsConfig)
+		err    string
+	}{{
+		update: func(config *FailedTargetsConfig) { config.Penalty = 0 },
+		err:    `penalty 0s not valid`,
+	}, {
+		update: func(config *FailedTargetsConfig) { config.MaxPenalty = time.Millisecond },
+		err:    `max penalty 1ms less than penalty 1s not valid`,
+	}, {
+		update: func(config *FailedTargetsConfig) { config.HalfLife = -time.Second },
+		err:    `half life -1s not valid`,
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.err)
+		config := valid
+		test.update(&config)
+		c.Check(config.Validate(), gc.ErrorMatches, test.err)
+		_, err := NewFailedTargets(config)
+		c.Check(err, gc.ErrorMatches, test.err)
+	}
+}
+
+func (s *failedTargetsSuite) TestPenalty(c *gc.C) {
+	targets := s.newTargets(c, false)
+	targets.record("192.0.2.1:443", true)
+	until, ok := targets.Failing("192.0.2.1:443")
+	c.Assert(ok, jc.IsTrue)
+	c.Check(until, gc.Equals, s.clock.Now().Add(time.Second))
+	_, ok = targets.Failing("192.0.2.2:443")
+	c.Check(ok, jc.IsFalse)
+
+	// Each further failure doubles the penalty, up to the max penalty.
+	targets.record("192.0.2.1:443", true)
+	until, _ = targets.Failing("192.0.2.1:443")
+	c.Check(until.Sub(s.clock.Now()) > 1900*time.Millisecond, jc.IsTrue)
+	for i := 0; i < 5; i++ {
+		targets.record("192.0.2.1:443", true)
+	}
+	until, _ = targets.Failing("192.0.2.1:443")
+	c.Check(until, gc.Equals, s.clock.Now().Add(10*time.Second))
+
+	s.clock.Advance(10 * time.Second)
+	_, ok = targets.Failing("192.0.2.1:443")
+	c.Check(ok, jc.IsFalse)
+
+	// A success forgets the failures.
+	targets.record("192.0.2.1:443", false)
+	targets.record("192.0.2.1:443", true)
+	until, _ = targets.Failing("192.0.2.1:443")
+	c.Check(until, gc.Equals, s.clock.Now().Add(time.Second))
+}
+
+func (s *failedTargetsSuite) TestScoreDecays(c *gc.C) {
+	targets := s.newTargets(c, false)
+	targets.record("192.0.2.1:443", true)
+	targets.record("192.0.2.1:443", true)
+	targets.record("192.0.2.1:443", true)
+
+	// After many half lives, the failures are all but forgotten, and the
+	// target is pruned by the next failure recorded.
+	s.clock.Advance(10 * time.Minute)
+	targets.record("192.0.2.2:443", true)
+	c.Check(targets.targets, gc.HasLen, 1)
+
+	targets.record("192.0.2.1:443", true)
+	until, _ := targets.Failing("192.0.2.1:443")
+	c.Check(until, gc.Equals, s.clock.Now().Add(time.Second))
+}
+
+func (s *failedTargetsSuite) TestFailingDialedLast(c *gc.C) {
+	s.addrs = []string{"192.0.2.1", "192.0.2.2"}
+	targets := s.newTargets(c, false)
+
+	var dialed []string
+	dialContext := failedTargetsDialContext(
+		fallbackDialContext(targets.dialContext(refused(&dialed, "192.0.2.1:80")), lookupIPAddr, time.Second),
+		targets,
+	)
+	for i := 0; i < 2; i++ {
+		conn, err := dialContext(context.Background(), "tcp", "controller:80")
+		c.Assert(err, jc.ErrorIsNil)
+		_ = conn.Close()
+	}
+	c.Check(dialed, jc.DeepEquals, []string{"192.0.2.1:80", "192.0.2.2:80", "192.0.2.2:80"})
+
+	// Once the penalty passes, the address is dialed first again.
+	s.clock.Advance(time.Second)
+	dialed = nil
+	_, err := dialContext(context.Background(), "tcp", "controller:80")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(dialed, jc.DeepEquals, []string{"192.0.2.1:80", "192.0.2.2:80"})
+}
+
+func (s *failedTargetsSuite) TestFastFail(c *gc.C) {
+	targets := s.newTargets(c, true)
+
+	var dialed []string
+	dialContext := targets.dialContext(refused(&dialed, "192.0.2.1:80"))
+	_, err := dialContext(context.Background(), "tcp", "192.0.2.1:80")
+	c.Check(err, gc.ErrorMatches, `dial: connection refused`)
+	_, err = dialContext(context.Background(), "tcp", "192.0.2.1:80")
+	c.Assert(err, jc.ErrorIs, ErrTargetFailing)
+	c.Check(err, gc.ErrorMatches, `target failing: "192.0.2.1:80" failed recently, avoided until 2024-01-01T00:00:01Z`)
+	failingErr, ok := errors.AsType[*TargetFailingError](err)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(failingErr.Address, gc.Equals, "192.0.2.1:80")
+	c.Check(dialed, gc.HasLen, 1)
+}
+
+func (s *failedTargetsSuite) TestNotFailures(c *gc.C) {
+	targets := s.newTargets(c, true)
+	dialContext := targets.dialContext(func(context.Context, string, string) (net.Conn, error) {
+		return nil, context.Canceled
+	})
+	_, err := dialContext(context.Background(), "tcp", "192.0.2.1:80")
+	c.Check(err, jc.ErrorIs, context.Canceled)
+	_, ok := targets.Failing("192.0.2.1:80")
+	c.Check(ok, jc.IsFalse)
+}
+
+func (s *failedTargetsSuite) TestSharedByClients(c *gc.C) {
+	// Nothing listens on the address of a closed listener.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, jc.ErrorIsNil)
+	addr := listener.Addr().String()
+	c.Assert(listener.Close(), jc.ErrorIsNil)
+
+	targets, err := NewFailedTargets(FailedTargetsConfig{
+		Penalty:    time.Minute,
+		MaxPenalty: time.Hour,
+		HalfLife:   time.Hour,
+		FastFail:   true,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	first := NewClient(WithFailedTargets(targets))
+	second := NewClient(WithFailedTargets(targets))
+
+	_, err = first.Get(context.Background(), "http://"+addr)
+	c.Assert(err, gc.NotNil)
+	c.Check(errors.Is(err, ErrTargetFailing), jc.IsFalse)
+	_, err = second.Get(context.Background(), "http://"+addr)
+	c.Check(err, jc.ErrorIs, ErrTargetFailing)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	resp, err := second.Get(context.Background(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(resp.Body.Close(), jc.ErrorIsNil)
+}
+
+func (s *failedTargetsSuite) TestWithBaseRoundTripper(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	opts := newOptions()
+	WithBaseRoundTripper(NewMockRoundTripper(ctrl))(opts)
+	WithFailedTargets(s.newTargets(c, false))(opts)
+	c.Check(opts.validate(), gc.ErrorMatches, `failed targets with a base round tripper that is not an \*http.Transport not valid`)
+}
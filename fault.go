@@ -0,0 +1,176 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/juju/errors"
+)
+
+// FaultClass classifies the cause of a failed request, so that callers can
+// report or handle failures without matching error text.
+type FaultClass int
+
+const (
+	// FaultNone is the class of a nil error or a successful response.
+	FaultNone FaultClass = iota
+	// FaultUnknown is the class of errors that fit no other class.
+	FaultUnknown
+	// FaultCanceled is the class of requests canceled by their context.
+	FaultCanceled
+	// FaultDNS is the class of failures to resolve the host name.
+	FaultDNS
+	// FaultConnectRefused is the class of connections refused by the host.
+	FaultConnectRefused
+	// FaultTLS is the class of TLS handshake and certificate failures.
+	FaultTLS
+	// FaultProxy is the class of failures to connect through a proxy.
+	FaultProxy
+	// FaultTimeout is the class of requests that timed out.
+	FaultTimeout
+	// FaultClientStatus is the class of 4xx responses.
+	FaultClientStatus
+	// FaultServerStatus is the class of 5xx responses.
+	FaultServerStatus
+	// FaultBody is the class of failures reading or validating a body.
+	FaultBody
+)
+
+var faultClassNames = map[FaultClass]string{
+	FaultNone:           "none",
+	FaultUnknown:        "unknown",
+	FaultCanceled:       "canceled",
+	FaultDNS:            "dns",
+	FaultConnectRefused: "connect-refused",
+	FaultTLS:            "tls",
+	FaultProxy:          "proxy",
+	FaultTimeout:        "timeout",
+	FaultClientStatus:   "client-status",
+	FaultServerStatus:   "server-status",
+	FaultBody:           "body",
+}
+
+// String returns the name of the fault class.
+func (f FaultClass) String() string {
+	if name, ok := faultClassNames[f]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ClassifyError returns the fault class of an error returned by a request,
+// or by reading its response body. Errors with a StatusCode() int method,
+// such as a *PreconditionFailedError, are classified by their status code.
+func ClassifyError(err error) FaultClass {
+	if err == nil {
+		return FaultNone
+	}
+	if errors.Is(err, context.Canceled) {
+		return FaultCanceled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return FaultTimeout
+	}
+	if statusErr, ok := errors.AsType[statusError](err); ok {
+		if class := classifyStatus(statusErr.StatusCode()); class != FaultNone {
+			return class
+		}
+	}
+	if opErr, ok := errors.AsType[*net.OpError](err); ok && opErr.Op == "proxyconnect" {
+		return FaultProxy
+	}
+	if _, ok := errors.AsType[*net.DNSError](err); ok {
+		return FaultDNS
+	}
+	if isTLSError(err) {
+		return FaultTLS
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return FaultConnectRefused
+	}
+	if netErr, ok := errors.AsType[net.Error](err); ok && netErr.Timeout() {
+		return FaultTimeout
+	}
+	if isBodyError(err) {
+		return FaultBody
+	}
+	return FaultUnknown
+}
+
+// ClassifyResponse returns the fault class of a response, which is
+// FaultNone unless it has a 4xx or 5xx status code.
+func ClassifyResponse(resp *http.Response) FaultClass {
+	return classifyStatus(resp.StatusCode)
+}
+
+// statusError is an error caused by a response with an error status code.
+type statusError interface {
+	error
+	StatusCode() int
+}
+
+func classifyStatus(code int) FaultClass {
+	switch {
+	case code >= 400 && code < 500:
+		return FaultClientStatus
+	case code >= 500 && code < 600:
+		return FaultServerStatus
+	}
+	return FaultNone
+}
+
+func isTLSError(err error) bool {
+	if errors.Is(err, ErrCertificateNotPinned) {
+		return true
+	}
+	if _, ok := errors.AsType[*tls.CertificateVerificationError](err); ok {
+		return true
+	}
+	if _, ok := errors.AsType[tls.RecordHeaderError](err); ok {
+		return true
+	}
+	if _, ok := errors.AsType[tls.AlertError](err); ok {
+		return true
+	}
+	if _, ok := errors.AsType[x509.UnknownAuthorityError](err); ok {
+		return true
+	}
+	if _, ok := errors.AsType[x509.HostnameError](err); ok {
+		return true
+	}
+	if _, ok := errors.AsType[x509.CertificateInvalidError](err); ok {
+		return true
+	}
+	// Alerts sent by the server are reported as an OpError.
+	opErr, ok := errors.AsType[*net.OpError](err)
+	return ok && opErr.Op == "remote error"
+}
+
+func isBodyError(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, http.ErrBodyReadAfterClose) {
+		return true
+	}
+	if _, ok := errors.AsType[*http.MaxBytesError](err); ok {
+		return true
+	}
+	_, ok := errors.AsType[*DigestMismatchError](err)
+	return ok
+}
+
+// StatusCode returns 412, the status code of the response.
+func (e *PreconditionFailedError) StatusCode() int {
+	return http.StatusPreconditionFailed
+}
+
+// StatusCode returns 503, the status code of the response.
+func (e *ServiceMaintenanceError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
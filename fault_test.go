@@ -0,0 +1,146 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type faultSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&faultSuite{})
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func (s *faultSuite) TestClassifyError(c *gc.C) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://example.com", Err: err}
+	}
+	tests := []struct {
+		about string
+		err   error
+		class FaultClass
+	}{{
+		about: "nil",
+		err:   nil,
+		class: FaultNone,
+	}, {
+		about: "unknown",
+		err:   errors.New("boom"),
+		class: FaultUnknown,
+	}, {
+		about: "canceled",
+		err:   urlErr(context.Canceled),
+		class: FaultCanceled,
+	}, {
+		about: "deadline exceeded",
+		err:   urlErr(context.DeadlineExceeded),
+		class: FaultTimeout,
+	}, {
+		about: "dns",
+		err:   urlErr(&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "example.com"}}),
+		class: FaultDNS,
+	}, {
+		about: "dns timeout",
+		err:   urlErr(&net.OpError{Op: "dial", Err: &net.DNSError{Err: "timeout", Name: "example.com", IsTimeout: true}}),
+		class: FaultDNS,
+	}, {
+		about: "connect refused",
+		err:   urlErr(&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}),
+		class: FaultConnectRefused,
+	}, {
+		about: "proxy",
+		err:   urlErr(&net.OpError{Op: "proxyconnect", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}),
+		class: FaultProxy,
+	}, {
+		about: "tls unknown authority",
+		err:   urlErr(x509.UnknownAuthorityError{}),
+		class: FaultTLS,
+	}, {
+		about: "tls remote alert",
+		err:   urlErr(&net.OpError{Op: "remote error", Err: errors.New("tls: bad certificate")}),
+		class: FaultTLS,
+	}, {
+		about: "timeout",
+		err:   urlErr(&net.OpError{Op: "read", Err: timeoutError{}}),
+		class: FaultTimeout,
+	}, {
+		about: "unexpected eof",
+		err:   errors.Annotate(io.ErrUnexpectedEOF, "reading body"),
+		class: FaultBody,
+	}, {
+		about: "digest mismatch",
+		err:   errors.Trace(&DigestMismatchError{}),
+		class: FaultBody,
+	}, {
+		about: "precondition failed",
+		err:   errors.Trace(&PreconditionFailedError{}),
+		class: FaultClientStatus,
+	}, {
+		about: "maintenance",
+		err:   urlErr(&ServiceMaintenanceError{}),
+		class: FaultServerStatus,
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.about)
+		c.Check(ClassifyError(test.err), gc.Equals, test.class)
+	}
+}
+
+func (s *faultSuite) TestClassifyResponse(c *gc.C) {
+	for code, class := range map[int]FaultClass{
+		http.StatusOK:                  FaultNone,
+		http.StatusNotModified:         FaultNone,
+		http.StatusNotFound:            FaultClientStatus,
+		http.StatusTooManyRequests:     FaultClientStatus,
+		http.StatusInternalServerError: FaultServerStatus,
+		http.StatusServiceUnavailable:  FaultServerStatus,
+	} {
+		c.Check(ClassifyResponse(&http.Response{StatusCode: code}), gc.Equals, class, gc.Commentf("status %d", code))
+	}
+}
+
+func (s *faultSuite) TestFaultClassString(c *gc.C) {
+	c.Check(FaultConnectRefused.String(), gc.Equals, "connect-refused")
+	c.Check(FaultClass(100).String(), gc.Equals, "unknown")
+}
+
+func (s *faultSuite) TestClassifyConnectRefused(c *gc.C) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, jc.ErrorIsNil)
+	addr := listener.Addr().String()
+	c.Assert(listener.Close(), jc.ErrorIsNil)
+
+	_, err = NewClient().Get(context.Background(), "http://"+addr)
+	c.Assert(err, gc.NotNil)
+	c.Check(ClassifyError(err), gc.Equals, FaultConnectRefused)
+}
+
+func (s *faultSuite) TestClassifyTLS(c *gc.C) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	_, err := NewClient().Get(context.Background(), server.URL)
+	c.Assert(err, gc.NotNil)
+	c.Check(ClassifyError(err), gc.Equals, FaultTLS)
+}
@@ -3,23 +3,35 @@ module github.com/juju/http/v2
 go 1.21
 
 require (
+	github.com/fxamacker/cbor/v2 v2.7.0
 	github.com/juju/clock v1.0.3
 	github.com/juju/errors v1.0.0
 	github.com/juju/loggo/v2 v2.0.0
 	github.com/juju/retry v1.0.0
 	github.com/juju/testing v1.1.0
+	github.com/prometheus/client_golang v1.19.1
+	go.etcd.io/bbolt v1.3.10
 	go.uber.org/mock v0.4.0
-	golang.org/x/net v0.7.0
+	golang.org/x/net v0.20.0
+	google.golang.org/protobuf v1.33.0
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/juju/loggo v1.0.0 // indirect
 	github.com/juju/utils/v3 v3.0.0 // indirect
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/kr/text v0.2.0 // indirect
-	github.com/rogpeppe/go-internal v1.9.0 // indirect
-	golang.org/x/crypto v0.3.0 // indirect
-	golang.org/x/text v0.7.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	github.com/rogpeppe/go-internal v1.10.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/crypto v0.18.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
 )
@@ -0,0 +1,45 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"time"
+)
+
+const (
+	// hardenedDialTimeout is the time limit of a dial of a client with
+	// HardenedDefaults.
+	hardenedDialTimeout = 10 * time.Second
+	// hardenedTLSHandshakeTimeout is the time limit of a TLS handshake of a
+	// client with HardenedDefaults.
+	hardenedTLSHandshakeTimeout = 10 * time.Second
+	// hardenedTimeout is the time limit of a request of a client with
+	// HardenedDefaults.
+	hardenedTimeout = time.Minute
+)
+
+// HardenedDefaults returns an option preset for security sensitive
+// components, such as the secrets backend client. It
+//   - drops the FileProtocolMiddleware and the ProxyMiddleware from the
+//     transport middlewares, keeping the dial breaker, so that file URLs
+//     can't be read and the proxy settings of the environment are ignored,
+//   - allows only https requests, including redirects, see
+//     WithAllowedSchemes,
+//   - limits a dial and a TLS handshake to 10 seconds, and a request to a
+//     minute, see WithTimeout.
+//
+// Like WithProfile, the preset is applied in place of HardenedDefaults, so
+// options given after it override those of the preset, such as WithProxyURL
+// to use a proxy explicitly, or WithTimeout for longer downloads.
+func HardenedDefaults() Option {
+	return func(opt *options) {
+		opt.middlewares = []TransportMiddleware{
+			opt.dialBreakerMiddleware(),
+		}
+		opt.allowedSchemes = []string{"https"}
+		opt.dialOptions.Timeout = hardenedDialTimeout
+		opt.tlsHandshakeTimeout = hardenedTLSHandshakeTimeout
+		opt.timeout = hardenedTimeout
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type hardenedSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&hardenedSuite{})
+
+func (s *hardenedSuite) TestHardenedDefaults(c *gc.C) {
+	s.PatchEnvironment("HTTPS_PROXY", "http://squid.example.com:3128")
+
+	client := NewClient(HardenedDefaults())
+	transport, err := InspectTransport(client)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(transport.Proxy, gc.IsNil)
+	c.Check(transport.TLSHandshakeTimeout, gc.Equals, 10*time.Second)
+	c.Check(client.Client().Timeout, gc.Equals, time.Minute)
+
+	// The file protocol isn't registered.
+	resp, err := transport.RoundTrip(&http.Request{
+		Method: "GET",
+		URL:    &url.URL{Scheme: "file", Path: "/etc/passwd"},
+		Header: http.Header{},
+	})
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+	c.Check(err, gc.ErrorMatches, `unsupported protocol scheme "file"`)
+
+	_, err = client.Get(context.Background(), "http://vault.example.com")
+	c.Check(err, gc.ErrorMatches, `.*scheme "http" of http://vault.example.com not allowed`)
+}
+
+func (s *hardenedSuite) TestHardenedDefaultsKeepDialBreaker(c *gc.C) {
+	client := NewClient(
+		HardenedDefaults(),
+		WithDialBreaker(NewLocalDialBreaker(false)),
+	)
+	_, err := client.Get(context.Background(), "https://0.1.2.3:1234")
+	c.Assert(err, jc.ErrorIs, ErrAccessNotAllowed)
+}
+
+func (s *hardenedSuite) TestHardenedDefaultsOverridden(c *gc.C) {
+	proxyURL, err := url.Parse("http://squid.example.com:3128")
+	c.Assert(err, jc.ErrorIsNil)
+
+	opts := newOptions()
+	for _, option := range []Option{
+		HardenedDefaults(),
+		WithProxyURL(proxyURL),
+		WithTimeout(time.Hour),
+	} {
+		option(opts)
+	}
+	c.Check(opts.middlewares, gc.HasLen, 1)
+	c.Check(opts.allowedSchemes, jc.DeepEquals, []string{"https"})
+	c.Check(opts.dialOptions.Timeout, gc.Equals, 10*time.Second)
+	c.Check(opts.timeout, gc.Equals, time.Hour)
+	c.Check(opts.validate(), jc.ErrorIsNil)
+
+	client := NewClient(HardenedDefaults(), WithProxyURL(proxyURL))
+	transport, err := InspectTransport(client)
+	c.Assert(err, jc.ErrorIsNil)
+	req, err := http.NewRequest("GET", "https://vault.example.com", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	proxy, err := transport.Proxy(req)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(proxy, jc.DeepEquals, proxyURL)
+}
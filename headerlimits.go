@@ -0,0 +1,130 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/juju/errors"
+)
+
+// ErrResponseHeaderLimit is matched, using errors.Is, by the error returned
+// for a response whose header exceeds a limit, see WithResponseHeaderLimits.
+const ErrResponseHeaderLimit = errors.ConstError("response header limit exceeded")
+
+// ResponseHeaderLimitError is returned for a response whose header exceeds
+// a limit.
+type ResponseHeaderLimitError struct {
+	// Limit is the limit exceeded, either "fields" or "field bytes".
+	Limit string
+	// Field is the name of the field exceeding the field bytes limit.
+	Field string
+	// Value is the number of fields, or the size of the field.
+	Value int
+	// Max is the limit.
+	Max int
+}
+
+// Error implements error.
+func (e *ResponseHeaderLimitError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%s: %s field %q of %d bytes exceeds %d", ErrResponseHeaderLimit, e.Limit, e.Field, e.Value, e.Max)
+	}
+	return fmt.Sprintf("%s: %d %s exceeds %d", ErrResponseHeaderLimit, e.Value, e.Limit, e.Max)
+}
+
+// Is returns true for ErrResponseHeaderLimit.
+func (e *ResponseHeaderLimitError) Is(target error) bool {
+	return target == ErrResponseHeaderLimit
+}
+
+// ResponseHeaderLimits limits the headers of responses, protecting the
+// client from malicious or broken servers. Zero values leave a limit
+// unset.
+type ResponseHeaderLimits struct {
+	// MaxBytes limits the total size of the header, setting the
+	// MaxResponseHeaderBytes of the transport, which aborts reading a
+	// larger header. It requires the transport of the client to be an
+	// *http.Transport.
+	MaxBytes int64
+
+	// MaxFields limits the number of header fields, counting each value of
+	// a repeated field.
+	MaxFields int
+
+	// MaxFieldBytes limits the size of a header field, its name and value.
+	MaxFieldBytes int
+}
+
+// Validate validates the ResponseHeaderLimits for any issues.
+func (l ResponseHeaderLimits) Validate() error {
+	if l.MaxBytes < 0 {
+		return errors.NotValidf("negative max response header bytes")
+	}
+	if l.MaxFields < 0 {
+		return errors.NotValidf("negative max response header fields")
+	}
+	if l.MaxFieldBytes < 0 {
+		return errors.NotValidf("negative max response header field bytes")
+	}
+	return nil
+}
+
+// WithResponseHeaderLimits rejects responses whose headers exceed the
+// limits, with a *ResponseHeaderLimitError, or the error of the transport
+// for MaxBytes, and closes their bodies.
+func WithResponseHeaderLimits(value ResponseHeaderLimits) Option {
+	return func(opt *options) {
+		opt.responseHeaderLimits = &value
+	}
+}
+
+// headerLimitTransport rejects responses with too many or too large header
+// fields.
+type headerLimitTransport struct {
+	wrappedRoundTripper http.RoundTripper
+	limits              ResponseHeaderLimits
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t headerLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.wrappedRoundTripper.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if err := t.check(resp.Header); err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t headerLimitTransport) check(header http.Header) error {
+	var fields int
+	for name, values := range header {
+		fields += len(values)
+		if t.limits.MaxFieldBytes <= 0 {
+			continue
+		}
+		for _, value := range values {
+			if size := len(name) + len(value); size > t.limits.MaxFieldBytes {
+				return &ResponseHeaderLimitError{
+					Limit: "field bytes",
+					Field: name,
+					Value: size,
+					Max:   t.limits.MaxFieldBytes,
+				}
+			}
+		}
+	}
+	if t.limits.MaxFields > 0 && fields > t.limits.MaxFields {
+		return &ResponseHeaderLimitError{
+			Limit: "fields",
+			Value: fields,
+			Max:   t.limits.MaxFields,
+		}
+	}
+	return nil
+}
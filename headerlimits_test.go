@@ -0,0 +1,103 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type headerLimitsSuite struct {
+	testing.IsolationSuite
+
+	server *httptest.Server
+}
+
+var _ = gc.Suite(&headerLimitsSuite{})
+
+func (s *headerLimitsSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 10; i++ {
+			w.Header().Add("X-Juju-Field", fmt.Sprintf("value-%d", i))
+		}
+		if r.URL.Path == "/large" {
+			w.Header().Set("X-Juju-Large", strings.Repeat("x", 8192))
+		}
+	}))
+}
+
+func (s *headerLimitsSuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+	s.IsolationSuite.TearDownTest(c)
+}
+
+func (s *headerLimitsSuite) TestWithinLimits(c *gc.C) {
+	client := NewClient(WithResponseHeaderLimits(ResponseHeaderLimits{
+		MaxBytes:      4096,
+		MaxFields:     20,
+		MaxFieldBytes: 100,
+	}))
+	resp, err := client.Get(context.Background(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	_ = resp.Body.Close()
+	c.Check(resp.Header.Values("X-Juju-Field"), gc.HasLen, 10)
+}
+
+func (s *headerLimitsSuite) TestMaxFields(c *gc.C) {
+	client := NewClient(WithResponseHeaderLimits(ResponseHeaderLimits{MaxFields: 5}))
+	_, err := client.Get(context.Background(), s.server.URL)
+	c.Assert(err, jc.ErrorIs, ErrResponseHeaderLimit)
+	limitErr, ok := errors.AsType[*ResponseHeaderLimitError](err)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(limitErr.Limit, gc.Equals, "fields")
+	c.Check(limitErr.Max, gc.Equals, 5)
+	c.Check(err, gc.ErrorMatches, `.*response header limit exceeded: 1\d fields exceeds 5`)
+}
+
+func (s *headerLimitsSuite) TestMaxFieldBytes(c *gc.C) {
+	client := NewClient(WithResponseHeaderLimits(ResponseHeaderLimits{MaxFieldBytes: 1024}))
+	_, err := client.Get(context.Background(), s.server.URL+"/large")
+	c.Assert(err, jc.ErrorIs, ErrResponseHeaderLimit)
+	c.Check(err, gc.ErrorMatches, `.*response header limit exceeded: field bytes field "X-Juju-Large" of 8204 bytes exceeds 1024`)
+}
+
+func (s *headerLimitsSuite) TestMaxBytes(c *gc.C) {
+	client := NewClient(WithResponseHeaderLimits(ResponseHeaderLimits{MaxBytes: 1024}))
+	transport, err := InspectTransport(client)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(transport.MaxResponseHeaderBytes, gc.Equals, int64(1024))
+
+	_, err = client.Get(context.Background(), s.server.URL+"/large")
+	c.Assert(err, gc.ErrorMatches, `.*server response headers exceeded 1024 bytes.*`)
+}
+
+func (s *headerLimitsSuite) TestInvalid(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	client := NewClient(
+		WithLogger(logger(ctrl)),
+		WithResponseHeaderLimits(ResponseHeaderLimits{MaxFields: -1}),
+	)
+	_, err := client.Get(context.Background(), s.server.URL)
+	c.Assert(err, gc.ErrorMatches, `.*negative max response header fields not valid`)
+
+	client = NewClient(
+		WithLogger(logger(ctrl)),
+		WithBaseRoundTripper(NewMockRoundTripper(ctrl)),
+		WithResponseHeaderLimits(ResponseHeaderLimits{MaxBytes: 1024}),
+	)
+	_, err = client.Get(context.Background(), s.server.URL)
+	c.Assert(err, gc.ErrorMatches, `.*max response header bytes with a base round tripper that is not an \*http.Transport not valid`)
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"time"
+
+	"github.com/juju/clock"
+)
+
+// WithHookTimeout bounds how long the request path waits for a request
+// recorder or other user supplied hook to return. A hook that takes longer
+// is left to complete in the background and an error is logged. A zero
+// timeout, the default, waits for hooks to complete.
+//
+// Regardless of the timeout, a panicking hook is recovered and logged, so it
+// can not break the request.
+func WithHookTimeout(value time.Duration) Option {
+	return func(opt *options) {
+		opt.hookTimeout = value
+	}
+}
+
+// hookRunner invokes user supplied hooks, isolating the request path from
+// panics and, if a timeout is set, from slow hooks.
+type hookRunner struct {
+	clock   clock.Clock
+	timeout time.Duration
+}
+
+// run invokes the named hook, logging any panic or timeout to the logger.
+func (r hookRunner) run(logger Logger, name string, fn func()) {
+	if r.timeout <= 0 {
+		r.call(logger, name, fn)
+		return
+	}
+
+	clk := r.clock
+	if clk == nil {
+		clk = clock.WallClock
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.call(logger, name, fn)
+	}()
+	select {
+	case <-done:
+	case <-clk.After(r.timeout):
+		logger.Errorf("%s did not complete within %s", name, r.timeout)
+	}
+}
+
+func (r hookRunner) call(logger Logger, name string, fn func()) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Errorf("%s panicked: %v", name, p)
+		}
+	}()
+	fn()
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type hooksSuite struct {
+	testing.IsolationSuite
+	server *httptest.Server
+}
+
+var _ = gc.Suite(&hooksSuite{})
+
+func (s *hooksSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+}
+
+func (s *hooksSuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+	s.IsolationSuite.TearDownTest(c)
+}
+
+func (s *hooksSuite) TestPanickingRecorder(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	recorder := NewMockRequestRecorder(ctrl)
+	recorder.EXPECT().Record(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Do(
+		func(string, *url.URL, *http.Response, time.Duration) {
+			panic("boom")
+		})
+
+	logger := NewMockLogger(ctrl)
+	logger.EXPECT().IsTraceEnabled().Return(false).AnyTimes()
+	logger.EXPECT().Errorf("%s panicked: %v", "request recorder", "boom")
+
+	client := NewClient(WithRequestRecorder(recorder), WithLogger(logger))
+	resp, err := client.Get(context.TODO(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+}
+
+func (s *hooksSuite) TestSlowRecorder(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	release := make(chan struct{})
+	recorded := make(chan struct{})
+	recorder := NewMockRequestRecorder(ctrl)
+	recorder.EXPECT().Record(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Do(
+		func(string, *url.URL, *http.Response, time.Duration) {
+			<-release
+			close(recorded)
+		})
+
+	logger := NewMockLogger(ctrl)
+	logger.EXPECT().IsTraceEnabled().Return(false).AnyTimes()
+	logger.EXPECT().Errorf("%s did not complete within %s", "request recorder", time.Millisecond)
+
+	client := NewClient(
+		WithRequestRecorder(recorder),
+		WithLogger(logger),
+		WithHookTimeout(time.Millisecond),
+	)
+	resp, err := client.Get(context.TODO(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+
+	// The recorder completes in the background.
+	close(release)
+	select {
+	case <-recorded:
+	case <-time.After(testing.LongWait):
+		c.Fatalf("timed out waiting for recorder")
+	}
+}
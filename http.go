@@ -4,10 +4,16 @@
 package http
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
 	"net/http"
 	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/juju/errors"
 )
 
 // BasicAuthHeader creates a header that contains just the "Authorization"
@@ -47,3 +53,99 @@ func ParseBasicAuthHeader(h http.Header) (userid, password string, err error) {
 	}
 	return tokens[0], tokens[1], nil
 }
+
+// BasicAuthHeaderStrict creates a header that contains just the
+// "Authorization" entry, as BasicAuthHeader does, after checking that the
+// credentials can be sent as RFC 7617 requires: they must be valid UTF-8,
+// without control characters, and the userid must not contain a colon.
+func BasicAuthHeaderStrict(userid, password string) (http.Header, error) {
+	if err := validateBasicAuthCredentials(userid, password); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return BasicAuthHeader(userid, password), nil
+}
+
+// ParseBasicAuthHeaderStrict parses the Authorization header of the supplied
+// http.Header, as ParseBasicAuthHeader does, following RFC 7617: the
+// scheme is matched case insensitively, and the credentials are decoded as
+// UTF-8, the charset the server asks for with BasicAuthChallengeHeader,
+// and must not contain control characters.
+func ParseBasicAuthHeaderStrict(h http.Header) (userid, password string, err error) {
+	scheme, credentials, _ := strings.Cut(strings.TrimSpace(h.Get("Authorization")), " ")
+	credentials = strings.TrimSpace(credentials)
+	if !strings.EqualFold(scheme, "Basic") || credentials == "" {
+		return "", "", errors.NotValidf("missing or non Basic HTTP auth header")
+	}
+	challenge, err := base64.StdEncoding.DecodeString(credentials)
+	if err != nil {
+		return "", "", errors.NotValidf("HTTP auth encoding")
+	}
+	userid, password, ok := strings.Cut(string(challenge), ":")
+	if !ok {
+		return "", "", errors.NotValidf("HTTP auth contents without a colon")
+	}
+	if err := validateBasicAuthCredentials(userid, password); err != nil {
+		return "", "", errors.Trace(err)
+	}
+	return userid, password, nil
+}
+
+// BasicAuthChallengeHeader creates a header that contains just the
+// "WWW-Authenticate" entry, asking for Basic credentials for the realm,
+// encoded as UTF-8, as described by RFC 7617.
+func BasicAuthChallengeHeader(realm string) http.Header {
+	realm = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(realm)
+	return http.Header{
+		"Www-Authenticate": {fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, realm)},
+	}
+}
+
+// BasicAuthCredentialsMatch reports whether the userid and password match
+// the expected ones, in time independent of their contents, so that a
+// server validating credentials doesn't reveal how much of them is right.
+func BasicAuthCredentialsMatch(userid, password, expectedUserid, expectedPassword string) bool {
+	// The hashes are compared, rather than the strings, as
+	// subtle.ConstantTimeCompare returns early on a length mismatch.
+	useridHash := sha256.Sum256([]byte(userid))
+	expectedUseridHash := sha256.Sum256([]byte(expectedUserid))
+	passwordHash := sha256.Sum256([]byte(password))
+	expectedPasswordHash := sha256.Sum256([]byte(expectedPassword))
+	useridMatch := subtle.ConstantTimeCompare(useridHash[:], expectedUseridHash[:])
+	passwordMatch := subtle.ConstantTimeCompare(passwordHash[:], expectedPasswordHash[:])
+	return useridMatch&passwordMatch == 1
+}
+
+// CheckBasicAuthHeader parses the Authorization header of the supplied
+// http.Header with ParseBasicAuthHeaderStrict, and reports whether its
+// credentials match the expected ones, see BasicAuthCredentialsMatch.
+func CheckBasicAuthHeader(h http.Header, expectedUserid, expectedPassword string) bool {
+	userid, password, err := ParseBasicAuthHeaderStrict(h)
+	if err != nil {
+		return false
+	}
+	return BasicAuthCredentialsMatch(userid, password, expectedUserid, expectedPassword)
+}
+
+// validateBasicAuthCredentials checks the credentials against the rules of
+// RFC 7617, Section 2.
+func validateBasicAuthCredentials(userid, password string) error {
+	if strings.Contains(userid, ":") {
+		return errors.NotValidf("HTTP auth userid containing a colon")
+	}
+	if err := validateBasicAuthText("userid", userid); err != nil {
+		return errors.Trace(err)
+	}
+	return validateBasicAuthText("password", password)
+}
+
+func validateBasicAuthText(name, value string) error {
+	if !utf8.ValidString(value) {
+		return errors.NotValidf("HTTP auth %s encoding", name)
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return errors.NotValidf("HTTP auth %s containing control character %U", name, r)
+		}
+	}
+	return nil
+}
@@ -8,7 +8,9 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/juju/errors"
 	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
 
 	jujuhttp "github.com/juju/http/v2"
@@ -88,3 +90,100 @@ func (s *httpSuite) TestParseBasicAuthHeader(c *gc.C) {
 		}
 	}
 }
+
+func (s *httpSuite) TestBasicAuthHeaderStrict(c *gc.C) {
+	header, err := jujuhttp.BasicAuthHeaderStrict("zoë", "sekrit")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(header.Get("Authorization"), gc.Equals, "Basic "+base64.StdEncoding.EncodeToString([]byte("zoë:sekrit")))
+
+	_, err = jujuhttp.BasicAuthHeaderStrict("user:name", "sekrit")
+	c.Check(err, gc.ErrorMatches, "HTTP auth userid containing a colon not valid")
+	_, err = jujuhttp.BasicAuthHeaderStrict("user\nname", "sekrit")
+	c.Check(err, gc.ErrorMatches, `HTTP auth userid containing control character U\+000A not valid`)
+	_, err = jujuhttp.BasicAuthHeaderStrict("user", "sek\x7frit")
+	c.Check(err, gc.ErrorMatches, `HTTP auth password containing control character U\+007F not valid`)
+	_, err = jujuhttp.BasicAuthHeaderStrict("user\xff", "sekrit")
+	c.Check(err, gc.ErrorMatches, `HTTP auth userid encoding not valid`)
+}
+
+func (s *httpSuite) TestParseBasicAuthHeaderStrict(c *gc.C) {
+	encode := func(credentials string) http.Header {
+		return http.Header{
+			"Authorization": {"basic  " + base64.StdEncoding.EncodeToString([]byte(credentials))},
+		}
+	}
+	tests := []struct {
+		about          string
+		h              http.Header
+		expectUserid   string
+		expectPassword string
+		expectError    string
+	}{{
+		about:       "no Authorization header",
+		h:           http.Header{},
+		expectError: "missing or non Basic HTTP auth header not valid",
+	}, {
+		about: "Not basic encoding",
+		h: http.Header{
+			"Authorization": {"Bearer stuff"},
+		},
+		expectError: "missing or non Basic HTTP auth header not valid",
+	}, {
+		about: "invalid base64",
+		h: http.Header{
+			"Authorization": {"Basic not-base64"},
+		},
+		expectError: "HTTP auth encoding not valid",
+	}, {
+		about:       "no ':'",
+		h:           encode("aladdin"),
+		expectError: "HTTP auth contents without a colon not valid",
+	}, {
+		about:       "control character in userid",
+		h:           encode("ala\x00ddin:open sesame"),
+		expectError: `HTTP auth userid containing control character U\+0000 not valid`,
+	}, {
+		about:       "invalid UTF-8 in password",
+		h:           encode("aladdin:open \xc3sesame"),
+		expectError: "HTTP auth password encoding not valid",
+	}, {
+		about:          "valid UTF-8 credentials, with a case insensitive scheme",
+		h:              encode("jürgen:open:sesame"),
+		expectUserid:   "jürgen",
+		expectPassword: "open:sesame",
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.about)
+		u, p, err := jujuhttp.ParseBasicAuthHeaderStrict(test.h)
+		c.Check(u, gc.Equals, test.expectUserid)
+		c.Check(p, gc.Equals, test.expectPassword)
+		if test.expectError != "" {
+			c.Check(err, gc.ErrorMatches, test.expectError)
+			c.Check(err, jc.Satisfies, errors.IsNotValid)
+		} else {
+			c.Check(err, jc.ErrorIsNil)
+		}
+	}
+}
+
+func (s *httpSuite) TestBasicAuthChallengeHeader(c *gc.C) {
+	header := jujuhttp.BasicAuthChallengeHeader(`juju "controller"`)
+	c.Assert(header.Get("WWW-Authenticate"), gc.Equals, `Basic realm="juju \"controller\"", charset="UTF-8"`)
+}
+
+func (s *httpSuite) TestBasicAuthCredentialsMatch(c *gc.C) {
+	c.Check(jujuhttp.BasicAuthCredentialsMatch("aladdin", "open sesame", "aladdin", "open sesame"), jc.IsTrue)
+	c.Check(jujuhttp.BasicAuthCredentialsMatch("aladdin", "open", "aladdin", "open sesame"), jc.IsFalse)
+	c.Check(jujuhttp.BasicAuthCredentialsMatch("jafar", "open sesame", "aladdin", "open sesame"), jc.IsFalse)
+	c.Check(jujuhttp.BasicAuthCredentialsMatch("", "", "aladdin", "open sesame"), jc.IsFalse)
+}
+
+func (s *httpSuite) TestCheckBasicAuthHeader(c *gc.C) {
+	header := jujuhttp.BasicAuthHeader("aladdin", "open sesame")
+	c.Check(jujuhttp.CheckBasicAuthHeader(header, "aladdin", "open sesame"), jc.IsTrue)
+	c.Check(jujuhttp.CheckBasicAuthHeader(header, "aladdin", "sesame"), jc.IsFalse)
+
+	// Credentials rejected by the strict parsing never match.
+	header = jujuhttp.BasicAuthHeader("ala\tddin", "open sesame")
+	c.Check(jujuhttp.CheckBasicAuthHeader(header, "ala\tddin", "open sesame"), jc.IsFalse)
+}
@@ -0,0 +1,49 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+)
+
+// InformationalResponseFunc is called with each informational (1xx)
+// response received for a request before its final response, such as 103
+// Early Hints, with its status code and header.
+type InformationalResponseFunc func(req *http.Request, code int, header http.Header)
+
+// WithInformationalResponseHook calls the hook with the informational
+// responses of requests, which are otherwise dropped, so that callers can
+// react to early hints or progress reported by the server. The hook is
+// called synchronously while the response is read, so should not block,
+// and is isolated from the request like other hooks, see WithHookTimeout.
+func WithInformationalResponseHook(value InformationalResponseFunc) Option {
+	return func(opt *options) {
+		opt.informationalHook = value
+	}
+}
+
+// informationalResponses delivers the informational responses of requests
+// to a hook.
+type informationalResponses struct {
+	hook     InformationalResponseFunc
+	snapshot *clientSnapshot
+	hooks    hookRunner
+}
+
+// withTrace returns the request with a client trace calling the hook,
+// composed with any trace already in its context.
+func (r *informationalResponses) withTrace(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			logger := requestLogger(req.Context(), r.snapshot.load().logger)
+			r.hooks.run(logger, "informational response hook", func() {
+				r.hook(req, code, http.Header(header))
+			})
+			return nil
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type informationalSuite struct {
+	testing.IsolationSuite
+
+	server *httptest.Server
+}
+
+var _ = gc.Suite(&informationalSuite{})
+
+func (s *informationalSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</charms.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Set("Link", "</charms.js>; rel=preload; as=script")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func (s *informationalSuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+	s.IsolationSuite.TearDownTest(c)
+}
+
+func (s *informationalSuite) TestInformationalResponseHook(c *gc.C) {
+	type informational struct {
+		url  string
+		code int
+		link []string
+	}
+	var got []informational
+	client := NewClient(WithInformationalResponseHook(func(req *http.Request, code int, header http.Header) {
+		got = append(got, informational{
+			url:  req.URL.String(),
+			code: code,
+			link: header.Values("Link"),
+		})
+	}))
+
+	resp, err := client.Get(context.Background(), s.server.URL+"/charms")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Check(resp.StatusCode, gc.Equals, http.StatusOK)
+	c.Check(got, jc.DeepEquals, []informational{{
+		url:  s.server.URL + "/charms",
+		code: http.StatusEarlyHints,
+		link: []string{"</charms.css>; rel=preload; as=style"},
+	}, {
+		url:  s.server.URL + "/charms",
+		code: http.StatusEarlyHints,
+		link: []string{"</charms.js>; rel=preload; as=script"},
+	}})
+}
+
+func (s *informationalSuite) TestInformationalResponseHookComposesTrace(c *gc.C) {
+	var hook, trace int
+	client := NewClient(WithInformationalResponseHook(func(*http.Request, int, http.Header) {
+		hook++
+	}))
+
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		Got1xxResponse: func(int, textproto.MIMEHeader) error {
+			trace++
+			return nil
+		},
+	})
+	resp, err := client.Get(ctx, s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Check(hook, gc.Equals, 2)
+	c.Check(trace, gc.Equals, 2)
+}
+
+func (s *informationalSuite) TestInformationalResponseHookPanic(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	logger := NewMockLogger(ctrl)
+	logger.EXPECT().IsTraceEnabled().Return(false).AnyTimes()
+	logger.EXPECT().Errorf("%s panicked: %v", "informational response hook", "boom").Times(2)
+
+	client := NewClient(
+		WithLogger(logger),
+		WithInformationalResponseHook(func(*http.Request, int, http.Header) {
+			panic("boom")
+		}),
+	)
+	resp, err := client.Get(context.Background(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Check(resp.StatusCode, gc.Equals, http.StatusOK)
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net/http"
+
+	"github.com/juju/errors"
+)
+
+// InspectTransport returns the *http.Transport that the client sends its
+// requests with, after the options have been applied, so that tests can
+// assert on the proxy and TLS configuration, including the CA
+// certificates in TLSClientConfig.RootCAs.
+//
+// The transport is the one in use by the client and must not be modified.
+// A NotFound error is returned if the client was configured with a custom
+// round tripper, or round tripper middleware, that hides the transport, and
+// a NotValid error if the client configuration is invalid.
+func InspectTransport(client *Client) (*http.Transport, error) {
+	httpClient, ok := client.HTTPClient.(*http.Client)
+	if !ok {
+		return nil, errors.NotFoundf("transport of %T", client.HTTPClient)
+	}
+	transport := httpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	for {
+		switch t := transport.(type) {
+		case *http.Transport:
+			return t, nil
+		case invalidConfigTransport:
+			return nil, errors.NewNotValid(t.err, "invalid http client configuration")
+		case schemeAllowListTransport:
+			transport = t.wrappedRoundTripper
+		case bodyTrackingTransport:
+			transport = t.wrappedRoundTripper
+		case responseCacheTransport:
+			transport = t.wrappedRoundTripper
+		case retryMiddleware:
+			transport = t.wrappedRoundTripper
+		case roundTripRecorder:
+			transport = t.wrappedRoundTripper
+		case runtimeTraceTransport:
+			transport = t.wrappedRoundTripper
+		case maintenanceTransport:
+			transport = t.wrappedRoundTripper
+		case clockSkewTransport:
+			transport = t.wrappedRoundTripper
+		case acceptLanguageTransport:
+			transport = t.wrappedRoundTripper
+		case *Router:
+			transport = t.fallback
+		case concurrencyLimitTransport:
+			transport = t.wrappedRoundTripper
+		case headerLimitTransport:
+			transport = t.wrappedRoundTripper
+		case certificateErrorTransport:
+			transport = t.wrappedRoundTripper
+		case decompressingTransport:
+			transport = t.wrappedRoundTripper
+		case decompressionTransport:
+			transport = t.wrappedRoundTripper
+		case rawHeaderTransport:
+			transport = t.wrappedRoundTripper
+		case curlTransport:
+			transport = t.wrappedRoundTripper
+		case compressionTransport:
+			transport = t.wrappedRoundTripper
+		case skipVerifyTransport:
+			transport = t.wrappedRoundTripper
+		case stallTransport:
+			transport = t.wrappedRoundTripper
+		case dnsPinTransport:
+			transport = t.wrappedRoundTripper
+		case apiVersionTransport:
+			transport = t.wrappedRoundTripper
+		case deadlineCheckTransport:
+			transport = t.wrappedRoundTripper
+		case affinityTransport:
+			return t.transport, nil
+		case clientTransport:
+			return InspectTransport(t.client)
+		default:
+			return nil, errors.NotFoundf("transport wrapped by %T", transport)
+		}
+	}
+}
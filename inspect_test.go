@@ -0,0 +1,130 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type inspectSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&inspectSuite{})
+
+func (s *inspectSuite) TestInspectTransport(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+
+	caPEM := new(bytes.Buffer)
+	err := pem.Encode(caPEM, &pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	cache, err := NewResponseCache(ResponseCacheConfig{Store: NewMemoryStore(clock.WallClock), TTL: time.Minute, MaxEntries: 1, MaxBodySize: 1})
+	c.Assert(err, jc.ErrorIsNil)
+
+	// Enable every option that wraps the transport.
+	client := NewClient(
+		WithCACertificates(caPEM.String()),
+		WithMinimumTLSVersion(tls.VersionTLS13),
+		WithPerRequestSkipVerify(true),
+		WithRequestCompression(RequestCompression{Probe: true}),
+		WithCurlCommandLogging(true),
+		WithResponseHeaderLimits(ResponseHeaderLimits{MaxFields: 100}),
+		WithCertificateErrorDetails(true),
+		WithConcurrencyLimit(1),
+		WithRoutes(Route{Scheme: "file", Transport: http.DefaultTransport}),
+		WithAcceptLanguage("en"),
+		WithClockSkewCheck(time.Minute, nil),
+		WithMaintenanceDetection(MaintenancePolicy{}),
+		WithConnectionAffinity(AffinityHeader("X-Juju-Model")),
+		WithRuntimeTrace(true),
+		WithRequestRecorder(NewMockRequestRecorder(ctrl)),
+		WithRequestRetrier(RetryPolicy{Attempts: 1, Delay: time.Millisecond}),
+		WithBodyLeakDetection(false),
+		WithAllowedSchemes("https"),
+		WithAPIVersionNegotiation(APIVersionPolicy{BasePath: "/api", Supported: []string{"v1"}}),
+		WithDeadlineCheck(DeadlineCheckLog),
+		WithDNSRebindingProtection(true),
+		WithResponseCache(cache),
+	)
+
+	transport, err := InspectTransport(client)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(transport.Proxy, gc.NotNil)
+	c.Assert(transport.TLSClientConfig, gc.NotNil)
+	c.Assert(transport.TLSClientConfig.MinVersion, gc.Equals, uint16(tls.VersionTLS13))
+	c.Assert(transport.TLSClientConfig.RootCAs, gc.NotNil)
+	c.Assert(transport.TLSClientConfig.InsecureSkipVerify, jc.IsFalse)
+}
+
+func (s *inspectSuite) TestInspectTransportAPIVersionNegotiation(c *gc.C) {
+	client := NewClient(WithAPIVersionNegotiation(APIVersionPolicy{BasePath: "/api", Supported: []string{"v1"}}))
+
+	transport, err := InspectTransport(client)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(transport, gc.NotNil)
+}
+
+func (s *inspectSuite) TestInspectTransportDeadlineCheck(c *gc.C) {
+	client := NewClient(WithDeadlineCheck(DeadlineCheckReject))
+
+	transport, err := InspectTransport(client)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(transport, gc.NotNil)
+}
+
+func (s *inspectSuite) TestInspectTransportDefault(c *gc.C) {
+	inspected, err := InspectTransport(&Client{HTTPClient: &http.Client{}})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(inspected, gc.Equals, http.DefaultTransport)
+}
+
+func (s *inspectSuite) TestInspectTransportStandardClient(c *gc.C) {
+	client := NewClient()
+
+	inspected, err := InspectTransport(client)
+	c.Assert(err, jc.ErrorIsNil)
+
+	standard, err := InspectTransport(&Client{HTTPClient: client.StandardClient()})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(standard, gc.Equals, inspected)
+}
+
+func (s *inspectSuite) TestInspectTransportHidden(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	client := NewClient(WithBaseRoundTripper(NewMockRoundTripper(ctrl)))
+
+	_, err := InspectTransport(client)
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+}
+
+func (s *inspectSuite) TestInspectTransportInvalidConfig(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	client := NewClient(WithLogger(logger(ctrl)), WithConcurrencyLimit(-1))
+
+	_, err := InspectTransport(client)
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+}
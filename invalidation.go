@@ -0,0 +1,73 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// CacheInvalidator is implemented by response caches layered on a Client,
+// so that they can be told to drop entries made stale by a write. This
+// allows read-after-write workflows, such as reading charm metadata after
+// uploading a charm, to see the new content. A ResponseCache configured
+// with WithResponseCache is registered with its client as one.
+type CacheInvalidator interface {
+	// InvalidateMatching removes any cached responses for requests
+	// matching the matcher.
+	InvalidateMatching(matcher RequestMatcher)
+}
+
+// cacheInvalidators holds the CacheInvalidators registered with a client.
+type cacheInvalidators struct {
+	mu           sync.Mutex
+	nextID       int
+	invalidators map[int]CacheInvalidator
+}
+
+// RegisterCacheInvalidator registers a cache to be invalidated by
+// InvalidateCache, returning a function that unregisters it.
+func (c *Client) RegisterCacheInvalidator(invalidator CacheInvalidator) (unregister func()) {
+	c.invalidators.mu.Lock()
+	defer c.invalidators.mu.Unlock()
+
+	if c.invalidators.invalidators == nil {
+		c.invalidators.invalidators = make(map[int]CacheInvalidator)
+	}
+	id := c.invalidators.nextID
+	c.invalidators.nextID++
+	c.invalidators.invalidators[id] = invalidator
+	return func() {
+		c.invalidators.mu.Lock()
+		defer c.invalidators.mu.Unlock()
+		delete(c.invalidators.invalidators, id)
+	}
+}
+
+// InvalidateCache invalidates the cached responses for requests matching
+// the matcher in every cache registered with RegisterCacheInvalidator. For
+// example, to invalidate the metadata of a charm after uploading it:
+//
+//	client.InvalidateCache(RequestMatcher{
+//		Host: "api.charmhub.io",
+//		Path: "/v2/charms/info/mysql/...",
+//	})
+func (c *Client) InvalidateCache(matcher RequestMatcher) error {
+	if err := matcher.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+
+	c.invalidators.mu.Lock()
+	invalidators := make([]CacheInvalidator, 0, len(c.invalidators.invalidators))
+	for _, invalidator := range c.invalidators.invalidators {
+		invalidators = append(invalidators, invalidator)
+	}
+	c.invalidators.mu.Unlock()
+
+	for _, invalidator := range invalidators {
+		invalidator.InvalidateMatching(matcher)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net/url"
+	"sort"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type invalidationSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&invalidationSuite{})
+
+// urlCache is a stand in for a response cache, holding cached URLs.
+type urlCache map[string]bool
+
+func (c urlCache) InvalidateMatching(matcher RequestMatcher) {
+	for raw := range c {
+		u, _ := url.Parse(raw)
+		if matcher.MatchesURL("", u) {
+			delete(c, raw)
+		}
+	}
+}
+
+func (c urlCache) urls() []string {
+	var urls []string
+	for raw := range c {
+		urls = append(urls, raw)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+func (s *invalidationSuite) TestInvalidateCache(c *gc.C) {
+	cache := urlCache{
+		"https://api.charmhub.io/v2/charms/info/mysql":           true,
+		"https://api.charmhub.io/v2/charms/info/mysql/resources": true,
+		"https://api.charmhub.io/v2/charms/info/postgresql":      true,
+		"https://example.com/v2/charms/info/mysql":               true,
+	}
+	client := NewClient()
+	unregister := client.RegisterCacheInvalidator(cache)
+
+	err := client.InvalidateCache(RequestMatcher{
+		Host: "api.charmhub.io",
+		Path: "/v2/charms/info/mysql/...",
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cache.urls(), jc.DeepEquals, []string{
+		"https://api.charmhub.io/v2/charms/info/postgresql",
+		"https://example.com/v2/charms/info/mysql",
+	})
+
+	unregister()
+	err = client.InvalidateCache(RequestMatcher{})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cache.urls(), gc.HasLen, 2)
+}
+
+func (s *invalidationSuite) TestInvalidateCacheInvalidMatcher(c *gc.C) {
+	client := NewClient()
+	err := client.InvalidateCache(RequestMatcher{Path: "[/"})
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+}
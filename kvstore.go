@@ -0,0 +1,91 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+)
+
+// KVStore is a key value store for client state that embedders may want to
+// persist, such as cookies, see NewStoreCookieJar. The boltstore package
+// provides an implementation backed by a bbolt database. Implementations
+// must be safe for concurrent use.
+type KVStore interface {
+	// Get returns the value of the key, or a NotFound error if the key is
+	// missing or has expired.
+	Get(key string) ([]byte, error)
+
+	// Set sets the value of the key, expiring it after ttl. A ttl of zero
+	// or less never expires.
+	Set(key string, value []byte, ttl time.Duration) error
+
+	// Delete deletes the key. Deleting a missing key is not an error.
+	Delete(key string) error
+}
+
+// MemoryStore is an in-memory KVStore. Expired entries are removed when
+// they are next read.
+type MemoryStore struct {
+	clock clock.Clock
+
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore, which expires entries using
+// the given clock.
+func NewMemoryStore(clk clock.Clock) *MemoryStore {
+	return &MemoryStore{
+		clock:   clk,
+		entries: make(map[string]memoryEntry),
+	}
+}
+
+// Get implements KVStore.
+func (s *MemoryStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, errors.NotFoundf("key %q", key)
+	}
+	if !entry.expires.IsZero() && !s.clock.Now().Before(entry.expires) {
+		delete(s.entries, key)
+		return nil, errors.NotFoundf("key %q", key)
+	}
+	return append([]byte(nil), entry.value...), nil
+}
+
+// Set implements KVStore.
+func (s *MemoryStore) Set(key string, value []byte, ttl time.Duration) error {
+	entry := memoryEntry{
+		value: append([]byte(nil), value...),
+	}
+	if ttl > 0 {
+		entry.expires = s.clock.Now().Add(ttl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = entry
+	return nil
+}
+
+// Delete implements KVStore.
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"time"
+
+	"github.com/juju/clock/testclock"
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type memoryStoreSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&memoryStoreSuite{})
+
+func (s *memoryStoreSuite) TestGetSetDelete(c *gc.C) {
+	store := NewMemoryStore(testclock.NewClock(time.Now()))
+
+	_, err := store.Get("key")
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+
+	c.Assert(store.Set("key", []byte("value"), 0), jc.ErrorIsNil)
+	value, err := store.Get("key")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(value), gc.Equals, "value")
+
+	c.Assert(store.Delete("key"), jc.ErrorIsNil)
+	_, err = store.Get("key")
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+
+	c.Assert(store.Delete("missing"), jc.ErrorIsNil)
+}
+
+func (s *memoryStoreSuite) TestTTL(c *gc.C) {
+	clk := testclock.NewClock(time.Now())
+	store := NewMemoryStore(clk)
+
+	c.Assert(store.Set("key", []byte("value"), time.Minute), jc.ErrorIsNil)
+	clk.Advance(59 * time.Second)
+	_, err := store.Get("key")
+	c.Assert(err, jc.ErrorIsNil)
+
+	clk.Advance(time.Second)
+	_, err = store.Get("key")
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+}
+
+func (s *memoryStoreSuite) TestValuesCopied(c *gc.C) {
+	store := NewMemoryStore(testclock.NewClock(time.Now()))
+
+	value := []byte("value")
+	c.Assert(store.Set("key", value, 0), jc.ErrorIsNil)
+	value[0] = 'V'
+
+	stored, err := store.Get("key")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(stored), gc.Equals, "value")
+}
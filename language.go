@@ -0,0 +1,107 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// WithAcceptLanguage sets the Accept-Language header of every request made
+// by the client that doesn't already have one, so that providers return
+// localized error messages. The language tags are in order of preference,
+// see SetAcceptLanguage.
+func WithAcceptLanguage(tags ...string) Option {
+	return func(opt *options) {
+		opt.acceptLanguage = tags
+	}
+}
+
+// SetAcceptLanguage sets the Accept-Language header of the request, taking
+// precedence over any set with WithAcceptLanguage. The language tags are in
+// order of preference, with decreasing quality values assigned after the
+// first, so "fr-CA", "fr", "en" results in "fr-CA, fr;q=0.9, en;q=0.8".
+func SetAcceptLanguage(req *http.Request, tags ...string) error {
+	value, err := acceptLanguage(tags)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Accept-Language", value)
+	return nil
+}
+
+// ContentLanguage returns the language tags of the Content-Language header
+// of the response, or nil if there is no such header.
+func ContentLanguage(resp *http.Response) []string {
+	var tags []string
+	for _, value := range resp.Header.Values("Content-Language") {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// acceptLanguage builds an Accept-Language header value from the language
+// tags.
+func acceptLanguage(tags []string) (string, error) {
+	if len(tags) == 0 {
+		return "", errors.NotValidf("empty language tags")
+	}
+	if len(tags) > 10 {
+		return "", errors.NotValidf("more than 10 language tags")
+	}
+	values := make([]string, len(tags))
+	for i, tag := range tags {
+		if !validLanguageTag(tag) {
+			return "", errors.NotValidf("language tag %q", tag)
+		}
+		values[i] = tag
+		if i > 0 {
+			values[i] = fmt.Sprintf("%s;q=0.%d", tag, 10-i)
+		}
+	}
+	return strings.Join(values, ", "), nil
+}
+
+// validLanguageTag checks the syntax of a language range, as used in
+// Accept-Language.
+func validLanguageTag(tag string) bool {
+	if tag == "*" {
+		return true
+	}
+	for _, subtag := range strings.Split(tag, "-") {
+		if len(subtag) == 0 || len(subtag) > 8 {
+			return false
+		}
+		for _, r := range subtag {
+			if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// acceptLanguageTransport sets a default Accept-Language header on
+// requests.
+type acceptLanguageTransport struct {
+	wrappedRoundTripper http.RoundTripper
+	value               string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t acceptLanguageTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept-Language") != "" {
+		return t.wrappedRoundTripper.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Language", t.value)
+	return t.wrappedRoundTripper.RoundTrip(req)
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type languageSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&languageSuite{})
+
+func (s *languageSuite) TestSetAcceptLanguage(c *gc.C) {
+	req, err := http.NewRequest("GET", "https://portal.azure.com", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	err = SetAcceptLanguage(req, "fr-CA", "fr", "en")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(req.Header.Get("Accept-Language"), gc.Equals, "fr-CA, fr;q=0.9, en;q=0.8")
+
+	err = SetAcceptLanguage(req, "en", "*")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(req.Header.Get("Accept-Language"), gc.Equals, "en, *;q=0.9")
+}
+
+func (s *languageSuite) TestSetAcceptLanguageInvalid(c *gc.C) {
+	req, err := http.NewRequest("GET", "https://portal.azure.com", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	for _, tags := range [][]string{
+		nil,
+		{""},
+		{"en_GB"},
+		{"en", "fr;q=0.1"},
+		{"toolongsubtag"},
+		{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"},
+	} {
+		c.Check(SetAcceptLanguage(req, tags...), jc.Satisfies, errors.IsNotValid, gc.Commentf("%q", tags))
+	}
+}
+
+func (s *languageSuite) TestContentLanguage(c *gc.C) {
+	resp := &http.Response{Header: http.Header{}}
+	c.Assert(ContentLanguage(resp), gc.IsNil)
+
+	resp.Header.Add("Content-Language", "de-DE, en-CA")
+	resp.Header.Add("Content-Language", "fr")
+	c.Assert(ContentLanguage(resp), jc.DeepEquals, []string{"de-DE", "en-CA", "fr"})
+}
+
+func (s *languageSuite) TestClientAcceptLanguage(c *gc.C) {
+	received := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("Accept-Language")
+	}))
+	defer server.Close()
+
+	client := NewClient(WithAcceptLanguage("de", "en"))
+
+	resp, err := client.Get(context.TODO(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Assert(<-received, gc.Equals, "de, en;q=0.9")
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(SetAcceptLanguage(req, "fr"), jc.ErrorIsNil)
+	resp, err = client.Do(req)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Assert(<-received, gc.Equals, "fr")
+}
+
+func (s *languageSuite) TestClientAcceptLanguageInvalid(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	client := NewClient(WithLogger(logger(ctrl)), WithAcceptLanguage("en_GB"))
+	_, err := client.Get(context.TODO(), "http://example.com")
+	c.Assert(err, gc.ErrorMatches, `.*language tag "en_GB" not valid`)
+}
@@ -0,0 +1,26 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import "context"
+
+type loggerKey struct{}
+
+// ContextWithLogger returns a context that overrides the logger of the
+// client for requests made with it. Passing a logger with trace enabled
+// traces a single request, for example while running a command with
+// --debug, without raising the level of the logger used by every other
+// request.
+func ContextWithLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// requestLogger returns the logger set on the context with
+// ContextWithLogger, or the given logger if there isn't one.
+func requestLogger(ctx context.Context, logger Logger) Logger {
+	if override, ok := ctx.Value(loggerKey{}).(Logger); ok && override != nil {
+		return override
+	}
+	return logger
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type loggingSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&loggingSuite{})
+
+func (s *loggingSuite) TestRequestLogger(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	clientLogger := NewMockLogger(ctrl)
+	c.Assert(requestLogger(context.Background(), clientLogger), gc.Equals, clientLogger)
+
+	requestLog := NewMockLogger(ctrl)
+	ctx := ContextWithLogger(context.Background(), requestLog)
+	c.Assert(requestLogger(ctx, clientLogger), gc.Equals, requestLog)
+}
+
+func (s *loggingSuite) TestTraceSingleRequest(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	// The client logger has trace disabled, so must not be used for tracing.
+	clientLogger := NewMockLogger(ctrl)
+	clientLogger.EXPECT().IsTraceEnabled().Return(false).AnyTimes()
+
+	requestLog := NewMockLogger(ctrl)
+	requestLog.EXPECT().IsTraceEnabled().Return(true)
+	requestLog.EXPECT().Tracef("request for %q: %q", server.URL, gomock.Any())
+	requestLog.EXPECT().Tracef(gomock.Any(), gomock.Any()).MinTimes(1)
+
+	client := NewClient(WithLogger(clientLogger))
+	resp, err := client.Get(ContextWithLogger(context.Background(), requestLog), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+
+	resp, err = client.Get(context.Background(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+}
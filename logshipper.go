@@ -0,0 +1,530 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+	"github.com/juju/loggo/v2"
+)
+
+const (
+	defaultLogBatchRecords   = 100
+	defaultLogBatchBytes     = 1 << 20
+	defaultLogFlushInterval  = time.Second
+	defaultLogBufferSize     = 1000
+	defaultLogTimeout        = 30 * time.Second
+	defaultLogContentType    = "application/x-ndjson"
+	logSpillSuffix           = ".batch"
+	logSpillTemporarySuffix  = ".tmp"
+	logShipperStoppedMessage = "log shipper stopped"
+)
+
+// LogShipperConfig holds the configuration of a LogShipper.
+type LogShipperConfig struct {
+	// Client sends the batches of logs, typically a *Client, so that its
+	// connection pool keeps the connection to the receiver alive between
+	// batches.
+	Client HTTPClient
+
+	// URL is the URL the batches are POSTed to.
+	URL string
+
+	// ContentType is the content type of the batches. It defaults to
+	// "application/x-ndjson".
+	ContentType string
+
+	// MaxBatchRecords is the number of records that are sent as soon as
+	// they are shipped. It defaults to 100.
+	MaxBatchRecords int
+
+	// MaxBatchBytes is the size of a batch of records that is sent as soon
+	// as it is reached. It defaults to 1MiB.
+	MaxBatchBytes int
+
+	// FlushInterval is the longest a record waits to be sent, and how
+	// often the delivery of spilled batches is retried. It defaults to one
+	// second.
+	FlushInterval time.Duration
+
+	// BufferSize is the number of records shipped that can wait for the
+	// batch being sent. Once it is full, Ship blocks. It defaults to 1000.
+	BufferSize int
+
+	// Timeout limits each attempt to deliver a batch, as the requests are
+	// made by the shipper rather than for a caller with a deadline. It
+	// defaults to 30 seconds.
+	Timeout time.Duration
+
+	// Retry, if set, retries the delivery of a batch that failed. The
+	// delays between attempts are those of RetryPolicy.RetryDelay, so a
+	// Retry-After header of the receiver is honoured.
+	Retry RetryPolicy
+
+	// SpillDir, if set, is the directory where batches that couldn't be
+	// delivered are written, to be sent, in order, before any later batch.
+	// Batches left by a previous LogShipper with the same directory are
+	// sent too. Otherwise batches that couldn't be delivered are dropped.
+	SpillDir string
+
+	// MaxSpillBytes, if set, limits the size of the spilled batches. The
+	// oldest are removed to make room for a new one.
+	MaxSpillBytes int64
+
+	// Clock is used to schedule flushes and retries. If nil, the wall
+	// clock is used.
+	Clock clock.Clock
+
+	// Logger is used to log batches that couldn't be delivered. If nil,
+	// the "http" logger is used.
+	Logger Logger
+}
+
+// Validate validates the LogShipperConfig for any issues.
+func (c LogShipperConfig) Validate() error {
+	if c.Client == nil {
+		return errors.NotValidf("nil Client")
+	}
+	if u, err := url.Parse(c.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		return errors.NotValidf("URL %q", c.URL)
+	}
+	if c.MaxBatchRecords < 0 {
+		return errors.NotValidf("negative max batch records")
+	}
+	if c.MaxBatchBytes < 0 {
+		return errors.NotValidf("negative max batch bytes")
+	}
+	if c.FlushInterval < 0 {
+		return errors.NotValidf("negative flush interval")
+	}
+	if c.BufferSize < 0 {
+		return errors.NotValidf("negative buffer size")
+	}
+	if c.Timeout < 0 {
+		return errors.NotValidf("negative timeout")
+	}
+	if c.MaxSpillBytes < 0 {
+		return errors.NotValidf("negative max spill bytes")
+	}
+	if c.Retry.Attempts != 0 {
+		if err := c.Retry.Validate(); err != nil {
+			return errors.Annotate(err, "retry")
+		}
+	}
+	return nil
+}
+
+// LogShipper ships log records to a receiver over HTTP, such as agents
+// forwarding their logs to the controller. Records are batched, one record
+// per line, and each batch is sent in a POST request on a connection kept
+// alive by the client. A batch is only sent once the previous one has been
+// delivered, spilled or dropped, and shipping blocks while the buffer of
+// records waiting for it is full, so a slow receiver slows down the
+// shipper rather than growing its memory without bound.
+type LogShipper struct {
+	config  LogShipperConfig
+	records chan []byte
+	flushes chan chan error
+	stop    chan struct{}
+	done    chan struct{}
+	err     error
+
+	// ctx is cancelled by Stop, to abandon a delivery in progress.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.RWMutex
+	stopped  bool
+	stopOnce sync.Once
+
+	// The spilled batches, and whether the last attempt is being made, are
+	// only used by the loop.
+	last       bool
+	spilled    []spilledBatch
+	spillBytes int64
+	spillSeq   uint64
+}
+
+type spilledBatch struct {
+	path string
+	size int64
+}
+
+// NewLogShipper creates and starts a LogShipper. It must be stopped with
+// Stop once no longer needed.
+func NewLogShipper(config LogShipperConfig) (*LogShipper, error) {
+	if err := config.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if config.ContentType == "" {
+		config.ContentType = defaultLogContentType
+	}
+	if config.MaxBatchRecords == 0 {
+		config.MaxBatchRecords = defaultLogBatchRecords
+	}
+	if config.MaxBatchBytes == 0 {
+		config.MaxBatchBytes = defaultLogBatchBytes
+	}
+	if config.FlushInterval == 0 {
+		config.FlushInterval = defaultLogFlushInterval
+	}
+	if config.BufferSize == 0 {
+		config.BufferSize = defaultLogBufferSize
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultLogTimeout
+	}
+	if config.Clock == nil {
+		config.Clock = clock.WallClock
+	}
+	if config.Logger == nil {
+		config.Logger = loggo.GetLogger("http")
+	}
+	s := &LogShipper{
+		config:  config,
+		records: make(chan []byte, config.BufferSize),
+		flushes: make(chan chan error),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if config.SpillDir != "" {
+		if err := s.loadSpilled(); err != nil {
+			return nil, errors.Annotate(err, "loading spilled log batches")
+		}
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.loop()
+	return s, nil
+}
+
+// Ship queues the record to be sent, blocking while the buffer of queued
+// records is full, until the context is done. Each record is sent on its
+// own line, so it must not contain newlines, as JSON encoded log entries
+// don't. The record is copied, so can be reused once Ship returns.
+func (s *LogShipper) Ship(ctx context.Context, record []byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.stopped {
+		return errors.New(logShipperStoppedMessage)
+	}
+	select {
+	case s.records <- append([]byte(nil), record...):
+		return nil
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
+	case <-s.stop:
+		return errors.New(logShipperStoppedMessage)
+	}
+}
+
+// Flush sends the records shipped so far, and any spilled batches,
+// returning once they have been delivered, or the error of the delivery
+// that failed. Records that couldn't be delivered are spilled or dropped
+// as if they had been sent by the shipper itself.
+func (s *LogShipper) Flush(ctx context.Context) error {
+	reply := make(chan error, 1)
+	select {
+	case s.flushes <- reply:
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
+	case <-s.done:
+		return errors.New(logShipperStoppedMessage)
+	}
+	select {
+	case err := <-reply:
+		return errors.Trace(err)
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
+	}
+}
+
+// Stop stops the shipper, making a last attempt to send the records
+// shipped so far, and any spilled batches, without retrying. A delivery in
+// progress is abandoned, and its batch spilled or dropped, so the last
+// attempt is made without waiting for it. It returns the error of the
+// delivery that failed, if any, in which case the records not delivered
+// have been spilled or dropped.
+func (s *LogShipper) Stop() error {
+	s.stopOnce.Do(func() {
+		// Unblock shippers waiting for room in the buffer, and retries
+		// waiting for their delay or their response.
+		close(s.stop)
+		s.cancel()
+		s.mu.Lock()
+		s.stopped = true
+		s.mu.Unlock()
+		// No shipper holds the read lock any more, so none can send.
+		close(s.records)
+	})
+	<-s.done
+	return errors.Trace(s.err)
+}
+
+func (s *LogShipper) loop() {
+	defer close(s.done)
+	var (
+		batch   bytes.Buffer
+		records int
+		timer   <-chan time.Time
+	)
+	add := func(record []byte) bool {
+		batch.Write(record)
+		batch.WriteByte('\n')
+		records++
+		return records >= s.config.MaxBatchRecords || batch.Len() >= s.config.MaxBatchBytes
+	}
+	flush := func() error {
+		err := s.send(batch.Bytes())
+		batch.Reset()
+		records = 0
+		timer = nil
+		return err
+	}
+	for {
+		if timer == nil && (records > 0 || len(s.spilled) > 0) {
+			timer = s.config.Clock.After(s.config.FlushInterval)
+		}
+		select {
+		case record, ok := <-s.records:
+			if !ok {
+				s.last = true
+				s.err = flush()
+				return
+			}
+			if add(record) {
+				_ = flush()
+			}
+		case reply := <-s.flushes:
+			// Take the records that were shipped before the flush.
+			var err error
+		drain:
+			for {
+				select {
+				case record, ok := <-s.records:
+					if !ok {
+						break drain
+					}
+					if add(record) {
+						err = flush()
+					}
+				default:
+					break drain
+				}
+			}
+			if flushErr := flush(); flushErr != nil {
+				err = flushErr
+			}
+			reply <- err
+		case <-timer:
+			_ = flush()
+		}
+	}
+}
+
+// send delivers the spilled batches and then the batch, spilling or
+// dropping the batch if any delivery fails.
+func (s *LogShipper) send(batch []byte) error {
+	// Spilled batches are older, so they are delivered first.
+	if err := s.deliverSpilled(); err != nil {
+		return s.spill(batch, err)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := s.deliver(batch); err != nil {
+		return s.spill(batch, err)
+	}
+	return nil
+}
+
+// deliver sends the batch, retrying as the retry policy allows, until the
+// shipper is stopped.
+func (s *LogShipper) deliver(batch []byte) error {
+	var (
+		delay time.Duration
+		err   error
+	)
+	for attempt := 1; ; attempt++ {
+		var resp *http.Response
+		if resp, err = s.post(batch); err == nil {
+			return nil
+		}
+		if attempt >= s.config.Retry.Attempts {
+			return errors.Trace(err)
+		}
+		if attempt == 1 {
+			delay = s.config.Retry.Delay
+		}
+		var delayErr error
+		if delay, delayErr = s.config.Retry.RetryDelay(resp, delay, attempt, s.config.Clock.Now()); delayErr != nil {
+			return errors.Trace(err)
+		}
+		select {
+		case <-s.config.Clock.After(delay):
+		case <-s.stop:
+			return errors.Trace(err)
+		}
+	}
+}
+
+// post sends a batch, returning the response if the receiver didn't accept
+// it, with its body closed.
+func (s *LogShipper) post(batch []byte) (*http.Response, error) {
+	// The last attempt is made once the shipper's context is cancelled.
+	parent := s.ctx
+	if s.last {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, s.config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", s.config.URL, bytes.NewReader(batch))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", s.config.ContentType)
+	resp, err := s.config.Client.Do(req)
+	if err != nil {
+		return nil, errors.Annotatef(err, "shipping logs to %s", s.config.URL)
+	}
+	// Read the body to the end, so the connection is kept alive.
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, errors.Errorf("shipping logs to %s: %s", s.config.URL, resp.Status)
+	}
+	return nil, nil
+}
+
+// deliverSpilled delivers the spilled batches, oldest first, removing
+// each once it has been delivered.
+func (s *LogShipper) deliverSpilled() error {
+	for len(s.spilled) > 0 {
+		spilled := s.spilled[0]
+		batch, err := os.ReadFile(spilled.path)
+		if err == nil {
+			if err := s.deliver(batch); err != nil {
+				return errors.Trace(err)
+			}
+		} else {
+			s.config.Logger.Errorf("dropping spilled log batch %s: %v", spilled.path, err)
+		}
+		s.removeSpilled()
+	}
+	return nil
+}
+
+// spill writes the batch that couldn't be delivered to the spill
+// directory, or drops it if there is none. It returns the error of the
+// delivery.
+func (s *LogShipper) spill(batch []byte, deliveryErr error) error {
+	if len(batch) == 0 {
+		return errors.Trace(deliveryErr)
+	}
+	if s.config.SpillDir == "" {
+		s.config.Logger.Errorf("dropping %d bytes of logs: %v", len(batch), deliveryErr)
+		return errors.Trace(deliveryErr)
+	}
+	size := int64(len(batch))
+	if s.config.MaxSpillBytes > 0 && size > s.config.MaxSpillBytes {
+		s.config.Logger.Errorf("dropping %d bytes of logs larger than the spill limit: %v", len(batch), deliveryErr)
+		return errors.Trace(deliveryErr)
+	}
+	for s.config.MaxSpillBytes > 0 && s.spillBytes+size > s.config.MaxSpillBytes {
+		s.config.Logger.Errorf("dropping spilled log batch %s over the spill limit", s.spilled[0].path)
+		s.removeSpilled()
+	}
+
+	s.spillSeq++
+	path := filepath.Join(s.config.SpillDir, fmt.Sprintf("%020d%s", s.spillSeq, logSpillSuffix))
+	if err := writeFileAtomic(path, batch); err != nil {
+		s.config.Logger.Errorf("dropping %d bytes of logs, spilling failed: %v", len(batch), err)
+		return errors.Trace(deliveryErr)
+	}
+	s.spilled = append(s.spilled, spilledBatch{path: path, size: size})
+	s.spillBytes += size
+	return errors.Annotatef(deliveryErr, "logs spilled to %s", path)
+}
+
+// removeSpilled removes the oldest spilled batch.
+func (s *LogShipper) removeSpilled() {
+	spilled := s.spilled[0]
+	if err := os.Remove(spilled.path); err != nil && !os.IsNotExist(err) {
+		s.config.Logger.Errorf("removing spilled log batch: %v", err)
+	}
+	s.spilled = s.spilled[1:]
+	s.spillBytes -= spilled.size
+}
+
+// loadSpilled creates the spill directory, and finds the batches spilled
+// to it by a previous shipper.
+func (s *LogShipper) loadSpilled() error {
+	if err := os.MkdirAll(s.config.SpillDir, 0o700); err != nil {
+		return errors.Trace(err)
+	}
+	entries, err := os.ReadDir(s.config.SpillDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(name, logSpillTemporarySuffix) {
+			// Left by a write that didn't complete.
+			_ = os.Remove(filepath.Join(s.config.SpillDir, name))
+			continue
+		}
+		var seq uint64
+		if _, err := fmt.Sscanf(name, "%d"+logSpillSuffix, &seq); err != nil || !strings.HasSuffix(name, logSpillSuffix) {
+			continue
+		}
+		if seq > s.spillSeq {
+			s.spillSeq = seq
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		info, err := os.Stat(filepath.Join(s.config.SpillDir, name))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		s.spilled = append(s.spilled, spilledBatch{
+			path: filepath.Join(s.config.SpillDir, name),
+			size: info.Size(),
+		})
+		s.spillBytes += info.Size()
+	}
+	return nil
+}
+
+// writeFileAtomic writes the file through a temporary file, so that a
+// partially written file is never read.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + logSpillTemporarySuffix
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Trace(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Trace(err)
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/juju/clock/testclock"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type logShipperSuite struct {
+	testing.IsolationSuite
+
+	server *httptest.Server
+
+	mu       sync.Mutex
+	batches  []string
+	failures int
+	status   int
+	received chan struct{}
+}
+
+var _ = gc.Suite(&logShipperSuite{})
+
+func (s *logShipperSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.batches = nil
+	s.failures = 0
+	s.status = http.StatusServiceUnavailable
+	s.received = make(chan struct{}, 100)
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.failures > 0 {
+			s.failures--
+			w.WriteHeader(s.status)
+			return
+		}
+		if r.Header.Get("Content-Type") != "application/x-ndjson" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		s.batches = append(s.batches, string(body))
+		s.received <- struct{}{}
+	}))
+	s.AddCleanup(func(*gc.C) { s.server.Close() })
+}
+
+func (s *logShipperSuite) fail(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = n
+}
+
+func (s *logShipperSuite) receivedBatches() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.batches...)
+}
+
+func (s *logShipperSuite) newShipper(c *gc.C, config LogShipperConfig) *LogShipper {
+	config.Client = NewClient()
+	config.URL = s.server.URL
+	shipper, err := NewLogShipper(config)
+	c.Assert(err, jc.ErrorIsNil)
+	s.AddCleanup(func(*gc.C) { _ = shipper.Stop() })
+	return shipper
+}
+
+func (s *logShipperSuite) ship(c *gc.C, shipper *LogShipper, records ...string) {
+	for _, record := range records {
+		c.Assert(shipper.Ship(context.Background(), []byte(record)), jc.ErrorIsNil)
+	}
+}
+
+func (s *logShipperSuite) TestValidate(c *gc.C) {
+	valid := LogShipperConfig{Client: NewClient(), URL: "https://controller.example.com/logs"}
+	c.Assert(valid.Validate(), jc.ErrorIsNil)
+	tests := []struct {
+		update func(*LogShipperConfig)
+		err    string
+	}{{
+		update: func(config *LogShipperConfig) { config.Client = nil },
+		err:    `nil Client not valid`,
+	}, {
+		update: func(config *LogShipperConfig) { config.URL = "/logs" },
+		err:    `URL "/logs" not valid`,
+	}, {
+		update: func(config *LogShipperConfig) { config.MaxBatchRecords = -1 },
+		err:    `negative max batch records not valid`,
+	}, {
+		update: func(config *LogShipperConfig) { config.MaxBatchBytes = -1 },
+		err:    `negative max batch bytes not valid`,
+	}, {
+		update: func(config *LogShipperConfig) { config.FlushInterval = -time.Second },
+		err:    `negative flush interval not valid`,
+	}, {
+		update: func(config *LogShipperConfig) { config.BufferSize = -1 },
+		err:    `negative buffer size not valid`,
+	}, {
+		update: func(config *LogShipperConfig) { config.Timeout = -time.Second },
+		err:    `negative timeout not valid`,
+	}, {
+		update: func(config *LogShipperConfig) { config.MaxSpillBytes = -1 },
+		err:    `negative max spill bytes not valid`,
+	}, {
+		update: func(config *LogShipperConfig) { config.Retry = RetryPolicy{Attempts: 3} },
+		err:    `retry: expected max delay to be a valid time`,
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.err)
+		config := valid
+		test.update(&config)
+		c.Check(config.Validate(), gc.ErrorMatches, test.err)
+		_, err := NewLogShipper(config)
+		c.Check(err, gc.ErrorMatches, test.err)
+	}
+}
+
+func (s *logShipperSuite) TestBatchRecords(c *gc.C) {
+	shipper := s.newShipper(c, LogShipperConfig{MaxBatchRecords: 2})
+	s.ship(c, shipper, `{"message":"one"}`, `{"message":"two"}`, `{"message":"three"}`)
+	select {
+	case <-s.received:
+	case <-time.After(testing.LongWait):
+		c.Fatalf("batch not sent")
+	}
+	c.Assert(shipper.Flush(context.Background()), jc.ErrorIsNil)
+	c.Check(s.receivedBatches(), jc.DeepEquals, []string{
+		"{\"message\":\"one\"}\n{\"message\":\"two\"}\n",
+		"{\"message\":\"three\"}\n",
+	})
+}
+
+func (s *logShipperSuite) TestFlushInterval(c *gc.C) {
+	clk := testclock.NewClock(time.Now())
+	shipper := s.newShipper(c, LogShipperConfig{FlushInterval: time.Minute, Clock: clk})
+	s.ship(c, shipper, "one")
+	c.Assert(clk.WaitAdvance(time.Minute, testing.LongWait, 1), jc.ErrorIsNil)
+	select {
+	case <-s.received:
+	case <-time.After(testing.LongWait):
+		c.Fatalf("batch not sent")
+	}
+	c.Check(s.receivedBatches(), jc.DeepEquals, []string{"one\n"})
+}
+
+func (s *logShipperSuite) TestBackpressure(c *gc.C) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	shipper, err := NewLogShipper(LogShipperConfig{
+		Client:          NewClient(),
+		URL:             server.URL,
+		MaxBatchRecords: 1,
+		BufferSize:      1,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer func() { _ = shipper.Stop() }()
+	defer close(release)
+
+	// The first record is being sent, and the second waits in the
+	// buffer, so the third can't be shipped until the first is delivered.
+	s.ship(c, shipper, "one")
+	var shipErr error
+	for i := 0; i < 10 && shipErr == nil; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		shipErr = shipper.Ship(ctx, []byte("more"))
+		cancel()
+	}
+	c.Assert(shipErr, gc.ErrorMatches, `context deadline exceeded`)
+}
+
+func (s *logShipperSuite) TestRetry(c *gc.C) {
+	s.fail(2)
+	shipper := s.newShipper(c, LogShipperConfig{
+		Retry: RetryPolicy{Attempts: 3, Delay: time.Millisecond, MaxDelay: time.Second},
+	})
+	s.ship(c, shipper, "one")
+	c.Assert(shipper.Flush(context.Background()), jc.ErrorIsNil)
+	c.Check(s.receivedBatches(), jc.DeepEquals, []string{"one\n"})
+}
+
+func (s *logShipperSuite) TestDropWithoutSpillDir(c *gc.C) {
+	s.fail(1)
+	shipper := s.newShipper(c, LogShipperConfig{})
+	s.ship(c, shipper, "one")
+	err := shipper.Flush(context.Background())
+	c.Assert(err, gc.ErrorMatches, `shipping logs to .*: 503 Service Unavailable`)
+
+	s.ship(c, shipper, "two")
+	c.Assert(shipper.Flush(context.Background()), jc.ErrorIsNil)
+	c.Check(s.receivedBatches(), jc.DeepEquals, []string{"two\n"})
+}
+
+func (s *logShipperSuite) TestSpillToDisk(c *gc.C) {
+	dir := filepath.Join(c.MkDir(), "spill")
+	s.fail(2)
+	shipper := s.newShipper(c, LogShipperConfig{SpillDir: dir})
+	s.ship(c, shipper, "one")
+	c.Assert(shipper.Flush(context.Background()), gc.ErrorMatches, `logs spilled to .*: shipping logs to .*: 503 Service Unavailable`)
+	// The spilled batch is retried first, and fails, so the next batch is
+	// spilled too.
+	s.ship(c, shipper, "two")
+	c.Assert(shipper.Flush(context.Background()), gc.ErrorMatches, `logs spilled to .*`)
+	entries, err := os.ReadDir(dir)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(entries, gc.HasLen, 2)
+
+	// Once the receiver recovers, the spilled batches are sent in order.
+	s.ship(c, shipper, "three")
+	c.Assert(shipper.Flush(context.Background()), jc.ErrorIsNil)
+	c.Check(s.receivedBatches(), jc.DeepEquals, []string{"one\n", "two\n", "three\n"})
+	entries, err = os.ReadDir(dir)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(entries, gc.HasLen, 0)
+}
+
+func (s *logShipperSuite) TestSpilledByPreviousShipper(c *gc.C) {
+	dir := c.MkDir()
+	s.fail(1)
+	shipper := s.newShipper(c, LogShipperConfig{SpillDir: dir})
+	s.ship(c, shipper, "one")
+	c.Assert(shipper.Stop(), gc.ErrorMatches, `logs spilled to .*`)
+	c.Check(shipper.Ship(context.Background(), []byte("two")), gc.ErrorMatches, `log shipper stopped`)
+	c.Check(shipper.Flush(context.Background()), gc.ErrorMatches, `log shipper stopped`)
+
+	shipper = s.newShipper(c, LogShipperConfig{SpillDir: dir})
+	s.ship(c, shipper, "two")
+	c.Assert(shipper.Flush(context.Background()), jc.ErrorIsNil)
+	c.Check(s.receivedBatches(), jc.DeepEquals, []string{"one\n", "two\n"})
+}
+
+func (s *logShipperSuite) TestMaxSpillBytes(c *gc.C) {
+	dir := c.MkDir()
+	s.fail(3)
+	shipper := s.newShipper(c, LogShipperConfig{SpillDir: dir, MaxSpillBytes: 10})
+	for _, record := range []string{"one", "two", "three"} {
+		s.ship(c, shipper, record)
+		c.Assert(shipper.Flush(context.Background()), gc.NotNil)
+	}
+
+	// The oldest batch was removed to make room for the last one.
+	c.Assert(shipper.Flush(context.Background()), jc.ErrorIsNil)
+	c.Check(s.receivedBatches(), jc.DeepEquals, []string{"two\n", "three\n"})
+}
+
+func (s *logShipperSuite) TestStopSendsPending(c *gc.C) {
+	shipper := s.newShipper(c, LogShipperConfig{FlushInterval: time.Hour})
+	s.ship(c, shipper, "one", "two")
+	c.Assert(shipper.Stop(), jc.ErrorIsNil)
+	c.Check(s.receivedBatches(), jc.DeepEquals, []string{"one\ntwo\n"})
+	c.Assert(shipper.Stop(), jc.ErrorIsNil)
+}
+
+func (s *logShipperSuite) TestStopAbandonsDelivery(c *gc.C) {
+	var (
+		mu      sync.Mutex
+		batches []string
+	)
+	started := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		first := batches == nil
+		batches = append(batches, string(body))
+		mu.Unlock()
+		if first {
+			// The receiver hangs on the first delivery.
+			close(started)
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Minute):
+			}
+		}
+	}))
+	defer server.Close()
+
+	shipper, err := NewLogShipper(LogShipperConfig{
+		// The requests of the shipper have a deadline.
+		Client:          NewClient(WithDeadlineCheck(DeadlineCheckReject)),
+		URL:             server.URL,
+		MaxBatchRecords: 1,
+		SpillDir:        c.MkDir(),
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	s.ship(c, shipper, "one")
+	<-started
+
+	start := time.Now()
+	c.Assert(shipper.Stop(), jc.ErrorIsNil)
+	c.Check(time.Since(start) < 10*time.Second, jc.IsTrue)
+	// The abandoned batch was spilled, and sent by the last attempt.
+	mu.Lock()
+	defer mu.Unlock()
+	c.Check(batches, jc.DeepEquals, []string{"one\n", "one\n"})
+}
@@ -0,0 +1,227 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+)
+
+// ErrServiceMaintenance is matched, using errors.Is, by the error returned
+// for a request to a service that is down for maintenance, see
+// WithMaintenanceDetection.
+const ErrServiceMaintenance = errors.ConstError("service under maintenance")
+
+// maxMaintenanceBody is the number of bytes of a response body passed to a
+// MaintenanceDetector.
+const maxMaintenanceBody = 64 * 1024
+
+// Maintenance describes a maintenance window reported by a service.
+type Maintenance struct {
+	// Reason is the reason given by the service, if any.
+	Reason string
+	// Until is the estimated end of the maintenance, or the zero time if
+	// the service didn't give one.
+	Until time.Time
+}
+
+// ServiceMaintenanceError is returned for a request to a service that is
+// down for maintenance.
+type ServiceMaintenanceError struct {
+	Maintenance
+}
+
+// Error implements error.
+func (e *ServiceMaintenanceError) Error() string {
+	msg := string(ErrServiceMaintenance)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	if !e.Until.IsZero() {
+		msg += fmt.Sprintf(", expected to end at %s", e.Until.Format(time.RFC3339))
+	}
+	return msg
+}
+
+// Is returns true for ErrServiceMaintenance.
+func (e *ServiceMaintenanceError) Is(target error) bool {
+	return target == ErrServiceMaintenance
+}
+
+// IsServiceMaintenance returns true if the error, or any error in its
+// chain, is a ServiceMaintenanceError.
+func IsServiceMaintenance(err error) bool {
+	return errors.Is(err, ErrServiceMaintenance)
+}
+
+// MaintenanceDetector recognizes a 503 Service Unavailable response that
+// reports a maintenance window. It is passed the response, the start of its
+// body, which it must use instead of reading the response body, and the
+// current time.
+type MaintenanceDetector func(resp *http.Response, body []byte, now time.Time) (Maintenance, bool)
+
+// jujuError is the error body of a juju API response.
+type jujuError struct {
+	Message string `json:"error"`
+	Code    string `json:"error-code"`
+}
+
+// DetectJujuMaintenance is a MaintenanceDetector for juju controllers,
+// which report an upgrade in progress in the error code of a JSON error
+// body. The end of the maintenance is estimated from the Retry-After
+// header, if there is one.
+func DetectJujuMaintenance(resp *http.Response, body []byte, now time.Time) (Maintenance, bool) {
+	var jErr jujuError
+	if err := json.Unmarshal(body, &jErr); err != nil || jErr.Code != "upgrade in progress" {
+		return Maintenance{}, false
+	}
+	until, _ := retryAfter(resp, now)
+	return Maintenance{
+		Reason: jErr.Message,
+		Until:  until,
+	}, true
+}
+
+// retryAfter returns the time given by the Retry-After header of the
+// response, either as a date or as a number of seconds from now.
+func retryAfter(resp *http.Response, now time.Time) (time.Time, bool) {
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.ParseInt(header, 10, 64); err == nil && seconds >= 0 {
+		return now.Add(time.Duration(seconds) * time.Second), true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return date, true
+	}
+	return time.Time{}, false
+}
+
+// MaintenancePolicy configures how responses reporting maintenance are
+// handled.
+type MaintenancePolicy struct {
+	// Detector recognizes maintenance responses. If nil,
+	// DetectJujuMaintenance is used.
+	Detector MaintenanceDetector
+
+	// MaxWait, if positive, is the longest a request waits for maintenance
+	// to end. A request to a service in maintenance with an estimated end
+	// within MaxWait pauses until then, instead of retrying, and is then
+	// sent again. Otherwise the request fails straight away.
+	MaxWait time.Duration
+}
+
+// Validate validates the MaintenancePolicy for any issues.
+func (p MaintenancePolicy) Validate() error {
+	if p.MaxWait < 0 {
+		return errors.NotValidf("negative maximum maintenance wait")
+	}
+	return nil
+}
+
+// WithMaintenanceDetection converts 503 Service Unavailable responses that
+// report maintenance, such as a juju controller upgrade, into a
+// *ServiceMaintenanceError, rather than retrying a service that is known
+// to be down. See MaintenancePolicy for waiting for the maintenance to end.
+func WithMaintenanceDetection(policy MaintenancePolicy) Option {
+	return func(opt *options) {
+		opt.maintenancePolicy = &policy
+	}
+}
+
+// maintenanceTransport detects maintenance responses.
+type maintenanceTransport struct {
+	wrappedRoundTripper http.RoundTripper
+	snapshot            *clientSnapshot
+	clock               clock.Clock
+	policy              MaintenancePolicy
+}
+
+func newMaintenanceTransport(transport http.RoundTripper, snapshot *clientSnapshot, clk clock.Clock, policy MaintenancePolicy) maintenanceTransport {
+	if policy.Detector == nil {
+		policy.Detector = DetectJujuMaintenance
+	}
+	return maintenanceTransport{
+		wrappedRoundTripper: transport,
+		snapshot:            snapshot,
+		clock:               clk,
+		policy:              policy,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t maintenanceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	deadline := t.clock.Now().Add(t.policy.MaxWait)
+	for {
+		res, err := t.wrappedRoundTripper.RoundTrip(req)
+		if err != nil || res.StatusCode != http.StatusServiceUnavailable {
+			return res, err
+		}
+		maintenance, ok, err := t.detect(res)
+		if err != nil || !ok {
+			return res, err
+		}
+		_ = res.Body.Close()
+
+		mErr := &ServiceMaintenanceError{Maintenance: maintenance}
+		if maintenance.Until.IsZero() || maintenance.Until.After(deadline) {
+			return nil, mErr
+		}
+		if req, err = t.rewind(req); err != nil {
+			return nil, mErr
+		}
+
+		logger := requestLogger(req.Context(), t.snapshot.load().logger)
+		logger.Tracef("%s at %s, waiting", mErr, req.URL.Redacted())
+		select {
+		case <-t.clock.After(maintenance.Until.Sub(t.clock.Now())):
+		case <-req.Context().Done():
+			return nil, errors.Trace(req.Context().Err())
+		}
+	}
+}
+
+// detect runs the detector on the response, restoring the body that it
+// reads for the detector.
+func (t maintenanceTransport) detect(res *http.Response) (Maintenance, bool, error) {
+	body := res.Body
+	prefix, err := io.ReadAll(io.LimitReader(body, maxMaintenanceBody))
+	if err != nil {
+		_ = body.Close()
+		return Maintenance{}, false, errors.Trace(err)
+	}
+	res.Body = readCloser{
+		Reader: io.MultiReader(bytes.NewReader(prefix), body),
+		Closer: body,
+	}
+	maintenance, ok := t.policy.Detector(res, prefix, t.clock.Now())
+	return maintenance, ok, nil
+}
+
+// rewind returns a copy of the request with a fresh body, so that it can be
+// sent again.
+func (t maintenanceTransport) rewind(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.NotSupportedf("resending request without GetBody")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	rewound := req.Clone(req.Context())
+	rewound.Body = body
+	return rewound, nil
+}
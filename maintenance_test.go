@@ -0,0 +1,151 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/clock/testclock"
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type maintenanceSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&maintenanceSuite{})
+
+const upgradeBody = `{"error": "upgrade in progress", "error-code": "upgrade in progress"}`
+
+func (s *maintenanceSuite) TestDetectJujuMaintenance(c *gc.C) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	resp := &http.Response{Header: http.Header{}}
+
+	_, ok := DetectJujuMaintenance(resp, []byte(`{"error": "boom"}`), now)
+	c.Assert(ok, jc.IsFalse)
+	_, ok = DetectJujuMaintenance(resp, []byte(`<html>`), now)
+	c.Assert(ok, jc.IsFalse)
+
+	maintenance, ok := DetectJujuMaintenance(resp, []byte(upgradeBody), now)
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(maintenance, gc.Equals, Maintenance{Reason: "upgrade in progress"})
+
+	resp.Header.Set("Retry-After", "120")
+	maintenance, ok = DetectJujuMaintenance(resp, []byte(upgradeBody), now)
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(maintenance.Until, gc.Equals, now.Add(2*time.Minute))
+
+	resp.Header.Set("Retry-After", now.Add(time.Hour).Format(http.TimeFormat))
+	maintenance, ok = DetectJujuMaintenance(resp, []byte(upgradeBody), now)
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(maintenance.Until.Equal(now.Add(time.Hour)), jc.IsTrue)
+}
+
+func (s *maintenanceSuite) TestMaintenanceError(c *gc.C) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = io.WriteString(w, upgradeBody)
+	}))
+	defer server.Close()
+
+	client := NewClient(
+		WithMaintenanceDetection(MaintenancePolicy{MaxWait: time.Minute}),
+		WithRequestRetrier(RetryPolicy{Attempts: 3, Delay: time.Millisecond, MaxDelay: time.Second}),
+	)
+	_, err := client.Get(context.TODO(), server.URL)
+	c.Assert(err, gc.ErrorMatches, `.*service under maintenance: upgrade in progress, expected to end at .*`)
+	c.Assert(IsServiceMaintenance(err), jc.IsTrue)
+	c.Assert(errors.Is(err, ErrServiceMaintenance), jc.IsTrue)
+
+	mErr, ok := errors.AsType[*ServiceMaintenanceError](err)
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(mErr.Until.IsZero(), jc.IsFalse)
+
+	// The service is known to be down, so the request isn't retried.
+	c.Assert(atomic.LoadInt32(&requests), gc.Equals, int32(1))
+}
+
+func (s *maintenanceSuite) TestServiceUnavailable(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = io.WriteString(w, "overloaded")
+	}))
+	defer server.Close()
+
+	client := NewClient(WithMaintenanceDetection(MaintenancePolicy{}))
+	resp, err := client.Get(context.TODO(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	defer resp.Body.Close()
+
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusServiceUnavailable)
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(body), gc.Equals, "overloaded")
+}
+
+func (s *maintenanceSuite) TestWaitForMaintenance(c *gc.C) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		c.Check(string(body), gc.Equals, "payload")
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Retry-After", "10")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = io.WriteString(w, upgradeBody)
+		}
+	}))
+	defer server.Close()
+
+	clk := testclock.NewClock(time.Now())
+	client := NewClient(
+		WithClock(clk),
+		WithMaintenanceDetection(MaintenancePolicy{MaxWait: time.Minute}),
+	)
+
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := client.Post(context.TODO(), server.URL, "text/plain", strings.NewReader("payload"))
+		results <- result{resp: resp, err: err}
+	}()
+
+	c.Assert(clk.WaitAdvance(10*time.Second, testing.LongWait, 1), jc.ErrorIsNil)
+	select {
+	case r := <-results:
+		c.Assert(r.err, jc.ErrorIsNil)
+		c.Assert(r.resp.StatusCode, gc.Equals, http.StatusOK)
+		c.Assert(r.resp.Body.Close(), jc.ErrorIsNil)
+	case <-time.After(testing.LongWait):
+		c.Fatalf("timed out waiting for request")
+	}
+	c.Assert(atomic.LoadInt32(&requests), gc.Equals, int32(2))
+}
+
+func (s *maintenanceSuite) TestInvalidPolicy(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	client := NewClient(
+		WithLogger(logger(ctrl)),
+		WithMaintenanceDetection(MaintenancePolicy{MaxWait: -time.Second}),
+	)
+	_, err := client.Get(context.TODO(), "http://example.com")
+	c.Assert(err, gc.ErrorMatches, `.*negative maximum maintenance wait not valid`)
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// RequestMatcher matches requests by method, host and path. Empty fields
+// match any request.
+type RequestMatcher struct {
+	// Method is the request method to match, for example "POST".
+	Method string
+
+	// Host is a pattern matched against the host name of the request URL,
+	// without the port, using path.Match syntax.
+	Host string
+
+	// Path is a pattern matched against the path of the request URL using
+	// path.Match syntax. A pattern ending in "/..." matches the prefix
+	// before it and any path below it.
+	Path string
+}
+
+// Validate validates the RequestMatcher for any issues.
+func (m RequestMatcher) Validate() error {
+	if _, err := path.Match(m.Host, ""); err != nil {
+		return errors.NotValidf("host pattern %q", m.Host)
+	}
+	if _, err := path.Match(strings.TrimSuffix(m.Path, "/..."), ""); err != nil {
+		return errors.NotValidf("path pattern %q", m.Path)
+	}
+	return nil
+}
+
+// Matches returns true if the request matches.
+func (m RequestMatcher) Matches(req *http.Request) bool {
+	return m.MatchesURL(req.Method, req.URL)
+}
+
+// MatchesURL returns true if a request with the given method and URL
+// matches. An empty method matches any method.
+func (m RequestMatcher) MatchesURL(method string, u *url.URL) bool {
+	if m.Method != "" && method != "" && !strings.EqualFold(m.Method, method) {
+		return false
+	}
+	if m.Host != "" {
+		if matched, _ := path.Match(strings.ToLower(m.Host), strings.ToLower(u.Hostname())); !matched {
+			return false
+		}
+	}
+	if m.Path != "" {
+		return matchPath(m.Path, u.Path)
+	}
+	return true
+}
+
+func matchPath(pattern, urlPath string) bool {
+	if !strings.HasSuffix(pattern, "/...") {
+		matched, _ := path.Match(pattern, urlPath)
+		return matched
+	}
+	// Match the prefix against the same number of leading path segments.
+	prefix := strings.TrimSuffix(pattern, "/...")
+	n := len(strings.Split(prefix, "/"))
+	segments := strings.Split(urlPath, "/")
+	if len(segments) < n {
+		return false
+	}
+	matched, _ := path.Match(prefix, strings.Join(segments[:n], "/"))
+	return matched
+}
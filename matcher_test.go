@@ -0,0 +1,81 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type matcherSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&matcherSuite{})
+
+func (s *matcherSuite) TestMatches(c *gc.C) {
+	tests := []struct {
+		matcher RequestMatcher
+		method  string
+		url     string
+		matches bool
+	}{
+		{RequestMatcher{}, "GET", "http://example.com/", true},
+		{RequestMatcher{Method: "POST"}, "post", "http://example.com/", true},
+		{RequestMatcher{Method: "POST"}, "GET", "http://example.com/", false},
+		{RequestMatcher{Host: "*.charmhub.io"}, "GET", "https://api.charmhub.io:443/", true},
+		{RequestMatcher{Host: "*.charmhub.io"}, "GET", "https://charmhub.io/", false},
+		{RequestMatcher{Path: "/v2/charms/*"}, "GET", "http://example.com/v2/charms/upload", true},
+		{RequestMatcher{Path: "/v2/charms/*"}, "GET", "http://example.com/v2/charms/upload/x", false},
+		{RequestMatcher{Path: "/v2/charms/..."}, "GET", "http://example.com/v2/charms", true},
+		{RequestMatcher{Path: "/v2/charms/..."}, "GET", "http://example.com/v2/charms/upload/x", true},
+		{RequestMatcher{Path: "/v2/charms/..."}, "GET", "http://example.com/v2/charmsx", false},
+		{RequestMatcher{Path: "/v2/*/upload/..."}, "GET", "http://example.com/v2/resources/upload/1", true},
+	}
+	for i, test := range tests {
+		c.Logf("test %d: %+v %s %s", i, test.matcher, test.method, test.url)
+		req, err := http.NewRequest(test.method, test.url, nil)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(test.matcher.Matches(req), gc.Equals, test.matches)
+	}
+}
+
+func (s *matcherSuite) TestValidate(c *gc.C) {
+	c.Assert(RequestMatcher{Host: "["}.Validate(), jc.Satisfies, errors.IsNotValid)
+	c.Assert(RequestMatcher{Path: "/[/..."}.Validate(), jc.Satisfies, errors.IsNotValid)
+}
+
+func (s *matcherSuite) TestRetryExcluded(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	req, err := http.NewRequest("POST", "http://api.charmhub.io/v2/charms/upload", nil)
+	c.Assert(err, gc.IsNil)
+
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(req).Return(&http.Response{
+		StatusCode: http.StatusBadGateway,
+	}, nil).Times(1)
+
+	middleware := makeRetryMiddleware(transport, RetryPolicy{
+		Attempts: 3,
+		Delay:    time.Second,
+		MaxDelay: time.Minute,
+		Exclude: []RequestMatcher{{
+			Method: "POST",
+			Path:   "/v2/charms/...",
+		}},
+	}, clock.WallClock, logger(ctrl))
+
+	resp, err := middleware.RoundTrip(req)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadGateway)
+}
@@ -0,0 +1,144 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// DefaultMaxMessageSize is the default limit of the size of the messages
+// sent and received by Client.SendMessage.
+const DefaultMaxMessageSize = 16 << 20
+
+// ErrMessageTooLarge is matched, using errors.Is, by the error returned by
+// Client.SendMessage for a request or response message larger than the
+// client's limit, see WithMaxMessageSize.
+const ErrMessageTooLarge = errors.ConstError("message too large")
+
+// WithMaxMessageSize limits the size of the encoded request and response
+// messages of Client.SendMessage, and so of the helpers built on it such
+// as those of the message package, so that a misbehaving endpoint can't
+// exhaust the memory of a constrained device. It defaults to
+// DefaultMaxMessageSize.
+func WithMaxMessageSize(value int64) Option {
+	return func(opt *options) {
+		opt.maxMessageSize = value
+	}
+}
+
+// MessageCodec encodes and decodes the messages of a compact binary API,
+// such as CBOR or protocol buffers, for Client.SendMessage. The message
+// package provides the codecs of common formats, so that only the callers
+// that need them depend on their libraries.
+type MessageCodec struct {
+	// MediaTypes are the media types accepted for responses, the first of
+	// which is the content type of requests.
+	MediaTypes []string
+
+	// Suffix is the structured syntax suffix of any other media type
+	// accepted for responses, such as "+cbor", if any.
+	Suffix string
+
+	// Marshal encodes a request message.
+	Marshal func(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes a response message into the value pointed to by v.
+	Unmarshal func(data []byte, v interface{}) error
+}
+
+// Validate validates the MessageCodec for any issues.
+func (c MessageCodec) Validate() error {
+	if len(c.MediaTypes) == 0 {
+		return errors.NotValidf("codec without media types")
+	}
+	if c.Marshal == nil || c.Unmarshal == nil {
+		return errors.NotValidf("codec %s without marshal and unmarshal funcs", c.MediaTypes[0])
+	}
+	return nil
+}
+
+// accepts returns true if the codec accepts responses of the media type.
+func (c MessageCodec) accepts(mediaType string) bool {
+	for _, accepted := range c.MediaTypes {
+		if strings.EqualFold(mediaType, accepted) {
+			return true
+		}
+	}
+	return c.Suffix != "" && strings.HasSuffix(mediaType, c.Suffix)
+}
+
+// SendMessage issues a request with the method to the specified URL, with
+// the in message, unless it is nil, encoded by the codec, accepting a
+// response of the media types of the codec, which is decoded into the
+// value pointed to by out, unless it is nil. A request with a message is
+// retried according to the client's RetryPolicy like any other.
+//
+// A response with a status code other than 2xx, or with a content type not
+// accepted by the codec, is an error. The request and response messages
+// are limited in size, see WithMaxMessageSize.
+func (c *Client) SendMessage(ctx context.Context, method, path string, codec MessageCodec, in, out interface{}) error {
+	if err := codec.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	maxSize := c.maxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	var (
+		body        io.Reader
+		contentType string
+	)
+	if in != nil {
+		data, err := codec.Marshal(in)
+		if err != nil {
+			return errors.Annotatef(err, "encoding %s request", codec.MediaTypes[0])
+		}
+		if int64(len(data)) > maxSize {
+			return errors.Annotatef(ErrMessageTooLarge, "request of %d bytes exceeds %d", len(data), maxSize)
+		}
+		// A bytes.Reader can be sent again, so the request can be retried.
+		body = bytes.NewReader(data)
+		contentType = codec.MediaTypes[0]
+	}
+	req, err := http.NewRequestWithContext(ctx, method, path, body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Accept", strings.Join(codec.MediaTypes, ", "))
+	resp, err := c.sendRequest(req, path, contentType)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("%s %s: %s", method, req.URL.Redacted(), resp.Status)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if resp.ContentLength > maxSize {
+		return errors.Annotatef(ErrMessageTooLarge, "response of %d bytes exceeds %d", resp.ContentLength, maxSize)
+	}
+	header := resp.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil || !codec.accepts(mediaType) {
+		return errors.NotSupportedf("response content type %q", header)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return errors.Annotate(err, "reading response")
+	}
+	if int64(len(data)) > maxSize {
+		return errors.Annotatef(ErrMessageTooLarge, "response exceeds %d bytes", maxSize)
+	}
+	return errors.Annotatef(codec.Unmarshal(data, out), "decoding %s response", mediaType)
+}
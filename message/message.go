@@ -0,0 +1,127 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package message provides helpers for the compact binary APIs of some
+// embedded and edge devices, sending and receiving CBOR and protocol buffer
+// messages with a client, so that only the callers that need them depend
+// on the libraries of those formats. Importing it registers decoders of
+// both formats for http.DecodeResponse.
+package message
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/juju/errors"
+	"google.golang.org/protobuf/proto"
+
+	jujuhttp "github.com/juju/http/v2"
+)
+
+const (
+	// CBORContentType is the content type of CBOR messages, see RFC 8949.
+	CBORContentType = "application/cbor"
+
+	// ProtobufContentType is the content type of protocol buffer messages.
+	ProtobufContentType = "application/x-protobuf"
+)
+
+var (
+	// CBOR is the codec of CBOR messages, accepting responses of any
+	// media type with a "+cbor" suffix too.
+	CBOR = jujuhttp.MessageCodec{
+		MediaTypes: []string{CBORContentType},
+		Suffix:     "+cbor",
+		Marshal:    cbor.Marshal,
+		Unmarshal:  cbor.Unmarshal,
+	}
+
+	// Protobuf is the codec of protocol buffer messages, which must be a
+	// proto.Message.
+	Protobuf = jujuhttp.MessageCodec{
+		MediaTypes: []string{ProtobufContentType, "application/protobuf", "application/vnd.google.protobuf"},
+		Marshal:    marshalProtobuf,
+		Unmarshal:  unmarshalProtobuf,
+	}
+)
+
+func init() {
+	jujuhttp.RegisterDecoder(CBORContentType, decodeCBOR)
+	for _, mediaType := range Protobuf.MediaTypes {
+		jujuhttp.RegisterDecoder(mediaType, decodeProtobuf)
+	}
+}
+
+// GetCBOR issues a GET to the specified URL with the client, accepting a
+// CBOR response, which is decoded into the value pointed to by out. See
+// Client.SendMessage.
+func GetCBOR(ctx context.Context, client *jujuhttp.Client, path string, out interface{}) error {
+	return client.SendMessage(ctx, "GET", path, CBOR, nil, out)
+}
+
+// PostCBOR issues a POST to the specified URL with the client, with the in
+// value encoded as CBOR, accepting a CBOR response, which is decoded into
+// the value pointed to by out unless it is nil. See Client.SendMessage.
+func PostCBOR(ctx context.Context, client *jujuhttp.Client, path string, in, out interface{}) error {
+	if in == nil {
+		return errors.NotValidf("nil request message")
+	}
+	return client.SendMessage(ctx, "POST", path, CBOR, in, out)
+}
+
+// GetProtobuf issues a GET to the specified URL with the client, accepting
+// a protocol buffer response, which is decoded into the out message. See
+// Client.SendMessage.
+func GetProtobuf(ctx context.Context, client *jujuhttp.Client, path string, out proto.Message) error {
+	if out == nil {
+		return errors.NotValidf("nil response message")
+	}
+	return client.SendMessage(ctx, "GET", path, Protobuf, nil, out)
+}
+
+// PostProtobuf issues a POST to the specified URL with the client, with the
+// in message encoded as a protocol buffer, accepting a protocol buffer
+// response, which is decoded into the out message unless it is nil. See
+// Client.SendMessage.
+func PostProtobuf(ctx context.Context, client *jujuhttp.Client, path string, in, out proto.Message) error {
+	if in == nil {
+		return errors.NotValidf("nil request message")
+	}
+	// A nil message is passed as a nil interface{}, rather than a nil
+	// message of a type.
+	var outValue interface{}
+	if out != nil {
+		outValue = out
+	}
+	return client.SendMessage(ctx, "POST", path, Protobuf, in, outValue)
+}
+
+func marshalProtobuf(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, errors.NotValidf("encoding %T as a protocol buffer", v)
+	}
+	return proto.Marshal(m)
+}
+
+func unmarshalProtobuf(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return errors.NotValidf("decoding a protocol buffer into %T", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func decodeCBOR(r io.Reader, v interface{}) error {
+	return cbor.NewDecoder(r).Decode(v)
+}
+
+func decodeProtobuf(r io.Reader, v interface{}) error {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return errors.Trace(err)
+	}
+	return unmarshalProtobuf(buf.Bytes(), v)
+}
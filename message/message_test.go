@@ -0,0 +1,146 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package message_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	gc "gopkg.in/check.v1"
+
+	jujuhttp "github.com/juju/http/v2"
+	"github.com/juju/http/v2/message"
+)
+
+type messageSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&messageSuite{})
+
+type charm struct {
+	Name     string `json:"name"`
+	Revision int    `json:"revision"`
+}
+
+func (s *messageSuite) TestPostCBOR(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("Content-Type"), gc.Equals, "application/cbor")
+		c.Check(r.Header.Get("Accept"), gc.Equals, "application/cbor")
+		var in charm
+		c.Check(cbor.NewDecoder(r.Body).Decode(&in), jc.ErrorIsNil)
+		in.Revision++
+		data, err := cbor.Marshal(in)
+		c.Check(err, jc.ErrorIsNil)
+		w.Header().Set("Content-Type", "application/vnd.charmhub+cbor")
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	var out charm
+	err := message.PostCBOR(context.Background(), jujuhttp.NewClient(), server.URL, charm{Name: "mysql", Revision: 41}, &out)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(out, gc.Equals, charm{Name: "mysql", Revision: 42})
+}
+
+func (s *messageSuite) TestGetProtobuf(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("Accept"), gc.Equals, "application/x-protobuf, application/protobuf, application/vnd.google.protobuf")
+		data, err := proto.Marshal(wrapperspb.String("mysql"))
+		c.Check(err, jc.ErrorIsNil)
+		w.Header().Set("Content-Type", "application/protobuf")
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	out := &wrapperspb.StringValue{}
+	err := message.GetProtobuf(context.Background(), jujuhttp.NewClient(), server.URL, out)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(out.GetValue(), gc.Equals, "mysql")
+}
+
+func (s *messageSuite) TestPostProtobuf(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("Content-Type"), gc.Equals, "application/x-protobuf")
+		data, err := io.ReadAll(r.Body)
+		c.Check(err, jc.ErrorIsNil)
+		var in wrapperspb.Int64Value
+		c.Check(proto.Unmarshal(data, &in), jc.ErrorIsNil)
+		data, err = proto.Marshal(wrapperspb.Int64(in.GetValue() + 1))
+		c.Check(err, jc.ErrorIsNil)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	client := jujuhttp.NewClient()
+	out := &wrapperspb.Int64Value{}
+	err := message.PostProtobuf(context.Background(), client, server.URL, wrapperspb.Int64(41), out)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(out.GetValue(), gc.Equals, int64(42))
+
+	// The response may be ignored.
+	err = message.PostProtobuf(context.Background(), client, server.URL, wrapperspb.Int64(41), nil)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *messageSuite) TestErrors(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := cbor.Marshal(strings.Repeat("x", 100))
+		w.Header().Set("Content-Type", "application/cbor")
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	client := jujuhttp.NewClient(jujuhttp.WithMaxMessageSize(64))
+	var out string
+	err := message.GetCBOR(context.Background(), client, server.URL, &out)
+	c.Check(err, jc.ErrorIs, jujuhttp.ErrMessageTooLarge)
+
+	err = message.PostCBOR(context.Background(), client, server.URL, nil, &out)
+	c.Check(err, gc.ErrorMatches, `nil request message not valid`)
+	err = message.PostProtobuf(context.Background(), client, server.URL, nil, nil)
+	c.Check(err, gc.ErrorMatches, `nil request message not valid`)
+	err = message.GetProtobuf(context.Background(), client, server.URL, nil)
+	c.Check(err, gc.ErrorMatches, `nil response message not valid`)
+
+	// Only a proto.Message is encoded as a protocol buffer.
+	err = client.SendMessage(context.Background(), "POST", server.URL, message.Protobuf, "mysql", nil)
+	c.Check(err, gc.ErrorMatches, `encoding application/x-protobuf request: encoding string as a protocol buffer not valid`)
+}
+
+func (s *messageSuite) TestDecodeResponse(c *gc.C) {
+	response := func(contentType string, body []byte) *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {contentType}},
+			Body:       io.NopCloser(strings.NewReader(string(body))),
+		}
+	}
+
+	data, err := cbor.Marshal(charm{Name: "mysql", Revision: 42})
+	c.Assert(err, jc.ErrorIsNil)
+	var result charm
+	err = jujuhttp.DecodeResponse(response("application/vnd.charmhub+cbor", data), &result)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(result, jc.DeepEquals, charm{Name: "mysql", Revision: 42})
+
+	data, err = proto.Marshal(wrapperspb.String("mysql"))
+	c.Assert(err, jc.ErrorIsNil)
+	var value wrapperspb.StringValue
+	err = jujuhttp.DecodeResponse(response("application/vnd.google.protobuf", data), &value)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(value.GetValue(), gc.Equals, "mysql")
+
+	err = jujuhttp.DecodeResponse(response("application/x-protobuf", data), &result)
+	c.Check(err, gc.ErrorMatches, `decoding application/x-protobuf response: decoding a protocol buffer into \*message_test.charm not valid`)
+}
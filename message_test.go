@@ -0,0 +1,146 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type messageSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&messageSuite{})
+
+// jsonCodec stands in for the codecs of binary formats.
+var jsonCodec = MessageCodec{
+	MediaTypes: []string{"application/json", "text/json"},
+	Suffix:     "+json",
+	Marshal:    json.Marshal,
+	Unmarshal:  json.Unmarshal,
+}
+
+func (s *messageSuite) TestSendMessage(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("Content-Type"), gc.Equals, "application/json")
+		c.Check(r.Header.Get("Accept"), gc.Equals, "application/json, text/json")
+		var in charm
+		c.Check(json.NewDecoder(r.Body).Decode(&in), jc.ErrorIsNil)
+		in.Revision++
+		w.Header().Set("Content-Type", "application/vnd.charmhub+json")
+		c.Check(json.NewEncoder(w).Encode(in), jc.ErrorIsNil)
+	}))
+	defer server.Close()
+
+	var out charm
+	err := NewClient().SendMessage(context.Background(), "POST", server.URL, jsonCodec, charm{Name: "mysql", Revision: 41}, &out)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(out, gc.Equals, charm{Name: "mysql", Revision: 42})
+}
+
+func (s *messageSuite) TestSendMessageRetried(c *gc.C) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		c.Check(err, jc.ErrorIsNil)
+		c.Check(string(body), gc.Equals, `{"name":"mysql","revision":42}`)
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := NewClient(WithRequestRetrier(RetryPolicy{
+		Attempts: 2,
+		Delay:    time.Millisecond,
+		MaxDelay: time.Millisecond,
+	}))
+	out := charm{Name: "unchanged"}
+	err := client.SendMessage(context.Background(), "PUT", server.URL, jsonCodec, charm{Name: "mysql", Revision: 42}, &out)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(out.Name, gc.Equals, "unchanged")
+	c.Check(atomic.LoadInt32(&attempts), gc.Equals, int32(2))
+}
+
+func (s *messageSuite) TestResponseErrors(c *gc.C) {
+	large := `"` + strings.Repeat("x", 100) + `"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/yaml":
+			w.Header().Set("Content-Type", "application/yaml")
+			_, _ = io.WriteString(w, `name: mysql`)
+		case "/large":
+			w.Header().Set("Content-Type", "application/json")
+			w.(http.Flusher).Flush()
+			_, _ = io.WriteString(w, large)
+		case "/length":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, large)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(WithMaxMessageSize(64))
+	var out string
+	err := client.SendMessage(context.Background(), "GET", server.URL+"/missing", jsonCodec, nil, &out)
+	c.Check(err, gc.ErrorMatches, `GET http://127.0.0.1:\d+/missing: 404 Not Found`)
+
+	err = client.SendMessage(context.Background(), "GET", server.URL+"/yaml", jsonCodec, nil, &out)
+	c.Check(err, gc.ErrorMatches, `response content type "application/yaml" not supported`)
+	c.Check(err, jc.ErrorIs, errors.NotSupported)
+
+	err = client.SendMessage(context.Background(), "GET", server.URL+"/large", jsonCodec, nil, &out)
+	c.Check(err, gc.ErrorMatches, `response exceeds 64 bytes: message too large`)
+	c.Check(err, jc.ErrorIs, ErrMessageTooLarge)
+
+	err = client.SendMessage(context.Background(), "GET", server.URL+"/length", jsonCodec, nil, &out)
+	c.Check(err, gc.ErrorMatches, `response of 102 bytes exceeds 64: message too large`)
+	c.Check(err, jc.ErrorIs, ErrMessageTooLarge)
+}
+
+func (s *messageSuite) TestRequestErrors(c *gc.C) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer server.Close()
+
+	client := NewClient(WithMaxMessageSize(64))
+	err := client.SendMessage(context.Background(), "POST", server.URL, jsonCodec, strings.Repeat("x", 100), nil)
+	c.Check(err, gc.ErrorMatches, `request of 102 bytes exceeds 64: message too large`)
+	c.Check(err, jc.ErrorIs, ErrMessageTooLarge)
+
+	err = client.SendMessage(context.Background(), "POST", server.URL, jsonCodec, make(chan int), nil)
+	c.Check(err, gc.ErrorMatches, `encoding application/json request: .*`)
+
+	err = client.SendMessage(context.Background(), "POST", server.URL, MessageCodec{}, "x", nil)
+	c.Check(err, gc.ErrorMatches, `codec without media types not valid`)
+	c.Check(atomic.LoadInt32(&requests), gc.Equals, int32(0))
+}
+
+func (s *messageSuite) TestValidate(c *gc.C) {
+	c.Check(jsonCodec.Validate(), jc.ErrorIsNil)
+	c.Check(MessageCodec{MediaTypes: []string{"application/cbor"}}.Validate(), gc.ErrorMatches,
+		`codec application/cbor without marshal and unmarshal funcs not valid`)
+
+	opts := newOptions()
+	WithMaxMessageSize(-1)(opts)
+	c.Check(opts.validate(), gc.ErrorMatches, `negative max message size not valid`)
+}
@@ -0,0 +1,127 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package metrics provides a RequestRecorder that exports Prometheus
+// metrics, so that every component gets the same metrics for the requests
+// of its clients.
+package metrics
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	jujuhttp "github.com/juju/http/v2"
+)
+
+var _ jujuhttp.RequestRecorder = (*Recorder)(nil)
+
+var _ prometheus.Collector = (*Recorder)(nil)
+
+// Config configures the metrics of a Recorder.
+type Config struct {
+	// Namespace is the namespace of the metrics, such as "juju".
+	Namespace string
+	// Subsystem is the subsystem of the metrics. If empty, "http_client"
+	// is used.
+	Subsystem string
+	// ConstLabels are added to every metric, such as the name of the
+	// component making the requests.
+	ConstLabels prometheus.Labels
+	// Buckets are the buckets of the request duration histogram, in
+	// seconds. If empty, prometheus.DefBuckets is used.
+	Buckets []float64
+}
+
+// Recorder is a RequestRecorder that counts requests and their errors, and
+// observes the duration of requests, labelled by method and host, and by
+// the status code of the response or the fault class of the error. It is a
+// prometheus.Collector, so must be registered for its metrics to be
+// exported, see Register.
+type Recorder struct {
+	requests *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// New returns a Recorder with the metrics described by the config.
+func New(config Config) *Recorder {
+	if config.Subsystem == "" {
+		config.Subsystem = "http_client"
+	}
+	if len(config.Buckets) == 0 {
+		config.Buckets = prometheus.DefBuckets
+	}
+	return &Recorder{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   config.Namespace,
+			Subsystem:   config.Subsystem,
+			Name:        "requests_total",
+			Help:        "The number of requests that received a response.",
+			ConstLabels: config.ConstLabels,
+		}, []string{"method", "host", "status"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   config.Namespace,
+			Subsystem:   config.Subsystem,
+			Name:        "request_errors_total",
+			Help:        "The number of requests that failed without a response.",
+			ConstLabels: config.ConstLabels,
+		}, []string{"method", "host", "fault"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   config.Namespace,
+			Subsystem:   config.Subsystem,
+			Name:        "request_duration_seconds",
+			Help:        "The time taken by requests until their response headers were received.",
+			ConstLabels: config.ConstLabels,
+			Buckets:     config.Buckets,
+		}, []string{"method", "host", "status"}),
+	}
+}
+
+// Register returns a Recorder registered with the registerer. If a
+// Recorder with the same metrics is already registered, such as by another
+// client of the same component, it is returned instead, so that the
+// clients share their metrics.
+func Register(registerer prometheus.Registerer, config Config) (*Recorder, error) {
+	recorder := New(config)
+	err := registerer.Register(recorder)
+	if err == nil {
+		return recorder, nil
+	}
+	if registered, ok := errors.AsType[prometheus.AlreadyRegisteredError](err); ok {
+		if existing, ok := registered.ExistingCollector.(*Recorder); ok {
+			return existing, nil
+		}
+	}
+	return nil, errors.Annotate(err, "registering http client metrics")
+}
+
+// Describe implements prometheus.Collector.
+func (r *Recorder) Describe(ch chan<- *prometheus.Desc) {
+	r.requests.Describe(ch)
+	r.errors.Describe(ch)
+	r.duration.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (r *Recorder) Collect(ch chan<- prometheus.Metric) {
+	r.requests.Collect(ch)
+	r.errors.Collect(ch)
+	r.duration.Collect(ch)
+}
+
+// Record implements jujuhttp.RequestRecorder.
+func (r *Recorder) Record(method string, url *url.URL, res *http.Response, rtt time.Duration) {
+	status := strconv.Itoa(res.StatusCode)
+	r.requests.WithLabelValues(method, url.Host, status).Inc()
+	r.duration.WithLabelValues(method, url.Host, status).Observe(rtt.Seconds())
+}
+
+// RecordError implements jujuhttp.RequestRecorder.
+func (r *Recorder) RecordError(method string, url *url.URL, err error) {
+	r.errors.WithLabelValues(method, url.Host, jujuhttp.ClassifyError(err).String()).Inc()
+}
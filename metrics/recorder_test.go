@@ -0,0 +1,101 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package metrics_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	gc "gopkg.in/check.v1"
+
+	jujuhttp "github.com/juju/http/v2"
+	"github.com/juju/http/v2/metrics"
+)
+
+type recorderSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&recorderSuite{})
+
+func (s *recorderSuite) TestRecord(c *gc.C) {
+	recorder := metrics.New(metrics.Config{Namespace: "juju"})
+	charmhub := &url.URL{Scheme: "https", Host: "api.charmhub.io", Path: "/v2/charms"}
+
+	recorder.Record("GET", charmhub, &http.Response{StatusCode: http.StatusOK}, 100*time.Millisecond)
+	recorder.Record("GET", charmhub, &http.Response{StatusCode: http.StatusOK}, 300*time.Millisecond)
+	recorder.Record("POST", charmhub, &http.Response{StatusCode: http.StatusBadGateway}, time.Second)
+	recorder.RecordError("GET", charmhub, context.DeadlineExceeded)
+
+	err := testutil.CollectAndCompare(recorder, strings.NewReader(`
+# HELP juju_http_client_requests_total The number of requests that received a response.
+# TYPE juju_http_client_requests_total counter
+juju_http_client_requests_total{host="api.charmhub.io",method="GET",status="200"} 2
+juju_http_client_requests_total{host="api.charmhub.io",method="POST",status="502"} 1
+# HELP juju_http_client_request_errors_total The number of requests that failed without a response.
+# TYPE juju_http_client_request_errors_total counter
+juju_http_client_request_errors_total{fault="timeout",host="api.charmhub.io",method="GET"} 1
+`), "juju_http_client_requests_total", "juju_http_client_request_errors_total")
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Check(testutil.CollectAndCount(recorder, "juju_http_client_request_duration_seconds"), gc.Equals, 2)
+}
+
+func (s *recorderSuite) TestRegister(c *gc.C) {
+	registry := prometheus.NewPedanticRegistry()
+	config := metrics.Config{
+		Namespace:   "juju",
+		ConstLabels: prometheus.Labels{"component": "charmhub"},
+	}
+
+	recorder, err := metrics.Register(registry, config)
+	c.Assert(err, jc.ErrorIsNil)
+
+	// Registering the same metrics again returns the registered recorder.
+	again, err := metrics.Register(registry, config)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(again, gc.Equals, recorder)
+}
+
+func (s *recorderSuite) TestRegisterConflict(c *gc.C) {
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "juju_http_client_requests_total",
+		Help: "Something else.",
+	}))
+
+	_, err := metrics.Register(registry, metrics.Config{Namespace: "juju"})
+	c.Assert(err, gc.ErrorMatches, `registering http client metrics: .*`)
+}
+
+func (s *recorderSuite) TestClient(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+
+	recorder := metrics.New(metrics.Config{})
+	client := jujuhttp.NewClient(jujuhttp.WithRequestRecorder(recorder))
+	resp, err := client.Get(context.Background(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+
+	err = testutil.CollectAndCompare(recorder, strings.NewReader(fmt.Sprintf(`
+# HELP http_client_requests_total The number of requests that received a response.
+# TYPE http_client_requests_total counter
+http_client_requests_total{host=%q,method="GET",status="204"} 1
+`, serverURL.Host)), "http_client_requests_total")
+	c.Assert(err, jc.ErrorIsNil)
+}
@@ -4,10 +4,13 @@ package http
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
-	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/juju/clock"
@@ -32,65 +35,309 @@ type DialBreaker interface {
 	Trip()
 }
 
+// ErrAccessNotAllowed is matched, using errors.Is, by the error returned
+// for a dial denied by the DialBreaker of the client.
+const ErrAccessNotAllowed = errors.ConstError("access not allowed")
+
+// AccessNotAllowedError is returned for a dial denied by the DialBreaker of
+// the client.
+type AccessNotAllowedError struct {
+	// Network is the network of the denied dial, such as "tcp".
+	Network string
+	// Address is the address of the denied dial.
+	Address string
+}
+
+// Error implements error.
+func (e *AccessNotAllowedError) Error() string {
+	return fmt.Sprintf("access to address %q not allowed", e.Address)
+}
+
+// Is returns true for ErrAccessNotAllowed.
+func (e *AccessNotAllowedError) Is(target error) bool {
+	return target == ErrAccessNotAllowed
+}
+
+// ContextDialBreaker is an optional extension of DialBreaker. If the
+// breaker implements it, AllowedContext is used in place of Allowed,
+// allowing decisions to depend on the network and on values carried by the
+// context of the request, such as the model it is made for.
+type ContextDialBreaker interface {
+	DialBreaker
+
+	// AllowedContext checks to see if a dial to the given network and
+	// address is allowed.
+	AllowedContext(ctx context.Context, network, addr string) bool
+}
+
+// dialAllowed checks the breaker, using AllowedContext if it is supported.
+func dialAllowed(ctx context.Context, breaker DialBreaker, network, addr string) bool {
+	if b, ok := breaker.(ContextDialBreaker); ok {
+		return b.AllowedContext(ctx, network, addr)
+	}
+	return breaker.Allowed(addr)
+}
+
 func isLocalAddr(addr string) bool {
-	host, _, err := net.SplitHostPort(addr)
+	return LocalLoopback.contains(addr)
+}
+
+// LocalAddrClass is a set of address classes that a LocalDialBreaker treats
+// as local. Classes can be combined, for example
+// LocalLoopback|LocalPrivate.
+type LocalAddrClass uint
+
+const (
+	// LocalLoopback is "localhost" and the loopback addresses.
+	LocalLoopback LocalAddrClass = 1 << iota
+	// LocalLinkLocal is the IPv4 and IPv6 link-local unicast addresses.
+	LocalLinkLocal
+	// LocalUniqueLocal is the IPv6 unique local addresses, fc00::/7.
+	LocalUniqueLocal
+	// LocalPrivate is the RFC 1918 private IPv4 ranges.
+	LocalPrivate
+	// LocalInterface is the addresses of the host's own network
+	// interfaces.
+	LocalInterface
+)
+
+// interfaceAddrs is patched out in tests.
+var interfaceAddrs = net.InterfaceAddrs
+
+// contains reports whether the host of addr belongs to any of the classes.
+// The addr may be a host and port, or a host alone, and IPv6 literals may
+// be bracketed and have a zone, such as [fe80::1%eth0]:80. Host names are
+// never resolved, so only "localhost" and its subdomains are local.
+func (c LocalAddrClass) contains(addr string) bool {
+	host := addrHost(addr)
+	if isLocalhostName(host) {
+		return c&LocalLoopback != 0
+	}
+	ip := parseIP(host)
+	if ip == nil {
+		return false
+	}
+	switch {
+	case c&LocalLoopback != 0 && ip.IsLoopback():
+		return true
+	case c&LocalLinkLocal != 0 && ip.IsLinkLocalUnicast():
+		return true
+	case c&LocalUniqueLocal != 0 && ip.To4() == nil && ip.IsPrivate():
+		return true
+	case c&LocalPrivate != 0 && ip.To4() != nil && ip.IsPrivate():
+		return true
+	case c&LocalInterface != 0:
+		return isInterfaceAddr(ip)
+	}
+	return false
+}
+
+// addrHost returns the host of addr, which may be a host and port, or a
+// host alone, with any brackets around an IPv6 literal removed.
+func addrHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// parseIP parses an IP address literal, which may be bracketed and have an
+// IPv6 zone, such as fe80::1%eth0, dropping the zone. It returns nil if the
+// host isn't an IP address literal.
+func parseIP(host string) net.IP {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return nil
+	}
+	return net.IP(ip.WithZone("").AsSlice())
+}
+
+// isLocalhostName returns true for "localhost" and its subdomains, which
+// always resolve to a loopback address, see RFC 6761.
+func isLocalhostName(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return host == "localhost" || strings.HasSuffix(host, ".localhost")
+}
+
+func isInterfaceAddr(ip net.IP) bool {
+	addrs, err := interfaceAddrs()
 	if err != nil {
 		return false
 	}
-	return host == "localhost" || net.ParseIP(host).IsLoopback()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
 }
 
 // DialContextMiddleware patches the default HTTP transport so
 // that it fails when an attempt is made to dial a non-local
 // host.
 func DialContextMiddleware(breaker DialBreaker) TransportMiddleware {
+	return dialContextMiddleware(breaker, DialOptions{})
+}
+
+// dialContextMiddleware is DialContextMiddleware, dialing with the dial
+// options.
+func dialContextMiddleware(breaker DialBreaker, dialOptions DialOptions) TransportMiddleware {
 	return func(transport *http.Transport) *http.Transport {
-		dialer := &net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}
+		dialer := dialOptions.dialer()
 		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-			if !breaker.Allowed(addr) {
-				return nil, errors.Errorf("access to address %q not allowed", addr)
+			if dialAllowed(ctx, breaker, network, addr) {
+				return dialer.DialContext(ctx, network, addr)
 			}
-
-			return dialer.DialContext(ctx, network, addr)
+			// A host name may resolve to addresses that are allowed, such as
+			// a name given to the loopback address in /etc/hosts.
+			if addrs, ok := resolvedAddrsAllowed(ctx, breaker, network, addr); ok {
+				return dialAddrs(ctx, dialer.DialContext, network, addrs)
+			}
+			midLogger.Debugf("dial to %s address %q denied by breaker", network, addr)
+			return nil, &AccessNotAllowedError{Network: network, Address: addr}
 		}
 		return transport
 	}
 }
 
+// resolvedAddrsAllowed resolves the host name of addr, returning its
+// addresses if the breaker allows dialing every one of them. The addresses
+// are dialed in place of the host name, so that it isn't resolved again to
+// a different address.
+func resolvedAddrsAllowed(ctx context.Context, breaker DialBreaker, network, addr string) ([]string, bool) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || parseIP(host) != nil {
+		return nil, false
+	}
+	ipAddrs, err := lookupIPAddr(ctx, host)
+	if err != nil || len(ipAddrs) == 0 {
+		return nil, false
+	}
+	addrs := make([]string, len(ipAddrs))
+	for i, ipAddr := range ipAddrs {
+		addrs[i] = net.JoinHostPort(ipAddr.String(), port)
+		if !dialAllowed(ctx, breaker, network, addrs[i]) {
+			return nil, false
+		}
+	}
+	return addrs, true
+}
+
+// dialAddrs dials each of the addresses in turn, those that failed recently
+// last, returning the first connection made, or the error of the first
+// address.
+func dialAddrs(ctx context.Context, dial dialContextFunc, network string, addrs []string) (net.Conn, error) {
+	var firstErr error
+	for _, addr := range orderFailedTargets(ctx, addrs) {
+		conn, err := dial(ctx, network, addr)
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
+
 // LocalDialBreaker defines a DialBreaker that when tripped only allows local
-// dials, anything else is prevented.
+// dials, anything else is prevented. It is safe for concurrent use.
 type LocalDialBreaker struct {
-	allowOutgoingAccess bool
+	allowOutgoingAccess int32
+	localAddrs          LocalAddrClass
 }
 
 // NewLocalDialBreaker creates a new LocalDialBreaker with a default value.
+// Only loopback addresses are treated as local.
 func NewLocalDialBreaker(allowOutgoingAccess bool) *LocalDialBreaker {
-	return &LocalDialBreaker{
-		allowOutgoingAccess: allowOutgoingAccess,
+	return NewLocalDialBreakerWithClasses(allowOutgoingAccess, LocalLoopback)
+}
+
+// NewLocalDialBreakerWithClasses creates a new LocalDialBreaker that treats
+// addresses in any of the given classes as local.
+func NewLocalDialBreakerWithClasses(allowOutgoingAccess bool, localAddrs LocalAddrClass) *LocalDialBreaker {
+	b := &LocalDialBreaker{
+		localAddrs: localAddrs,
 	}
+	b.SetAllowOutgoingAccess(allowOutgoingAccess)
+	return b
 }
 
 // Allowed checks to see if a dial is allowed to happen, or returns an error
 // stating why.
 func (b *LocalDialBreaker) Allowed(addr string) bool {
-	if b.allowOutgoingAccess {
+	if b.AllowOutgoingAccess() {
 		return true
 	}
 	// If we're not allowing outgoing access, then only local addresses are
 	// allowed to be dialed. Check for local only addresses.
-	return isLocalAddr(addr)
+	return b.localAddrs.contains(addr)
+}
+
+// AllowedContext implements ContextDialBreaker. Dials to unix sockets are
+// always local, so are always allowed.
+func (b *LocalDialBreaker) AllowedContext(_ context.Context, network, addr string) bool {
+	switch network {
+	case "unix", "unixgram", "unixpacket":
+		return true
+	}
+	return b.Allowed(addr)
 }
 
 // Trip inverts the local state of the DialBreaker.
 func (b *LocalDialBreaker) Trip() {
-	b.allowOutgoingAccess = !b.allowOutgoingAccess
+	for {
+		old := atomic.LoadInt32(&b.allowOutgoingAccess)
+		if atomic.CompareAndSwapInt32(&b.allowOutgoingAccess, old, 1-old) {
+			return
+		}
+	}
+}
+
+// AllowOutgoingAccess returns true if dials to non-local addresses are
+// allowed.
+func (b *LocalDialBreaker) AllowOutgoingAccess() bool {
+	return atomic.LoadInt32(&b.allowOutgoingAccess) == 1
+}
+
+// SetAllowOutgoingAccess sets whether dials to non-local addresses are
+// allowed, returning the previous value.
+func (b *LocalDialBreaker) SetAllowOutgoingAccess(allow bool) bool {
+	var value int32
+	if allow {
+		value = 1
+	}
+	return atomic.SwapInt32(&b.allowOutgoingAccess, value) == 1
+}
+
+// DefaultDialBreaker is the DialBreaker used by http.DefaultTransport and by
+// clients that are not given one via WithDialBreaker. It allows outgoing
+// access by default.
+//
+// Tests that need to prevent outgoing access should prefer injecting their
+// own DialBreaker with WithDialBreaker, which is safe to use from parallel
+// tests. Where that isn't possible, use PatchOutgoingAccess.
+var DefaultDialBreaker = NewLocalDialBreaker(true)
+
+// PatchOutgoingAccess sets whether the DefaultDialBreaker allows outgoing
+// access, returning a function that restores the previous value. It is
+// intended for use in test suites:
+//
+//	s.AddCleanup(func(*gc.C) { restore() })
+func PatchOutgoingAccess(allowed bool) (restore func()) {
+	previous := DefaultDialBreaker.SetAllowOutgoingAccess(allowed)
+	return func() {
+		DefaultDialBreaker.SetAllowOutgoingAccess(previous)
+	}
 }
 
 // ProxyMiddleware adds a Proxy to the given transport. This implementation
-// uses the http.ProxyFromEnvironment.
+// uses the http.ProxyFromEnvironment. Use ProxyFromSettings or
+// WithProxyFunc to take the proxy settings from elsewhere.
 func ProxyMiddleware(transport *http.Transport) *http.Transport {
 	transport.Proxy = getProxy
 	return transport
@@ -98,16 +345,11 @@ func ProxyMiddleware(transport *http.Transport) *http.Transport {
 
 var midLogger = loggo.GetLoggerWithTags("juju.http.middleware", "http")
 
-func getProxy(req *http.Request) (*url.URL, error) {
-	// Get proxy config new for each client.  Go will cache the proxy
-	// settings for a process, this is a problem for long running programs.
-	// And caused changes in proxy settings via model-config not to
-	// be used.
-	cfg := httpproxy.FromEnvironment()
-	midLogger.Tracef("proxy config http(%s), https(%s), no-proxy(%s)",
-		cfg.HTTPProxy, cfg.HTTPSProxy, cfg.NoProxy)
-	return cfg.ProxyFunc()(req.URL)
-}
+// getProxy gets the proxy config from the environment for each request. Go
+// caches the proxy settings for a process, which is a problem for long
+// running programs, and caused changes in proxy settings via model-config
+// not to be used.
+var getProxy = recordingProxy(proxyFromSettings(httpproxy.FromEnvironment))
 
 // ForceAttemptHTTP2Middleware forces a HTTP/2 connection if a non-zero
 // Dial, DialTLS, or DialContext func or TLSClientConfig is provided to the
@@ -135,20 +377,51 @@ type RoundTripper = http.RoundTripper
 type roundTripRecorder struct {
 	requestRecorder     RequestRecorder
 	wrappedRoundTripper http.RoundTripper
+	snapshot            *clientSnapshot
+	clock               clock.Clock
+	hooks               hookRunner
+	sampler             *sampler
 }
 
 // RoundTrip implements http.RoundTripper. If delegates the request to the
 // wrapped RoundTripper and invokes the appropriate RequestRecorder methods
 // depending on the outcome.
 func (lr roundTripRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
-	start := time.Now()
+	recorder := lr.requestRecorder
+	var (
+		logger   Logger = midLogger
+		sampling *SamplingPolicy
+	)
+	if lr.snapshot != nil {
+		state := lr.snapshot.load()
+		recorder = state.recorder
+		logger = state.logger
+		sampling = state.sampling
+	}
+	recorder = requestRecorder(req.Context(), recorder)
+	if recorder == nil {
+		return lr.wrappedRoundTripper.RoundTrip(req)
+	}
+
+	clk := lr.clock
+	if clk == nil {
+		clk = clock.WallClock
+	}
+	start := clk.Now()
 	res, err := lr.wrappedRoundTripper.RoundTrip(req)
-	rtt := time.Since(start)
+	rtt := clk.Now().Sub(start)
 
+	if lr.sampler != nil && !lr.sampler.sample(sampling, res, err, rtt) {
+		return res, err
+	}
 	if err != nil {
-		lr.requestRecorder.RecordError(req.Method, req.URL, err)
+		lr.hooks.run(logger, "request recorder", func() {
+			recorder.RecordError(req.Method, req.URL, err)
+		})
 	} else {
-		lr.requestRecorder.Record(req.Method, req.URL, res, rtt)
+		lr.hooks.run(logger, "request recorder", func() {
+			recorder.Record(req.Method, req.URL, res, rtt)
+		})
 	}
 
 	return res, err
@@ -157,20 +430,79 @@ func (lr roundTripRecorder) RoundTrip(req *http.Request) (*http.Response, error)
 // RetryMiddleware allows retrying of certain retryable http errors.
 // This only handles very specific status codes, ones that are deemed retryable:
 //
+//   - 429 Too Many Requests
 //   - 502 Bad Gateway
 //   - 503 Service Unavailable
 //   - 504 Gateway Timeout
+//
+// Transport errors are retried if the RetryableError func of the policy
+// allows it.
 type retryMiddleware struct {
 	policy              RetryPolicy
 	wrappedRoundTripper http.RoundTripper
 	clock               clock.Clock
 	logger              Logger
+	stats               *clientStats
+	snapshot            *clientSnapshot
 }
 
+// RetryPolicy defines how requests are retried. Requests with a body that
+// can't be sent again, as GetBody isn't set, are never retried, and the
+// outcome of their first attempt is returned. See PostBody for uploads
+// that can be retried.
 type RetryPolicy struct {
 	Delay    time.Duration
 	MaxDelay time.Duration
 	Attempts int
+
+	// MaxDuration, if set, limits the time spent on a request, including
+	// all of its retries. A retry whose delay would exceed it isn't
+	// attempted.
+	MaxDuration time.Duration
+
+	// Budget, if set, limits the number of retries across all requests
+	// sharing the budget. When the budget is exhausted, the response to the
+	// last attempt is returned with a nil error, or the transport error of
+	// the last attempt if it failed without a response, without further
+	// retries.
+	Budget *RetryBudget
+
+	// BackoffFunc, if set, computes the delay before each retry, see
+	// ExponentialBackoff and ExponentialWithJitter. Otherwise the Delay is
+	// used for every retry.
+	BackoffFunc BackoffFunc
+
+	// RetryableError, if set, reports whether a request that failed with a
+	// transport error, such as a connection reset, is retried. If nil,
+	// DefaultRetryableError is used.
+	RetryableError RetryableErrorFunc
+
+	// Exclude lists requests that must never be retried, for example
+	// non-idempotent upload endpoints. Matching requests are attempted
+	// once.
+	Exclude []RequestMatcher
+
+	// BeforeRetry, if set, is called before each retry with a copy of the
+	// request about to be sent, so that it can be updated, for example to
+	// refresh an auth token or regenerate a signature that went stale
+	// during the backoff. Returning an error stops retrying, and the error
+	// is returned for the request.
+	BeforeRetry func(req *http.Request, attempt RetryAttempt) error
+}
+
+// RetryAttempt describes a retry attempt.
+type RetryAttempt struct {
+	// Number is the number of the attempt, starting from 2 for the first
+	// retry.
+	Number int
+	// Elapsed is the time since the first attempt was made.
+	Elapsed time.Duration
+	// StatusCode is the status code of the response to the previous
+	// attempt, or zero if it failed without a response.
+	StatusCode int
+	// Err is the transport error of the previous attempt, if it failed
+	// without a response.
+	Err error
 }
 
 // Validate validates the RetryPolicy for any issues.
@@ -181,11 +513,48 @@ func (p RetryPolicy) Validate() error {
 	if p.MaxDelay < 1 {
 		return errors.Errorf("expected max delay to be a valid time")
 	}
+	if p.MaxDuration < 0 {
+		return errors.Errorf("expected max duration to be a valid time")
+	}
+	for _, matcher := range p.Exclude {
+		if err := matcher.Validate(); err != nil {
+			return errors.Annotate(err, "retry exclusion")
+		}
+	}
 	return nil
 }
 
+// excluded returns true if the request must not be retried.
+func (p RetryPolicy) excluded(req *http.Request) bool {
+	for _, matcher := range p.Exclude {
+		if matcher.Matches(req) {
+			return true
+		}
+	}
+	return false
+}
+
+type retryPolicyKey struct{}
+
+// ContextWithRetryPolicy returns a context that overrides the retry policy
+// of the client for requests made with it, so that a client shared by
+// several subsystems can, for example, retry charm downloads aggressively
+// while metadata probes fail fast. The override only applies to clients
+// created with a retry policy, see WithRequestRetrier. A request with an
+// invalid policy fails.
+func ContextWithRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// retryPolicyFromContext returns the retry policy set on the context with
+// ContextWithRetryPolicy.
+func retryPolicyFromContext(ctx context.Context) (RetryPolicy, bool) {
+	policy, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy)
+	return policy, ok
+}
+
 // makeRetryMiddleware creates a retry transport.
-func makeRetryMiddleware(transport http.RoundTripper, policy RetryPolicy, clock clock.Clock, logger Logger) http.RoundTripper {
+func makeRetryMiddleware(transport http.RoundTripper, policy RetryPolicy, clock clock.Clock, logger Logger) retryMiddleware {
 	return retryMiddleware{
 		policy:              policy,
 		wrappedRoundTripper: transport,
@@ -194,20 +563,103 @@ func makeRetryMiddleware(transport http.RoundTripper, policy RetryPolicy, clock
 	}
 }
 
-type retryableErr struct{}
+// retryableErr is returned for an attempt that should be retried, wrapping
+// the transport error of the attempt if it has one.
+type retryableErr struct {
+	err error
+}
 
-func (retryableErr) Error() string {
+func (e retryableErr) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
 	return "retryable error"
 }
 
+// Unwrap returns the transport error of the attempt.
+func (e retryableErr) Unwrap() error {
+	return e.err
+}
+
+// ErrRetryExhausted is matched, using errors.Is, by the error returned for
+// a request that still failed after all the attempts its retry policy
+// allows.
+const ErrRetryExhausted = errors.ConstError("retries exhausted")
+
+// RetryExhaustedError is returned for a request that still failed after all
+// the attempts, or the MaxDuration, its retry policy allows.
+type RetryExhaustedError struct {
+	// Attempts is the number of attempts made.
+	Attempts int
+	// StatusCode is the status code of the response to the last attempt,
+	// or zero if it failed without a response.
+	StatusCode int
+	// Err is the error of the retry package, wrapping the error of the
+	// last attempt.
+	Err error
+}
+
+// Error implements error, keeping the message of the retry package.
+func (e *RetryExhaustedError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error of the retry package.
+func (e *RetryExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns the cause of the error of the retry package, so that
+// retry.IsAttemptsExceeded and retry.IsDurationExceeded still recognize
+// the error.
+func (e *RetryExhaustedError) Cause() error {
+	return errors.Cause(e.Err)
+}
+
+// Is returns true for ErrRetryExhausted.
+func (e *RetryExhaustedError) Is(target error) bool {
+	return target == ErrRetryExhausted
+}
+
 // RoundTrip defines a strategy for handling retries based on the status code.
 func (m retryMiddleware) RoundTrip(req *http.Request) (*http.Response, error) {
+	if m.snapshot != nil {
+		// Use a consistent policy and logger for the whole request, even if
+		// the client is reconfigured in the meantime.
+		state := m.snapshot.load()
+		m.policy = state.retryPolicy
+		m.logger = state.logger
+	}
+	m.logger = requestLogger(req.Context(), m.logger)
+	if policy, ok := retryPolicyFromContext(req.Context()); ok {
+		if err := policy.Validate(); err != nil {
+			if req.Body != nil {
+				_ = req.Body.Close()
+			}
+			return nil, errors.Annotate(err, "retry policy from context")
+		}
+		m.policy = policy
+	}
+	if m.policy.excluded(req) {
+		return m.wrappedRoundTripper.RoundTrip(req)
+	}
 	var (
 		res        *http.Response
+		lastErr    error
 		backOffErr error
+		attempt    int
 	)
+	if m.policy.Budget != nil {
+		m.policy.Budget.recordRequest()
+	}
+	var start time.Time
+	if m.policy.BeforeRetry != nil {
+		start = m.clock.Now()
+	}
 	err := retry.Call(retry.CallArgs{
 		Clock: m.clock,
+		// Stop waiting for a retry as soon as the request is cancelled.
+		Stop: req.Context().Done(),
 		Func: func() error {
 			if err := req.Context().Err(); err != nil {
 				return err
@@ -215,14 +667,60 @@ func (m retryMiddleware) RoundTrip(req *http.Request) (*http.Response, error) {
 			if backOffErr != nil {
 				return backOffErr
 			}
+			if attempt > 0 && m.policy.Budget != nil && !m.policy.Budget.tryRetry() {
+				// The outcome of the last attempt is returned, its response
+				// or its transport error.
+				m.logger.Tracef("not retrying %s %s, retry budget exhausted", req.Method, req.URL)
+				return lastErr
+			}
+			attempt++
+			requestInfoFromContext(req.Context()).setAttempt(attempt)
+			if attempt > 1 {
+				m.stats.recordRetry()
+			}
+
+			attemptReq := req
+			if attempt > 1 && (m.policy.BeforeRetry != nil || needsRewind(req)) {
+				retryAttempt := RetryAttempt{
+					Number: attempt,
+					Err:    lastErr,
+				}
+				if m.policy.BeforeRetry != nil {
+					retryAttempt.Elapsed = m.clock.Now().Sub(start)
+				}
+				if res != nil {
+					retryAttempt.StatusCode = res.StatusCode
+				}
+				var err error
+				attemptReq, err = m.prepareRetry(req, retryAttempt)
+				if err != nil {
+					return errors.Annotatef(err, "preparing retry attempt %d", attempt)
+				}
+			}
 
 			var retryable bool
 			var err error
-			res, retryable, err = m.roundTrip(req)
+			res, retryable, err = m.roundTrip(attemptReq)
+			lastErr = err
 			if err != nil {
+				// A request that was cancelled or timed out is never
+				// retried, whatever the RetryableError func of the policy.
+				if attemptReq.Context().Err() != nil ||
+					errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+					return err
+				}
+				if canResend(req) && m.retryableError(attemptReq, err) {
+					return retryableErr{err: err}
+				}
 				return err
 			}
 			if retryable {
+				if !canResend(req) {
+					// Sending the request again would send what remains
+					// of a consumed body, so the response is returned.
+					m.logger.Tracef("not retrying %s %s, its body can't be sent again", req.Method, req.URL)
+					return nil
+				}
 				return retryableErr{}
 			}
 			return nil
@@ -232,18 +730,84 @@ func (m retryMiddleware) RoundTrip(req *http.Request) (*http.Response, error) {
 			_, ok := errors.Cause(err).(retryableErr)
 			return !ok
 		},
-		Attempts: m.policy.Attempts,
-		Delay:    m.policy.Delay,
+		Attempts:    m.policy.Attempts,
+		Delay:       m.policy.Delay,
+		MaxDuration: m.policy.MaxDuration,
 		BackoffFunc: func(delay time.Duration, attempts int) time.Duration {
 			var duration time.Duration
-			duration, backOffErr = m.defaultBackoff(res, delay)
+			duration, backOffErr = m.defaultBackoff(res, delay, attempts)
+			if backOffErr == nil {
+				event := Event{
+					Kind:    EventRetry,
+					Attempt: attempt + 1,
+					Delay:   duration,
+					Err:     lastErr,
+				}
+				if res != nil {
+					event.StatusCode = res.StatusCode
+				}
+				emitEvent(req.Context(), event)
+			}
 			return duration
 		},
 	})
+	if retry.IsRetryStopped(err) {
+		// The request was cancelled while waiting for a retry, so the
+		// response to the previous attempt is discarded.
+		if res != nil && res.Body != nil {
+			_ = res.Body.Close()
+		}
+		return nil, req.Context().Err()
+	}
+	if retry.IsAttemptsExceeded(err) || retry.IsDurationExceeded(err) {
+		exhausted := &RetryExhaustedError{
+			Attempts: attempt,
+			Err:      err,
+		}
+		if res != nil {
+			exhausted.StatusCode = res.StatusCode
+		}
+		err = exhausted
+	}
 
 	return res, err
 }
 
+// prepareRetry returns a copy of the request, with a fresh body, updated by
+// the BeforeRetry callback of the policy.
+func (m retryMiddleware) prepareRetry(req *http.Request, attempt RetryAttempt) (*http.Request, error) {
+	retryReq := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		retryReq.Body = body
+	}
+	if m.policy.BeforeRetry == nil {
+		return retryReq, nil
+	}
+	if err := m.policy.BeforeRetry(retryReq, attempt); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return retryReq, nil
+}
+
+// needsRewind returns true if the request has a body that must be replaced
+// before the request is sent again.
+func needsRewind(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody && req.GetBody != nil
+}
+
+// retryableError reports whether the transport error of an attempt should
+// be retried.
+func (m retryMiddleware) retryableError(req *http.Request, err error) bool {
+	if m.policy.RetryableError != nil {
+		return m.policy.RetryableError(req, err)
+	}
+	return DefaultRetryableError(req, err)
+}
+
 func (m retryMiddleware) roundTrip(req *http.Request) (*http.Response, bool, error) {
 	res, err := m.wrappedRoundTripper.RoundTrip(req)
 	if err != nil {
@@ -271,34 +835,18 @@ func (m retryMiddleware) roundTrip(req *http.Request) (*http.Response, bool, err
 //
 //   - Retry-After: <http-date>
 //   - Retry-After: <delay-seconds>
-func (m retryMiddleware) defaultBackoff(resp *http.Response, backoff time.Duration) (time.Duration, error) {
-	if header := resp.Header.Get("Retry-After"); header != "" {
-		// Attempt to parse the header from the request.
-		//
-		// Check for delay in seconds first, before checking for a http-date
-		seconds, err := strconv.ParseInt(header, 10, 64)
-		if err == nil {
-			return m.clampBackoff(time.Second * time.Duration(seconds))
-		}
-		// Check for http-date.
-		date, err := time.Parse(time.RFC1123, header)
-		if err == nil {
-			return m.clampBackoff(m.clock.Now().Sub(date))
-		}
-		url := ""
-		if resp.Request != nil {
-			url = resp.Request.URL.String()
+func (m retryMiddleware) defaultBackoff(resp *http.Response, backoff time.Duration, attempt int) (time.Duration, error) {
+	now := m.clock.Now()
+	if resp != nil {
+		if header := resp.Header.Get("Retry-After"); header != "" {
+			if _, ok := parseRetryAfter(header, now); !ok {
+				url := ""
+				if resp.Request != nil {
+					url = resp.Request.URL.String()
+				}
+				m.logger.Errorf("unable to parse Retry-After header %s from %s", header, url)
+			}
 		}
-		m.logger.Errorf("unable to parse Retry-After header %s from %s", header, url)
-	}
-
-	return m.clampBackoff(backoff)
-}
-
-func (m retryMiddleware) clampBackoff(duration time.Duration) (time.Duration, error) {
-	if m.policy.MaxDelay > 0 && duration > m.policy.MaxDelay {
-		future := m.clock.Now().Add(duration)
-		return duration, errors.Errorf("API request retry is not accepting further requests until %s", future.Format(time.RFC3339))
 	}
-	return duration, nil
+	return m.policy.RetryDelay(resp, backoff, attempt, now)
 }
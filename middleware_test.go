@@ -4,11 +4,20 @@ package http
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/juju/clock"
+	"github.com/juju/errors"
+	"github.com/juju/retry"
 	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
 	"go.uber.org/mock/gomock"
 	gc "gopkg.in/check.v1"
 )
@@ -26,6 +35,13 @@ var isLocalAddrTests = []struct {
 	{"localhost:456", true},
 	{"127.0.0.1:1234", true},
 	{"[::1]:4567", true},
+	{"[::1]", true},
+	{"::1", true},
+	{"127.0.0.1", true},
+	{"[::ffff:127.0.0.1]:80", true},
+	{"LOCALHOST.:80", true},
+	{"juju.localhost:80", true},
+	{"localhost.example.com:80", false},
 	{"localhost:smtp", true},
 	{"123.45.67.5", false},
 	{"0.1.2.3", false},
@@ -41,6 +57,47 @@ func (s *DialContextMiddlewareSuite) TestIsLocalAddr(c *gc.C) {
 	}
 }
 
+func (s *DialContextMiddlewareSuite) TestLocalAddrClasses(c *gc.C) {
+	s.PatchValue(&interfaceAddrs, func() ([]net.Addr, error) {
+		return []net.Addr{&net.IPNet{
+			IP:   net.ParseIP("123.45.67.5"),
+			Mask: net.CIDRMask(24, 32),
+		}}, nil
+	})
+
+	tests := []struct {
+		addr    string
+		classes LocalAddrClass
+		isLocal bool
+	}{
+		{"localhost:456", LocalLoopback, true},
+		{"localhost:456", LocalPrivate, false},
+		{"169.254.1.2:80", LocalLinkLocal, true},
+		{"[fe80::1]:80", LocalLinkLocal, true},
+		{"[fe80::1%eth0]:80", LocalLinkLocal, true},
+		{"fe80::1%eth0", LocalLinkLocal, true},
+		{"[fe80::1%eth0]:80", LocalLoopback, false},
+		{"169.254.1.2:80", LocalLoopback, false},
+		{"[fd00::1]:80", LocalUniqueLocal, true},
+		{"[fd00::1]:80", LocalPrivate, false},
+		{"10.0.43.6:12345", LocalPrivate, true},
+		{"192.168.1.1:80", LocalPrivate, true},
+		{"172.16.0.1:80", LocalPrivate, true},
+		{"10.0.43.6:12345", LocalUniqueLocal, false},
+		{"123.45.67.5:80", LocalInterface, true},
+		{"123.45.67.6:80", LocalInterface, false},
+		{"10.0.43.6:12345", LocalLoopback | LocalPrivate, true},
+		{"[::1]:80", LocalLoopback | LocalPrivate, true},
+		{"example.com:80", LocalLoopback | LocalPrivate | LocalInterface, false},
+	}
+	for i, test := range tests {
+		c.Logf("test %d: %v", i, test.addr)
+		c.Assert(test.classes.contains(test.addr), gc.Equals, test.isLocal)
+		breaker := NewLocalDialBreakerWithClasses(false, test.classes)
+		c.Assert(breaker.Allowed(test.addr), gc.Equals, test.isLocal)
+	}
+}
+
 func (s *DialContextMiddlewareSuite) TestInsecureClientNoAccess(c *gc.C) {
 	client := NewClient(
 		WithTransportMiddlewares(
@@ -50,6 +107,11 @@ func (s *DialContextMiddlewareSuite) TestInsecureClientNoAccess(c *gc.C) {
 	)
 	_, err := client.Get(context.TODO(), "http://0.1.2.3:1234")
 	c.Assert(err, gc.ErrorMatches, `.*access to address "0.1.2.3:1234" not allowed`)
+	c.Assert(err, jc.ErrorIs, ErrAccessNotAllowed)
+	accessErr, ok := errors.AsType[*AccessNotAllowedError](err)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(accessErr.Network, gc.Equals, "tcp")
+	c.Check(accessErr.Address, gc.Equals, "0.1.2.3:1234")
 }
 
 func (s *DialContextMiddlewareSuite) TestSecureClientNoAccess(c *gc.C) {
@@ -62,6 +124,37 @@ func (s *DialContextMiddlewareSuite) TestSecureClientNoAccess(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, `.*access to address "0.1.2.3:1234" not allowed`)
 }
 
+func (s *DialContextMiddlewareSuite) TestResolvedLocalName(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+
+	s.PatchValue(&lookupIPAddr, func(_ context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "ip6-localhost":
+			return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+		case "mixed.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}, {IP: net.ParseIP("0.1.2.3")}}, nil
+		}
+		return nil, errors.NotFoundf("host %q", host)
+	})
+	client := NewClient(
+		WithTransportMiddlewares(
+			DialContextMiddleware(NewLocalDialBreaker(false)),
+		),
+	)
+
+	// A name resolving to a loopback address is local.
+	resp, err := client.Get(context.TODO(), "http://ip6-localhost:"+serverURL.Port())
+	c.Assert(err, jc.ErrorIsNil)
+	_ = resp.Body.Close()
+
+	// Every address of the name must be local.
+	_, err = client.Get(context.TODO(), "http://mixed.example.com:"+serverURL.Port())
+	c.Assert(err, gc.ErrorMatches, `.*access to address "mixed.example.com:\d+" not allowed`)
+}
+
 type LocalDialBreakerSuite struct {
 	testing.IsolationSuite
 }
@@ -217,6 +310,48 @@ func (s *RetrySuite) TestRetryRequiredUsingBackoff(c *gc.C) {
 	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
 }
 
+func (s *RetrySuite) TestRetryRequiredUsingBackoffFunc(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	req, err := http.NewRequest("GET", "http://meshuggah.rocks", nil)
+	c.Assert(err, gc.IsNil)
+
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(req).Return(&http.Response{
+		StatusCode: http.StatusBadGateway,
+	}, nil).Times(4)
+	transport.EXPECT().RoundTrip(req).Return(&http.Response{
+		StatusCode: http.StatusOK,
+	}, nil)
+
+	ch := make(chan time.Time, 4)
+	for i := 0; i < 4; i++ {
+		ch <- time.Now()
+	}
+
+	clock := NewMockClock(ctrl)
+	clock.EXPECT().Now().Return(time.Now()).AnyTimes()
+	gomock.InOrder(
+		clock.EXPECT().After(time.Second).Return(ch),
+		clock.EXPECT().After(2*time.Second).Return(ch),
+		clock.EXPECT().After(4*time.Second).Return(ch),
+		// The delay is capped at the max delay of the policy.
+		clock.EXPECT().After(5*time.Second).Return(ch),
+	)
+
+	middleware := makeRetryMiddleware(transport, RetryPolicy{
+		Attempts:    5,
+		Delay:       time.Second,
+		MaxDelay:    5 * time.Second,
+		BackoffFunc: ExponentialBackoff(time.Second, time.Minute),
+	}, clock, logger(ctrl))
+
+	resp, err := middleware.RoundTrip(req)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+}
+
 func (s *RetrySuite) TestRetryRequiredUsingBackoffFailure(c *gc.C) {
 	ctrl := gomock.NewController(c)
 	defer ctrl.Finish()
@@ -252,6 +387,7 @@ func (s *RetrySuite) TestRetryRequiredUsingBackoffFailure(c *gc.C) {
 
 	_, err = middleware.RoundTrip(req)
 	c.Assert(err, gc.ErrorMatches, `API request retry is not accepting further requests until .*`)
+	c.Assert(err, jc.ErrorIs, ErrRetryAfterExceedsMax)
 }
 
 func (s *RetrySuite) TestRetryRequiredUsingBackoffError(c *gc.C) {
@@ -324,6 +460,57 @@ func (s *RetrySuite) TestRetryRequiredAndExceeded(c *gc.C) {
 
 	_, err = middleware.RoundTrip(req)
 	c.Assert(err, gc.ErrorMatches, `attempt count exceeded: retryable error`)
+	c.Assert(err, jc.ErrorIs, ErrRetryExhausted)
+	c.Check(retry.IsAttemptsExceeded(err), jc.IsTrue)
+	exhaustedErr, ok := errors.AsType[*RetryExhaustedError](err)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(exhaustedErr.Attempts, gc.Equals, retries)
+	c.Check(exhaustedErr.StatusCode, gc.Equals, http.StatusBadGateway)
+}
+
+func (s *RetrySuite) TestRetryPolicyFromContext(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	ctx := ContextWithRetryPolicy(context.Background(), RetryPolicy{
+		Attempts: 1,
+		Delay:    time.Second,
+		MaxDelay: time.Minute,
+	})
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://meshuggah.rocks", nil)
+	c.Assert(err, gc.IsNil)
+
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(req).Return(&http.Response{
+		StatusCode: http.StatusBadGateway,
+	}, nil)
+
+	middleware := makeRetryMiddleware(transport, RetryPolicy{
+		Attempts: 3,
+		Delay:    time.Second,
+		MaxDelay: time.Minute,
+	}, clock.WallClock, logger(ctrl))
+
+	_, err = middleware.RoundTrip(req)
+	c.Assert(err, gc.ErrorMatches, `attempt count exceeded: retryable error`)
+}
+
+func (s *RetrySuite) TestRetryPolicyFromContextInvalid(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	ctx := ContextWithRetryPolicy(context.Background(), RetryPolicy{})
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://meshuggah.rocks", nil)
+	c.Assert(err, gc.IsNil)
+
+	middleware := makeRetryMiddleware(NewMockRoundTripper(ctrl), RetryPolicy{
+		Attempts: 3,
+		Delay:    time.Second,
+		MaxDelay: time.Minute,
+	}, clock.WallClock, logger(ctrl))
+
+	_, err = middleware.RoundTrip(req)
+	c.Assert(err, gc.ErrorMatches, `retry policy from context: expected at least one attempt`)
 }
 
 func (s *RetrySuite) TestRetryRequiredContextKilled(c *gc.C) {
@@ -359,3 +546,196 @@ func logger(ctrl *gomock.Controller) Logger {
 	logger.EXPECT().Errorf(gomock.Any(), gomock.Any()).AnyTimes()
 	return logger
 }
+
+func (s *LocalDialBreakerSuite) TestSetAllowOutgoingAccess(c *gc.C) {
+	breaker := NewLocalDialBreaker(true)
+	c.Assert(breaker.SetAllowOutgoingAccess(false), gc.Equals, true)
+	c.Assert(breaker.AllowOutgoingAccess(), gc.Equals, false)
+	c.Assert(breaker.Allowed("0.1.2.3:1234"), gc.Equals, false)
+	c.Assert(breaker.Allowed("127.0.0.1:1234"), gc.Equals, true)
+}
+
+func (s *LocalDialBreakerSuite) TestPatchOutgoingAccess(c *gc.C) {
+	restore := PatchOutgoingAccess(false)
+	c.Assert(DefaultDialBreaker.AllowOutgoingAccess(), gc.Equals, false)
+
+	client := NewClient()
+	_, err := client.Get(context.TODO(), "http://0.1.2.3:1234")
+	c.Assert(err, gc.ErrorMatches, `.*access to address "0.1.2.3:1234" not allowed`)
+
+	restore()
+	c.Assert(DefaultDialBreaker.AllowOutgoingAccess(), gc.Equals, true)
+}
+
+func (s *LocalDialBreakerSuite) TestWithDialBreaker(c *gc.C) {
+	client := NewClient(WithDialBreaker(NewLocalDialBreaker(false)))
+	_, err := client.Get(context.TODO(), "http://0.1.2.3:1234")
+	c.Assert(err, gc.ErrorMatches, `.*access to address "0.1.2.3:1234" not allowed`)
+	c.Assert(DefaultDialBreaker.AllowOutgoingAccess(), gc.Equals, true)
+}
+
+func (s *LocalDialBreakerSuite) TestAllowedContextUnix(c *gc.C) {
+	breaker := NewLocalDialBreaker(false)
+	c.Assert(breaker.AllowedContext(context.Background(), "unix", "/var/run/juju.socket"), gc.Equals, true)
+	c.Assert(breaker.AllowedContext(context.Background(), "tcp", "0.1.2.3:1234"), gc.Equals, false)
+	c.Assert(breaker.AllowedContext(context.Background(), "tcp", "127.0.0.1:1234"), gc.Equals, true)
+}
+
+type modelKey struct{}
+
+// modelDialBreaker only allows dials for requests made for a given model.
+type modelDialBreaker struct {
+	model    string
+	networks []string
+}
+
+func (b *modelDialBreaker) Allowed(string) bool {
+	return false
+}
+
+func (b *modelDialBreaker) Trip() {}
+
+func (b *modelDialBreaker) AllowedContext(ctx context.Context, network, addr string) bool {
+	b.networks = append(b.networks, network)
+	model, _ := ctx.Value(modelKey{}).(string)
+	return model == b.model
+}
+
+func (s *LocalDialBreakerSuite) TestContextDialBreaker(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+
+	breaker := &modelDialBreaker{model: "deadbeef"}
+	client := NewClient(WithDialBreaker(breaker))
+
+	_, err := client.Get(context.WithValue(context.Background(), modelKey{}, "cafebabe"), server.URL)
+	c.Assert(err, gc.ErrorMatches, `.*access to address ".*" not allowed`)
+
+	resp, err := client.Get(context.WithValue(context.Background(), modelKey{}, "deadbeef"), server.URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.Body.Close(), gc.IsNil)
+	c.Assert(breaker.networks, gc.DeepEquals, []string{"tcp", "tcp"})
+}
+
+func (s *RetrySuite) TestBeforeRetry(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	req, err := http.NewRequest("POST", "http://meshuggah.rocks", strings.NewReader("body"))
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Authorization", "token-1")
+
+	var bodies, tokens []string
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(r *http.Request) (*http.Response, error) {
+		body, err := io.ReadAll(r.Body)
+		c.Check(err, gc.IsNil)
+		bodies = append(bodies, string(body))
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		status := http.StatusServiceUnavailable
+		if len(tokens) == 3 {
+			status = http.StatusOK
+		}
+		return &http.Response{StatusCode: status}, nil
+	}).Times(3)
+
+	now := time.Now()
+	clock := NewMockClock(ctrl)
+	clock.EXPECT().Now().DoAndReturn(func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}).AnyTimes()
+	clock.EXPECT().After(gomock.Any()).DoAndReturn(func(time.Duration) <-chan time.Time {
+		ch := make(chan time.Time, 1)
+		ch <- now
+		return ch
+	}).AnyTimes()
+
+	var attempts []RetryAttempt
+	middleware := makeRetryMiddleware(transport, RetryPolicy{
+		Attempts: 3,
+		Delay:    time.Second,
+		MaxDelay: time.Minute,
+		BeforeRetry: func(r *http.Request, attempt RetryAttempt) error {
+			attempts = append(attempts, attempt)
+			r.Header.Set("Authorization", fmt.Sprintf("token-%d", attempt.Number))
+			return nil
+		},
+	}, clock, logger(ctrl))
+
+	resp, err := middleware.RoundTrip(req)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(bodies, jc.DeepEquals, []string{"body", "body", "body"})
+	c.Assert(tokens, jc.DeepEquals, []string{"token-1", "token-2", "token-3"})
+	c.Assert(attempts, gc.HasLen, 2)
+	c.Assert(attempts[0].Number, gc.Equals, 2)
+	c.Assert(attempts[0].StatusCode, gc.Equals, http.StatusServiceUnavailable)
+	c.Assert(attempts[1].Number, gc.Equals, 3)
+	c.Assert(attempts[1].Elapsed > attempts[0].Elapsed, jc.IsTrue)
+	// The caller's request is left untouched.
+	c.Assert(req.Header.Get("Authorization"), gc.Equals, "token-1")
+}
+
+func (s *RetrySuite) TestBeforeRetryError(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	req, err := http.NewRequest("GET", "http://meshuggah.rocks", nil)
+	c.Assert(err, gc.IsNil)
+
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(req).Return(&http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+	}, nil)
+
+	clock := NewMockClock(ctrl)
+	clock.EXPECT().Now().Return(time.Now()).AnyTimes()
+	clock.EXPECT().After(gomock.Any()).DoAndReturn(func(time.Duration) <-chan time.Time {
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}).AnyTimes()
+
+	middleware := makeRetryMiddleware(transport, RetryPolicy{
+		Attempts: 3,
+		Delay:    time.Second,
+		MaxDelay: time.Minute,
+		BeforeRetry: func(*http.Request, RetryAttempt) error {
+			return errors.New("token expired")
+		},
+	}, clock, logger(ctrl))
+
+	_, err = middleware.RoundTrip(req)
+	c.Assert(err, gc.ErrorMatches, `preparing retry attempt 2: token expired`)
+}
+
+func (s *RetrySuite) TestRetryBodyNotReplayable(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	// Only a few readers have GetBody set by http.NewRequest.
+	req, err := http.NewRequest("POST", "http://meshuggah.rocks", io.MultiReader(strings.NewReader("body")))
+	c.Assert(err, gc.IsNil)
+	c.Assert(req.GetBody, gc.IsNil)
+
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(req).DoAndReturn(func(r *http.Request) (*http.Response, error) {
+		_, _ = io.ReadAll(r.Body)
+		return &http.Response{StatusCode: http.StatusServiceUnavailable}, nil
+	})
+
+	// The clock is never waited on, as the request isn't retried.
+	clock := NewMockClock(ctrl)
+	clock.EXPECT().Now().Return(time.Now()).AnyTimes()
+
+	middleware := makeRetryMiddleware(transport, RetryPolicy{
+		Attempts: 3,
+		Delay:    time.Second,
+		MaxDelay: time.Minute,
+	}, clock, logger(ctrl))
+
+	resp, err := middleware.RoundTrip(req)
+	c.Assert(err, gc.IsNil)
+	c.Check(resp.StatusCode, gc.Equals, http.StatusServiceUnavailable)
+}
@@ -0,0 +1,148 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// MultipartFile is a file uploaded in a multipart form by PostMultipart.
+type MultipartFile struct {
+	// FieldName is the name of the form field of the file.
+	FieldName string
+
+	// FileName is the name of the file, such as "mysql.charm".
+	FileName string
+
+	// ContentType is the content type of the file. If empty,
+	// "application/octet-stream" is used.
+	ContentType string
+
+	// Open returns a reader of the content of the file from its start. It
+	// is called once for each attempt of the request, see BodyFunc.
+	Open BodyFunc
+}
+
+// Validate validates the MultipartFile for any issues.
+func (f MultipartFile) Validate() error {
+	if f.FieldName == "" {
+		return errors.NotValidf("empty field name of file %q", f.FileName)
+	}
+	if f.Open == nil {
+		return errors.NotValidf("nil open func of file %q", f.FileName)
+	}
+	return nil
+}
+
+// PostMultipart issues a POST to the specified URL, with a multipart/form-data
+// body of the fields, sorted by name, followed by the files, in order. The
+// body is streamed as it is sent, so that large files such as charm
+// archives aren't held in memory, and as the files are opened afresh for
+// every attempt, the upload can be retried by the client's RetryPolicy.
+//
+// When err is nil, resp always contains a non-nil resp.Body.
+// Caller should close resp.Body when done reading from it.
+func (c *Client) PostMultipart(ctx context.Context, path string, fields url.Values, files []MultipartFile) (resp *http.Response, err error) {
+	for _, file := range files {
+		if err := file.Validate(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	// The boundary is chosen once, as the content type is shared by all the
+	// attempts.
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	form := multipartForm{
+		boundary: boundary,
+		fields:   fields,
+		files:    files,
+	}
+	return c.sendBody(ctx, "POST", path, form.open, -1, "multipart/form-data; boundary="+boundary)
+}
+
+// multipartForm writes the body of a multipart form.
+type multipartForm struct {
+	boundary string
+	fields   url.Values
+	files    []MultipartFile
+}
+
+// open returns a reader of the body, which is written by a goroutine as it
+// is read. The goroutine ends once the body is written, or the reader is
+// closed.
+func (f multipartForm) open() (io.ReadCloser, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		_ = writer.CloseWithError(f.write(writer))
+	}()
+	return reader, nil
+}
+
+// write writes the body to w.
+func (f multipartForm) write(w io.Writer) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(f.boundary); err != nil {
+		return errors.Trace(err)
+	}
+	names := make([]string, 0, len(f.fields))
+	for name := range f.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range f.fields[name] {
+			if err := mw.WriteField(name, value); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	for _, file := range f.files {
+		if err := writeMultipartFile(mw, file); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return errors.Trace(mw.Close())
+}
+
+// writeMultipartFile writes a part with the content of the file.
+func writeMultipartFile(mw *multipart.Writer, file MultipartFile) error {
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		escapeQuotes(file.FieldName), escapeQuotes(file.FileName)))
+	header.Set("Content-Type", contentType)
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	content, err := file.Open()
+	if err != nil {
+		return errors.Annotatef(err, "opening file %q", file.FileName)
+	}
+	defer content.Close()
+	if _, err := io.Copy(part, content); err != nil {
+		return errors.Annotatef(err, "reading file %q", file.FileName)
+	}
+	return nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// escapeQuotes escapes a quoted parameter of a header, as mime/multipart
+// does.
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
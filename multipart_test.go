@@ -0,0 +1,162 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type multipartSuite struct {
+	testing.IsolationSuite
+
+	server *httptest.Server
+
+	mu       sync.Mutex
+	failures int
+	forms    []string
+}
+
+var _ = gc.Suite(&multipartSuite{})
+
+func (s *multipartSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.failures = 0
+	s.forms = nil
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		form := readForm(c, r)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.forms = append(s.forms, form)
+		if s.failures > 0 {
+			s.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	s.AddCleanup(func(*gc.C) { s.server.Close() })
+}
+
+// readForm returns a summary of the parts of the multipart form of the
+// request, one per line.
+func readForm(c *gc.C, r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	c.Check(err, jc.ErrorIsNil)
+	c.Check(mediaType, gc.Equals, "multipart/form-data")
+	reader, err := r.MultipartReader()
+	if !c.Check(err, jc.ErrorIsNil) {
+		return ""
+	}
+	var lines []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if !c.Check(err, jc.ErrorIsNil) {
+			return ""
+		}
+		content, err := io.ReadAll(part)
+		c.Check(err, jc.ErrorIsNil)
+		line := part.FormName() + "=" + string(content)
+		if part.FileName() != "" {
+			line += " (" + part.FileName() + ", " + part.Header.Get("Content-Type") + ")"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (s *multipartSuite) TestPostMultipart(c *gc.C) {
+	resp, err := NewClient().PostMultipart(context.Background(), s.server.URL, url.Values{
+		"series":   {"jammy", "noble"},
+		"revision": {"42"},
+	}, []MultipartFile{{
+		FieldName: "charm",
+		FileName:  "mysql.charm",
+		Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("charm archive")), nil
+		},
+	}, {
+		FieldName:   "metadata",
+		FileName:    `my "metadata".yaml`,
+		ContentType: "application/yaml",
+		Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("name: mysql")), nil
+		},
+	}})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Check(resp.StatusCode, gc.Equals, http.StatusOK)
+	c.Check(s.forms, jc.DeepEquals, []string{`revision=42
+series=jammy
+series=noble
+charm=charm archive (mysql.charm, application/octet-stream)
+metadata=name: mysql (my "metadata".yaml, application/yaml)`})
+}
+
+func (s *multipartSuite) TestPostMultipartRetried(c *gc.C) {
+	s.failures = 1
+	var opened int
+	client := NewClient(WithRequestRetrier(RetryPolicy{
+		Attempts: 2,
+		Delay:    time.Millisecond,
+		MaxDelay: time.Millisecond,
+	}))
+	resp, err := client.PostMultipart(context.Background(), s.server.URL, nil, []MultipartFile{{
+		FieldName: "charm",
+		FileName:  "mysql.charm",
+		Open: func() (io.ReadCloser, error) {
+			opened++
+			return io.NopCloser(strings.NewReader("charm archive")), nil
+		},
+	}})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Check(resp.StatusCode, gc.Equals, http.StatusOK)
+	c.Check(opened, gc.Equals, 2)
+	c.Check(s.forms, jc.DeepEquals, []string{
+		"charm=charm archive (mysql.charm, application/octet-stream)",
+		"charm=charm archive (mysql.charm, application/octet-stream)",
+	})
+}
+
+func (s *multipartSuite) TestOpenError(c *gc.C) {
+	// The form is cut short, so it isn't read as one.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer server.Close()
+
+	_, err := NewClient().PostMultipart(context.Background(), server.URL, nil, []MultipartFile{{
+		FieldName: "charm",
+		FileName:  "mysql.charm",
+		Open: func() (io.ReadCloser, error) {
+			return nil, errors.New("file removed")
+		},
+	}})
+	c.Check(err, gc.ErrorMatches, `.*opening file "mysql.charm": file removed`)
+}
+
+func (s *multipartSuite) TestValidate(c *gc.C) {
+	open := func() (io.ReadCloser, error) { return nil, nil }
+	c.Check(MultipartFile{FieldName: "charm", Open: open}.Validate(), jc.ErrorIsNil)
+	c.Check(MultipartFile{FileName: "mysql.charm", Open: open}.Validate(), gc.ErrorMatches, `empty field name of file "mysql.charm" not valid`)
+	c.Check(MultipartFile{FieldName: "charm", FileName: "mysql.charm"}.Validate(), gc.ErrorMatches, `nil open func of file "mysql.charm" not valid`)
+
+	_, err := NewClient().PostMultipart(context.Background(), s.server.URL, nil, []MultipartFile{{FieldName: "charm"}})
+	c.Check(err, gc.ErrorMatches, `nil open func of file "" not valid`)
+	c.Check(s.forms, gc.HasLen, 0)
+}
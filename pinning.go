@@ -0,0 +1,130 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// ErrCertificateNotPinned is matched, using errors.Is, by the error
+// returned for a server whose certificate isn't pinned, see
+// WithPinnedCertificates and WithPinnedSPKIHashes.
+const ErrCertificateNotPinned = errors.ConstError("certificate not pinned")
+
+// certificatePins are the certificates, and hashes of public keys, that a
+// server certificate is checked against.
+type certificatePins struct {
+	certs [][]byte
+	spki  [][]byte
+	err   error
+}
+
+func (p *certificatePins) empty() bool {
+	return len(p.certs) == 0 && len(p.spki) == 0
+}
+
+// WithPinnedCertificates accepts servers presenting one of the PEM encoded
+// certificates, instead of verifying their certificate chain, for
+// controllers with self-signed certificates. Unlike skipping verification,
+// a server without the private key of a pinned certificate is rejected.
+// Pins replace chain verification, including host name checks, and can be
+// combined with WithPinnedSPKIHashes.
+func WithPinnedCertificates(certPEM ...string) Option {
+	return func(opt *options) {
+		for _, value := range certPEM {
+			block, _ := pem.Decode([]byte(value))
+			if block == nil || block.Type != "CERTIFICATE" {
+				opt.certificatePins.err = errors.NotValidf("pinned certificate")
+				return
+			}
+			if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+				opt.certificatePins.err = errors.NewNotValid(err, "pinned certificate")
+				return
+			}
+			opt.certificatePins.certs = append(opt.certificatePins.certs, block.Bytes)
+		}
+	}
+}
+
+// WithPinnedSPKIHashes accepts servers whose certificate has one of the
+// public keys, instead of verifying their certificate chain, as
+// WithPinnedCertificates does for whole certificates. Pinning the key
+// rather than the certificate survives the certificate being reissued. A
+// hash is the base64 encoded SHA-256 hash of the DER encoded subject public
+// key info, optionally prefixed with "sha256/", as used by HPKP.
+func WithPinnedSPKIHashes(hashes ...string) Option {
+	return func(opt *options) {
+		for _, value := range hashes {
+			hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, "sha256/"))
+			if err != nil || len(hash) != sha256.Size {
+				opt.certificatePins.err = errors.NotValidf("pinned SPKI hash %q", value)
+				return
+			}
+			opt.certificatePins.spki = append(opt.certificatePins.spki, hash)
+		}
+	}
+}
+
+// SPKIHash returns the hash of the public key of the certificate, in the
+// form accepted by WithPinnedSPKIHashes.
+func SPKIHash(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// pinned returns true if the certificate matches a pin.
+func (p *certificatePins) pinned(cert *x509.Certificate) bool {
+	for _, pin := range p.certs {
+		if bytes.Equal(pin, cert.Raw) {
+			return true
+		}
+	}
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	for _, pin := range p.spki {
+		if bytes.Equal(pin, hash[:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func transportWithPins(defaultTransport *http.Transport, pins *certificatePins) *http.Transport {
+	if pins.empty() {
+		return defaultTransport
+	}
+
+	transport := defaultTransport
+	config := ownTLSConfig(transport)
+	// The pins take the place of chain verification, which would reject a
+	// self-signed certificate.
+	config.InsecureSkipVerify = true
+	verify := config.VerifyConnection
+	// VerifyConnection is used rather than VerifyPeerCertificate, as it is
+	// also called for resumed sessions.
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		// Only the leaf certificate is checked, as the rest of the chain
+		// isn't verified, so could be any certificate.
+		if len(state.PeerCertificates) == 0 || !pins.pinned(state.PeerCertificates[0]) {
+			return errors.Annotatef(ErrCertificateNotPinned, "server %q", state.ServerName)
+		}
+		if verify != nil {
+			return verify(state)
+		}
+		return nil
+	}
+
+	// We're creating a new tls.Config, HTTP/2 requests will not work, force the
+	// client to create a HTTP/2 requests.
+	transport.ForceAttemptHTTP2 = true
+	return transport
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type pinningSuite struct {
+	testing.IsolationSuite
+
+	server *httptest.Server
+}
+
+var _ = gc.Suite(&pinningSuite{})
+
+func (s *pinningSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+}
+
+func (s *pinningSuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+	s.IsolationSuite.TearDownTest(c)
+}
+
+func (s *pinningSuite) get(c *gc.C, options ...Option) error {
+	resp, err := NewClient(options...).Get(context.Background(), s.server.URL)
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+	return err
+}
+
+func (s *pinningSuite) TestPinnedCertificate(c *gc.C) {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.server.Certificate().Raw})
+	err := s.get(c, WithPinnedCertificates(string(certPEM)))
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *pinningSuite) TestPinnedSPKIHash(c *gc.C) {
+	hash := SPKIHash(s.server.Certificate())
+	c.Assert(s.get(c, WithPinnedSPKIHashes(hash)), jc.ErrorIsNil)
+
+	// The prefix is optional.
+	sum := sha256.Sum256(s.server.Certificate().RawSubjectPublicKeyInfo)
+	c.Assert(s.get(c, WithPinnedSPKIHashes(base64.StdEncoding.EncodeToString(sum[:]))), jc.ErrorIsNil)
+}
+
+func (s *pinningSuite) TestNotPinned(c *gc.C) {
+	sum := sha256.Sum256([]byte("another key"))
+
+	err := s.get(c, WithPinnedSPKIHashes(base64.StdEncoding.EncodeToString(sum[:])))
+	c.Assert(err, jc.ErrorIs, ErrCertificateNotPinned)
+	c.Check(ClassifyError(err), gc.Equals, FaultTLS)
+}
+
+func (s *pinningSuite) TestSkipVerifyPerRequest(c *gc.C) {
+	sum := sha256.Sum256([]byte("another key"))
+	client := NewClient(
+		WithPinnedSPKIHashes(base64.StdEncoding.EncodeToString(sum[:])),
+		WithPerRequestSkipVerify(true),
+	)
+
+	_, err := client.Get(context.Background(), s.server.URL)
+	c.Assert(err, jc.ErrorIs, ErrCertificateNotPinned)
+
+	resp, err := client.Get(ContextWithSkipVerify(context.Background()), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+}
+
+func (s *pinningSuite) TestInvalidPins(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	tests := []struct {
+		option Option
+		err    string
+	}{{
+		option: WithPinnedCertificates("not a certificate"),
+		err:    `.*pinned certificate not valid`,
+	}, {
+		option: WithPinnedSPKIHashes("sha256/bm90IGEgaGFzaA=="),
+		err:    `.*pinned SPKI hash "sha256/bm90IGEgaGFzaA==" not valid`,
+	}}
+	for i, test := range tests {
+		c.Logf("test %d", i)
+		err := s.get(c, WithLogger(logger(ctrl)), test.option)
+		c.Assert(err, gc.ErrorMatches, test.err)
+		c.Assert(errors.Is(err, errors.NotValid), jc.IsTrue)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// ConnectionPoolConfig tunes the pool of connections kept by the transport
+// of a client, such as for a controller making thousands of requests to the
+// same hosts. Zero values keep the defaults of http.Transport.
+type ConnectionPoolConfig struct {
+	// MaxIdleConns limits the number of idle connections kept across all
+	// hosts.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost limits the number of idle connections kept for
+	// each host. The default of http.Transport is 2.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits the number of connections to each host,
+	// including those in use. Requests beyond the limit wait for a
+	// connection to become available.
+	MaxConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept before it is
+	// closed.
+	IdleConnTimeout time.Duration
+}
+
+// Validate validates the ConnectionPoolConfig for any issues.
+func (c ConnectionPoolConfig) Validate() error {
+	if c.MaxIdleConns < 0 {
+		return errors.NotValidf("negative max idle connections")
+	}
+	if c.MaxIdleConnsPerHost < 0 {
+		return errors.NotValidf("negative max idle connections per host")
+	}
+	if c.MaxConnsPerHost < 0 {
+		return errors.NotValidf("negative max connections per host")
+	}
+	if c.IdleConnTimeout < 0 {
+		return errors.NotValidf("negative idle connection timeout")
+	}
+	return nil
+}
+
+// apply sets the pool settings of the transport, leaving those with a zero
+// value unchanged.
+func (c ConnectionPoolConfig) apply(transport *http.Transport) {
+	if c.MaxIdleConns > 0 {
+		transport.MaxIdleConns = c.MaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	}
+	if c.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = c.MaxConnsPerHost
+	}
+	if c.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = c.IdleConnTimeout
+	}
+}
+
+// WithConnectionPool tunes the pool of connections of the client. The
+// settings are applied before the transport middlewares run, so that a
+// middleware can still override them. It requires the transport of the
+// client to be an *http.Transport.
+func WithConnectionPool(value ConnectionPoolConfig) Option {
+	return func(opt *options) {
+		opt.connectionPool = value
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type poolSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&poolSuite{})
+
+func (s *poolSuite) TestConnectionPool(c *gc.C) {
+	client := NewClient(WithConnectionPool(ConnectionPoolConfig{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		MaxConnsPerHost:     20,
+		IdleConnTimeout:     time.Minute,
+	}))
+	transport, err := InspectTransport(client)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(transport.MaxIdleConns, gc.Equals, 100)
+	c.Check(transport.MaxIdleConnsPerHost, gc.Equals, 10)
+	c.Check(transport.MaxConnsPerHost, gc.Equals, 20)
+	c.Check(transport.IdleConnTimeout, gc.Equals, time.Minute)
+}
+
+func (s *poolSuite) TestConnectionPoolZeroKeepsDefaults(c *gc.C) {
+	base := &http.Transport{MaxIdleConns: 7, IdleConnTimeout: time.Second}
+	client := NewClient(
+		WithBaseRoundTripper(base),
+		WithConnectionPool(ConnectionPoolConfig{MaxConnsPerHost: 3}),
+	)
+	transport, err := InspectTransport(client)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(transport.MaxIdleConns, gc.Equals, 7)
+	c.Check(transport.IdleConnTimeout, gc.Equals, time.Second)
+	c.Check(transport.MaxConnsPerHost, gc.Equals, 3)
+
+	// The base transport is left untouched.
+	c.Check(base.MaxConnsPerHost, gc.Equals, 0)
+}
+
+func (s *poolSuite) TestConnectionPoolBeforeMiddlewares(c *gc.C) {
+	var seen int
+	client := NewClient(
+		WithTransportMiddlewares(func(transport *http.Transport) *http.Transport {
+			seen = transport.MaxIdleConns
+			transport.MaxIdleConns = 42
+			return transport
+		}),
+		WithConnectionPool(ConnectionPoolConfig{MaxIdleConns: 100}),
+	)
+	c.Check(seen, gc.Equals, 100)
+	transport, err := InspectTransport(client)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(transport.MaxIdleConns, gc.Equals, 42)
+}
+
+func (s *poolSuite) TestValidate(c *gc.C) {
+	c.Check(ConnectionPoolConfig{}.Validate(), jc.ErrorIsNil)
+	c.Check(ConnectionPoolConfig{MaxIdleConns: -1}.Validate(), gc.ErrorMatches, `negative max idle connections not valid`)
+	c.Check(ConnectionPoolConfig{MaxIdleConnsPerHost: -1}.Validate(), gc.ErrorMatches, `negative max idle connections per host not valid`)
+	c.Check(ConnectionPoolConfig{MaxConnsPerHost: -1}.Validate(), gc.ErrorMatches, `negative max connections per host not valid`)
+	c.Check(ConnectionPoolConfig{IdleConnTimeout: -1}.Validate(), gc.ErrorMatches, `negative idle connection timeout not valid`)
+
+	_, err := NewClient(WithConnectionPool(ConnectionPoolConfig{MaxConnsPerHost: -1})).Get(context.Background(), "http://example.com")
+	c.Check(err, gc.ErrorMatches, `.*connection pool: negative max connections per host not valid`)
+}
+
+func (s *poolSuite) TestConnectionPoolRequiresTransport(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	client := NewClient(
+		WithBaseRoundTripper(NewMockRoundTripper(ctrl)),
+		WithConnectionPool(ConnectionPoolConfig{MaxIdleConns: 1}),
+	)
+	_, err := client.Get(context.Background(), "http://example.com")
+	c.Check(err, gc.ErrorMatches, `.*connection pool with a base round tripper that is not an \*http.Transport not valid`)
+}
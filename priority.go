@@ -0,0 +1,243 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"container/heap"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// Priority is the priority class of a request.
+type Priority int
+
+const (
+	// PriorityBulk is for background transfers, such as agent binary and
+	// charm downloads, which can wait behind other requests.
+	PriorityBulk Priority = iota - 1
+	// PriorityNormal is the priority of requests without one.
+	PriorityNormal
+	// PriorityInteractive is for requests a user is waiting on, such as
+	// juju CLI calls.
+	PriorityInteractive
+)
+
+// urgency returns the RFC 9218 urgency of the priority, or -1 to leave the
+// server default.
+func (p Priority) urgency() int {
+	switch {
+	case p > PriorityNormal:
+		return 1
+	case p < PriorityNormal:
+		return 5
+	}
+	return -1
+}
+
+type priorityKey struct{}
+
+// ContextWithPriority returns a context that gives requests made with it the
+// priority. The priority is sent to the server in an RFC 9218 Priority
+// header, which HTTP/2 and HTTP/3 servers use to order streams, and orders
+// the queue of requests waiting on a client concurrency limit, see
+// WithConcurrencyLimit.
+func ContextWithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFromContext returns the priority set on the context with
+// ContextWithPriority, or PriorityNormal if there isn't one.
+func PriorityFromContext(ctx context.Context) Priority {
+	priority, ok := ctx.Value(priorityKey{}).(Priority)
+	if !ok {
+		return PriorityNormal
+	}
+	return priority
+}
+
+// withPriorityHeader sets the Priority header of a request with a priority,
+// unless it already has one. The header is copied first, as the request
+// belongs to the caller.
+func withPriorityHeader(req *http.Request) {
+	urgency := PriorityFromContext(req.Context()).urgency()
+	if urgency < 0 || req.Header.Get("Priority") != "" {
+		return
+	}
+	req.Header = req.Header.Clone()
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	req.Header.Set("Priority", "u="+strconv.Itoa(urgency))
+}
+
+// WithConcurrencyLimit limits the number of requests the client has in
+// flight at once. Requests over the limit wait, in order of priority and
+// then arrival, until a request completes. A request is in flight until
+// its response body is closed or read to the end.
+func WithConcurrencyLimit(value int) Option {
+	return func(opt *options) {
+		opt.concurrencyLimit = value
+	}
+}
+
+// concurrencyLimitTransport limits the number of requests in flight.
+type concurrencyLimitTransport struct {
+	wrappedRoundTripper http.RoundTripper
+	limiter             *priorityLimiter
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t concurrencyLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.acquire(req.Context(), PriorityFromContext(req.Context())); err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, errors.Trace(err)
+	}
+	res, err := t.wrappedRoundTripper.RoundTrip(req)
+	if err != nil {
+		t.limiter.release()
+		return nil, err
+	}
+	res.Body = &releasingBody{
+		ReadCloser: res.Body,
+		release:    t.limiter.release,
+	}
+	return res, nil
+}
+
+// releasingBody releases a limiter slot once the body is closed or fully
+// read.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+// Read implements io.Reader.
+func (b *releasingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.release)
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// priorityLimiter is a counting semaphore that admits waiters in order of
+// priority.
+type priorityLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	inFlight int
+	seq      int64
+	waiters  waiterQueue
+}
+
+func newPriorityLimiter(limit int) *priorityLimiter {
+	return &priorityLimiter{limit: limit}
+}
+
+// acquire waits for a slot, or for the context to be done.
+func (l *priorityLimiter) acquire(ctx context.Context, priority Priority) error {
+	l.mu.Lock()
+	if l.inFlight < l.limit && len(l.waiters) == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	w := &waiter{
+		priority: priority,
+		seq:      l.seq,
+		ready:    make(chan struct{}),
+	}
+	l.seq++
+	heap.Push(&l.waiters, w)
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-w.ready:
+		// The slot was handed over as the context finished, pass it on.
+		l.releaseLocked()
+	default:
+		heap.Remove(&l.waiters, w.index)
+	}
+	return ctx.Err()
+}
+
+// release frees a slot, handing it to the highest priority waiter.
+func (l *priorityLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked()
+}
+
+func (l *priorityLimiter) releaseLocked() {
+	if len(l.waiters) == 0 {
+		l.inFlight--
+		return
+	}
+	w := heap.Pop(&l.waiters).(*waiter)
+	close(w.ready)
+}
+
+type waiter struct {
+	priority Priority
+	seq      int64
+	index    int
+	ready    chan struct{}
+}
+
+// waiterQueue implements heap.Interface, ordering waiters by priority and
+// then by arrival.
+type waiterQueue []*waiter
+
+func (q waiterQueue) Len() int { return len(q) }
+
+func (q waiterQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waiterQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waiterQueue) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waiterQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return w
+}
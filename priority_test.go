@@ -0,0 +1,126 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type prioritySuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&prioritySuite{})
+
+func (s *prioritySuite) TestContext(c *gc.C) {
+	ctx := context.Background()
+	c.Assert(PriorityFromContext(ctx), gc.Equals, PriorityNormal)
+	ctx = ContextWithPriority(ctx, PriorityBulk)
+	c.Assert(PriorityFromContext(ctx), gc.Equals, PriorityBulk)
+}
+
+func (s *prioritySuite) waitForWaiters(c *gc.C, l *priorityLimiter, n int) {
+	timeout := time.After(testing.LongWait)
+	for {
+		l.mu.Lock()
+		waiting := len(l.waiters)
+		l.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		select {
+		case <-time.After(time.Millisecond):
+		case <-timeout:
+			c.Fatalf("expected %d waiters, got %d", n, waiting)
+		}
+	}
+}
+
+func (s *prioritySuite) TestLimiterOrdering(c *gc.C) {
+	limiter := newPriorityLimiter(1)
+	c.Assert(limiter.acquire(context.Background(), PriorityNormal), jc.ErrorIsNil)
+
+	admitted := make(chan string, 4)
+	queue := func(name string, priority Priority) {
+		go func() {
+			c.Check(limiter.acquire(context.Background(), priority), jc.ErrorIsNil)
+			admitted <- name
+		}()
+	}
+	queue("bulk", PriorityBulk)
+	s.waitForWaiters(c, limiter, 1)
+	queue("normal-1", PriorityNormal)
+	s.waitForWaiters(c, limiter, 2)
+	queue("normal-2", PriorityNormal)
+	s.waitForWaiters(c, limiter, 3)
+	queue("interactive", PriorityInteractive)
+	s.waitForWaiters(c, limiter, 4)
+
+	var order []string
+	for i := 0; i < 4; i++ {
+		limiter.release()
+		order = append(order, <-admitted)
+	}
+	c.Assert(order, jc.DeepEquals, []string{"interactive", "normal-1", "normal-2", "bulk"})
+
+	limiter.release()
+	c.Assert(limiter.inFlight, gc.Equals, 0)
+}
+
+func (s *prioritySuite) TestLimiterContextDone(c *gc.C) {
+	limiter := newPriorityLimiter(1)
+	c.Assert(limiter.acquire(context.Background(), PriorityNormal), jc.ErrorIsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- limiter.acquire(ctx, PriorityInteractive)
+	}()
+	s.waitForWaiters(c, limiter, 1)
+	cancel()
+	c.Assert(<-done, gc.Equals, context.Canceled)
+	c.Assert(limiter.waiters, gc.HasLen, 0)
+
+	limiter.release()
+	c.Assert(limiter.inFlight, gc.Equals, 0)
+}
+
+func (s *prioritySuite) TestConcurrencyLimit(c *gc.C) {
+	headers := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Get("Priority")
+		_, _ = io.WriteString(w, "data")
+	}))
+	defer server.Close()
+
+	client := NewClient(WithConcurrencyLimit(1))
+
+	ctx := ContextWithPriority(context.Background(), PriorityBulk)
+	first, err := client.Get(ctx, server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(<-headers, gc.Equals, "u=5")
+
+	// The first response body is still open, so the limit is reached.
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), testing.ShortWait)
+	defer cancel()
+	_, err = client.Get(timeoutCtx, server.URL)
+	c.Assert(err, gc.ErrorMatches, `.*context deadline exceeded`)
+
+	_, err = io.ReadAll(first.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(first.Body.Close(), jc.ErrorIsNil)
+
+	second, err := client.Get(ContextWithPriority(context.Background(), PriorityInteractive), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(second.Body.Close(), jc.ErrorIsNil)
+	c.Assert(<-headers, gc.Equals, "u=1")
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+var (
+	profilesMu sync.RWMutex
+	profiles   = map[string][]Option{}
+)
+
+// RegisterProfile registers a named bundle of options, such as the
+// timeouts, retries, logging and TLS settings of an environment like
+// "dev" or "prod", to be selected with WithProfile, so that the components
+// of an environment get consistent behaviour. It replaces any profile
+// already registered with the name. It is safe to call concurrently with
+// NewClient.
+func RegisterProfile(name string, options ...Option) {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+	profiles[name] = append([]Option(nil), options...)
+}
+
+// WithProfile applies the options of the named profile, registered with
+// RegisterProfile. They are applied in place of WithProfile, so options
+// given after it override those of the profile. If no profile is
+// registered with the name, every request fails with a NotFound error.
+func WithProfile(name string) Option {
+	return func(opt *options) {
+		profilesMu.RLock()
+		profileOptions, ok := profiles[name]
+		profilesMu.RUnlock()
+		if !ok {
+			opt.profileErr = errors.NotFoundf("http client profile %q", name)
+			return
+		}
+		for _, active := range opt.activeProfiles {
+			if active == name {
+				opt.profileErr = errors.NotValidf("recursive http client profile %q", name)
+				return
+			}
+		}
+		opt.activeProfiles = append(opt.activeProfiles, name)
+		for _, option := range profileOptions {
+			option(opt)
+		}
+		opt.activeProfiles = opt.activeProfiles[:len(opt.activeProfiles)-1]
+	}
+}
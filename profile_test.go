@@ -0,0 +1,103 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type profileSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&profileSuite{})
+
+func (s *profileSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.PatchValue(&profiles, map[string][]Option{})
+}
+
+func (s *profileSuite) TestWithProfile(c *gc.C) {
+	RegisterProfile("dev",
+		WithTLSHandshakeTimeout(time.Minute),
+		WithCurlCommandLogging(true),
+		WithSkipHostnameVerification(true),
+	)
+	RegisterProfile("prod",
+		WithTLSHandshakeTimeout(5*time.Second),
+		WithRequestRetrier(RetryPolicy{Attempts: 3, Delay: time.Second}),
+	)
+
+	opts := newOptions()
+	WithProfile("dev")(opts)
+	c.Check(opts.tlsHandshakeTimeout, gc.Equals, time.Minute)
+	c.Check(opts.curlCommandLogging, jc.IsTrue)
+	c.Check(opts.skipHostnameVerification, jc.IsTrue)
+	c.Check(opts.retryPolicy, gc.IsNil)
+
+	opts = newOptions()
+	WithProfile("prod")(opts)
+	c.Check(opts.tlsHandshakeTimeout, gc.Equals, 5*time.Second)
+	c.Check(opts.curlCommandLogging, jc.IsFalse)
+	c.Check(opts.skipHostnameVerification, jc.IsFalse)
+	c.Check(opts.retryPolicy, jc.DeepEquals, &RetryPolicy{Attempts: 3, Delay: time.Second})
+	c.Check(opts.validate(), jc.ErrorIsNil)
+}
+
+func (s *profileSuite) TestWithProfileOverridden(c *gc.C) {
+	RegisterProfile("dev", WithTLSHandshakeTimeout(time.Minute))
+
+	opts := newOptions()
+	for _, option := range []Option{
+		WithTLSHandshakeTimeout(time.Second),
+		WithProfile("dev"),
+		WithCurlCommandLogging(true),
+	} {
+		option(opts)
+	}
+	c.Check(opts.tlsHandshakeTimeout, gc.Equals, time.Minute)
+	c.Check(opts.curlCommandLogging, jc.IsTrue)
+
+	opts = newOptions()
+	WithProfile("dev")(opts)
+	WithTLSHandshakeTimeout(time.Second)(opts)
+	c.Check(opts.tlsHandshakeTimeout, gc.Equals, time.Second)
+}
+
+func (s *profileSuite) TestWithProfileNested(c *gc.C) {
+	RegisterProfile("base", WithCurlCommandLogging(true))
+	RegisterProfile("dev", WithProfile("base"), WithTLSHandshakeTimeout(time.Minute))
+
+	opts := newOptions()
+	WithProfile("dev")(opts)
+	c.Check(opts.curlCommandLogging, jc.IsTrue)
+	c.Check(opts.tlsHandshakeTimeout, gc.Equals, time.Minute)
+	c.Check(opts.validate(), jc.ErrorIsNil)
+}
+
+func (s *profileSuite) TestWithProfileRecursive(c *gc.C) {
+	RegisterProfile("dev", WithProfile("stage"))
+	RegisterProfile("stage", WithProfile("dev"))
+
+	opts := newOptions()
+	WithProfile("dev")(opts)
+	c.Check(opts.validate(), gc.ErrorMatches, `recursive http client profile "dev" not valid`)
+}
+
+func (s *profileSuite) TestWithProfileNotFound(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	client := NewClient(WithLogger(logger(ctrl)), WithProfile("qa"))
+	_, err := client.Get(context.Background(), "http://charmhub.example.com")
+	c.Assert(err, jc.ErrorIs, errors.NotFound)
+	c.Check(err, gc.ErrorMatches, `.*invalid http client configuration: http client profile "qa" not found`)
+}
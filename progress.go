@@ -0,0 +1,146 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+)
+
+// Progress describes the progress of a transfer.
+type Progress struct {
+	// Done is the number of bytes transferred so far.
+	Done int64
+	// Total is the total number of bytes to transfer, or -1 if unknown.
+	Total int64
+	// Rate is the average transfer rate so far, in bytes per second.
+	Rate float64
+	// ETA is the estimated time until the transfer completes, or zero if
+	// it can't be estimated.
+	ETA time.Duration
+}
+
+// ProgressTracker tracks the progress of a download or upload, publishing
+// it as a stream of events decoupled from the reader, so that a slow
+// consumer, such as a progress bar, never holds up the transfer.
+//
+// To track a download, wrap the response body:
+//
+//	tracker := NewProgressTracker(resp.ContentLength, clock.WallClock)
+//	resp.Body = tracker.Wrap(resp.Body)
+//
+// To track an upload, wrap the request body in the same way.
+type ProgressTracker struct {
+	clock  clock.Clock
+	start  time.Time
+	events chan Progress
+
+	mu       sync.Mutex
+	progress Progress
+	closed   bool
+}
+
+// NewProgressTracker creates a ProgressTracker for a transfer of total
+// bytes, or -1 if the size is unknown.
+func NewProgressTracker(total int64, clk clock.Clock) *ProgressTracker {
+	if total < 0 {
+		total = -1
+	}
+	return &ProgressTracker{
+		clock:    clk,
+		start:    clk.Now(),
+		events:   make(chan Progress, 1),
+		progress: Progress{Total: total},
+	}
+}
+
+// Events returns the stream of progress events. Only the latest event is
+// buffered, older events not yet received are discarded. The channel is
+// closed, after a final event, once the wrapped reader is closed.
+func (t *ProgressTracker) Events() <-chan Progress {
+	return t.events
+}
+
+// Progress returns the current progress.
+func (t *ProgressTracker) Progress() Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.progress
+}
+
+// Wrap returns a ReadCloser that reports the bytes read from rc to the
+// tracker.
+func (t *ProgressTracker) Wrap(rc io.ReadCloser) io.ReadCloser {
+	return &progressReader{
+		reader:  rc,
+		tracker: t,
+	}
+}
+
+// add records n more bytes transferred and publishes the progress.
+func (t *ProgressTracker) add(n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return
+	}
+	t.progress.Done += n
+	elapsed := t.clock.Now().Sub(t.start)
+	if elapsed > 0 {
+		t.progress.Rate = float64(t.progress.Done) / elapsed.Seconds()
+	}
+	t.progress.ETA = 0
+	if remaining := t.progress.Total - t.progress.Done; t.progress.Total >= 0 && remaining > 0 && t.progress.Rate > 0 {
+		t.progress.ETA = time.Duration(float64(remaining) / t.progress.Rate * float64(time.Second))
+	}
+	t.publish()
+}
+
+// close publishes the final progress and closes the event stream.
+func (t *ProgressTracker) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return
+	}
+	t.closed = true
+	t.publish()
+	close(t.events)
+}
+
+// publish sends the current progress, replacing any event not yet
+// received. It must be called with the mutex held.
+func (t *ProgressTracker) publish() {
+	select {
+	case <-t.events:
+	default:
+	}
+	t.events <- t.progress
+}
+
+type progressReader struct {
+	reader  io.ReadCloser
+	tracker *ProgressTracker
+}
+
+// Read implements io.Reader.
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.tracker.add(int64(n))
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (r *progressReader) Close() error {
+	err := r.reader.Close()
+	r.tracker.close()
+	return err
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/clock/testclock"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type progressSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&progressSuite{})
+
+func (s *progressSuite) TestProgress(c *gc.C) {
+	clk := testclock.NewClock(time.Now())
+	tracker := NewProgressTracker(100, clk)
+	body := tracker.Wrap(io.NopCloser(strings.NewReader(strings.Repeat("x", 100))))
+
+	clk.Advance(time.Second)
+	_, err := io.ReadFull(body, make([]byte, 25))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(<-tracker.Events(), jc.DeepEquals, Progress{
+		Done:  25,
+		Total: 100,
+		Rate:  25,
+		ETA:   3 * time.Second,
+	})
+
+	// Events not received are replaced by the latest.
+	clk.Advance(2 * time.Second)
+	_, err = io.ReadFull(body, make([]byte, 25))
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = io.ReadFull(body, make([]byte, 25))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(<-tracker.Events(), jc.DeepEquals, Progress{
+		Done:  75,
+		Total: 100,
+		Rate:  25,
+		ETA:   time.Second,
+	})
+
+	_, err = io.ReadAll(body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(body.Close(), jc.ErrorIsNil)
+
+	final, ok := <-tracker.Events()
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(final.Done, gc.Equals, int64(100))
+	c.Assert(final.ETA, gc.Equals, time.Duration(0))
+	_, ok = <-tracker.Events()
+	c.Assert(ok, jc.IsFalse)
+	c.Assert(tracker.Progress(), jc.DeepEquals, final)
+}
+
+func (s *progressSuite) TestUnknownTotal(c *gc.C) {
+	clk := testclock.NewClock(time.Now())
+	tracker := NewProgressTracker(-1, clk)
+	body := tracker.Wrap(io.NopCloser(strings.NewReader("data")))
+
+	clk.Advance(time.Second)
+	_, err := io.ReadAll(body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(tracker.Progress(), jc.DeepEquals, Progress{
+		Done:  4,
+		Total: -1,
+		Rate:  4,
+	})
+}
+
+func (s *progressSuite) TestDownload(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "0123456789")
+	}))
+	defer server.Close()
+
+	resp, err := NewClient().Get(context.TODO(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	tracker := NewProgressTracker(resp.ContentLength, clock.WallClock)
+	resp.Body = tracker.Wrap(resp.Body)
+
+	done := make(chan Progress)
+	go func() {
+		var last Progress
+		for progress := range tracker.Events() {
+			last = progress
+		}
+		done <- last
+	}()
+
+	_, err = io.ReadAll(resp.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	last := <-done
+	c.Assert(last.Done, gc.Equals, int64(10))
+	c.Assert(last.Total, gc.Equals, int64(10))
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// WithProxyFunc sets the function that selects the proxy for each request,
+// in place of the proxy settings of the environment used by
+// ProxyMiddleware. A nil URL, with a nil error, sends the request directly.
+// It applies to the transport built by the client, or to a base round
+// tripper that is an *http.Transport, after any TransportMiddleware.
+func WithProxyFunc(value func(*http.Request) (*url.URL, error)) Option {
+	return func(opt *options) {
+		opt.proxyFunc = value
+	}
+}
+
+// WithProxyURL sends every request through the proxy at the URL, in place
+// of the proxy settings of the environment. A nil URL sends every request
+// directly, disabling proxying.
+func WithProxyURL(value *url.URL) Option {
+	return WithProxyFunc(http.ProxyURL(value))
+}
+
+// WithProxyLogging logs, at trace level with the client Logger, the proxy
+// chosen for each request, or "direct" if there isn't one, to help debug
+// requests sent through the wrong proxy.
+func WithProxyLogging(value bool) Option {
+	return func(opt *options) {
+		opt.proxyLogging = value
+	}
+}
+
+// ProxyFromSettings returns a TransportMiddleware that selects the proxy of
+// each request from the settings returned by the function, such as the
+// proxy settings of a juju model config. The settings are fetched for
+// every request, so changes apply without recreating the client, though
+// pooled connections made through a previous proxy are reused until they
+// are closed, see ProxyWatcher.
+func ProxyFromSettings(settings func() *httpproxy.Config) TransportMiddleware {
+	return func(transport *http.Transport) *http.Transport {
+		transport.Proxy = recordingProxy(proxyFromSettings(settings))
+		return transport
+	}
+}
+
+func proxyFromSettings(settings func() *httpproxy.Config) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		cfg := settings()
+		midLogger.Tracef("proxy config http(%s), https(%s), no-proxy(%s)",
+			cfg.HTTPProxy, cfg.HTTPSProxy, cfg.NoProxy)
+		return cfg.ProxyFunc()(req.URL)
+	}
+}
+
+// recordingProxy wraps the proxy function, recording the proxy selected
+// for a request in its request info, and emitting an EventProxy.
+func recordingProxy(proxyFunc func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		proxy, err := proxyFunc(req)
+		event := Event{
+			Kind: EventProxy,
+			Err:  err,
+		}
+		if proxy != nil {
+			requestInfoFromContext(req.Context()).setProxy(proxy)
+			event.Proxy = proxy.Redacted()
+		}
+		emitEvent(req.Context(), event)
+		return proxy, err
+	}
+}
+
+// loggingProxy wraps the proxy function, logging the proxy selected for
+// each request.
+func loggingProxy(proxyFunc func(*http.Request) (*url.URL, error), snapshot *clientSnapshot) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		proxy, err := proxyFunc(req)
+		logger := requestLogger(req.Context(), snapshot.load().logger)
+		if !logger.IsTraceEnabled() {
+			return proxy, err
+		}
+		switch {
+		case err != nil:
+			logger.Tracef("proxy for %s: %v", req.URL.Redacted(), err)
+		case proxy == nil:
+			logger.Tracef("proxy for %s: direct", req.URL.Redacted())
+		default:
+			logger.Tracef("proxy for %s: %s", req.URL.Redacted(), proxy.Redacted())
+		}
+		return proxy, err
+	}
+}
+
+// noProxy sends every request directly.
+func noProxy(*http.Request) (*url.URL, error) {
+	return nil, nil
+}
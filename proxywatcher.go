@@ -0,0 +1,107 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyWatcherConfig holds the configuration of a ProxyWatcher.
+type ProxyWatcherConfig struct {
+	// Interval is how often the proxy configuration is checked.
+	Interval time.Duration
+
+	// OnChange is called with the previous and the new configuration
+	// whenever a change is detected. It is called from the watcher's
+	// goroutine, so must not block for long. A typical use is closing idle
+	// connections, so that pooled connections made through the old proxy
+	// are not reused.
+	OnChange func(previous, current httpproxy.Config)
+
+	// Source returns the current proxy configuration. If nil, the
+	// configuration is read from the environment, which is where the
+	// per-request proxy lookups of ProxyMiddleware read it from.
+	Source func() *httpproxy.Config
+
+	// Clock is used to schedule the checks. If nil, the wall clock is
+	// used.
+	Clock clock.Clock
+}
+
+// Validate validates the ProxyWatcherConfig for any issues.
+func (c ProxyWatcherConfig) Validate() error {
+	if c.Interval <= 0 {
+		return errors.NotValidf("interval %s", c.Interval)
+	}
+	if c.OnChange == nil {
+		return errors.NotValidf("nil OnChange")
+	}
+	return nil
+}
+
+// ProxyWatcher polls the proxy configuration and notifies of changes, so
+// that dependent subsystems can flush caches or close idle connections.
+// Requests made through ProxyMiddleware always look up the current
+// configuration, the watcher is only needed to react to a change.
+type ProxyWatcher struct {
+	config   ProxyWatcherConfig
+	current  httpproxy.Config
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewProxyWatcher creates and starts a ProxyWatcher. It must be stopped
+// with Stop once no longer needed.
+func NewProxyWatcher(config ProxyWatcherConfig) (*ProxyWatcher, error) {
+	if err := config.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if config.Source == nil {
+		config.Source = httpproxy.FromEnvironment
+	}
+	if config.Clock == nil {
+		config.Clock = clock.WallClock
+	}
+	w := &ProxyWatcher{
+		config:  config,
+		current: *config.Source(),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go w.loop()
+	return w, nil
+}
+
+// Stop stops the watcher, waiting for any OnChange call in progress to
+// complete.
+func (w *ProxyWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+}
+
+func (w *ProxyWatcher) loop() {
+	defer close(w.done)
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.config.Clock.After(w.config.Interval):
+		}
+		current := *w.config.Source()
+		if current == w.current {
+			continue
+		}
+		previous := w.current
+		w.current = current
+		w.config.OnChange(previous, current)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/clock/testclock"
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"golang.org/x/net/http/httpproxy"
+	gc "gopkg.in/check.v1"
+)
+
+type proxyWatcherSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&proxyWatcherSuite{})
+
+func (s *proxyWatcherSuite) TestValidate(c *gc.C) {
+	_, err := NewProxyWatcher(ProxyWatcherConfig{
+		OnChange: func(_, _ httpproxy.Config) {},
+	})
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+
+	_, err = NewProxyWatcher(ProxyWatcherConfig{
+		Interval: time.Second,
+	})
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+}
+
+func (s *proxyWatcherSuite) TestChangeNotified(c *gc.C) {
+	var mu sync.Mutex
+	config := httpproxy.Config{HTTPProxy: "http://squid:3128"}
+	source := func() *httpproxy.Config {
+		mu.Lock()
+		defer mu.Unlock()
+		current := config
+		return &current
+	}
+
+	changes := make(chan [2]httpproxy.Config, 1)
+	clk := testclock.NewClock(time.Now())
+	watcher, err := NewProxyWatcher(ProxyWatcherConfig{
+		Interval: time.Minute,
+		Source:   source,
+		Clock:    clk,
+		OnChange: func(previous, current httpproxy.Config) {
+			changes <- [2]httpproxy.Config{previous, current}
+		},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer watcher.Stop()
+
+	// No change, no notification.
+	c.Assert(clk.WaitAdvance(time.Minute, testing.LongWait, 1), jc.ErrorIsNil)
+
+	mu.Lock()
+	config.HTTPProxy = "http://squid:8080"
+	mu.Unlock()
+	c.Assert(clk.WaitAdvance(time.Minute, testing.LongWait, 1), jc.ErrorIsNil)
+
+	select {
+	case change := <-changes:
+		c.Assert(change[0].HTTPProxy, gc.Equals, "http://squid:3128")
+		c.Assert(change[1].HTTPProxy, gc.Equals, "http://squid:8080")
+	case <-time.After(testing.LongWait):
+		c.Fatalf("change not notified")
+	}
+
+	// Wait for the next check to be scheduled, then ensure that the
+	// unchanged configuration isn't notified again.
+	c.Assert(clk.WaitAdvance(time.Minute, testing.LongWait, 1), jc.ErrorIsNil)
+	c.Assert(clk.WaitAdvance(0, testing.LongWait, 1), jc.ErrorIsNil)
+	select {
+	case change := <-changes:
+		c.Fatalf("unexpected change %v", change)
+	default:
+	}
+}
+
+func (s *proxyWatcherSuite) TestStop(c *gc.C) {
+	watcher, err := NewProxyWatcher(ProxyWatcherConfig{
+		Interval: time.Minute,
+		OnChange: func(_, _ httpproxy.Config) {},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	watcher.Stop()
+	// Stopping again is a no-op.
+	watcher.Stop()
+}
@@ -0,0 +1,396 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// maxRawHeaderBytes limits the size of a header block captured for
+// WithRawHeaders, unless the transport sets a larger limit for response
+// headers.
+const maxRawHeaderBytes = 1 << 20
+
+// HeaderField is a header field as it was sent or received.
+type HeaderField struct {
+	// Name is the name of the field, as it was sent, without
+	// canonicalization.
+	Name string
+	// Value is the value of the field, with any leading and trailing white
+	// space removed, and any obsolete line folding replaced by a space.
+	Value string
+}
+
+// RawHeader is a header as it was sent or received, with its fields in
+// their original order, and repeated fields kept apart, as needed to
+// compute order sensitive signatures.
+type RawHeader []HeaderField
+
+// Values returns the values of the fields with the name, matched case
+// insensitively, in their original order.
+func (h RawHeader) Values(name string) []string {
+	var values []string
+	for _, field := range h {
+		if strings.EqualFold(field.Name, name) {
+			values = append(values, field.Value)
+		}
+	}
+	return values
+}
+
+// Header returns the fields of the header as an http.Header.
+func (h RawHeader) Header() http.Header {
+	header := make(http.Header, len(h))
+	for _, field := range h {
+		header.Add(field.Name, field.Value)
+	}
+	return header
+}
+
+// RawHeaders are the headers of a request, and of its response, as they
+// were written to and read from the connection.
+type RawHeaders struct {
+	// Request is the header of the request, as written by the transport.
+	Request RawHeader
+	// Response is the header of the final response, after any
+	// informational responses.
+	Response RawHeader
+}
+
+// WithRawHeaders captures the headers of requests and responses as they
+// are written and read, so that they can be retrieved with
+// RawHeadersFromContext for requests made with a context returned by
+// ContextWithRawHeaders.
+//
+// The headers can only be captured from HTTP/1.1, so requests are sent
+// over HTTP/1.1, and the client performs TLS handshakes itself, with the
+// TLS configuration of its transport. The headers of HTTPS requests sent
+// through a proxy aren't captured. It requires the transport of the client
+// to be an *http.Transport, and can't be used with per request skip
+// verify.
+func WithRawHeaders(value bool) Option {
+	return func(opt *options) {
+		opt.rawHeaders = value
+	}
+}
+
+type rawHeadersKey struct{}
+
+// ContextWithRawHeaders returns a context that collects the RawHeaders of a
+// request made with it through a Client created with WithRawHeaders, to be
+// retrieved with RawHeadersFromContext.
+func ContextWithRawHeaders(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rawHeadersKey{}, &rawHeaders{})
+}
+
+// RawHeadersFromContext returns the RawHeaders of the request made with the
+// context, which must have been returned by ContextWithRawHeaders. They are
+// complete once the response has been returned. If the request was retried
+// or redirected, they are those of the last attempt. It returns false if
+// the context doesn't collect raw headers, or if they weren't captured.
+func RawHeadersFromContext(ctx context.Context) (RawHeaders, bool) {
+	collector, ok := ctx.Value(rawHeadersKey{}).(*rawHeaders)
+	if !ok {
+		return RawHeaders{}, false
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	return collector.headers, collector.headers.Request != nil || collector.headers.Response != nil
+}
+
+// rawHeaders collects the RawHeaders of a request.
+type rawHeaders struct {
+	mu      sync.Mutex
+	headers RawHeaders
+}
+
+func (c *rawHeaders) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.headers = RawHeaders{}
+}
+
+func (c *rawHeaders) setRequest(header RawHeader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.headers.Request = header
+}
+
+func (c *rawHeaders) setResponse(header RawHeader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.headers.Response = header
+}
+
+// transportWithRawHeaders makes the transport dial connections that capture
+// the headers written and read through them, performing TLS handshakes
+// itself, as the transport would, so that the headers are captured before
+// they are encrypted.
+func transportWithRawHeaders(transport *http.Transport) {
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	maxBytes := int64(maxRawHeaderBytes)
+	if transport.MaxResponseHeaderBytes > maxBytes {
+		maxBytes = transport.MaxResponseHeaderBytes
+	}
+	tlsConfig := transport.TLSClientConfig
+	handshakeTimeout := transport.TLSHandshakeTimeout
+
+	transport.ForceAttemptHTTP2 = false
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return newRawHeaderConn(conn, nil, maxBytes), nil
+	}
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		config := &tls.Config{}
+		if tlsConfig != nil {
+			config = tlsConfig.Clone()
+		}
+		if config.ServerName == "" {
+			if host, _, err := net.SplitHostPort(addr); err == nil {
+				config.ServerName = host
+			}
+		}
+		config.NextProtos = []string{"http/1.1"}
+
+		handshakeCtx := ctx
+		if handshakeTimeout > 0 {
+			var cancel context.CancelFunc
+			handshakeCtx, cancel = context.WithTimeout(ctx, handshakeTimeout)
+			defer cancel()
+		}
+		trace := httptrace.ContextClientTrace(ctx)
+		if trace != nil && trace.TLSHandshakeStart != nil {
+			trace.TLSHandshakeStart()
+		}
+		tlsConn := tls.Client(conn, config)
+		err = tlsConn.HandshakeContext(handshakeCtx)
+		if trace != nil && trace.TLSHandshakeDone != nil {
+			trace.TLSHandshakeDone(tlsConn.ConnectionState(), err)
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		state := tlsConn.ConnectionState()
+		return newRawHeaderConn(tlsConn, &state, maxBytes), nil
+	}
+}
+
+// rawHeaderTransport binds the connection of each request to the collector
+// of its raw headers.
+type rawHeaderTransport struct {
+	wrappedRoundTripper http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t rawHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	collector, _ := req.Context().Value(rawHeadersKey{}).(*rawHeaders)
+	if collector != nil {
+		collector.reset()
+	}
+
+	var (
+		mu   sync.Mutex
+		conn *rawHeaderConn
+	)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			rawConn, ok := info.Conn.(*rawHeaderConn)
+			if !ok {
+				return
+			}
+			// Binding a connection to no collector stops it capturing
+			// headers for the previous request sent on it.
+			rawConn.bind(collector)
+			mu.Lock()
+			conn = rawConn
+			mu.Unlock()
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	res, err := t.wrappedRoundTripper.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+
+	// The transport only records the TLS state of connections it made
+	// the handshake for.
+	mu.Lock()
+	defer mu.Unlock()
+	if res.TLS == nil && conn != nil && conn.tlsState != nil {
+		res.TLS = conn.tlsState
+	}
+	return res, nil
+}
+
+// rawHeaderConn captures the header blocks of the request written, and of
+// the response read, through a connection after it has been bound to a
+// collector.
+type rawHeaderConn struct {
+	net.Conn
+	tlsState *tls.ConnectionState
+	maxBytes int64
+
+	mu        sync.Mutex
+	collector *rawHeaders
+	written   headerBlock
+	read      headerBlock
+}
+
+// newRawHeaderConn returns a connection that captures nothing until it is
+// bound to a collector, such as while a proxy connection is established.
+func newRawHeaderConn(conn net.Conn, tlsState *tls.ConnectionState, maxBytes int64) *rawHeaderConn {
+	c := &rawHeaderConn{
+		Conn:     conn,
+		tlsState: tlsState,
+		maxBytes: maxBytes,
+	}
+	c.bind(nil)
+	return c
+}
+
+// bind sets the collector of the raw headers of the next request sent on
+// the connection.
+func (c *rawHeaderConn) bind(collector *rawHeaders) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.collector = collector
+	c.written = headerBlock{done: collector == nil}
+	c.read = headerBlock{done: collector == nil}
+}
+
+// Write implements io.Writer.
+func (c *rawHeaderConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	if block, ok := c.written.add(p, c.maxBytes); ok {
+		if header, _, err := parseRawHeader(block); err == nil {
+			c.collector.setRequest(header)
+		}
+	}
+	c.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+// Read implements io.Reader.
+func (c *rawHeaderConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data := p[:n]
+	for {
+		block, ok := c.read.add(data, c.maxBytes)
+		if !ok {
+			break
+		}
+		header, informational, err := parseRawHeader(block)
+		if err != nil || !informational {
+			if err == nil {
+				c.collector.setResponse(header)
+			}
+			break
+		}
+		// The final response follows an informational response, possibly
+		// in the same read.
+		data = c.read.rest
+		c.read = headerBlock{}
+	}
+	return n, err
+}
+
+// headerBlock accumulates the bytes of a header block, up to the empty line
+// ending it.
+type headerBlock struct {
+	buf  []byte
+	rest []byte
+	done bool
+}
+
+// add appends the data to the block, returning the block once it is
+// complete. Once the block is complete, or exceeds the limit, further data
+// is ignored.
+func (b *headerBlock) add(data []byte, limit int64) ([]byte, bool) {
+	if b.done || len(data) == 0 {
+		return nil, false
+	}
+	start := len(b.buf)
+	b.buf = append(b.buf, data...)
+	// The end of the block may straddle the previous data.
+	search := start - 3
+	if search < 0 {
+		search = 0
+	}
+	end := bytes.Index(b.buf[search:], []byte("\r\n\r\n"))
+	if end < 0 {
+		if int64(len(b.buf)) > limit {
+			b.done = true
+			b.buf = nil
+		}
+		return nil, false
+	}
+	end += search + 4
+	b.done = true
+	b.rest = b.buf[end:]
+	return b.buf[:end], true
+}
+
+// parseRawHeader parses the header block of a request or response,
+// returning its fields in order, and whether it is an informational
+// response.
+func parseRawHeader(block []byte) (RawHeader, bool, error) {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(block)))
+	startLine, err := reader.ReadLine()
+	if err != nil {
+		return nil, false, errors.Trace(err)
+	}
+	informational := false
+	if strings.HasPrefix(startLine, "HTTP/") {
+		_, status, _ := strings.Cut(startLine, " ")
+		informational = strings.HasPrefix(status, "1") && !strings.HasPrefix(status, "101")
+	} else if !strings.HasSuffix(startLine, " HTTP/1.1") && !strings.HasSuffix(startLine, " HTTP/1.0") {
+		return nil, false, errors.NotValidf("HTTP start line %q", startLine)
+	}
+
+	header := RawHeader{}
+	for {
+		line, err := reader.ReadContinuedLine()
+		if err == io.EOF {
+			return nil, false, errors.NotValidf("unterminated header block")
+		} else if err != nil {
+			return nil, false, errors.Trace(err)
+		}
+		if line == "" {
+			return header, informational, nil
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, false, errors.NotValidf("header field %q", line)
+		}
+		header = append(header, HeaderField{
+			Name:  name,
+			Value: strings.TrimSpace(value),
+		})
+	}
+}
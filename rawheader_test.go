@@ -0,0 +1,229 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type rawHeaderSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&rawHeaderSuite{})
+
+// rawServer returns the URL of a server answering every request on a
+// connection with the raw response.
+func (s *rawHeaderSuite) rawServer(c *gc.C, response string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, jc.ErrorIsNil)
+	s.AddCleanup(func(*gc.C) { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					req, err := http.ReadRequest(reader)
+					if err != nil {
+						return
+					}
+					_, _ = io.Copy(io.Discard, req.Body)
+					if _, err := io.WriteString(conn, response); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return "http://" + listener.Addr().String()
+}
+
+func (s *rawHeaderSuite) TestParseRawHeader(c *gc.C) {
+	header, informational, err := parseRawHeader([]byte("HTTP/1.1 200 OK\r\n" +
+		"x-b: 1\r\n" +
+		"X-A:2\r\n" +
+		"X-B: 3\r\n" +
+		"X-Folded: one\r\n two\r\n" +
+		"\r\n"))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(informational, jc.IsFalse)
+	c.Check(header, jc.DeepEquals, RawHeader{
+		{Name: "x-b", Value: "1"},
+		{Name: "X-A", Value: "2"},
+		{Name: "X-B", Value: "3"},
+		{Name: "X-Folded", Value: "one two"},
+	})
+	c.Check(header.Values("X-B"), jc.DeepEquals, []string{"1", "3"})
+	c.Check(header.Header(), jc.DeepEquals, http.Header{
+		"X-B":      {"1", "3"},
+		"X-A":      {"2"},
+		"X-Folded": {"one two"},
+	})
+
+	_, informational, err = parseRawHeader([]byte("HTTP/1.1 103 Early Hints\r\nLink: </style.css>\r\n\r\n"))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(informational, jc.IsTrue)
+
+	header, _, err = parseRawHeader([]byte("GET /path HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(header, jc.DeepEquals, RawHeader{{Name: "Host", Value: "example.com"}})
+
+	_, _, err = parseRawHeader([]byte("\x16\x03\x01\x02\x00\r\n\r\n"))
+	c.Check(err, gc.ErrorMatches, `HTTP start line .* not valid`)
+}
+
+func (s *rawHeaderSuite) TestHeaderBlock(c *gc.C) {
+	block := headerBlock{}
+	for _, data := range []string{"HTTP/1.1 200 OK\r\nX-A: 1\r", "\n\r", "\nbody"} {
+		if result, ok := block.add([]byte(data), maxRawHeaderBytes); ok {
+			c.Check(string(result), gc.Equals, "HTTP/1.1 200 OK\r\nX-A: 1\r\n\r\n")
+			c.Check(string(block.rest), gc.Equals, "body")
+			return
+		}
+	}
+	c.Fatalf("header block not completed")
+}
+
+func (s *rawHeaderSuite) TestHeaderBlockLimit(c *gc.C) {
+	block := headerBlock{}
+	_, ok := block.add(bytes.Repeat([]byte("X"), 11), 10)
+	c.Check(ok, jc.IsFalse)
+	_, ok = block.add([]byte("\r\n\r\n"), 10)
+	c.Check(ok, jc.IsFalse)
+}
+
+func (s *rawHeaderSuite) TestRawHeaders(c *gc.C) {
+	serverURL := s.rawServer(c, "HTTP/1.1 103 Early Hints\r\n"+
+		"Link: </style.css>\r\n"+
+		"\r\n"+
+		"HTTP/1.1 200 OK\r\n"+
+		"x-signature: b\r\n"+
+		"X-Amz-Date: 20240101T000000Z\r\n"+
+		"X-Signature: a\r\n"+
+		"Content-Length: 2\r\n"+
+		"\r\n"+
+		"ok")
+	client := NewClient(WithRawHeaders(true))
+
+	for i := 0; i < 2; i++ {
+		ctx := ContextWithRawHeaders(context.Background())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, serverURL, nil)
+		c.Assert(err, jc.ErrorIsNil)
+		req.Header.Add("X-Test", "one")
+		req.Header.Add("X-Test", "two")
+		resp, err := client.Do(req)
+		c.Assert(err, jc.ErrorIsNil)
+		body, err := io.ReadAll(resp.Body)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(string(body), gc.Equals, "ok")
+		_ = resp.Body.Close()
+
+		headers, ok := RawHeadersFromContext(ctx)
+		c.Assert(ok, jc.IsTrue)
+		c.Check(headers.Response, jc.DeepEquals, RawHeader{
+			{Name: "x-signature", Value: "b"},
+			{Name: "X-Amz-Date", Value: "20240101T000000Z"},
+			{Name: "X-Signature", Value: "a"},
+			{Name: "Content-Length", Value: "2"},
+		})
+		c.Check(headers.Request.Values("X-Test"), jc.DeepEquals, []string{"one", "two"})
+		c.Check(headers.Request.Values("Host"), gc.HasLen, 1)
+	}
+	c.Check(client.Stats().ConnectionsOpened, gc.Equals, int64(1))
+}
+
+func (s *rawHeaderSuite) TestRawHeadersNotCollected(c *gc.C) {
+	serverURL := s.rawServer(c, "HTTP/1.1 204 No Content\r\n\r\n")
+	client := NewClient(WithRawHeaders(true))
+
+	resp, err := client.Get(context.Background(), serverURL)
+	c.Assert(err, jc.ErrorIsNil)
+	_ = resp.Body.Close()
+
+	_, ok := RawHeadersFromContext(context.Background())
+	c.Check(ok, jc.IsFalse)
+
+	// A context collecting raw headers of a client without raw headers
+	// collects nothing.
+	ctx := ContextWithRawHeaders(context.Background())
+	resp, err = NewClient().Get(ctx, serverURL)
+	c.Assert(err, jc.ErrorIsNil)
+	_ = resp.Body.Close()
+	_, ok = RawHeadersFromContext(ctx)
+	c.Check(ok, jc.IsFalse)
+}
+
+func (s *rawHeaderSuite) TestRawHeadersTLS(c *gc.C) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+	}))
+	defer server.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	})
+
+	client := NewClient(
+		WithCACertificates(string(caPEM)),
+		WithRawHeaders(true),
+	)
+	ctx := ContextWithRawHeaders(context.Background())
+	resp, err := client.Get(ctx, server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	_ = resp.Body.Close()
+
+	c.Check(resp.TLS, gc.NotNil)
+	c.Check(resp.Header.Get("X-Proto"), gc.Equals, "HTTP/1.1")
+	headers, ok := RawHeadersFromContext(ctx)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(headers.Response.Values("X-Proto"), jc.DeepEquals, []string{"HTTP/1.1"})
+}
+
+func (s *rawHeaderSuite) TestRawHeadersTLSVerifies(c *gc.C) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	_, err := NewClient(WithRawHeaders(true)).Get(context.Background(), server.URL)
+	c.Check(err, gc.ErrorMatches, `.*certificate signed by unknown authority`)
+}
+
+func (s *rawHeaderSuite) TestInspectTransport(c *gc.C) {
+	transport, err := InspectTransport(NewClient(WithRawHeaders(true)))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(transport.DialTLSContext, gc.NotNil)
+}
+
+func (s *rawHeaderSuite) TestInvalid(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	_, err := NewClient(
+		WithRawHeaders(true),
+		WithBaseRoundTripper(NewMockRoundTripper(ctrl)),
+	).Get(context.Background(), "http://example.com")
+	c.Check(err, gc.ErrorMatches, `.*raw headers with a base round tripper that is not an \*http.Transport not valid`)
+
+	_, err = NewClient(
+		WithRawHeaders(true),
+		WithPerRequestSkipVerify(true),
+	).Get(context.Background(), "http://example.com")
+	c.Check(err, gc.ErrorMatches, `.*raw headers with per request skip verify not valid`)
+}
@@ -0,0 +1,246 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// privateAddrs are the address classes that a request to a public address
+// must not be redirected to.
+const privateAddrs = LocalLoopback | LocalLinkLocal | LocalUniqueLocal | LocalPrivate
+
+// lookupIPAddr resolves host names, it is a variable so that tests can
+// replace it.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// WithDNSRebindingProtection protects against DNS rebinding attacks, for
+// webhook and relay features that make requests to user supplied URLs.
+// Each host name is resolved once for a request, and its address pinned for
+// any redirects and retries of the request, and a request to a public
+// address that is redirected to a host with a private address, such as a
+// loopback or RFC 1918 address, fails with a Forbidden error. The address
+// of a pooled connection reused for a request is checked too, and the
+// connection is closed if it may not be used, so that the request is sent
+// on a connection dialed to the pinned address, or fails.
+//
+// The protection applies to the transport built by the client, not to a
+// base round tripper, and not to requests sent through a proxy, which
+// resolves the host names itself. The dial breaker is consulted with the
+// pinned address, rather than the host name.
+func WithDNSRebindingProtection(value bool) Option {
+	return func(opt *options) {
+		opt.dnsRebindingProtection = value
+	}
+}
+
+type dnsPinsKey struct{}
+
+// dnsPins holds the addresses resolved for a request.
+type dnsPins struct {
+	mu sync.Mutex
+	// host is the host name of the original request.
+	host string
+	// public is set once it is known whether the original request was
+	// sent to a public address.
+	public *bool
+	addrs  map[string]net.IP
+}
+
+func withDNSPins(req *http.Request) *http.Request {
+	pins := &dnsPins{
+		host:  strings.ToLower(req.URL.Hostname()),
+		addrs: make(map[string]net.IP),
+	}
+	return req.WithContext(context.WithValue(req.Context(), dnsPinsKey{}, pins))
+}
+
+func dnsPinsFromContext(ctx context.Context) *dnsPins {
+	pins, _ := ctx.Value(dnsPinsKey{}).(*dnsPins)
+	return pins
+}
+
+// resolve returns the address to dial for the host, resolving it with the
+// lookup and pinning it the first time, and checks that it may be dialed.
+func (p *dnsPins) resolve(ctx context.Context, host string, lookup lookupFunc) (net.IP, error) {
+	host = strings.ToLower(host)
+
+	p.mu.Lock()
+	ip, pinned := p.addrs[host]
+	p.mu.Unlock()
+	if !pinned {
+		var err error
+		if ip, err = lookupHost(ctx, host, lookup); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	public, err := p.originPublic(ctx, host, ip, lookup)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if public && isPrivateIP(ip) {
+		return nil, errors.Forbiddenf("redirect from a public address to %s (%s) not allowed", host, ip)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pinnedIP, ok := p.addrs[host]; ok {
+		// Another dial for the request pinned the host first.
+		return pinnedIP, nil
+	}
+	p.addrs[host] = ip
+	return ip, nil
+}
+
+// checkConn checks that a connection to the IP address, reused from the
+// pool, may be used for a request to the host, pinning the address if the
+// host wasn't pinned already.
+func (p *dnsPins) checkConn(ctx context.Context, host string, ip net.IP, lookup lookupFunc) error {
+	host = strings.ToLower(host)
+
+	p.mu.Lock()
+	pinnedIP, pinned := p.addrs[host]
+	p.mu.Unlock()
+	if pinned && !pinnedIP.Equal(ip) {
+		return errors.Forbiddenf("connection to %s at %s rather than its pinned address %s", host, ip, pinnedIP)
+	}
+
+	public, err := p.originPublic(ctx, host, ip, lookup)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if public && isPrivateIP(ip) {
+		return errors.Forbiddenf("redirect from a public address to %s (%s) not allowed", host, ip)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.addrs[host]; !ok {
+		p.addrs[host] = ip
+	}
+	return nil
+}
+
+// originPublic returns whether the original request was sent to a public
+// address, resolving its host if it isn't the one being dialed, as the
+// original request may have reused a pooled connection.
+func (p *dnsPins) originPublic(ctx context.Context, host string, ip net.IP, lookup lookupFunc) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.public != nil {
+		return *p.public, nil
+	}
+	if host != p.host {
+		var err error
+		if ip, err = lookupHost(ctx, p.host, lookup); err != nil {
+			return false, errors.Annotatef(err, "resolving original host")
+		}
+	}
+	public := !isPrivateIP(ip)
+	p.public = &public
+	return public, nil
+}
+
+// lookupHost returns the first address of the host, which may be an IP
+// address.
+func lookupHost(ctx context.Context, host string, lookup lookupFunc) (net.IP, error) {
+	if ip := parseIP(host); ip != nil {
+		return ip, nil
+	}
+	addrs, err := lookup(ctx, host)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(addrs) == 0 {
+		return nil, errors.NotFoundf("address for %q", host)
+	}
+	return addrs[0].IP, nil
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsUnspecified() || privateAddrs.contains(net.JoinHostPort(ip.String(), "0"))
+}
+
+// pinningDialContext dials the address pinned for the host of addr, for
+// requests made with DNS rebinding protection, resolving hosts with the
+// lookup.
+func pinningDialContext(dial func(context.Context, string, string) (net.Conn, error), lookup lookupFunc) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		pins := dnsPinsFromContext(ctx)
+		if pins == nil || requestInfoFromContext(ctx).isProxyAddr(addr) {
+			return dial(ctx, network, addr)
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		ip, err := pins.resolve(ctx, host, lookup)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if parseIP(host) != nil {
+			// Dial the literal address, keeping any IPv6 zone.
+			return dial(ctx, network, addr)
+		}
+		return dial(ctx, network, net.JoinHostPort(ip.String(), port))
+	}
+}
+
+// dnsPinTransport checks the address of the pooled connections reused for
+// requests made with DNS rebinding protection, as they aren't dialed by
+// pinningDialContext.
+type dnsPinTransport struct {
+	wrappedRoundTripper http.RoundTripper
+	lookup              lookupFunc
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t dnsPinTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	pins := dnsPinsFromContext(ctx)
+	if pins == nil {
+		return t.wrappedRoundTripper.RoundTrip(req)
+	}
+	var (
+		mu      sync.Mutex
+		connErr error
+	)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if !info.Reused || requestInfoFromContext(ctx).proxied() {
+				return
+			}
+			tcpAddr, ok := info.Conn.RemoteAddr().(*net.TCPAddr)
+			if !ok {
+				return
+			}
+			if err := pins.checkConn(ctx, req.URL.Hostname(), tcpAddr.IP, t.lookup); err != nil {
+				// Closing the connection fails the request before it
+				// is written, or has it retried on a new connection,
+				// which is dialed to the pinned address.
+				_ = info.Conn.Close()
+				mu.Lock()
+				connErr = err
+				mu.Unlock()
+			}
+		},
+	}
+	resp, err := t.wrappedRoundTripper.RoundTrip(req.WithContext(httptrace.WithClientTrace(ctx, trace)))
+	if err != nil {
+		mu.Lock()
+		defer mu.Unlock()
+		if connErr != nil {
+			return nil, connErr
+		}
+	}
+	return resp, err
+}
@@ -0,0 +1,221 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type rebindingSuite struct {
+	testing.IsolationSuite
+
+	lookups  map[string][]string
+	lookedUp []string
+}
+
+var _ = gc.Suite(&rebindingSuite{})
+
+func (s *rebindingSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.lookups = make(map[string][]string)
+	s.lookedUp = nil
+	s.PatchValue(&lookupIPAddr, func(_ context.Context, host string) ([]net.IPAddr, error) {
+		s.lookedUp = append(s.lookedUp, host)
+		addrs := s.lookups[host]
+		if len(addrs) == 0 {
+			return nil, errors.NotFoundf("host %q", host)
+		}
+		// Each lookup returns the next address, as a rebinding DNS server
+		// would.
+		ip := net.ParseIP(addrs[0])
+		if len(addrs) > 1 {
+			s.lookups[host] = addrs[1:]
+		}
+		return []net.IPAddr{{IP: ip}}, nil
+	})
+}
+
+// dial returns a dial func that records the addresses dialed.
+func dial(dialed *[]string) func(context.Context, string, string) (net.Conn, error) {
+	return func(_ context.Context, _, addr string) (net.Conn, error) {
+		*dialed = append(*dialed, addr)
+		client, server := net.Pipe()
+		_ = server.Close()
+		return client, nil
+	}
+}
+
+func (s *rebindingSuite) pinnedContext(c *gc.C, rawURL string) context.Context {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	return withDNSPins(req).Context()
+}
+
+func (s *rebindingSuite) TestPinned(c *gc.C) {
+	s.lookups["example.com"] = []string{"203.0.113.1", "10.0.0.1"}
+
+	var dialed []string
+	dialContext := pinningDialContext(dial(&dialed), lookupIPAddr)
+	ctx := s.pinnedContext(c, "https://example.com")
+
+	for i := 0; i < 2; i++ {
+		conn, err := dialContext(ctx, "tcp", "example.com:443")
+		c.Assert(err, jc.ErrorIsNil)
+		_ = conn.Close()
+	}
+	c.Assert(dialed, jc.DeepEquals, []string{"203.0.113.1:443", "203.0.113.1:443"})
+	c.Assert(s.lookedUp, jc.DeepEquals, []string{"example.com"})
+}
+
+func (s *rebindingSuite) TestRedirectFromPublicToPrivate(c *gc.C) {
+	s.lookups["example.com"] = []string{"203.0.113.1"}
+	s.lookups["internal.example.com"] = []string{"192.168.1.1"}
+	s.lookups["other.example.com"] = []string{"198.51.100.1"}
+
+	var dialed []string
+	dialContext := pinningDialContext(dial(&dialed), lookupIPAddr)
+	ctx := s.pinnedContext(c, "https://example.com")
+
+	_, err := dialContext(ctx, "tcp", "internal.example.com:443")
+	c.Assert(err, jc.Satisfies, errors.IsForbidden)
+	c.Assert(err, gc.ErrorMatches, `redirect from a public address to internal.example.com \(192.168.1.1\) not allowed`)
+
+	_, err = dialContext(ctx, "tcp", "127.0.0.1:443")
+	c.Assert(err, jc.Satisfies, errors.IsForbidden)
+
+	conn, err := dialContext(ctx, "tcp", "other.example.com:443")
+	c.Assert(err, jc.ErrorIsNil)
+	_ = conn.Close()
+	c.Assert(dialed, jc.DeepEquals, []string{"198.51.100.1:443"})
+}
+
+func (s *rebindingSuite) TestRedirectFromPrivateToPrivate(c *gc.C) {
+	s.lookups["internal.example.com"] = []string{"192.168.1.1"}
+
+	var dialed []string
+	dialContext := pinningDialContext(dial(&dialed), lookupIPAddr)
+	ctx := s.pinnedContext(c, "https://10.0.0.1")
+
+	conn, err := dialContext(ctx, "tcp", "internal.example.com:443")
+	c.Assert(err, jc.ErrorIsNil)
+	_ = conn.Close()
+	c.Assert(dialed, jc.DeepEquals, []string{"192.168.1.1:443"})
+}
+
+func (s *rebindingSuite) TestUnpinned(c *gc.C) {
+	var dialed []string
+	dialContext := pinningDialContext(dial(&dialed), lookupIPAddr)
+
+	conn, err := dialContext(context.Background(), "tcp", "example.com:443")
+	c.Assert(err, jc.ErrorIsNil)
+	_ = conn.Close()
+	c.Assert(dialed, jc.DeepEquals, []string{"example.com:443"})
+	c.Assert(s.lookedUp, gc.HasLen, 0)
+}
+
+func (s *rebindingSuite) TestProxyNotPinned(c *gc.C) {
+	var dialed []string
+	dialContext := pinningDialContext(dial(&dialed), lookupIPAddr)
+
+	req, err := http.NewRequest("GET", "https://example.com", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	req, info := withRequestInfo(req)
+	info.setProxy(&url.URL{Scheme: "http", Host: "squid.internal:3128"})
+	ctx := withDNSPins(req).Context()
+
+	conn, err := dialContext(ctx, "tcp", "squid.internal:3128")
+	c.Assert(err, jc.ErrorIsNil)
+	_ = conn.Close()
+	c.Assert(dialed, jc.DeepEquals, []string{"squid.internal:3128"})
+}
+
+func (s *rebindingSuite) TestClient(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Close the connection, so that the redirect is dialed again.
+		w.Header().Set("Connection", "close")
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/final", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	// The second lookup would rebind the host to an unreachable address.
+	s.lookups["rebind.test"] = []string{serverURL.Hostname(), "192.0.2.1"}
+
+	client := NewClient(WithDNSRebindingProtection(true))
+	target := strings.Replace(server.URL, serverURL.Hostname(), "rebind.test", 1)
+	resp, err := client.Get(context.TODO(), target+"/redirect")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Assert(resp.Request.URL.Path, gc.Equals, "/final")
+	c.Assert(s.lookedUp, jc.DeepEquals, []string{"rebind.test"})
+}
+
+func (s *rebindingSuite) TestPooledConnectionChecked(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://internal.test/final", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	s.lookups["public.test"] = []string{"203.0.113.1"}
+	s.lookups["internal.test"] = []string{"192.168.1.1"}
+
+	// Every address is dialed to the server, so that the public host can
+	// be served by it.
+	var dialed []string
+	transport := &http.Transport{
+		DialContext: pinningDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		}, lookupIPAddr),
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: dnsPinTransport{
+		wrappedRoundTripper: transport,
+		lookup:              lookupIPAddr,
+	}}
+
+	// A request without protection leaves a connection to the internal
+	// host in the pool.
+	resp, err := client.Get("http://internal.test/prime")
+	c.Assert(err, jc.ErrorIsNil)
+	_, _ = io.Copy(io.Discard, resp.Body)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+
+	req, err := http.NewRequest("GET", "http://public.test/redirect", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = client.Do(withDNSPins(req))
+	c.Assert(err, jc.Satisfies, errors.IsForbidden)
+	c.Assert(err, gc.ErrorMatches, `.*redirect from a public address to internal.test \(.*\) not allowed`)
+	c.Assert(dialed, jc.DeepEquals, []string{"internal.test:80", "203.0.113.1:80"})
+}
+
+func (s *rebindingSuite) TestCheckConn(c *gc.C) {
+	s.lookups["example.com"] = []string{"203.0.113.1"}
+	ctx := s.pinnedContext(c, "https://example.com")
+	pins := dnsPinsFromContext(ctx)
+
+	c.Assert(pins.checkConn(ctx, "example.com", net.ParseIP("203.0.113.1"), lookupIPAddr), jc.ErrorIsNil)
+	err := pins.checkConn(ctx, "example.com", net.ParseIP("203.0.113.2"), lookupIPAddr)
+	c.Assert(err, jc.Satisfies, errors.IsForbidden)
+	c.Assert(err, gc.ErrorMatches, `connection to example.com at 203.0.113.2 rather than its pinned address 203.0.113.1`)
+	err = pins.checkConn(ctx, "internal.example.com", net.ParseIP("10.0.0.1"), lookupIPAddr)
+	c.Assert(err, jc.Satisfies, errors.IsForbidden)
+	c.Assert(pins.checkConn(ctx, "other.example.com", net.ParseIP("198.51.100.1"), lookupIPAddr), jc.ErrorIsNil)
+}
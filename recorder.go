@@ -0,0 +1,85 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// WithRequestRecorderChain specifies several RequestRecorders, for example
+// for metrics, auditing and debugging, all of which record every outgoing
+// request. Recorders are invoked in the order given.
+func WithRequestRecorderChain(values ...RequestRecorder) Option {
+	return func(opt *options) {
+		opt.requestRecorder = NewRequestRecorderChain(values...)
+	}
+}
+
+// NewRequestRecorderChain returns a RequestRecorder that fans out to each of
+// the given recorders in order. Nil recorders are ignored.
+func NewRequestRecorderChain(recorders ...RequestRecorder) RequestRecorder {
+	var chain requestRecorderChain
+	for _, recorder := range recorders {
+		if recorder != nil {
+			chain = append(chain, recorder)
+		}
+	}
+	switch len(chain) {
+	case 0:
+		return nil
+	case 1:
+		return chain[0]
+	}
+	return chain
+}
+
+type requestRecorderChain []RequestRecorder
+
+// Record implements RequestRecorder.
+func (c requestRecorderChain) Record(method string, url *url.URL, res *http.Response, rtt time.Duration) {
+	for _, recorder := range c {
+		recorder.Record(method, url, res, rtt)
+	}
+}
+
+// RecordError implements RequestRecorder.
+func (c requestRecorderChain) RecordError(method string, url *url.URL, err error) {
+	for _, recorder := range c {
+		recorder.RecordError(method, url, err)
+	}
+}
+
+type requestRecorderKey struct{}
+
+// requestRecorderOverride wraps the recorder set on a context, so that a
+// nil recorder can be told apart from no override.
+type requestRecorderOverride struct {
+	recorder RequestRecorder
+}
+
+// ContextWithRequestRecorder returns a context that overrides the request
+// recorder of the client for requests made with it. A nil recorder
+// suppresses recording, for example of high-frequency health checks that
+// would drown the metrics of every other request. The override applies to
+// the optional recorder extensions, such as BodyRecorder, too, and only to
+// clients created with a request recorder, see WithRequestRecorder.
+func ContextWithRequestRecorder(ctx context.Context, recorder RequestRecorder) context.Context {
+	return context.WithValue(ctx, requestRecorderKey{}, requestRecorderOverride{recorder: recorder})
+}
+
+// requestRecorder returns the recorder set on the context with
+// ContextWithRequestRecorder, or the given recorder if there isn't one. A
+// client without a recorder records nothing, whatever the context.
+func requestRecorder(ctx context.Context, recorder RequestRecorder) RequestRecorder {
+	if recorder == nil {
+		return nil
+	}
+	if override, ok := ctx.Value(requestRecorderKey{}).(requestRecorderOverride); ok {
+		return override.recorder
+	}
+	return recorder
+}
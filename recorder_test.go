@@ -0,0 +1,116 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type recorderSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&recorderSuite{})
+
+func (s *recorderSuite) TestChain(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	metrics := NewMockRequestRecorder(ctrl)
+	audit := NewMockRequestRecorder(ctrl)
+	gomock.InOrder(
+		metrics.EXPECT().Record("GET", gomock.Any(), gomock.Any(), gomock.Any()),
+		audit.EXPECT().Record("GET", gomock.Any(), gomock.Any(), gomock.Any()),
+		metrics.EXPECT().RecordError("GET", gomock.Any(), gomock.Any()),
+		audit.EXPECT().RecordError("GET", gomock.Any(), gomock.Any()),
+	)
+
+	client := NewClient(WithRequestRecorderChain(metrics, nil, audit))
+	resp, err := client.Get(context.TODO(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+
+	_, err = client.Get(context.TODO(), "btc://secret/wallet")
+	c.Assert(err, gc.NotNil)
+}
+
+func (s *recorderSuite) TestChainCollapses(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	recorder := NewMockRequestRecorder(ctrl)
+	c.Assert(NewRequestRecorderChain(), gc.IsNil)
+	c.Assert(NewRequestRecorderChain(nil, recorder), gc.Equals, recorder)
+}
+
+func (s *recorderSuite) TestContextSuppressesRecording(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// The mock fails the test if it is called.
+	recorder := NewMockRequestRecorder(ctrl)
+	client := NewClient(WithRequestRecorder(recorder))
+
+	ctx := ContextWithRequestRecorder(context.TODO(), nil)
+	resp, err := client.Get(ctx, server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+}
+
+func (s *recorderSuite) TestContextReplacesRecorder(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	recorder := NewMockRequestRecorder(ctrl)
+	override := NewMockRequestRecorder(ctrl)
+	override.EXPECT().Record("GET", gomock.Any(), gomock.Any(), gomock.Any())
+	client := NewClient(WithRequestRecorder(recorder))
+
+	ctx := ContextWithRequestRecorder(context.TODO(), override)
+	resp, err := client.Get(ctx, server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+}
+
+func (s *recorderSuite) TestContextSuppressesExtensions(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	recorder := &requestStatsRecorder{}
+	client := NewClient(WithRequestRecorder(recorder))
+
+	ctx := ContextWithRequestRecorder(context.TODO(), nil)
+	resp, err := client.Get(ctx, server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Check(recorder.stats, gc.HasLen, 0)
+}
+
+func (s *recorderSuite) TestContextRecorderRequiresClientRecorder(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	override := &requestStatsRecorder{}
+	ctx := ContextWithRequestRecorder(context.TODO(), override)
+	resp, err := NewClient().Get(ctx, server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Check(override.stats, gc.HasLen, 0)
+}
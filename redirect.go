@@ -0,0 +1,168 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// defaultMaxRedirects is the number of redirects followed by a
+// RedirectPolicy without MaxRedirects, the same as the net/http default.
+const defaultMaxRedirects = 10
+
+// credentialHeaders are removed from requests redirected to another origin.
+var credentialHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+}
+
+// RedirectPolicy is evaluated for each redirect followed by the client. The
+// zero value is a secure default, which:
+//
+//   - follows at most 10 redirects,
+//   - refuses redirects from https to http,
+//   - refuses redirects from a public address to a loopback, link local or
+//     private IP address, or localhost,
+//   - removes credentials from requests redirected to another origin.
+//
+// Redirects to host names with private addresses are only refused with
+// WithDNSRebindingProtection, which checks the resolved addresses.
+type RedirectPolicy struct {
+	// MaxRedirects is the maximum number of redirects followed for a
+	// request. If zero, 10 redirects are followed.
+	MaxRedirects int
+
+	// AllowDowngrade allows redirects from https to http.
+	AllowDowngrade bool
+
+	// AllowInternal allows redirects from a public address to an internal
+	// one.
+	AllowInternal bool
+
+	// KeepCredentials keeps the Authorization, Proxy-Authorization and
+	// Cookie headers of requests redirected to another origin. The
+	// net/http client still removes them for redirects to a different
+	// domain.
+	KeepCredentials bool
+
+	// Hosts, if not empty, are patterns, using path.Match syntax, matching
+	// the host names that requests may be redirected to.
+	Hosts []string
+
+	// Check, if set, is called for each redirect that passes the other
+	// checks, with the same arguments as http.Client.CheckRedirect. An
+	// error returned by it stops the redirect.
+	Check func(req *http.Request, via []*http.Request) error
+}
+
+// Validate validates the RedirectPolicy for any issues.
+func (p RedirectPolicy) Validate() error {
+	if p.MaxRedirects < 0 {
+		return errors.NotValidf("negative maximum redirects")
+	}
+	for _, host := range p.Hosts {
+		if _, err := path.Match(host, ""); err != nil {
+			return errors.NotValidf("redirect host pattern %q", host)
+		}
+	}
+	return nil
+}
+
+// WithRedirectPolicy checks each redirect followed by the client against
+// the policy, so that fetches of user supplied URLs can't be redirected to
+// insecure or internal locations. A CheckRedirect function already set on
+// the http.Client is called after the policy.
+func WithRedirectPolicy(policy RedirectPolicy) Option {
+	return func(opt *options) {
+		opt.redirectPolicy = &policy
+	}
+}
+
+// checkRedirect implements http.Client.CheckRedirect.
+func (p RedirectPolicy) checkRedirect(req *http.Request, via []*http.Request) error {
+	maxRedirects := p.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+	if len(via) >= maxRedirects {
+		return errors.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	prev, first := via[len(via)-1], via[0]
+	if !p.AllowDowngrade && prev.URL.Scheme == "https" && req.URL.Scheme == "http" {
+		return errors.Forbiddenf("redirect from https to http %s not allowed", req.URL.Redacted())
+	}
+	if !p.AllowInternal && isInternalHost(req.URL.Hostname()) && !isInternalHost(first.URL.Hostname()) {
+		return errors.Forbiddenf("redirect from a public address to internal %s not allowed", req.URL.Redacted())
+	}
+	if len(p.Hosts) > 0 && !p.allowedHost(req.URL.Hostname()) {
+		return errors.Forbiddenf("redirect to host %q not allowed", req.URL.Hostname())
+	}
+	if !p.KeepCredentials && !sameOrigin(prev, req) {
+		for _, header := range credentialHeaders {
+			req.Header.Del(header)
+		}
+	}
+	if p.Check != nil {
+		return p.Check(req, via)
+	}
+	return nil
+}
+
+func (p RedirectPolicy) allowedHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range p.Hosts {
+		if matched, _ := path.Match(strings.ToLower(pattern), host); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isInternalHost returns true if the host is localhost or an internal IP
+// address. Other host names are not resolved.
+func isInternalHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && isPrivateIP(ip)
+}
+
+// sameOrigin returns true if the requests have the same scheme, host and
+// port.
+func sameOrigin(a, b *http.Request) bool {
+	return a.URL.Scheme == b.URL.Scheme && strings.EqualFold(canonicalHost(a), canonicalHost(b))
+}
+
+func canonicalHost(req *http.Request) string {
+	port := req.URL.Port()
+	if port == "" {
+		port = "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(req.URL.Hostname(), port)
+}
+
+// chainCheckRedirect returns a CheckRedirect function that checks the
+// policy before calling next, if it isn't nil.
+func chainCheckRedirect(policy RedirectPolicy, next func(*http.Request, []*http.Request) error) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if err := policy.checkRedirect(req, via); err != nil {
+			return err
+		}
+		if next != nil {
+			return next(req, via)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type redirectSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&redirectSuite{})
+
+func (s *redirectSuite) request(c *gc.C, url string) *http.Request {
+	req, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	return req
+}
+
+func (s *redirectSuite) TestDefaultPolicy(c *gc.C) {
+	tests := []struct {
+		from, to string
+		err      string
+	}{{
+		from: "https://example.com/a",
+		to:   "https://example.com/b",
+	}, {
+		from: "http://example.com/a",
+		to:   "https://example.com/b",
+	}, {
+		from: "https://example.com/a",
+		to:   "http://example.com/b",
+		err:  `redirect from https to http http://example.com/b not allowed`,
+	}, {
+		from: "https://example.com/a",
+		to:   "https://169.254.169.254/latest/meta-data",
+		err:  `redirect from a public address to internal https://169.254.169.254/latest/meta-data not allowed`,
+	}, {
+		from: "https://example.com/a",
+		to:   "https://localhost:8080/",
+		err:  `redirect from a public address to internal https://localhost:8080/ not allowed`,
+	}, {
+		from: "https://10.0.0.1/a",
+		to:   "https://10.0.0.2/b",
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s -> %s", i, test.from, test.to)
+		err := RedirectPolicy{}.checkRedirect(s.request(c, test.to), []*http.Request{s.request(c, test.from)})
+		if test.err == "" {
+			c.Check(err, jc.ErrorIsNil)
+			continue
+		}
+		c.Check(err, gc.ErrorMatches, test.err)
+		c.Check(err, jc.Satisfies, errors.IsForbidden)
+	}
+}
+
+func (s *redirectSuite) TestAllowPolicy(c *gc.C) {
+	policy := RedirectPolicy{
+		AllowDowngrade: true,
+		AllowInternal:  true,
+	}
+	via := []*http.Request{s.request(c, "https://example.com/a")}
+	c.Assert(policy.checkRedirect(s.request(c, "http://example.com/b"), via), jc.ErrorIsNil)
+	c.Assert(policy.checkRedirect(s.request(c, "https://127.0.0.1/b"), via), jc.ErrorIsNil)
+}
+
+func (s *redirectSuite) TestHosts(c *gc.C) {
+	policy := RedirectPolicy{Hosts: []string{"*.charmhub.io"}}
+	via := []*http.Request{s.request(c, "https://api.charmhub.io/a")}
+	c.Assert(policy.checkRedirect(s.request(c, "https://storage.CHARMHUB.io/b"), via), jc.ErrorIsNil)
+	err := policy.checkRedirect(s.request(c, "https://example.com/b"), via)
+	c.Assert(err, gc.ErrorMatches, `redirect to host "example.com" not allowed`)
+}
+
+func (s *redirectSuite) TestMaxRedirects(c *gc.C) {
+	policy := RedirectPolicy{MaxRedirects: 2}
+	via := []*http.Request{s.request(c, "https://example.com/a")}
+	c.Assert(policy.checkRedirect(s.request(c, "https://example.com/b"), via), jc.ErrorIsNil)
+	via = append(via, s.request(c, "https://example.com/b"))
+	err := policy.checkRedirect(s.request(c, "https://example.com/c"), via)
+	c.Assert(err, gc.ErrorMatches, `stopped after 2 redirects`)
+}
+
+func (s *redirectSuite) TestCredentialsStripped(c *gc.C) {
+	via := []*http.Request{s.request(c, "https://example.com/a")}
+
+	req := s.request(c, "https://example.com:8443/b")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=secret")
+	c.Assert(RedirectPolicy{}.checkRedirect(req, via), jc.ErrorIsNil)
+	c.Assert(req.Header.Get("Authorization"), gc.Equals, "")
+	c.Assert(req.Header.Get("Cookie"), gc.Equals, "")
+
+	req = s.request(c, "https://example.com:443/b")
+	req.Header.Set("Authorization", "Bearer secret")
+	c.Assert(RedirectPolicy{}.checkRedirect(req, via), jc.ErrorIsNil)
+	c.Assert(req.Header.Get("Authorization"), gc.Equals, "Bearer secret")
+
+	req = s.request(c, "https://example.com:8443/b")
+	req.Header.Set("Authorization", "Bearer secret")
+	c.Assert(RedirectPolicy{KeepCredentials: true}.checkRedirect(req, via), jc.ErrorIsNil)
+	c.Assert(req.Header.Get("Authorization"), gc.Equals, "Bearer secret")
+}
+
+func (s *redirectSuite) TestClient(c *gc.C) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Authorization", r.Header.Get("Authorization"))
+	}))
+	defer target.Close()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusFound)
+	}))
+	defer origin.Close()
+
+	var checked bool
+	client := NewClient(WithRedirectPolicy(RedirectPolicy{
+		Check: func(*http.Request, []*http.Request) error {
+			checked = true
+			return nil
+		},
+	}))
+	req, err := http.NewRequestWithContext(context.TODO(), "GET", origin.URL, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	resp, err := client.Do(req)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Assert(resp.Header.Get("X-Authorization"), gc.Equals, "")
+	c.Assert(checked, jc.IsTrue)
+}
+
+func (s *redirectSuite) TestInvalidPolicy(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	client := NewClient(
+		WithLogger(logger(ctrl)),
+		WithRedirectPolicy(RedirectPolicy{Hosts: []string{"["}}),
+	)
+	_, err := client.Get(context.TODO(), "http://example.com")
+	c.Assert(err, gc.ErrorMatches, `.*redirect host pattern "\[" not valid`)
+}
@@ -0,0 +1,561 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+)
+
+// RemoteFSConfig configures a RemoteFS.
+type RemoteFSConfig struct {
+	// Client sends the requests for the files, so that they are subject to
+	// its policies, such as retries and proxies.
+	Client HTTPClient
+
+	// BaseURL is the URL of the root of the tree. The name of a file is
+	// joined to its path to get the URL of the file.
+	BaseURL string
+
+	// StatTTL, if set, is how long the size, modification time and ETag
+	// of a file, from a HEAD request, are cached.
+	StatTTL time.Duration
+
+	// MaxCacheBytes, if set, is the total size of the contents of the
+	// files read with ReadFile that are cached. The least recently used
+	// contents are evicted first. Cached contents are only used while
+	// the cached stat of their file is unchanged.
+	MaxCacheBytes int64
+
+	// Clock expires the cached stats. It defaults to the wall clock.
+	Clock clock.Clock
+}
+
+// Validate validates the RemoteFSConfig for any issues.
+func (c RemoteFSConfig) Validate() error {
+	if c.Client == nil {
+		return errors.NotValidf("nil Client")
+	}
+	u, err := url.Parse(c.BaseURL)
+	if err != nil || !u.IsAbs() || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return errors.NotValidf("base URL %q", c.BaseURL)
+	}
+	if c.StatTTL < 0 {
+		return errors.NotValidf("negative stat ttl")
+	}
+	if c.MaxCacheBytes < 0 {
+		return errors.NotValidf("negative max cache bytes")
+	}
+	return nil
+}
+
+// RemoteFS is a read-only fs.FS of the files below a base URL, for code
+// that reads an fs.FS, such as charm readers, to read from HTTP mirrors.
+// Opened files implement io.ReaderAt and io.Seeker with Range requests, so
+// parts of large files, such as archives, are read without downloading the
+// whole file. Directories can't be listed, as HTTP has no standard way to
+// do so.
+//
+// The errors returned are *fs.PathError values, and a file that isn't found
+// satisfies errors.Is(err, fs.ErrNotExist).
+type RemoteFS struct {
+	ctx    context.Context
+	client HTTPClient
+	base   *url.URL
+	cache  *remoteFSCache
+}
+
+var (
+	_ fs.StatFS     = (*RemoteFS)(nil)
+	_ fs.ReadFileFS = (*RemoteFS)(nil)
+)
+
+// NewRemoteFS returns a RemoteFS reading the tree below the base URL of the
+// config.
+func NewRemoteFS(config RemoteFSConfig) (*RemoteFS, error) {
+	if err := config.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if config.Clock == nil {
+		config.Clock = clock.WallClock
+	}
+	base, _ := url.Parse(config.BaseURL)
+	return &RemoteFS{
+		ctx:    context.Background(),
+		client: config.Client,
+		base:   base,
+		cache: &remoteFSCache{
+			clock:    config.Clock,
+			statTTL:  config.StatTTL,
+			maxBytes: config.MaxCacheBytes,
+			stats:    make(map[string]remoteStat),
+			contents: make(map[string]*list.Element),
+			lru:      list.New(),
+		},
+	}, nil
+}
+
+// WithContext returns a RemoteFS sending its requests with the context,
+// which shares the cache of the original.
+func (f *RemoteFS) WithContext(ctx context.Context) *RemoteFS {
+	clone := *f
+	clone.ctx = ctx
+	return &clone
+}
+
+// Open implements fs.FS.
+func (f *RemoteFS) Open(name string) (fs.File, error) {
+	info, err := f.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &remoteDir{info: info}, nil
+	}
+	if content, ok := f.cache.content(name, info); ok {
+		return &cachedFile{Reader: bytes.NewReader(content), info: info}, nil
+	}
+	return &remoteFile{fs: f, name: name, info: info}, nil
+}
+
+// Stat implements fs.StatFS.
+func (f *RemoteFS) Stat(name string) (fs.FileInfo, error) {
+	return f.stat("stat", name)
+}
+
+// ReadFile implements fs.ReadFileFS. The contents read are cached, up to
+// the MaxCacheBytes of the config.
+func (f *RemoteFS) ReadFile(name string) ([]byte, error) {
+	info, err := f.stat("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDirectory}
+	}
+	if content, ok := f.cache.content(name, info); ok {
+		return bytes.Clone(content), nil
+	}
+	body, err := f.get("read", name, info, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = body.Close() }()
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	f.cache.setContent(name, info, content)
+	return bytes.Clone(content), nil
+}
+
+// errIsDirectory is returned for attempts to read a directory.
+const errIsDirectory = errors.ConstError("is a directory")
+
+// stat returns the info of the named file, from the cache or a HEAD
+// request.
+func (f *RemoteFS) stat(op, name string) (*remoteFileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &remoteFileInfo{name: ".", dir: true}, nil
+	}
+	if info, ok := f.cache.stat(name); ok {
+		return info, nil
+	}
+	req, err := http.NewRequestWithContext(f.ctx, "HEAD", f.url(name), nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	_ = resp.Body.Close()
+	if err := remoteFSStatusError(req, resp); err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	info := &remoteFileInfo{
+		name: path.Base(name),
+		size: resp.ContentLength,
+		etag: ETag(resp),
+	}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.modTime = modTime
+	}
+	f.cache.setStat(name, info)
+	return info, nil
+}
+
+// get requests length bytes of the named file, from the offset, or the rest
+// of the file if length is negative. It fails if the content of the file
+// changed since it was stat'ed.
+func (f *RemoteFS) get(op, name string, info *remoteFileInfo, offset, length int64) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(f.ctx, "GET", f.url(name), nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	switch {
+	case length >= 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	if req.Header.Get("Range") != "" && info.etag != "" && !strings.HasPrefix(info.etag, "W/") {
+		req.Header.Set("If-Range", info.etag)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		_ = resp.Body.Close()
+		return nil, io.EOF
+	}
+	if err := remoteFSStatusError(req, resp); err != nil {
+		_ = resp.Body.Close()
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if info.etag != "" && ETag(resp) != info.etag {
+		_ = resp.Body.Close()
+		f.cache.invalidate(name)
+		return nil, &fs.PathError{Op: op, Path: name, Err: errors.Errorf("content changed (etag %s)", ETag(resp))}
+	}
+
+	body := resp.Body
+	if resp.StatusCode == http.StatusPartialContent {
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			_ = resp.Body.Close()
+			return nil, &fs.PathError{Op: op, Path: name,
+				Err: errors.Errorf("unexpected content range %q", resp.Header.Get("Content-Range"))}
+		}
+	} else if offset > 0 {
+		// The server ignored the range, so skip to the offset.
+		if _, err := io.CopyN(io.Discard, body, offset); err != nil {
+			_ = resp.Body.Close()
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+	}
+	if length >= 0 {
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(body, length), body}, nil
+	}
+	return body, nil
+}
+
+// url returns the URL of the named file.
+func (f *RemoteFS) url(name string) string {
+	return f.base.JoinPath(name).String()
+}
+
+// remoteFSStatusError returns the error of a response that isn't
+// successful.
+func remoteFSStatusError(req *http.Request, resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return fs.ErrNotExist
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fs.ErrPermission
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return errors.Errorf("%s %s: %s", req.Method, req.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
+
+// remoteFileInfo is the fs.FileInfo of a file of a RemoteFS.
+type remoteFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	etag    string
+	dir     bool
+}
+
+// Name implements fs.FileInfo.
+func (i *remoteFileInfo) Name() string { return i.name }
+
+// Size implements fs.FileInfo. It is -1 if the server didn't report the
+// size of the file.
+func (i *remoteFileInfo) Size() int64 { return i.size }
+
+// Mode implements fs.FileInfo.
+func (i *remoteFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// ModTime implements fs.FileInfo.
+func (i *remoteFileInfo) ModTime() time.Time { return i.modTime }
+
+// IsDir implements fs.FileInfo.
+func (i *remoteFileInfo) IsDir() bool { return i.dir }
+
+// Sys implements fs.FileInfo.
+func (i *remoteFileInfo) Sys() any { return nil }
+
+// remoteFile is an open file of a RemoteFS. Sequential reads share a single
+// request, which is replaced when the file is seeked.
+type remoteFile struct {
+	fs     *RemoteFS
+	name   string
+	info   *remoteFileInfo
+	offset int64
+	body   io.ReadCloser
+	closed bool
+}
+
+var (
+	_ io.ReaderAt = (*remoteFile)(nil)
+	_ io.Seeker   = (*remoteFile)(nil)
+)
+
+// Stat implements fs.File.
+func (f *remoteFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Read implements fs.File.
+func (f *remoteFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.body == nil {
+		if f.info.size >= 0 && f.offset >= f.info.size {
+			return 0, io.EOF
+		}
+		body, err := f.fs.get("read", f.name, f.info, f.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt, with a request for the bytes read.
+func (f *remoteFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	body, err := f.fs.get("read", f.name, f.info, off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = body.Close() }()
+	n, err := io.ReadFull(body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker. Seeking relative to the end of the file fails
+// if the server didn't report its size.
+func (f *remoteFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		if f.info.size < 0 {
+			return 0, &fs.PathError{Op: "seek", Path: f.name, Err: errors.NotSupportedf("seek from end of file of unknown size")}
+		}
+		offset += f.info.size
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset != f.offset && f.body != nil {
+		_ = f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Close implements fs.File.
+func (f *remoteFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+// cachedFile is an open file of a RemoteFS with cached contents.
+type cachedFile struct {
+	*bytes.Reader
+	info *remoteFileInfo
+}
+
+// Stat implements fs.File.
+func (f *cachedFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Close implements fs.File.
+func (f *cachedFile) Close() error {
+	return nil
+}
+
+// remoteDir is an open directory of a RemoteFS, which can't be read.
+type remoteDir struct {
+	info *remoteFileInfo
+}
+
+// Stat implements fs.File.
+func (d *remoteDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+// Read implements fs.File.
+func (d *remoteDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errIsDirectory}
+}
+
+// Close implements fs.File.
+func (d *remoteDir) Close() error {
+	return nil
+}
+
+// remoteFSCache caches the stats and contents of the files of a RemoteFS.
+type remoteFSCache struct {
+	clock    clock.Clock
+	statTTL  time.Duration
+	maxBytes int64
+
+	mu       sync.Mutex
+	stats    map[string]remoteStat
+	contents map[string]*list.Element
+	lru      *list.List
+	bytes    int64
+}
+
+// remoteStat is a cached stat of a file.
+type remoteStat struct {
+	info    *remoteFileInfo
+	expires time.Time
+}
+
+// remoteContent is the cached content of a file, with the stat of the file
+// it was read for.
+type remoteContent struct {
+	name    string
+	info    *remoteFileInfo
+	content []byte
+}
+
+func (c *remoteFSCache) stat(name string) (*remoteFileInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.stats[name]
+	if !ok || !c.clock.Now().Before(entry.expires) {
+		return nil, false
+	}
+	return entry.info, true
+}
+
+func (c *remoteFSCache) setStat(name string, info *remoteFileInfo) {
+	if c.statTTL <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats[name] = remoteStat{
+		info:    info,
+		expires: c.clock.Now().Add(c.statTTL),
+	}
+}
+
+// content returns the cached content of the file, if it was read for the
+// same version of the file as the given stat describes.
+func (c *remoteFSCache) content(name string, info *remoteFileInfo) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.contents[name]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*remoteContent)
+	if entry.info.etag != info.etag || !entry.info.modTime.Equal(info.modTime) || entry.info.size != info.size {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.content, true
+}
+
+func (c *remoteFSCache) setContent(name string, info *remoteFileInfo, content []byte) {
+	size := int64(len(content))
+	if c.maxBytes <= 0 || size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.contents[name]; ok {
+		c.remove(elem)
+	}
+	for c.bytes+size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+	c.contents[name] = c.lru.PushFront(&remoteContent{
+		name:    name,
+		info:    info,
+		content: content,
+	})
+	c.bytes += size
+}
+
+// invalidate removes the cached stat and content of the file.
+func (c *remoteFSCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.stats, name)
+	if elem, ok := c.contents[name]; ok {
+		c.remove(elem)
+	}
+}
+
+func (c *remoteFSCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*remoteContent)
+	delete(c.contents, entry.name)
+	c.bytes -= int64(len(entry.content))
+}
@@ -0,0 +1,290 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/clock/testclock"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type remoteFSSuite struct {
+	testing.IsolationSuite
+
+	server *httptest.Server
+
+	mu       sync.Mutex
+	files    map[string]string
+	noRanges bool
+	requests []string
+}
+
+var _ = gc.Suite(&remoteFSSuite{})
+
+var remoteFSModTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func (s *remoteFSSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.files = map[string]string{
+		"/charms/metadata.yaml": "name: ubuntu\n",
+		"/charms/charm.zip":     "0123456789abcdefghij",
+	}
+	s.noRanges = false
+	s.requests = nil
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, strings.TrimSpace(r.Method+" "+r.URL.Path+" "+r.Header.Get("Range")))
+		content, ok := s.files[r.URL.Path]
+		noRanges := s.noRanges
+		s.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(content))))
+		if noRanges {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "", remoteFSModTime, strings.NewReader(content))
+	}))
+	s.AddCleanup(func(*gc.C) { s.server.Close() })
+}
+
+func (s *remoteFSSuite) newFS(c *gc.C, config RemoteFSConfig) *RemoteFS {
+	config.Client = NewClient()
+	config.BaseURL = s.server.URL + "/charms"
+	remote, err := NewRemoteFS(config)
+	c.Assert(err, jc.ErrorIsNil)
+	return remote
+}
+
+func (s *remoteFSSuite) setFile(path, content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[path] = content
+}
+
+func (s *remoteFSSuite) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+func (s *remoteFSSuite) TestValidate(c *gc.C) {
+	valid := RemoteFSConfig{Client: NewClient(), BaseURL: "https://mirror.example.com/charms"}
+	c.Assert(valid.Validate(), jc.ErrorIsNil)
+	tests := []struct {
+		update func(*RemoteFSConfig)
+		err    string
+	}{{
+		update: func(config *RemoteFSConfig) { config.Client = nil },
+		err:    `nil Client not valid`,
+	}, {
+		update: func(config *RemoteFSConfig) { config.BaseURL = "/charms" },
+		err:    `base URL "/charms" not valid`,
+	}, {
+		update: func(config *RemoteFSConfig) { config.BaseURL = "https://mirror.example.com/charms?q=1" },
+		err:    `base URL "https://mirror.example.com/charms\?q=1" not valid`,
+	}, {
+		update: func(config *RemoteFSConfig) { config.StatTTL = -time.Second },
+		err:    `negative stat ttl not valid`,
+	}, {
+		update: func(config *RemoteFSConfig) { config.MaxCacheBytes = -1 },
+		err:    `negative max cache bytes not valid`,
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.err)
+		config := valid
+		test.update(&config)
+		c.Check(config.Validate(), gc.ErrorMatches, test.err)
+		_, err := NewRemoteFS(config)
+		c.Check(err, gc.ErrorMatches, test.err)
+	}
+}
+
+func (s *remoteFSSuite) TestReadFile(c *gc.C) {
+	remote := s.newFS(c, RemoteFSConfig{})
+	content, err := fs.ReadFile(remote, "metadata.yaml")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(content), gc.Equals, "name: ubuntu\n")
+
+	info, err := fs.Stat(remote, "metadata.yaml")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(info.Name(), gc.Equals, "metadata.yaml")
+	c.Check(info.Size(), gc.Equals, int64(13))
+	c.Check(info.ModTime().Equal(remoteFSModTime), jc.IsTrue)
+	c.Check(info.IsDir(), jc.IsFalse)
+
+	c.Check(s.received(), jc.DeepEquals, []string{
+		"HEAD /charms/metadata.yaml",
+		"GET /charms/metadata.yaml",
+		"HEAD /charms/metadata.yaml",
+	})
+}
+
+func (s *remoteFSSuite) TestErrors(c *gc.C) {
+	remote := s.newFS(c, RemoteFSConfig{})
+	_, err := remote.Open("missing.yaml")
+	c.Assert(err, jc.ErrorIs, fs.ErrNotExist)
+	c.Check(err, gc.ErrorMatches, `open missing.yaml: file does not exist`)
+
+	_, err = remote.Open("../metadata.yaml")
+	c.Check(err, jc.ErrorIs, fs.ErrInvalid)
+
+	dir, err := remote.Open(".")
+	c.Assert(err, jc.ErrorIsNil)
+	info, err := dir.Stat()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(info.IsDir(), jc.IsTrue)
+	_, err = dir.Read(make([]byte, 1))
+	c.Check(err, gc.ErrorMatches, `read .: is a directory`)
+	c.Check(s.received(), jc.DeepEquals, []string{"HEAD /charms/missing.yaml"})
+}
+
+func (s *remoteFSSuite) TestRanges(c *gc.C) {
+	remote := s.newFS(c, RemoteFSConfig{})
+	file, err := remote.Open("charm.zip")
+	c.Assert(err, jc.ErrorIsNil)
+	defer file.Close()
+
+	readerAt, ok := file.(io.ReaderAt)
+	c.Assert(ok, jc.IsTrue)
+	buf := make([]byte, 4)
+	n, err := readerAt.ReadAt(buf, 10)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(buf[:n]), gc.Equals, "abcd")
+
+	// Reading past the end returns the rest, and io.EOF.
+	n, err = readerAt.ReadAt(buf, 18)
+	c.Check(err, gc.Equals, io.EOF)
+	c.Check(string(buf[:n]), gc.Equals, "ij")
+
+	seeker := file.(io.Seeker)
+	offset, err := seeker.Seek(-5, io.SeekEnd)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(offset, gc.Equals, int64(15))
+	rest, err := io.ReadAll(file)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(rest), gc.Equals, "fghij")
+
+	c.Check(s.received(), jc.DeepEquals, []string{
+		"HEAD /charms/charm.zip",
+		"GET /charms/charm.zip bytes=10-13",
+		"GET /charms/charm.zip bytes=18-21",
+		"GET /charms/charm.zip bytes=15-",
+	})
+}
+
+func (s *remoteFSSuite) TestRangesIgnored(c *gc.C) {
+	s.noRanges = true
+	remote := s.newFS(c, RemoteFSConfig{})
+	file, err := remote.Open("charm.zip")
+	c.Assert(err, jc.ErrorIsNil)
+	defer file.Close()
+
+	buf := make([]byte, 4)
+	n, err := file.(io.ReaderAt).ReadAt(buf, 10)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(buf[:n]), gc.Equals, "abcd")
+
+	_, err = file.(io.Seeker).Seek(16, io.SeekStart)
+	c.Assert(err, jc.ErrorIsNil)
+	rest, err := io.ReadAll(file)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(rest), gc.Equals, "ghij")
+}
+
+func (s *remoteFSSuite) TestContentChanged(c *gc.C) {
+	remote := s.newFS(c, RemoteFSConfig{})
+	file, err := remote.Open("charm.zip")
+	c.Assert(err, jc.ErrorIsNil)
+	defer file.Close()
+
+	s.setFile("/charms/charm.zip", "changed")
+	_, err = file.(io.ReaderAt).ReadAt(make([]byte, 4), 2)
+	c.Check(err, gc.ErrorMatches, `read charm.zip: content changed \(etag ".*"\)`)
+}
+
+func (s *remoteFSSuite) TestStatCache(c *gc.C) {
+	clk := testclock.NewClock(time.Now())
+	remote := s.newFS(c, RemoteFSConfig{StatTTL: time.Minute, Clock: clk})
+	for i := 0; i < 2; i++ {
+		_, err := remote.Stat("metadata.yaml")
+		c.Assert(err, jc.ErrorIsNil)
+	}
+	clk.Advance(time.Minute)
+	_, err := remote.Stat("metadata.yaml")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(s.received(), jc.DeepEquals, []string{
+		"HEAD /charms/metadata.yaml",
+		"HEAD /charms/metadata.yaml",
+	})
+}
+
+func (s *remoteFSSuite) TestContentCache(c *gc.C) {
+	remote := s.newFS(c, RemoteFSConfig{MaxCacheBytes: 20})
+	for i := 0; i < 2; i++ {
+		content, err := remote.ReadFile("charm.zip")
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(string(content), gc.Equals, "0123456789abcdefghij")
+	}
+	file, err := remote.Open("charm.zip")
+	c.Assert(err, jc.ErrorIsNil)
+	content, err := io.ReadAll(file)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(content), gc.Equals, "0123456789abcdefghij")
+	c.Assert(file.Close(), jc.ErrorIsNil)
+	c.Check(s.received(), jc.DeepEquals, []string{
+		"HEAD /charms/charm.zip",
+		"GET /charms/charm.zip",
+		"HEAD /charms/charm.zip",
+		"HEAD /charms/charm.zip",
+	})
+
+	// Reading another file evicts the least recently used content.
+	_, err = remote.ReadFile("metadata.yaml")
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = remote.ReadFile("charm.zip")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(s.received(), jc.DeepEquals, []string{
+		"HEAD /charms/metadata.yaml",
+		"GET /charms/metadata.yaml",
+		"HEAD /charms/charm.zip",
+		"GET /charms/charm.zip",
+	})
+
+	// A changed file is read again.
+	s.setFile("/charms/charm.zip", "changed")
+	content, err = remote.ReadFile("charm.zip")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(bytes.Equal(content, []byte("changed")), jc.IsTrue)
+	c.Check(s.received(), jc.DeepEquals, []string{
+		"HEAD /charms/charm.zip",
+		"GET /charms/charm.zip",
+	})
+}
+
+func (s *remoteFSSuite) TestClosedFile(c *gc.C) {
+	remote := s.newFS(c, RemoteFSConfig{})
+	file, err := remote.Open("charm.zip")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(file.Close(), jc.ErrorIsNil)
+	_, err = file.Read(make([]byte, 1))
+	c.Check(err, jc.ErrorIs, fs.ErrClosed)
+	c.Check(file.Close(), jc.ErrorIs, fs.ErrClosed)
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// RequestError wraps an error returned by a request made through a Client,
+// adding details of how the request was attempted, so that callers can
+// report more than a bare dial error, for example "cannot reach
+// api.charmhub.io via proxy squid:3128 after 3 attempts". It is obtained
+// from the returned error with errors.As. The message of the wrapped error
+// is preserved.
+type RequestError struct {
+	// Method is the method of the request.
+	Method string
+	// URL is the URL of the request.
+	URL string
+	// Host is the host the request was sent to.
+	Host string
+	// Attempts is the number of times the request was attempted, including
+	// any retries.
+	Attempts int
+	// Elapsed is the total time spent on the request, across all attempts.
+	Elapsed time.Duration
+	// Proxy is the URL of the proxy the request was sent through, or empty
+	// if the request was made directly.
+	Proxy string
+	// Err is the underlying error.
+	Err error
+}
+
+// Error implements error.
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns the cause of the underlying error, so that callers using
+// errors.Cause, such as to check for a *url.Error, still see the error
+// returned by the http.Client.
+func (e *RequestError) Cause() error {
+	return errors.Cause(e.Err)
+}
+
+type requestInfoKey struct{}
+
+// requestInfo gathers details of a request as it passes through the
+// transport, for use in a RequestError.
+type requestInfo struct {
+	mu       sync.Mutex
+	attempts int
+	proxy    *url.URL
+}
+
+func withRequestInfo(req *http.Request) (*http.Request, *requestInfo) {
+	info := &requestInfo{}
+	return req.WithContext(context.WithValue(req.Context(), requestInfoKey{}, info)), info
+}
+
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// setAttempt records the number of the attempt being made.
+func (i *requestInfo) setAttempt(attempt int) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	i.attempts = attempt
+	i.mu.Unlock()
+}
+
+// setProxy records the proxy used for the request.
+func (i *requestInfo) setProxy(proxy *url.URL) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	i.proxy = proxy
+	i.mu.Unlock()
+}
+
+// proxied returns true if a proxy was used for the request.
+func (i *requestInfo) proxied() bool {
+	if i == nil {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.proxy != nil
+}
+
+// wrap wraps the error with the details of the request.
+func (i *requestInfo) wrap(req *http.Request, elapsed time.Duration, err error) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	reqErr := &RequestError{
+		Method:   req.Method,
+		URL:      req.URL.String(),
+		Host:     req.URL.Host,
+		Attempts: i.attempts,
+		Elapsed:  elapsed,
+		Err:      err,
+	}
+	if reqErr.Attempts == 0 {
+		reqErr.Attempts = 1
+	}
+	if i.proxy != nil {
+		reqErr.Proxy = i.proxy.Redacted()
+	}
+	return reqErr
+}
+
+// isProxyAddr returns true if addr is the address of the proxy used for the
+// request.
+func (i *requestInfo) isProxyAddr(addr string) bool {
+	if i == nil {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.proxy == nil {
+		return false
+	}
+	port := i.proxy.Port()
+	if port == "" {
+		switch i.proxy.Scheme {
+		case "https":
+			port = "443"
+		case "socks5", "socks5h":
+			port = "1080"
+		default:
+			port = "80"
+		}
+	}
+	return strings.EqualFold(net.JoinHostPort(i.proxy.Hostname(), port), addr)
+}
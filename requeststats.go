@@ -0,0 +1,223 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+)
+
+// RequestStats is the timing breakdown of a single request. If the request
+// was retried or redirected, the phases are those of the last attempt,
+// while the total covers all of them.
+type RequestStats struct {
+	// DNS is the time taken to resolve the host.
+	DNS time.Duration
+	// Connect is the time taken to establish the connection.
+	Connect time.Duration
+	// TLSHandshake is the time taken by the TLS handshake.
+	TLSHandshake time.Duration
+	// TimeToFirstByte is the time from the start of the request until the
+	// first byte of the response was received.
+	TimeToFirstByte time.Duration
+	// Total is the time from the start of the request until its response
+	// body was closed, or until it failed.
+	Total time.Duration
+	// BytesReceived is the number of bytes read from the response body.
+	BytesReceived int64
+	// ReusedConnection is true if the request was sent on a connection
+	// that had been used before, so there was no DNS, connect or TLS
+	// handshake time.
+	ReusedConnection bool
+}
+
+// RequestStatsRecorder is an optional extension of RequestRecorder. If the
+// client's RequestRecorder implements it, the RequestStats of every request
+// are recorded once its response body is closed, or it fails.
+type RequestStatsRecorder interface {
+	// RecordStats records the timing breakdown of a request.
+	RecordStats(method string, url *url.URL, stats RequestStats)
+}
+
+type requestStatsKey struct{}
+
+// ContextWithRequestStats returns a context that collects the RequestStats
+// of a request made with it through a Client, to be retrieved with
+// StatsFromContext.
+func ContextWithRequestStats(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestStatsKey{}, &requestStats{})
+}
+
+// StatsFromContext returns the RequestStats of the request made with the
+// context, which must have been returned by ContextWithRequestStats. They
+// are complete once the response body has been closed, or the request has
+// failed. It returns false if the context doesn't collect stats.
+func StatsFromContext(ctx context.Context) (RequestStats, bool) {
+	collector, ok := ctx.Value(requestStatsKey{}).(*requestStats)
+	if !ok {
+		return RequestStats{}, false
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	return collector.stats, true
+}
+
+// requestStats collects the RequestStats of a request.
+type requestStats struct {
+	mu    sync.Mutex
+	stats RequestStats
+	done  bool
+
+	clock     clock.Clock
+	start     time.Time
+	dnsStart  time.Time
+	dialStart time.Time
+	tlsStart  time.Time
+	recorder  RequestStatsRecorder
+	method    string
+	url       *url.URL
+	logger    Logger
+	hooks     hookRunner
+}
+
+// withRequestStats returns the request with a client trace collecting its
+// stats, if the context of the request collects them, or the recorder
+// records them. Otherwise it returns the request unchanged, and a nil
+// collector.
+func withRequestStats(req *http.Request, clk clock.Clock, state clientState, hooks hookRunner) (*http.Request, *requestStats) {
+	collector, _ := req.Context().Value(requestStatsKey{}).(*requestStats)
+	recorder, _ := requestRecorder(req.Context(), state.recorder).(RequestStatsRecorder)
+	if collector == nil && recorder == nil {
+		return req, nil
+	}
+	if collector == nil {
+		collector = &requestStats{}
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	collector.stats = RequestStats{}
+	collector.done = false
+	collector.clock = clk
+	collector.start = clk.Now()
+	collector.recorder = recorder
+	collector.method = req.Method
+	collector.url = req.URL
+	collector.logger = requestLogger(req.Context(), state.logger)
+	collector.hooks = hooks
+
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) {
+			// Only the phases of the last attempt are reported.
+			collector.update(func(stats *RequestStats) {
+				stats.DNS, stats.Connect, stats.TLSHandshake = 0, 0, 0
+			})
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			collector.mark(&collector.dnsStart)
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			collector.since(&collector.dnsStart, &collector.stats.DNS)
+		},
+		ConnectStart: func(string, string) {
+			collector.mark(&collector.dialStart)
+		},
+		ConnectDone: func(string, string, error) {
+			collector.since(&collector.dialStart, &collector.stats.Connect)
+		},
+		TLSHandshakeStart: func() {
+			collector.mark(&collector.tlsStart)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			collector.since(&collector.tlsStart, &collector.stats.TLSHandshake)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			collector.update(func(stats *RequestStats) {
+				stats.ReusedConnection = info.Reused
+			})
+		},
+		GotFirstResponseByte: func() {
+			collector.since(&collector.start, &collector.stats.TimeToFirstByte)
+		},
+	}
+	ctx := context.WithValue(req.Context(), requestStatsKey{}, collector)
+	return req.WithContext(httptrace.WithClientTrace(ctx, trace)), collector
+}
+
+func (s *requestStats) update(fn func(*RequestStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.stats)
+}
+
+// mark sets the start time of a phase.
+func (s *requestStats) mark(start *time.Time) {
+	now := s.clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*start = now
+}
+
+// since sets the duration of a phase, from its start time.
+func (s *requestStats) since(start *time.Time, duration *time.Duration) {
+	now := s.clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*duration = now.Sub(*start)
+}
+
+// finish completes the stats, recording them if there is a recorder.
+func (s *requestStats) finish() {
+	now := s.clock.Now()
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return
+	}
+	s.done = true
+	s.stats.Total = now.Sub(s.start)
+	stats := s.stats
+	s.mu.Unlock()
+
+	if s.recorder == nil {
+		return
+	}
+	s.hooks.run(s.logger, "request stats recorder", func() {
+		s.recorder.RecordStats(s.method, s.url, stats)
+	})
+}
+
+// body returns the response body, counting the bytes read from it, and
+// finishing the stats when it is closed.
+func (s *requestStats) body(body io.ReadCloser) io.ReadCloser {
+	return &requestStatsBody{body: body, stats: s}
+}
+
+type requestStatsBody struct {
+	body  io.ReadCloser
+	stats *requestStats
+}
+
+// Read implements io.Reader.
+func (b *requestStatsBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.stats.update(func(stats *RequestStats) {
+		stats.BytesReceived += int64(n)
+	})
+	return n, err
+}
+
+// Close implements io.Closer.
+func (b *requestStatsBody) Close() error {
+	err := b.body.Close()
+	b.stats.finish()
+	return err
+}
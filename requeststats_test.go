@@ -0,0 +1,127 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type requestStatsSuite struct {
+	testing.IsolationSuite
+
+	server *httptest.Server
+}
+
+var _ = gc.Suite(&requestStatsSuite{})
+
+func (s *requestStatsSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "juju")
+	}))
+}
+
+func (s *requestStatsSuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+	s.IsolationSuite.TearDownTest(c)
+}
+
+func (s *requestStatsSuite) get(c *gc.C, client *Client, ctx context.Context) {
+	resp, err := client.Get(ctx, s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = io.Copy(io.Discard, resp.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+}
+
+func (s *requestStatsSuite) TestStatsFromContext(c *gc.C) {
+	client := NewClient(WithSkipHostnameVerification(true))
+
+	ctx := ContextWithRequestStats(context.Background())
+	s.get(c, client, ctx)
+	stats, ok := StatsFromContext(ctx)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(stats.ReusedConnection, jc.IsFalse)
+	c.Check(stats.Connect > 0, jc.IsTrue)
+	c.Check(stats.TLSHandshake > 0, jc.IsTrue)
+	c.Check(stats.TimeToFirstByte >= stats.TLSHandshake, jc.IsTrue)
+	c.Check(stats.Total >= stats.TimeToFirstByte, jc.IsTrue)
+	c.Check(stats.BytesReceived, gc.Equals, int64(4))
+
+	// The connection is reused by the next request.
+	ctx = ContextWithRequestStats(context.Background())
+	s.get(c, client, ctx)
+	stats, ok = StatsFromContext(ctx)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(stats.ReusedConnection, jc.IsTrue)
+	c.Check(stats.Connect, gc.Equals, time.Duration(0))
+	c.Check(stats.TLSHandshake, gc.Equals, time.Duration(0))
+	c.Check(stats.TimeToFirstByte > 0, jc.IsTrue)
+}
+
+func (s *requestStatsSuite) TestStatsFromContextWithoutStats(c *gc.C) {
+	_, ok := StatsFromContext(context.Background())
+	c.Check(ok, jc.IsFalse)
+}
+
+type requestStatsRecorder struct {
+	stats []RequestStats
+}
+
+func (r *requestStatsRecorder) Record(string, *url.URL, *http.Response, time.Duration) {}
+
+func (r *requestStatsRecorder) RecordError(string, *url.URL, error) {}
+
+func (r *requestStatsRecorder) RecordStats(_ string, _ *url.URL, stats RequestStats) {
+	r.stats = append(r.stats, stats)
+}
+
+func (s *requestStatsSuite) TestRecordStats(c *gc.C) {
+	recorder := &requestStatsRecorder{}
+	client := NewClient(
+		WithSkipHostnameVerification(true),
+		WithRequestRecorder(recorder),
+	)
+
+	resp, err := client.Get(context.Background(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = io.Copy(io.Discard, resp.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	// The stats are recorded once the body is closed.
+	c.Check(recorder.stats, gc.HasLen, 0)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Assert(recorder.stats, gc.HasLen, 1)
+	c.Check(recorder.stats[0].BytesReceived, gc.Equals, int64(4))
+	c.Check(recorder.stats[0].TLSHandshake > 0, jc.IsTrue)
+
+	// Closing again doesn't record again.
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Check(recorder.stats, gc.HasLen, 1)
+}
+
+func (s *requestStatsSuite) TestRecordStatsError(c *gc.C) {
+	recorder := &requestStatsRecorder{}
+	client := NewClient(WithRequestRecorder(recorder))
+
+	ctx := ContextWithRequestStats(context.Background())
+	// The certificate of the server isn't trusted.
+	_, err := client.Get(ctx, s.server.URL)
+	c.Assert(err, gc.NotNil)
+	c.Assert(recorder.stats, gc.HasLen, 1)
+	c.Check(recorder.stats[0].Total > 0, jc.IsTrue)
+	c.Check(recorder.stats[0].BytesReceived, gc.Equals, int64(0))
+
+	stats, ok := StatsFromContext(ctx)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(stats, jc.DeepEquals, recorder.stats[0])
+}
@@ -0,0 +1,220 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+)
+
+// lookupFunc resolves a host name to its addresses.
+type lookupFunc func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+// defaultLookup resolves host names with lookupIPAddr, looking it up on
+// every call so that tests can replace it.
+func defaultLookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return lookupIPAddr(ctx, host)
+}
+
+// WithResolver resolves the host names of requests with the resolver,
+// rather than the default resolver of the system, such as to use the DNS
+// servers of an air-gapped deployment.
+//
+// Like WithHostMapping and WithDNSCache, it applies to the transport built
+// by the client, not to a base round tripper, and not to the hosts of
+// requests sent through a proxy, which resolves them itself. The dial
+// breaker is consulted with the resolved addresses, rather than the host
+// name.
+func WithResolver(value *net.Resolver) Option {
+	return func(opt *options) {
+		opt.resolver = value
+	}
+}
+
+// WithHostMapping maps host names to the addresses or host names dialed in
+// their place, as /etc/hosts does, but for the client alone, such as to
+// send requests for charmhub.io to an internal mirror. Host names are
+// matched case insensitively. The URL of a request is left unchanged, so
+// the certificate of a mapped host must still be valid for its original
+// name, and the Host header of the request remains that name.
+func WithHostMapping(value map[string]string) Option {
+	return func(opt *options) {
+		opt.hostMapping = make(map[string]string, len(value))
+		for host, target := range value {
+			opt.hostMapping[strings.ToLower(host)] = target
+		}
+	}
+}
+
+// WithDNSCache caches the addresses a host name resolves to for the ttl,
+// rather than resolving it again for every connection. Failed lookups are
+// not cached. A zero ttl, the default, disables the cache.
+func WithDNSCache(ttl time.Duration) Option {
+	return func(opt *options) {
+		opt.dnsCacheTTL = ttl
+	}
+}
+
+// validateResolverOptions checks the host mapping and DNS cache options.
+func validateResolverOptions(hostMapping map[string]string, dnsCacheTTL time.Duration) error {
+	for host, target := range hostMapping {
+		if host == "" {
+			return errors.NotValidf("empty host name in host mapping")
+		}
+		if target == "" || (strings.ContainsAny(target, "/:[]") && parseIP(target) == nil) {
+			return errors.NotValidf("host mapping of %q to %q", host, target)
+		}
+	}
+	if dnsCacheTTL < 0 {
+		return errors.NotValidf("negative DNS cache ttl")
+	}
+	return nil
+}
+
+// hostResolver resolves host names for a client, with its host mapping,
+// resolver and DNS cache.
+type hostResolver struct {
+	resolver    *net.Resolver
+	hostMapping map[string]string
+	cache       *dnsCache
+}
+
+// newHostResolver returns the resolver configured by the options, or nil
+// if host names are resolved as normal.
+func newHostResolver(opts *options) *hostResolver {
+	if opts.resolver == nil && len(opts.hostMapping) == 0 && opts.dnsCacheTTL == 0 {
+		return nil
+	}
+	r := &hostResolver{
+		resolver:    opts.resolver,
+		hostMapping: opts.hostMapping,
+	}
+	if opts.dnsCacheTTL > 0 {
+		r.cache = newDNSCache(opts.clock, opts.dnsCacheTTL)
+	}
+	return r
+}
+
+// lookup returns the addresses of the host. It implements lookupFunc.
+func (r *hostResolver) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if target, ok := r.hostMapping[strings.ToLower(host)]; ok {
+		if ip := parseIP(target); ip != nil {
+			return []net.IPAddr{{IP: ip}}, nil
+		}
+		host = target
+	}
+	if r.cache != nil {
+		if addrs, ok := r.cache.get(host); ok {
+			return addrs, nil
+		}
+	}
+
+	var (
+		addrs []net.IPAddr
+		err   error
+	)
+	if r.resolver != nil {
+		addrs, err = r.resolver.LookupIPAddr(ctx, host)
+	} else {
+		addrs, err = lookupIPAddr(ctx, host)
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if r.cache != nil {
+		r.cache.set(host, addrs)
+	}
+	return addrs, nil
+}
+
+// resolvingDialContext resolves the host of addr with the lookup, and dials
+// its addresses the address family allows, racing them after the fallback
+// delay, see dialHappyEyeballs.
+func resolvingDialContext(dial dialContextFunc, lookup lookupFunc, family AddressFamily, clk clock.Clock, fallbackDelay time.Duration) dialContextFunc {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dial(ctx, network, addr)
+		}
+		if ip := parseIP(host); ip != nil {
+			if !family.allows(ip) {
+				return nil, errors.NotValidf("%s address %q with address family %s", network, addr, family)
+			}
+			return dial(ctx, network, addr)
+		}
+		ipAddrs, err := lookup(ctx, host)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(ipAddrs) == 0 {
+			return nil, errors.NotFoundf("address for %q", host)
+		}
+		ipAddrs = family.order(ipAddrs)
+		if len(ipAddrs) == 0 {
+			return nil, errors.NotFoundf("%s address for %q", family, host)
+		}
+		addrs := make([]string, len(ipAddrs))
+		for i, ipAddr := range ipAddrs {
+			addrs[i] = net.JoinHostPort(ipAddr.String(), port)
+		}
+		return dialHappyEyeballs(ctx, clk, dial, network, orderFailedTargets(ctx, addrs), fallbackDelay)
+	}
+}
+
+// dnsCache caches the addresses of host names for a ttl.
+type dnsCache struct {
+	clock clock.Clock
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	entries map[string]dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	addrs   []net.IPAddr
+	expires time.Time
+}
+
+func newDNSCache(clk clock.Clock, ttl time.Duration) *dnsCache {
+	return &dnsCache{
+		clock:   clk,
+		ttl:     ttl,
+		entries: make(map[string]dnsCacheEntry),
+	}
+}
+
+// get returns the cached addresses of the host, if they haven't expired.
+func (c *dnsCache) get(host string) ([]net.IPAddr, bool) {
+	host = strings.ToLower(host)
+	now := c.clock.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[host]
+	if !ok {
+		return nil, false
+	}
+	if !now.Before(entry.expires) {
+		delete(c.entries, host)
+		return nil, false
+	}
+	return entry.addrs, true
+}
+
+// set caches the addresses of the host.
+func (c *dnsCache) set(host string, addrs []net.IPAddr) {
+	host = strings.ToLower(host)
+	expires := c.clock.Now().Add(c.ttl)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[host] = dnsCacheEntry{addrs: addrs, expires: expires}
+}
@@ -0,0 +1,179 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/juju/clock/testclock"
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type resolverSuite struct {
+	testing.IsolationSuite
+
+	lookups  map[string]string
+	lookedUp []string
+}
+
+var _ = gc.Suite(&resolverSuite{})
+
+func (s *resolverSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.lookups = make(map[string]string)
+	s.lookedUp = nil
+	s.PatchValue(&lookupIPAddr, func(_ context.Context, host string) ([]net.IPAddr, error) {
+		s.lookedUp = append(s.lookedUp, host)
+		addr, ok := s.lookups[host]
+		if !ok {
+			return nil, errors.NotFoundf("host %q", host)
+		}
+		return []net.IPAddr{{IP: net.ParseIP(addr)}}, nil
+	})
+}
+
+// server returns a test server recording the Host header of its requests,
+// and its port.
+func (s *resolverSuite) server(c *gc.C, hosts *[]string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*hosts = append(*hosts, r.Host)
+	}))
+	s.AddCleanup(func(*gc.C) { server.Close() })
+	serverURL, err := url.Parse(server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	return serverURL.Port()
+}
+
+func (s *resolverSuite) TestHostMappingToAddress(c *gc.C) {
+	var hosts []string
+	port := s.server(c, &hosts)
+
+	client := NewClient(WithHostMapping(map[string]string{"CharmHub.io": "127.0.0.1"}))
+	resp, err := client.Get(context.Background(), "http://charmhub.io:"+port)
+	c.Assert(err, jc.ErrorIsNil)
+	_ = resp.Body.Close()
+
+	c.Check(hosts, jc.DeepEquals, []string{"charmhub.io:" + port})
+	c.Check(s.lookedUp, gc.HasLen, 0)
+}
+
+func (s *resolverSuite) TestHostMappingToHost(c *gc.C) {
+	s.lookups["mirror.internal"] = "127.0.0.1"
+	var hosts []string
+	port := s.server(c, &hosts)
+
+	client := NewClient(WithHostMapping(map[string]string{"charmhub.io": "mirror.internal"}))
+	resp, err := client.Get(context.Background(), "http://charmhub.io:"+port)
+	c.Assert(err, jc.ErrorIsNil)
+	_ = resp.Body.Close()
+
+	c.Check(hosts, jc.DeepEquals, []string{"charmhub.io:" + port})
+	c.Check(s.lookedUp, jc.DeepEquals, []string{"mirror.internal"})
+}
+
+func (s *resolverSuite) TestHostMappingWithDNSRebindingProtection(c *gc.C) {
+	var hosts []string
+	port := s.server(c, &hosts)
+
+	client := NewClient(
+		WithHostMapping(map[string]string{"charmhub.io": "127.0.0.1"}),
+		WithDNSRebindingProtection(true),
+	)
+	resp, err := client.Get(context.Background(), "http://charmhub.io:"+port)
+	c.Assert(err, jc.ErrorIsNil)
+	_ = resp.Body.Close()
+
+	c.Check(hosts, jc.DeepEquals, []string{"charmhub.io:" + port})
+	c.Check(s.lookedUp, gc.HasLen, 0)
+}
+
+func (s *resolverSuite) TestResolver(c *gc.C) {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(context.Context, string, string) (net.Conn, error) {
+			return nil, errors.New("no DNS server here")
+		},
+	}
+	client := NewClient(WithResolver(resolver))
+	_, err := client.Get(context.Background(), "http://charmhub.invalid")
+	c.Check(err, gc.ErrorMatches, `.*no DNS server here.*`)
+	c.Check(s.lookedUp, gc.HasLen, 0)
+}
+
+func (s *resolverSuite) TestDNSCache(c *gc.C) {
+	s.lookups["charmhub.io"] = "203.0.113.1"
+	clk := testclock.NewClock(time.Now())
+	resolver := newHostResolver(&options{clock: clk, dnsCacheTTL: time.Minute})
+
+	for _, host := range []string{"charmhub.io", "CharmHub.io"} {
+		addrs, err := resolver.lookup(context.Background(), host)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(addrs, jc.DeepEquals, []net.IPAddr{{IP: net.ParseIP("203.0.113.1")}})
+	}
+	c.Check(s.lookedUp, gc.HasLen, 1)
+
+	clk.Advance(time.Minute)
+	_, err := resolver.lookup(context.Background(), "charmhub.io")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(s.lookedUp, gc.HasLen, 2)
+}
+
+func (s *resolverSuite) TestDNSCacheSkipsFailures(c *gc.C) {
+	clk := testclock.NewClock(time.Now())
+	resolver := newHostResolver(&options{clock: clk, dnsCacheTTL: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		_, err := resolver.lookup(context.Background(), "charmhub.io")
+		c.Check(err, jc.Satisfies, errors.IsNotFound)
+	}
+	c.Check(s.lookedUp, gc.HasLen, 2)
+}
+
+func (s *resolverSuite) TestNoResolver(c *gc.C) {
+	c.Check(newHostResolver(newOptions()), gc.IsNil)
+}
+
+func (s *resolverSuite) TestValidate(c *gc.C) {
+	for _, test := range []struct {
+		mapping map[string]string
+		ttl     time.Duration
+		err     string
+	}{{
+		mapping: map[string]string{"charmhub.io": "10.0.0.1"},
+	}, {
+		mapping: map[string]string{"charmhub.io": "fd00::1"},
+	}, {
+		mapping: map[string]string{"charmhub.io": "mirror.internal"},
+	}, {
+		mapping: map[string]string{"": "10.0.0.1"},
+		err:     `empty host name in host mapping not valid`,
+	}, {
+		mapping: map[string]string{"charmhub.io": ""},
+		err:     `host mapping of "charmhub.io" to "" not valid`,
+	}, {
+		mapping: map[string]string{"charmhub.io": "mirror.internal:8443"},
+		err:     `host mapping of "charmhub.io" to "mirror.internal:8443" not valid`,
+	}, {
+		ttl: -time.Second,
+		err: `negative DNS cache ttl not valid`,
+	}} {
+		err := validateResolverOptions(test.mapping, test.ttl)
+		if test.err == "" {
+			c.Check(err, jc.ErrorIsNil)
+		} else {
+			c.Check(err, gc.ErrorMatches, test.err)
+		}
+	}
+
+	_, err := NewClient(WithDNSCache(-time.Second)).Get(context.Background(), "http://example.com")
+	c.Check(err, gc.ErrorMatches, `.*negative DNS cache ttl not valid`)
+}
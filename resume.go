@@ -0,0 +1,106 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// ResumableBody returns the body of a response to a GET request, wrapped so
+// that a read that fails partway through the download is transparently
+// resumed with a Range request from the last byte read, up to maxResumes
+// times. A resumed response is only used if it carries the same strong ETag
+// as the original response, ensuring the content didn't change in between.
+//
+// If the response can't be resumed, because it isn't a complete 200 response
+// to a GET request or it has no strong ETag, the body is returned unchanged.
+func (c *Client) ResumableBody(resp *http.Response, maxResumes int) io.ReadCloser {
+	etag := ETag(resp)
+	if resp.Request == nil || resp.Request.Method != "GET" ||
+		resp.StatusCode != http.StatusOK ||
+		etag == "" || strings.HasPrefix(etag, "W/") {
+		return resp.Body
+	}
+	return &resumingBody{
+		client:     c,
+		req:        resp.Request,
+		etag:       etag,
+		body:       resp.Body,
+		maxResumes: maxResumes,
+	}
+}
+
+// resumingBody reads a response body, resuming the download with a Range
+// request if a read fails.
+type resumingBody struct {
+	client     *Client
+	req        *http.Request
+	etag       string
+	body       io.ReadCloser
+	offset     int64
+	resumes    int
+	maxResumes int
+}
+
+// Read implements io.Reader.
+func (b *resumingBody) Read(p []byte) (int, error) {
+	for {
+		n, err := b.body.Read(p)
+		b.offset += int64(n)
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		if n > 0 {
+			// Hand over the bytes read, the failure will be seen
+			// again by the next read.
+			return n, nil
+		}
+		if resumeErr := b.resume(err); resumeErr != nil {
+			return 0, resumeErr
+		}
+	}
+}
+
+// resume replaces the body with the remainder of the content, fetched with a
+// Range request. The original read error is returned if that isn't
+// possible.
+func (b *resumingBody) resume(readErr error) error {
+	if b.resumes >= b.maxResumes || b.req.Context().Err() != nil {
+		return readErr
+	}
+	b.resumes++
+
+	logger := requestLogger(b.req.Context(), b.client.snapshot.load().logger)
+	logger.Tracef("resuming download of %s from offset %d after: %v", b.req.URL, b.offset, readErr)
+
+	req := b.req.Clone(b.req.Context())
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", b.offset))
+	req.Header.Set("If-Range", b.etag)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return errors.Annotatef(readErr, "cannot resume download: %v", err)
+	}
+
+	contentRange := fmt.Sprintf("bytes %d-", b.offset)
+	if resp.StatusCode != http.StatusPartialContent ||
+		ETag(resp) != b.etag ||
+		!strings.HasPrefix(resp.Header.Get("Content-Range"), contentRange) {
+		_ = resp.Body.Close()
+		return errors.Annotatef(readErr, "cannot resume download: content changed (%s, etag %s)", resp.Status, ETag(resp))
+	}
+
+	_ = b.body.Close()
+	b.body = resp.Body
+	return nil
+}
+
+// Close implements io.Closer.
+func (b *resumingBody) Close() error {
+	return b.body.Close()
+}
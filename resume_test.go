@@ -0,0 +1,111 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type resumeSuite struct {
+	testing.IsolationSuite
+	content []byte
+	etag    string
+	// cut is the number of bytes served before the connection is dropped,
+	// for every request.
+	cut int
+}
+
+var _ = gc.Suite(&resumeSuite{})
+
+func (s *resumeSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.content = []byte("0123456789")
+	s.etag = `"v1"`
+	s.cut = 4
+}
+
+func (s *resumeSuite) server() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", s.etag)
+		if r.Header.Get("Range") != "" {
+			http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(s.content))
+			return
+		}
+		// Promise the full content, but drop the connection partway.
+		conn, buf, _ := w.(http.Hijacker).Hijack()
+		defer conn.Close()
+		fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nETag: %s\r\nContent-Length: %d\r\n\r\n", s.etag, len(s.content))
+		_, _ = buf.Write(s.content[:s.cut])
+		_ = buf.Flush()
+	}))
+}
+
+func (s *resumeSuite) TestResumed(c *gc.C) {
+	server := s.server()
+	defer server.Close()
+
+	client := NewClient()
+	resp, err := client.Get(context.TODO(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	body := client.ResumableBody(resp, 1)
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(data), gc.Equals, "0123456789")
+}
+
+func (s *resumeSuite) TestContentChanged(c *gc.C) {
+	server := s.server()
+	defer server.Close()
+
+	client := NewClient()
+	resp, err := client.Get(context.TODO(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	body := client.ResumableBody(resp, 1)
+	defer body.Close()
+
+	s.etag = `"v2"`
+	data, err := io.ReadAll(body)
+	c.Assert(err, gc.ErrorMatches, `cannot resume download: content changed \(200 OK, etag "v2"\): unexpected EOF`)
+	c.Assert(string(data), gc.Equals, "0123")
+}
+
+func (s *resumeSuite) TestResumesExhausted(c *gc.C) {
+	server := s.server()
+	defer server.Close()
+
+	client := NewClient()
+	resp, err := client.Get(context.TODO(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	body := client.ResumableBody(resp, 0)
+	defer body.Close()
+
+	_, err = io.ReadAll(body)
+	c.Assert(err, gc.Equals, io.ErrUnexpectedEOF)
+}
+
+func (s *resumeSuite) TestWeakETagNotResumable(c *gc.C) {
+	s.etag = `W/"v1"`
+	server := s.server()
+	defer server.Close()
+
+	client := NewClient()
+	resp, err := client.Get(context.TODO(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	body := client.ResumableBody(resp, 1)
+	defer body.Close()
+
+	c.Assert(body, gc.Equals, resp.Body)
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/juju/errors"
+)
+
+// RetryableErrorFunc reports whether a request that failed with the
+// transport error err, without a response, should be retried.
+type RetryableErrorFunc func(req *http.Request, err error) bool
+
+// DefaultRetryableError is the RetryableErrorFunc of a RetryPolicy without
+// one. It retries:
+//
+//   - timeouts and temporary DNS failures,
+//   - connections reset or refused by the server,
+//   - connections closed before a response, for idempotent requests only,
+//     as the server may have acted on the request.
+//
+// A request cancelled, or past its deadline, is never retried, even though
+// context.DeadlineExceeded is a timeout.
+func DefaultRetryableError(req *http.Request, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return isIdempotent(req)
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isIdempotent returns true if the request can safely be sent more than
+// once, as defined by RFC 9110.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	// An idempotency key marks a request as safe to resend.
+	return req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != ""
+}
+
+// canResend returns true if the body of the request, if it has one, can be
+// sent again.
+func canResend(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
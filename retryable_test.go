@@ -0,0 +1,256 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/clock/testclock"
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type retryableSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&retryableSuite{})
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func (s *retryableSuite) TestDefaultRetryableError(c *gc.C) {
+	get, err := http.NewRequest("GET", "http://meshuggah.rocks", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	post, err := http.NewRequest("POST", "http://meshuggah.rocks", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	keyed, err := http.NewRequest("POST", "http://meshuggah.rocks", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	keyed.Header.Set("Idempotency-Key", "42")
+
+	reset := &url.Error{Op: "Get", URL: "http://meshuggah.rocks", Err: &net.OpError{
+		Op:  "read",
+		Err: os.NewSyscallError("read", syscall.ECONNRESET),
+	}}
+
+	tests := []struct {
+		req       *http.Request
+		err       error
+		retryable bool
+	}{
+		{req: post, err: reset, retryable: true},
+		{req: post, err: &net.OpError{Op: "dial", Err: timeoutErr{}}, retryable: true},
+		{req: get, err: &net.DNSError{Err: "server misbehaving", IsTemporary: true}, retryable: true},
+		{req: get, err: &net.DNSError{Err: "no such host", IsNotFound: true}, retryable: false},
+		{req: get, err: io.ErrUnexpectedEOF, retryable: true},
+		{req: get, err: errors.Annotate(io.EOF, "reading response"), retryable: true},
+		{req: post, err: io.EOF, retryable: false},
+		{req: keyed, err: io.EOF, retryable: true},
+		{req: get, err: context.Canceled, retryable: false},
+		{req: get, err: &url.Error{Op: "Get", URL: "http://meshuggah.rocks", Err: context.DeadlineExceeded}, retryable: false},
+		{req: get, err: errors.New("x509: certificate signed by unknown authority"), retryable: false},
+	}
+	for i, test := range tests {
+		c.Logf("test %d: %s %v", i, test.req.Method, test.err)
+		c.Check(DefaultRetryableError(test.req, test.err), gc.Equals, test.retryable)
+	}
+}
+
+func (s *retryableSuite) TestRetryTransportError(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	req, err := http.NewRequest("PUT", "http://meshuggah.rocks", strings.NewReader("body"))
+	c.Assert(err, jc.ErrorIsNil)
+
+	var bodies []string
+	transport := NewMockRoundTripper(ctrl)
+	gomock.InOrder(
+		transport.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(r *http.Request) (*http.Response, error) {
+			body, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(body))
+			return nil, syscall.ECONNRESET
+		}),
+		transport.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(r *http.Request) (*http.Response, error) {
+			body, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(body))
+			return &http.Response{StatusCode: http.StatusOK}, nil
+		}),
+	)
+
+	middleware := makeRetryMiddleware(transport, RetryPolicy{
+		Attempts: 3,
+		Delay:    time.Millisecond,
+		MaxDelay: time.Second,
+	}, clock.WallClock, logger(ctrl))
+
+	resp, err := middleware.RoundTrip(req)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	// The body is sent again with the retry.
+	c.Assert(bodies, jc.DeepEquals, []string{"body", "body"})
+}
+
+func (s *retryableSuite) TestRetryTransportErrorExceeded(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	req, err := http.NewRequest("GET", "http://meshuggah.rocks", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(req).Return(nil, syscall.ECONNRESET).Times(2)
+
+	middleware := makeRetryMiddleware(transport, RetryPolicy{
+		Attempts: 2,
+		Delay:    time.Millisecond,
+		MaxDelay: time.Second,
+	}, clock.WallClock, logger(ctrl))
+
+	_, err = middleware.RoundTrip(req)
+	c.Assert(err, gc.ErrorMatches, `attempt count exceeded: connection reset by peer`)
+	c.Assert(errors.Is(err, syscall.ECONNRESET), jc.IsTrue)
+}
+
+func (s *retryableSuite) TestNoRetryWithoutGetBody(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	req, err := http.NewRequest("PUT", "http://meshuggah.rocks", io.NopCloser(strings.NewReader("body")))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(req.GetBody, gc.IsNil)
+
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(req).Return(nil, syscall.ECONNRESET)
+
+	middleware := makeRetryMiddleware(transport, RetryPolicy{
+		Attempts: 3,
+		Delay:    time.Millisecond,
+		MaxDelay: time.Second,
+	}, clock.WallClock, logger(ctrl))
+
+	_, err = middleware.RoundTrip(req)
+	c.Assert(errors.Is(err, syscall.ECONNRESET), jc.IsTrue)
+}
+
+func (s *retryableSuite) TestRetryableErrorFunc(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	req, err := http.NewRequest("GET", "http://meshuggah.rocks", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(req).Return(nil, syscall.ECONNRESET)
+
+	var classified []error
+	middleware := makeRetryMiddleware(transport, RetryPolicy{
+		Attempts: 3,
+		Delay:    time.Millisecond,
+		MaxDelay: time.Second,
+		RetryableError: func(_ *http.Request, err error) bool {
+			classified = append(classified, err)
+			return false
+		},
+	}, clock.WallClock, logger(ctrl))
+
+	_, err = middleware.RoundTrip(req)
+	c.Assert(err, gc.ErrorMatches, `connection reset by peer`)
+	c.Assert(classified, gc.HasLen, 1)
+}
+
+func (s *retryableSuite) TestDeadlineExceededNotRetried(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	req, err := http.NewRequest("GET", "http://meshuggah.rocks", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(req).Return(nil, context.DeadlineExceeded)
+
+	// Even a policy retrying every error doesn't retry a timed out request.
+	middleware := makeRetryMiddleware(transport, RetryPolicy{
+		Attempts:       3,
+		Delay:          time.Millisecond,
+		MaxDelay:       time.Second,
+		RetryableError: func(*http.Request, error) bool { return true },
+	}, clock.WallClock, logger(ctrl))
+
+	_, err = middleware.RoundTrip(req)
+	c.Assert(err, jc.ErrorIs, context.DeadlineExceeded)
+}
+
+func (s *retryableSuite) TestCancelStopsRetryDelay(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://meshuggah.rocks", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	body := &closeRecorder{Reader: strings.NewReader("busy")}
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(req).Return(&http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Body:       body,
+	}, nil)
+
+	clk := testclock.NewClock(time.Now())
+	middleware := makeRetryMiddleware(transport, RetryPolicy{
+		Attempts: 3,
+		Delay:    time.Hour,
+		MaxDelay: time.Hour,
+	}, clk, logger(ctrl))
+
+	done := make(chan error, 1)
+	go func() {
+		resp, err := middleware.RoundTrip(req)
+		c.Check(resp, gc.IsNil)
+		done <- err
+	}()
+
+	// Cancel the request while it waits for the retry, without the clock
+	// ever advancing.
+	select {
+	case <-clk.Alarms():
+	case <-time.After(testing.LongWait):
+		c.Fatalf("retry delay not started")
+	}
+	cancel()
+	select {
+	case err := <-done:
+		c.Assert(err, jc.ErrorIs, context.Canceled)
+	case <-time.After(testing.LongWait):
+		c.Fatalf("retry not stopped by cancelled request")
+	}
+	c.Check(body.closed, jc.IsTrue)
+}
+
+// closeRecorder is a response body recording whether it was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+)
+
+// budgetBuckets is the number of buckets the retry budget window is split
+// into. Counts older than the window are expired a bucket at a time.
+const budgetBuckets = 10
+
+// RetryBudgetConfig holds the configuration of a RetryBudget.
+type RetryBudgetConfig struct {
+	// Ratio is the maximum ratio of retries to requests permitted over the
+	// window. A ratio of 0.2 allows one retry for every five requests.
+	Ratio float64
+
+	// MinRetries is the number of retries that are always permitted within
+	// the window, regardless of the number of requests. This allows low
+	// traffic clients to still retry.
+	MinRetries int
+
+	// Window is the sliding window over which requests and retries are
+	// counted.
+	Window time.Duration
+
+	// Clock is used to expire counts from the window. If nil, the wall
+	// clock is used. The clock is bound when the budget is created, as the
+	// budget may be shared by clients with different clocks.
+	Clock clock.Clock
+}
+
+// Validate validates the RetryBudgetConfig for any issues.
+func (c RetryBudgetConfig) Validate() error {
+	if c.Ratio < 0 {
+		return errors.NotValidf("negative ratio")
+	}
+	if c.MinRetries < 0 {
+		return errors.NotValidf("negative min retries")
+	}
+	if c.Window < budgetBuckets {
+		return errors.NotValidf("window %s", c.Window)
+	}
+	return nil
+}
+
+// RetryBudgetStats describes the state of a RetryBudget over the current
+// window.
+type RetryBudgetStats struct {
+	// Requests is the number of requests counted in the window.
+	Requests int64
+	// Retries is the number of retries permitted in the window.
+	Retries int64
+	// Denied is the number of retries denied in the window.
+	Denied int64
+	// Available is the number of retries that would currently be permitted.
+	Available int64
+}
+
+type budgetBucket struct {
+	start    time.Time
+	requests int64
+	retries  int64
+	denied   int64
+}
+
+// RetryBudget limits the number of retries made across all requests sharing
+// the budget, to prevent retry storms amplifying an outage of the remote
+// service. A budget is safe for concurrent use and can be shared by several
+// retry policies.
+type RetryBudget struct {
+	mu      sync.Mutex
+	config  RetryBudgetConfig
+	clock   clock.Clock
+	span    time.Duration
+	buckets [budgetBuckets]budgetBucket
+}
+
+// NewRetryBudget creates a new RetryBudget from the given config.
+func NewRetryBudget(config RetryBudgetConfig) (*RetryBudget, error) {
+	if err := config.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	clk := config.Clock
+	if clk == nil {
+		clk = clock.WallClock
+	}
+	return &RetryBudget{
+		config: config,
+		clock:  clk,
+		span:   config.Window / budgetBuckets,
+	}, nil
+}
+
+// recordRequest counts a new request against the budget.
+func (b *RetryBudget) recordRequest() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.current().requests++
+}
+
+// tryRetry reports whether a retry is permitted by the budget, counting it if
+// so.
+func (b *RetryBudget) tryRetry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bucket := b.current()
+	if b.stats().Available <= 0 {
+		bucket.denied++
+		return false
+	}
+	bucket.retries++
+	return true
+}
+
+// Stats returns the state of the budget over the current window.
+func (b *RetryBudget) Stats() RetryBudgetStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.current()
+	return b.stats()
+}
+
+func (b *RetryBudget) stats() RetryBudgetStats {
+	var stats RetryBudgetStats
+	for _, bucket := range b.buckets {
+		stats.Requests += bucket.requests
+		stats.Retries += bucket.retries
+		stats.Denied += bucket.denied
+	}
+	allowed := int64(float64(stats.Requests)*b.config.Ratio) + int64(b.config.MinRetries)
+	if available := allowed - stats.Retries; available > 0 {
+		stats.Available = available
+	}
+	return stats
+}
+
+// current returns the bucket for the current time, resetting any buckets
+// that have fallen out of the window.
+func (b *RetryBudget) current() *budgetBucket {
+	now := b.clock.Now()
+	start := now.Truncate(b.span)
+	index := int(start.UnixNano()/int64(b.span)) % budgetBuckets
+	for i := range b.buckets {
+		if now.Sub(b.buckets[i].start) >= b.config.Window {
+			b.buckets[i] = budgetBucket{}
+		}
+	}
+	bucket := &b.buckets[index]
+	if !bucket.start.Equal(start) {
+		*bucket = budgetBucket{start: start}
+	}
+	return bucket
+}
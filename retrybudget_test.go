@@ -0,0 +1,156 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/clock/testclock"
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type RetryBudgetSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&RetryBudgetSuite{})
+
+func (s *RetryBudgetSuite) TestValidate(c *gc.C) {
+	_, err := NewRetryBudget(RetryBudgetConfig{Ratio: -1, Window: time.Second})
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+	_, err = NewRetryBudget(RetryBudgetConfig{Ratio: 0.2})
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+}
+
+func (s *RetryBudgetSuite) TestRatio(c *gc.C) {
+	clk := testclock.NewClock(time.Now())
+	budget, err := NewRetryBudget(RetryBudgetConfig{
+		Ratio:  0.2,
+		Window: 10 * time.Second,
+		Clock:  clk,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+
+	for i := 0; i < 10; i++ {
+		budget.recordRequest()
+	}
+	c.Assert(budget.tryRetry(), jc.IsTrue)
+	c.Assert(budget.tryRetry(), jc.IsTrue)
+	c.Assert(budget.tryRetry(), jc.IsFalse)
+	c.Assert(budget.Stats(), jc.DeepEquals, RetryBudgetStats{
+		Requests: 10,
+		Retries:  2,
+		Denied:   1,
+	})
+
+	// Once the window has passed, the counts expire.
+	clk.Advance(11 * time.Second)
+	c.Assert(budget.Stats(), jc.DeepEquals, RetryBudgetStats{})
+}
+
+func (s *RetryBudgetSuite) TestMinRetries(c *gc.C) {
+	budget, err := NewRetryBudget(RetryBudgetConfig{
+		MinRetries: 1,
+		Window:     time.Minute,
+		Clock:      testclock.NewClock(time.Now()),
+	})
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Assert(budget.tryRetry(), jc.IsTrue)
+	c.Assert(budget.tryRetry(), jc.IsFalse)
+}
+
+func (s *RetryBudgetSuite) TestRetryMiddlewareBudgetExhausted(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	req, err := http.NewRequest("GET", "http://meshuggah.rocks", nil)
+	c.Assert(err, gc.IsNil)
+
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(req).Return(&http.Response{
+		StatusCode: http.StatusBadGateway,
+	}, nil).Times(2)
+
+	budget, err := NewRetryBudget(RetryBudgetConfig{
+		MinRetries: 1,
+		Window:     time.Minute,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+
+	stats := &clientStats{}
+	middleware := makeRetryMiddleware(transport, RetryPolicy{
+		Attempts: 3,
+		Delay:    time.Nanosecond,
+		MaxDelay: time.Minute,
+		Budget:   budget,
+	}, clock.WallClock, logger(ctrl))
+	middleware.stats = stats
+
+	// The response to the last attempt is returned.
+	resp, err := middleware.RoundTrip(req)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadGateway)
+	c.Assert(stats.snapshot().Retries, gc.Equals, int64(1))
+	c.Assert(budget.Stats(), jc.DeepEquals, RetryBudgetStats{
+		Requests: 1,
+		Retries:  1,
+		Denied:   1,
+	})
+}
+
+func (s *RetryBudgetSuite) TestRetryMiddlewareBudgetExhaustedTransportError(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	req, err := http.NewRequest("GET", "http://meshuggah.rocks", nil)
+	c.Assert(err, gc.IsNil)
+
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(req).Return(nil, syscall.ECONNREFUSED)
+
+	budget, err := NewRetryBudget(RetryBudgetConfig{
+		Window: time.Minute,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+
+	middleware := makeRetryMiddleware(transport, RetryPolicy{
+		Attempts: 3,
+		Delay:    time.Nanosecond,
+		MaxDelay: time.Minute,
+		Budget:   budget,
+	}, clock.WallClock, logger(ctrl))
+
+	resp, err := middleware.RoundTrip(req)
+	c.Assert(resp, gc.IsNil)
+	c.Assert(err, jc.ErrorIs, syscall.ECONNREFUSED)
+}
+
+func (s *RetryBudgetSuite) TestSharedBudgetKeepsCounts(c *gc.C) {
+	clk := testclock.NewClock(time.Now())
+	budget, err := NewRetryBudget(RetryBudgetConfig{
+		MinRetries: 1,
+		Window:     time.Minute,
+		Clock:      clk,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(budget.tryRetry(), jc.IsTrue)
+
+	// A client with another clock doesn't give the budget its allowance
+	// back.
+	policy := RetryPolicy{Attempts: 2, Delay: time.Nanosecond, MaxDelay: time.Minute, Budget: budget}
+	client := NewClient(WithRequestRetrier(policy), WithClock(testclock.NewClock(time.Now())))
+	c.Assert(client.SetRetryPolicy(policy), jc.ErrorIsNil)
+	c.Assert(budget.tryRetry(), jc.IsFalse)
+
+	clk.Advance(time.Minute)
+	c.Assert(budget.tryRetry(), jc.IsTrue)
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/juju/errors"
+	"github.com/juju/retry"
+)
+
+// RetryPolicyFromCallArgs returns the RetryPolicy equivalent to a strategy
+// of the juju/retry package, so that code already retrying with
+// retry.CallArgs can reuse its strategy for requests. The fields map as
+// follows:
+//
+//   - Attempts, Delay, MaxDuration and BackoffFunc are used as they are.
+//     Attempts must be positive, as requests are never retried forever,
+//     and so must Delay, as retry.Call requires.
+//   - MaxDelay caps the delay computed by the BackoffFunc. If it is zero
+//     there is no cap, although a Retry-After header still can't exceed
+//     it.
+//   - IsFatalError, if set, reports which transport errors stop retrying.
+//     Otherwise DefaultRetryableError is used. Responses with a retryable
+//     status code are retried either way.
+//   - NotifyFunc, if set, is called before each retry with the error of
+//     the previous attempt, or an error describing its status code, and
+//     the number of that attempt.
+//
+// Func, Clock and Stop are ignored, as the request, the clock of the client
+// and the context of the request take their place.
+func RetryPolicyFromCallArgs(args retry.CallArgs) (RetryPolicy, error) {
+	if args.Attempts < 1 {
+		return RetryPolicy{}, errors.NotSupportedf("retry strategy without an attempt limit")
+	}
+	if args.Delay <= 0 {
+		return RetryPolicy{}, errors.NotValidf("retry strategy without a positive delay")
+	}
+	if args.MaxDelay < 0 {
+		return RetryPolicy{}, errors.NotValidf("retry strategy with negative max delay")
+	}
+	if args.MaxDuration < 0 {
+		return RetryPolicy{}, errors.NotValidf("retry strategy with negative max duration")
+	}
+	policy := RetryPolicy{
+		Attempts:    args.Attempts,
+		Delay:       args.Delay,
+		MaxDelay:    args.MaxDelay,
+		MaxDuration: args.MaxDuration,
+	}
+	if policy.MaxDelay == 0 {
+		policy.MaxDelay = math.MaxInt64
+	}
+	if args.BackoffFunc != nil {
+		policy.BackoffFunc = BackoffFunc(args.BackoffFunc)
+	}
+	if isFatal := args.IsFatalError; isFatal != nil {
+		policy.RetryableError = func(_ *http.Request, err error) bool {
+			return !isFatal(err)
+		}
+	}
+	if notify := args.NotifyFunc; notify != nil {
+		policy.BeforeRetry = func(_ *http.Request, attempt RetryAttempt) error {
+			err := attempt.Err
+			if err == nil {
+				err = errors.Errorf("request failed with status %d", attempt.StatusCode)
+			}
+			notify(err, attempt.Number-1)
+			return nil
+		}
+	}
+	return policy, nil
+}
+
+// WithRetryStrategy specifies a strategy of the juju/retry package to retry
+// requests with, converted by RetryPolicyFromCallArgs. It replaces any
+// policy set by WithRequestRetrier, and the client is invalid if the
+// strategy can't be converted.
+func WithRetryStrategy(args retry.CallArgs) Option {
+	return func(opt *options) {
+		policy, err := RetryPolicyFromCallArgs(args)
+		if err != nil {
+			opt.retryStrategyErr = errors.Annotate(err, "retry strategy")
+			return
+		}
+		opt.retryStrategyErr = nil
+		opt.retryPolicy = &policy
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+	"github.com/juju/retry"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type retryStrategySuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&retryStrategySuite{})
+
+func (s *retryStrategySuite) TestRetryPolicyFromCallArgs(c *gc.C) {
+	policy, err := RetryPolicyFromCallArgs(retry.CallArgs{
+		Attempts:    4,
+		Delay:       time.Second,
+		MaxDelay:    3 * time.Second,
+		MaxDuration: time.Minute,
+		BackoffFunc: retry.DoubleDelay,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(policy.Validate(), jc.ErrorIsNil)
+	c.Check(policy.Attempts, gc.Equals, 4)
+	c.Check(policy.MaxDuration, gc.Equals, time.Minute)
+	c.Check(policy.RetryDelays(), jc.DeepEquals, []time.Duration{
+		time.Second,
+		2 * time.Second,
+		3 * time.Second,
+	})
+	c.Check(policy.RetryableError, gc.IsNil)
+	c.Check(policy.BeforeRetry, gc.IsNil)
+}
+
+func (s *retryStrategySuite) TestRetryPolicyFromCallArgsWithoutMaxDelay(c *gc.C) {
+	policy, err := RetryPolicyFromCallArgs(retry.CallArgs{
+		Attempts: 3,
+		Delay:    time.Hour,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(policy.Validate(), jc.ErrorIsNil)
+	c.Check(policy.MaxDelay, gc.Equals, time.Duration(math.MaxInt64))
+	c.Check(policy.RetryDelays(), jc.DeepEquals, []time.Duration{time.Hour, time.Hour})
+}
+
+func (s *retryStrategySuite) TestRetryPolicyFromCallArgsInvalid(c *gc.C) {
+	tests := []struct {
+		args retry.CallArgs
+		err  string
+	}{{
+		args: retry.CallArgs{MaxDuration: time.Minute},
+		err:  `retry strategy without an attempt limit not supported`,
+	}, {
+		args: retry.CallArgs{Attempts: -1},
+		err:  `retry strategy without an attempt limit not supported`,
+	}, {
+		args: retry.CallArgs{Attempts: 2},
+		err:  `retry strategy without a positive delay not valid`,
+	}, {
+		args: retry.CallArgs{Attempts: 2, Delay: time.Second, MaxDelay: -time.Second},
+		err:  `retry strategy with negative max delay not valid`,
+	}, {
+		args: retry.CallArgs{Attempts: 2, Delay: time.Second, MaxDuration: -time.Second},
+		err:  `retry strategy with negative max duration not valid`,
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.err)
+		_, err := RetryPolicyFromCallArgs(test.args)
+		c.Check(err, gc.ErrorMatches, test.err)
+	}
+}
+
+func (s *retryStrategySuite) TestIsFatalError(c *gc.C) {
+	fatal := errors.New("fatal")
+	policy, err := RetryPolicyFromCallArgs(retry.CallArgs{
+		Attempts: 2,
+		Delay:    time.Second,
+		IsFatalError: func(err error) bool {
+			return errors.Is(err, fatal)
+		},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	req, err := http.NewRequest("GET", "http://meshuggah.rocks", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(policy.RetryableError(req, fatal), jc.IsFalse)
+	c.Check(policy.RetryableError(req, errors.New("boom")), jc.IsTrue)
+}
+
+func (s *retryStrategySuite) TestNotifyFunc(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	req, err := http.NewRequest("GET", "http://meshuggah.rocks", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(gomock.Any()).Return(&http.Response{
+		StatusCode: http.StatusBadGateway,
+		Body:       io.NopCloser(nil),
+	}, nil)
+	transport.EXPECT().RoundTrip(gomock.Any()).Return(nil, errors.New("connection reset"))
+	transport.EXPECT().RoundTrip(gomock.Any()).Return(&http.Response{
+		StatusCode: http.StatusOK,
+	}, nil)
+
+	type notification struct {
+		err     string
+		attempt int
+	}
+	var notified []notification
+	policy, err := RetryPolicyFromCallArgs(retry.CallArgs{
+		Attempts: 3,
+		Delay:    time.Millisecond,
+		NotifyFunc: func(err error, attempt int) {
+			notified = append(notified, notification{err: err.Error(), attempt: attempt})
+		},
+		IsFatalError: func(error) bool {
+			return false
+		},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+
+	middleware := makeRetryMiddleware(transport, policy, clock.WallClock, logger(ctrl))
+	resp, err := middleware.RoundTrip(req)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(resp.StatusCode, gc.Equals, http.StatusOK)
+	c.Check(notified, jc.DeepEquals, []notification{
+		{err: "request failed with status 502", attempt: 1},
+		{err: "connection reset", attempt: 2},
+	})
+}
+
+func (s *retryStrategySuite) TestMaxDuration(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	req, err := http.NewRequest("GET", "http://meshuggah.rocks", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	transport := NewMockRoundTripper(ctrl)
+	transport.EXPECT().RoundTrip(req).Return(&http.Response{
+		StatusCode: http.StatusBadGateway,
+	}, nil)
+
+	clock := NewMockClock(ctrl)
+	clock.EXPECT().Now().Return(time.Now()).AnyTimes()
+
+	policy, err := RetryPolicyFromCallArgs(retry.CallArgs{
+		Attempts:    3,
+		Delay:       time.Minute,
+		MaxDuration: time.Second,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+
+	// The first retry would exceed the max duration, so it isn't attempted.
+	middleware := makeRetryMiddleware(transport, policy, clock, logger(ctrl))
+	_, err = middleware.RoundTrip(req)
+	c.Check(err, gc.ErrorMatches, `max duration exceeded: retryable error`)
+	c.Check(retry.IsDurationExceeded(err), jc.IsTrue)
+}
+
+func (s *retryStrategySuite) TestWithRetryStrategy(c *gc.C) {
+	opts := newOptions()
+	WithRetryStrategy(retry.CallArgs{Attempts: 2, Delay: time.Second})(opts)
+	c.Assert(opts.validate(), jc.ErrorIsNil)
+	c.Assert(opts.retryPolicy, gc.NotNil)
+	c.Check(opts.retryPolicy.Attempts, gc.Equals, 2)
+	c.Check(opts.retryPolicy.Delay, gc.Equals, time.Second)
+}
+
+func (s *retryStrategySuite) TestWithRetryStrategyInvalid(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	client := NewClient(WithLogger(logger(ctrl)), WithRetryStrategy(retry.CallArgs{Attempts: -1}))
+	_, err := client.Get(context.Background(), "http://charmhub.example.com")
+	c.Assert(err, jc.ErrorIs, errors.NotSupported)
+	c.Check(err, gc.ErrorMatches, `.*invalid http client configuration: retry strategy: retry strategy without an attempt limit not supported`)
+}
@@ -0,0 +1,39 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import "net/http"
+
+// RoundTripperMiddleware represents a way to wrap the base RoundTripper of a
+// client. Unlike a TransportMiddleware, it isn't tied to *http.Transport, so
+// can be used with any base RoundTripper.
+type RoundTripperMiddleware func(http.RoundTripper) http.RoundTripper
+
+// WithBaseRoundTripper sets the RoundTripper that requests are ultimately
+// sent with, in place of the *http.Transport built by the client, for
+// example a recording transport in tests or one that authenticates with an
+// OCI registry. The client's own features, such as retries and request
+// recording, are layered on top of it.
+//
+// If the RoundTripper is an *http.Transport, a clone of it is used, with the
+// transport middlewares and TLS options applied. Otherwise those options
+// can't be applied and configuring them is an error.
+func WithBaseRoundTripper(value http.RoundTripper) Option {
+	return func(opt *options) {
+		opt.baseRoundTripper = value
+	}
+}
+
+// WithRoundTripperMiddlewares allows the wrapping of the base RoundTripper
+// of a client, whether it is the client's own transport or one given with
+// WithBaseRoundTripper, for example to sign requests or gather metrics. The
+// middlewares are applied in order, so the first middleware is the
+// innermost wrapper. They are always applied after any TransportMiddleware,
+// so they wrap the fully configured transport, and inside the client's own
+// features, such as retries, so they see every attempt of a request.
+func WithRoundTripperMiddlewares(middlewares ...RoundTripperMiddleware) Option {
+	return func(opt *options) {
+		opt.roundTripperMiddlewares = middlewares
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type roundTripperSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&roundTripperSuite{})
+
+type headerRoundTripper struct {
+	wrapped     http.RoundTripper
+	name, value string
+}
+
+func (t headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(t.name, t.value)
+	return t.wrapped.RoundTrip(req)
+}
+
+func withHeader(name, value string) RoundTripperMiddleware {
+	return func(wrapped http.RoundTripper) http.RoundTripper {
+		return headerRoundTripper{wrapped: wrapped, name: name, value: value}
+	}
+}
+
+func (s *roundTripperSuite) TestCustomBase(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	base := NewMockRoundTripper(ctrl)
+	base.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+		c.Check(req.Header.Get("Authorization"), gc.Equals, "Bearer token")
+		c.Check(req.Header.Get("X-Order"), gc.Equals, "outer")
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("")),
+		}, nil
+	})
+
+	client := NewClient(
+		WithBaseRoundTripper(base),
+		WithRoundTripperMiddlewares(
+			withHeader("X-Order", "outer"),
+			withHeader("Authorization", "Bearer token"),
+			// Applied last, so is the outermost and is overridden.
+			withHeader("X-Order", "inner"),
+		),
+	)
+	resp, err := client.Get(context.TODO(), "https://registry.example.com/v2/")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+}
+
+func (s *roundTripperSuite) TestTransportBase(c *gc.C) {
+	base := &http.Transport{MaxIdleConns: 42}
+
+	client := NewClient(WithBaseRoundTripper(base))
+	transport, ok := client.Client().Transport.(*http.Transport)
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(transport, gc.Not(gc.Equals), base)
+	c.Assert(transport.MaxIdleConns, gc.Equals, 42)
+	// The transport middlewares are applied to the clone only.
+	c.Assert(transport.Proxy, gc.NotNil)
+	c.Assert(base.Proxy, gc.IsNil)
+}
+
+func (s *roundTripperSuite) TestTransportMiddlewaresApplied(c *gc.C) {
+	var wrapped http.RoundTripper
+	client := NewClient(
+		WithRoundTripperMiddlewares(func(rt http.RoundTripper) http.RoundTripper {
+			wrapped = rt
+			return headerRoundTripper{wrapped: rt, name: "X-Signed", value: "yes"}
+		}),
+		WithTransportMiddlewares(func(transport *http.Transport) *http.Transport {
+			transport.MaxIdleConns = 42
+			return transport
+		}),
+	)
+	c.Assert(client, gc.NotNil)
+
+	// The round tripper middleware wraps the transport after the transport
+	// middlewares, regardless of the order of the options.
+	transport, ok := wrapped.(*http.Transport)
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(transport.MaxIdleConns, gc.Equals, 42)
+}
+
+func (s *roundTripperSuite) TestTLSOptionsWithCustomBase(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	client := NewClient(
+		WithLogger(logger(ctrl)),
+		WithBaseRoundTripper(NewMockRoundTripper(ctrl)),
+		WithSkipHostnameVerification(true),
+	)
+	_, err := client.Get(context.TODO(), "https://registry.example.com/v2/")
+	c.Assert(err, gc.ErrorMatches, `.*TLS options with a base round tripper that is not an \*http.Transport not valid`)
+}
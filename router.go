@@ -0,0 +1,99 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Route dispatches requests matching a scheme and host to a transport.
+type Route struct {
+	// Scheme is the URL scheme to match, for example "https" or "file". An
+	// empty scheme matches any scheme.
+	Scheme string
+
+	// Host is a pattern matched against the host name of the request URL,
+	// without the port, using path.Match syntax, for example
+	// "*.charmhub.io". An empty host matches any host.
+	Host string
+
+	// Transport handles requests that match the route.
+	Transport http.RoundTripper
+}
+
+// Validate validates the Route for any issues.
+func (r Route) Validate() error {
+	if r.Transport == nil {
+		return errors.NotValidf("route for scheme %q host %q without transport", r.Scheme, r.Host)
+	}
+	if _, err := path.Match(r.Host, ""); err != nil {
+		return errors.NotValidf("route host pattern %q", r.Host)
+	}
+	return nil
+}
+
+func (r Route) matches(req *http.Request) bool {
+	if r.Scheme != "" && !strings.EqualFold(r.Scheme, req.URL.Scheme) {
+		return false
+	}
+	if r.Host == "" {
+		return true
+	}
+	matched, _ := path.Match(strings.ToLower(r.Host), strings.ToLower(req.URL.Hostname()))
+	return matched
+}
+
+// Router is a http.RoundTripper that dispatches each request to the transport
+// of the first matching route, falling back to a default transport when no
+// route matches.
+type Router struct {
+	routes   []Route
+	fallback http.RoundTripper
+}
+
+// NewRouter creates a new Router with the given fallback transport and
+// routes. Routes are evaluated in order.
+func NewRouter(fallback http.RoundTripper, routes ...Route) (*Router, error) {
+	if fallback == nil {
+		return nil, errors.NotValidf("nil fallback transport")
+	}
+	for _, route := range routes {
+		if err := route.Validate(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return &Router{
+		routes:   routes,
+		fallback: fallback,
+	}, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Router) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, route := range r.routes {
+		if route.matches(req) {
+			return route.Transport.RoundTrip(req)
+		}
+	}
+	return r.fallback.RoundTrip(req)
+}
+
+// NewUnixSocketTransport returns a transport that sends every request over
+// the unix socket at the given path, regardless of the host in the request
+// URL. It is intended for use as the transport of a Route, for example to
+// talk to a local agent.
+func NewUnixSocketTransport(socketPath string) *http.Transport {
+	dialer := &net.Dialer{}
+	return &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+}
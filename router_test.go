@@ -0,0 +1,92 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type RouterSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&RouterSuite{})
+
+func (s *RouterSuite) TestRoutes(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	fallback := NewMockRoundTripper(ctrl)
+	files := NewMockRoundTripper(ctrl)
+	charmhub := NewMockRoundTripper(ctrl)
+
+	router, err := NewRouter(fallback,
+		Route{Scheme: "file", Transport: files},
+		Route{Scheme: "https", Host: "*.charmhub.io", Transport: charmhub},
+	)
+	c.Assert(err, jc.ErrorIsNil)
+
+	tests := []struct {
+		url       string
+		transport *MockRoundTripper
+	}{
+		{"file:///var/lib/juju/metadata.json", files},
+		{"https://api.charmhub.io/v2/charms", charmhub},
+		{"https://API.CHARMHUB.IO:443/v2/charms", charmhub},
+		{"http://api.charmhub.io/v2/charms", fallback},
+		{"https://10.0.0.1:17070/model", fallback},
+	}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.url)
+		req, err := http.NewRequest("GET", test.url, nil)
+		c.Assert(err, jc.ErrorIsNil)
+		test.transport.EXPECT().RoundTrip(req).Return(&http.Response{StatusCode: http.StatusOK}, nil)
+		_, err = router.RoundTrip(req)
+		c.Assert(err, jc.ErrorIsNil)
+	}
+}
+
+func (s *RouterSuite) TestInvalidRoute(c *gc.C) {
+	_, err := NewRouter(http.DefaultTransport, Route{Scheme: "https"})
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+
+	_, err = NewRouter(http.DefaultTransport, Route{Host: "[", Transport: http.DefaultTransport})
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+
+	client := NewClient(WithRoutes(Route{Scheme: "https"}))
+	_, err = client.Get(context.TODO(), "https://0.1.2.3:1234")
+	c.Assert(err, gc.ErrorMatches, `.*invalid http client configuration: route for scheme "https" host "" without transport not valid`)
+}
+
+func (s *RouterSuite) TestUnixSocketTransport(c *gc.C) {
+	socketPath := filepath.Join(c.MkDir(), "agent.socket")
+	listener, err := net.Listen("unix", socketPath)
+	c.Assert(err, jc.ErrorIsNil)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "agent")
+	})}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	client := NewClient(WithRoutes(Route{
+		Host:      "localhost",
+		Transport: NewUnixSocketTransport(socketPath),
+	}))
+	resp, err := client.Get(context.TODO(), "http://localhost/status")
+	c.Assert(err, jc.ErrorIsNil)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(body), gc.Equals, "agent")
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net/http"
+	"runtime/trace"
+	"strconv"
+)
+
+// WithRuntimeTrace annotates every round trip made by the client with a
+// runtime/trace task named by the request method and host, so that captures
+// viewed with "go tool trace" show where HTTP time is spent. The annotations
+// are only created while a trace is being captured.
+func WithRuntimeTrace(value bool) Option {
+	return func(opt *options) {
+		opt.runtimeTrace = value
+	}
+}
+
+// runtimeTraceTransport wraps each round trip in a runtime/trace task and
+// region.
+type runtimeTraceTransport struct {
+	wrappedRoundTripper http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t runtimeTraceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !trace.IsEnabled() {
+		return t.wrappedRoundTripper.RoundTrip(req)
+	}
+
+	ctx, task := trace.NewTask(req.Context(), req.Method+" "+req.URL.Host)
+	defer task.End()
+
+	var (
+		res *http.Response
+		err error
+	)
+	trace.WithRegion(ctx, "round trip", func() {
+		res, err = t.wrappedRoundTripper.RoundTrip(req.WithContext(ctx))
+	})
+	if err != nil {
+		trace.Log(ctx, "error", err.Error())
+	} else {
+		trace.Log(ctx, "status", strconv.Itoa(res.StatusCode))
+	}
+	return res, err
+}
@@ -0,0 +1,45 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime/trace"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type runtimeTraceSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&runtimeTraceSuite{})
+
+func (s *runtimeTraceSuite) TestRuntimeTrace(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := NewClient(WithRuntimeTrace(true))
+	_, ok := client.Client().Transport.(runtimeTraceTransport)
+	c.Assert(ok, jc.IsTrue)
+
+	// Requests succeed with and without a trace being captured.
+	resp, err := client.Get(context.TODO(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+
+	var buf bytes.Buffer
+	err = trace.Start(&buf)
+	c.Assert(err, jc.ErrorIsNil)
+	resp, err = client.Get(context.TODO(), server.URL)
+	trace.Stop()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Assert(bytes.Contains(buf.Bytes(), []byte("GET "+server.Listener.Addr().String())), jc.IsTrue)
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// SamplingPolicy limits the requests passed to the RequestRecorder of a
+// client, to keep the logs and metrics of very chatty clients usable under
+// load. A request is recorded if it matches any of the criteria.
+type SamplingPolicy struct {
+	// EveryN records one in every N requests. If zero, requests are only
+	// recorded if they are slow or fail.
+	EveryN int64
+
+	// Slow, if non-zero, records every request with a round trip time of at
+	// least this long.
+	Slow time.Duration
+
+	// Errors records every request that fails, or that receives a 5xx
+	// response.
+	Errors bool
+}
+
+// Validate validates the SamplingPolicy for any issues.
+func (p SamplingPolicy) Validate() error {
+	if p.EveryN < 0 {
+		return errors.NotValidf("negative sampling interval %d", p.EveryN)
+	}
+	if p.Slow < 0 {
+		return errors.NotValidf("negative slow request threshold %s", p.Slow)
+	}
+	return nil
+}
+
+// WithRequestSampling samples the requests passed to the RequestRecorder of
+// the client according to the policy, instead of recording every request.
+func WithRequestSampling(policy SamplingPolicy) Option {
+	return func(opt *options) {
+		opt.samplingPolicy = &policy
+	}
+}
+
+// SetRequestSampling replaces the sampling policy of the client. A nil
+// policy records every request.
+func (c *Client) SetRequestSampling(policy *SamplingPolicy) error {
+	if policy != nil {
+		if err := policy.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+		copied := *policy
+		policy = &copied
+	}
+	return errors.Trace(c.snapshot.update(func(state *clientState) {
+		state.sampling = policy
+	}))
+}
+
+// sampler counts requests to implement a SamplingPolicy.
+type sampler struct {
+	count int64
+}
+
+// sample reports whether the outcome of a request should be recorded.
+func (s *sampler) sample(policy *SamplingPolicy, res *http.Response, err error, rtt time.Duration) bool {
+	if policy == nil {
+		return true
+	}
+	n := atomic.AddInt64(&s.count, 1)
+	switch {
+	case policy.EveryN > 0 && n%policy.EveryN == 0:
+		return true
+	case policy.Slow > 0 && rtt >= policy.Slow:
+		return true
+	case policy.Errors && (err != nil || res.StatusCode >= http.StatusInternalServerError):
+		return true
+	}
+	return false
+}
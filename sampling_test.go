@@ -0,0 +1,112 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type samplingSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&samplingSuite{})
+
+func (s *samplingSuite) TestValidate(c *gc.C) {
+	c.Assert(SamplingPolicy{EveryN: -1}.Validate(), jc.Satisfies, errors.IsNotValid)
+	c.Assert(SamplingPolicy{Slow: -time.Second}.Validate(), jc.Satisfies, errors.IsNotValid)
+	c.Assert(SamplingPolicy{EveryN: 10, Slow: time.Second, Errors: true}.Validate(), jc.ErrorIsNil)
+}
+
+func (s *samplingSuite) TestSample(c *gc.C) {
+	ok := &http.Response{StatusCode: http.StatusOK}
+	failed := &http.Response{StatusCode: http.StatusBadGateway}
+
+	tests := []struct {
+		policy  *SamplingPolicy
+		res     *http.Response
+		err     error
+		rtt     time.Duration
+		sampled []bool
+	}{{
+		policy:  nil,
+		res:     ok,
+		sampled: []bool{true, true, true},
+	}, {
+		policy:  &SamplingPolicy{EveryN: 2},
+		res:     ok,
+		sampled: []bool{false, true, false, true},
+	}, {
+		policy:  &SamplingPolicy{Slow: time.Second},
+		res:     ok,
+		rtt:     time.Millisecond,
+		sampled: []bool{false, false},
+	}, {
+		policy:  &SamplingPolicy{Slow: time.Second},
+		res:     ok,
+		rtt:     time.Second,
+		sampled: []bool{true, true},
+	}, {
+		policy:  &SamplingPolicy{Errors: true},
+		res:     ok,
+		sampled: []bool{false, false},
+	}, {
+		policy:  &SamplingPolicy{Errors: true},
+		res:     failed,
+		sampled: []bool{true, true},
+	}, {
+		policy:  &SamplingPolicy{Errors: true},
+		err:     errors.New("boom"),
+		sampled: []bool{true, true},
+	}}
+	for i, test := range tests {
+		c.Logf("test %d", i)
+		sampler := &sampler{}
+		for j, expected := range test.sampled {
+			c.Check(sampler.sample(test.policy, test.res, test.err, test.rtt), gc.Equals, expected, gc.Commentf("request %d", j))
+		}
+	}
+}
+
+func (s *samplingSuite) TestClientSampling(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	recorder := NewMockRequestRecorder(ctrl)
+	client := NewClient(
+		WithRequestRecorder(recorder),
+		WithRequestSampling(SamplingPolicy{EveryN: 3}),
+	)
+	get := func() {
+		resp, err := client.Get(context.TODO(), server.URL)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	}
+
+	recorder.EXPECT().Record("GET", gomock.Any(), gomock.Any(), gomock.Any())
+	for i := 0; i < 3; i++ {
+		get()
+	}
+
+	// Without a policy, every request is recorded.
+	c.Assert(client.SetRequestSampling(nil), jc.ErrorIsNil)
+	recorder.EXPECT().Record("GET", gomock.Any(), gomock.Any(), gomock.Any()).Times(2)
+	get()
+	get()
+
+	err := client.SetRequestSampling(&SamplingPolicy{EveryN: -1})
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// DisallowedSchemeError is returned for a request whose URL scheme is not
+// in the allow-list of the client, see WithAllowedSchemes.
+type DisallowedSchemeError struct {
+	// Scheme is the scheme of the rejected request.
+	Scheme string
+	// URL is the URL of the rejected request.
+	URL string
+}
+
+// Error implements error.
+func (e *DisallowedSchemeError) Error() string {
+	return fmt.Sprintf("scheme %q of %s not allowed", e.Scheme, e.URL)
+}
+
+// IsDisallowedScheme returns true if the error, or any error in its chain,
+// is a DisallowedSchemeError.
+func IsDisallowedScheme(err error) bool {
+	_, ok := errors.AsType[*DisallowedSchemeError](err)
+	return ok
+}
+
+// WithAllowedSchemes restricts the client to requests with the given URL
+// schemes, for example "https", hardening components that build URLs from
+// user or charm input. Requests with any other scheme, including those
+// reached by a redirect, fail with a *DisallowedSchemeError.
+func WithAllowedSchemes(schemes ...string) Option {
+	return func(opt *options) {
+		opt.allowedSchemes = schemes
+	}
+}
+
+// schemeAllowListTransport rejects requests with schemes that are not
+// allowed.
+type schemeAllowListTransport struct {
+	wrappedRoundTripper http.RoundTripper
+	schemes             map[string]bool
+}
+
+func newSchemeAllowListTransport(transport http.RoundTripper, schemes []string) schemeAllowListTransport {
+	allowed := make(map[string]bool, len(schemes))
+	for _, scheme := range schemes {
+		allowed[strings.ToLower(scheme)] = true
+	}
+	return schemeAllowListTransport{
+		wrappedRoundTripper: transport,
+		schemes:             allowed,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t schemeAllowListTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if scheme := strings.ToLower(req.URL.Scheme); !t.schemes[scheme] {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, &DisallowedSchemeError{
+			Scheme: scheme,
+			URL:    req.URL.Redacted(),
+		}
+	}
+	return t.wrappedRoundTripper.RoundTrip(req)
+}
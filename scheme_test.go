@@ -0,0 +1,70 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type schemeSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&schemeSuite{})
+
+func (s *schemeSuite) TestDisallowedScheme(c *gc.C) {
+	client := NewClient(WithAllowedSchemes("https"))
+
+	for _, url := range []string{
+		"http://example.com",
+		"file:///etc/passwd",
+		"ftp://example.com",
+	} {
+		_, err := client.Get(context.TODO(), url)
+		c.Check(err, gc.ErrorMatches, `.*scheme ".*" of .* not allowed`)
+		c.Check(IsDisallowedScheme(err), jc.IsTrue)
+	}
+}
+
+func (s *schemeSuite) TestAllowedScheme(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+
+	client := NewClient(WithAllowedSchemes("HTTP"))
+	resp, err := client.Get(context.TODO(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+}
+
+func (s *schemeSuite) TestDisallowedRedirect(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	}))
+	defer server.Close()
+
+	client := NewClient(WithAllowedSchemes("http"))
+	_, err := client.Get(context.TODO(), server.URL)
+	c.Assert(err, gc.ErrorMatches, `.*scheme "file" of file:///etc/passwd not allowed`)
+
+	sErr, ok := errors.AsType[*DisallowedSchemeError](err)
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(sErr.Scheme, gc.Equals, "file")
+}
+
+func (s *schemeSuite) TestEmptyScheme(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	client := NewClient(WithLogger(logger(ctrl)), WithAllowedSchemes("https", ""))
+	_, err := client.Get(context.TODO(), "https://example.com")
+	c.Assert(err, gc.ErrorMatches, `.*empty allowed scheme not valid`)
+}
@@ -0,0 +1,49 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import "net/http"
+
+// The cloud provider SDKs accept an HTTP client in one of two shapes. Using
+// a Client in either means requests made by the SDK inherit the proxy, CA
+// certificate and dial policy of this package.
+//
+// The Azure SDK accepts a policy.Transporter, which has the same method set
+// as HTTPClient, so a *Client can be used directly:
+//
+//	options.Transport = client
+//
+// The Google Cloud client libraries accept an *http.Client, see
+// StandardClient:
+//
+//	option.WithHTTPClient(client.StandardClient())
+var _ HTTPClient = (*Client)(nil)
+
+// StandardClient returns an *http.Client that sends every request through
+// Client.Do, for use with SDKs that require one. Requests made with it get
+// everything requests made with the Client do: DNS rebinding protection,
+// errors wrapped in a *RequestError, events, informational response hooks
+// and request statistics. The Client follows redirects and sets cookies,
+// so the returned client does neither itself. Changes made to the returned
+// client do not affect the Client.
+func (c *Client) StandardClient() *http.Client {
+	return &http.Client{
+		Transport: clientTransport{client: c},
+		// The redirects have been followed by the Client, so a redirect
+		// response is its final response.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// clientTransport sends requests through a Client.
+type clientTransport struct {
+	client *Client
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.client.Do(req)
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type sdkSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&sdkSuite{})
+
+// transporter mirrors the Azure SDK policy.Transporter interface.
+type transporter interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+func (s *sdkSuite) TestTransporter(c *gc.C) {
+	var _ transporter = NewClient()
+}
+
+func (s *sdkSuite) TestStandardClient(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+
+	client := NewClient()
+	std := client.StandardClient()
+	c.Assert(std, gc.Not(gc.Equals), client.Client())
+
+	resp, err := std.Get(server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Assert(client.Stats().Requests, gc.Equals, int64(1))
+
+	// Changes to the standard client don't leak back.
+	std.Transport = nil
+	c.Assert(client.Client().Transport, gc.NotNil)
+}
+
+func (s *sdkSuite) TestStandardClientSendsThroughDo(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusFound)
+			return
+		}
+		w.Header().Set("Link", "</style.css>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.WriteHeader(http.StatusOK)
+	}))
+	addr := server.URL
+	defer server.Close()
+
+	var (
+		slow          int
+		informational []int
+	)
+	client := NewClient(
+		WithDNSRebindingProtection(true),
+		WithSlowRequestThreshold(time.Nanosecond),
+		WithEventSubscriber(func(event Event) {
+			if event.Kind == EventSlowRequest {
+				slow++
+			}
+		}),
+		WithInformationalResponseHook(func(_ *http.Request, code int, _ http.Header) {
+			informational = append(informational, code)
+		}),
+	)
+	std := client.StandardClient()
+
+	// The redirect is followed once, by the client.
+	resp, err := std.Get(addr + "/old")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Check(resp.StatusCode, gc.Equals, http.StatusOK)
+	c.Check(resp.Request.URL.Path, gc.Equals, "/new")
+	c.Check(slow, gc.Equals, 1)
+	c.Check(informational, jc.DeepEquals, []int{http.StatusEarlyHints})
+
+	// Errors are wrapped in a *RequestError.
+	server.Close()
+	_, err = std.Get(addr)
+	requestErr, ok := errors.AsType[*RequestError](err)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(requestErr.Attempts, gc.Equals, 1)
+	c.Check(client.Stats().Requests, gc.Equals, int64(2))
+	c.Check(client.Stats().Errors, gc.Equals, int64(1))
+}
@@ -0,0 +1,186 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package server provides helpers for http handlers, such as reading large
+// uploads without buffering them in memory.
+package server
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+
+	"github.com/juju/errors"
+)
+
+// ErrPartTooLarge is matched, using errors.Is, by the error returned for a
+// part larger than the MaxPartSize of a MultipartReader.
+const ErrPartTooLarge = errors.ConstError("multipart part too large")
+
+// defaultMaxMemory is the number of bytes of a part held in memory, unless
+// the config says otherwise.
+const defaultMaxMemory = 1 << 20
+
+// MultipartConfig configures a MultipartReader.
+type MultipartConfig struct {
+	// MaxPartSize is the maximum size of a part. Zero means no limit.
+	MaxPartSize int64
+
+	// MaxMemory is the number of bytes of a part held in memory, the parts
+	// larger than it are spooled to a temporary file. Zero means 1MiB.
+	MaxMemory int64
+
+	// TempDir is the directory of the temporary files. If empty, the
+	// default directory for temporary files is used.
+	TempDir string
+}
+
+// Validate validates the MultipartConfig for any issues.
+func (c MultipartConfig) Validate() error {
+	if c.MaxPartSize < 0 {
+		return errors.NotValidf("negative max part size")
+	}
+	if c.MaxMemory < 0 {
+		return errors.NotValidf("negative max memory")
+	}
+	return nil
+}
+
+// MultipartReader iterates over the parts of a multipart request body,
+// reading one part at a time, unlike http.Request.ParseMultipartForm which
+// reads the whole form before any of it can be used.
+type MultipartReader struct {
+	reader *multipart.Reader
+	config MultipartConfig
+}
+
+// NewMultipartReader returns a MultipartReader of the body of the request,
+// which must be a multipart/form-data or multipart/mixed request.
+func NewMultipartReader(req *http.Request, config MultipartConfig) (*MultipartReader, error) {
+	if err := config.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if config.MaxMemory == 0 {
+		config.MaxMemory = defaultMaxMemory
+	}
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return nil, errors.NewNotValid(err, "multipart request")
+	}
+	return &MultipartReader{
+		reader: reader,
+		config: config,
+	}, nil
+}
+
+// Next reads the next part, returning io.EOF once there are no more parts.
+// The part must be closed once it is no longer needed, to remove its
+// temporary file, if it has one. A part larger than MaxPartSize is
+// discarded and the error matches ErrPartTooLarge.
+func (r *MultipartReader) Next() (*Part, error) {
+	p, err := r.reader.NextPart()
+	if err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, errors.Annotate(err, "reading multipart part")
+	}
+	defer p.Close()
+
+	part := &Part{
+		FormName: p.FormName(),
+		FileName: p.FileName(),
+		Header:   p.Header,
+	}
+
+	src := io.Reader(p)
+	if r.config.MaxPartSize > 0 {
+		// Read one byte more than the limit so that we know if the part
+		// is too large.
+		src = io.LimitReader(p, r.config.MaxPartSize+1)
+	}
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, src, r.config.MaxMemory+1)
+	if err != nil && err != io.EOF {
+		return nil, errors.Annotatef(err, "reading part %q", part.FormName)
+	}
+	if n <= r.config.MaxMemory {
+		part.Size = n
+		part.content = bytes.NewReader(buf.Bytes())
+	} else if err := part.spool(r.config.TempDir, io.MultiReader(&buf, src)); err != nil {
+		return nil, errors.Annotatef(err, "spooling part %q", part.FormName)
+	}
+	if r.config.MaxPartSize > 0 && part.Size > r.config.MaxPartSize {
+		_ = part.Close()
+		return nil, errors.Annotatef(ErrPartTooLarge, "part %q larger than %d bytes", part.FormName, r.config.MaxPartSize)
+	}
+	return part, nil
+}
+
+// Part is a part of a multipart request body. Its content is read with
+// Read and Seek.
+type Part struct {
+	// FormName is the name of the form field of the part, if any.
+	FormName string
+	// FileName is the file name of the part, if any.
+	FileName string
+	// Header is the header of the part.
+	Header textproto.MIMEHeader
+	// Size is the size of the content of the part.
+	Size int64
+
+	content io.ReadSeeker
+	file    *os.File
+}
+
+// spool copies the content to a temporary file.
+func (p *Part) spool(dir string, content io.Reader) error {
+	file, err := os.CreateTemp(dir, "multipart-")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	p.file = file
+	p.content = file
+	if p.Size, err = io.Copy(file, content); err != nil {
+		_ = p.Close()
+		return errors.Trace(err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		_ = p.Close()
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// Spooled returns true if the content of the part is held in a temporary
+// file, rather than in memory.
+func (p *Part) Spooled() bool {
+	return p.file != nil
+}
+
+// Read implements io.Reader.
+func (p *Part) Read(b []byte) (int, error) {
+	return p.content.Read(b)
+}
+
+// Seek implements io.Seeker.
+func (p *Part) Seek(offset int64, whence int) (int64, error) {
+	return p.content.Seek(offset, whence)
+}
+
+// Close removes the temporary file of the part, if it has one.
+func (p *Part) Close() error {
+	if p.file == nil {
+		return nil
+	}
+	file := p.file
+	p.file = nil
+	closeErr := file.Close()
+	if err := os.Remove(file.Name()); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(closeErr)
+}
@@ -0,0 +1,134 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package server_test
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/http/v2/server"
+)
+
+type multipartSuite struct {
+	testing.IsolationSuite
+
+	dir string
+}
+
+var _ = gc.Suite(&multipartSuite{})
+
+func (s *multipartSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.dir = c.MkDir()
+}
+
+// request returns a multipart request with a field, and a file with the
+// content.
+func (s *multipartSuite) request(c *gc.C, content string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	c.Assert(writer.WriteField("name", "mysql"), jc.ErrorIsNil)
+	file, err := writer.CreateFormFile("resource", "mysql.tar.gz")
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = io.WriteString(file, content)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(writer.Close(), jc.ErrorIsNil)
+
+	req, err := http.NewRequest("POST", "https://example.com/resources", &body)
+	c.Assert(err, jc.ErrorIsNil)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func (s *multipartSuite) tempFiles(c *gc.C) []os.DirEntry {
+	entries, err := os.ReadDir(s.dir)
+	c.Assert(err, jc.ErrorIsNil)
+	return entries
+}
+
+func (s *multipartSuite) TestNext(c *gc.C) {
+	content := strings.Repeat("x", 100)
+	reader, err := server.NewMultipartReader(s.request(c, content), server.MultipartConfig{
+		MaxMemory: 10,
+		TempDir:   s.dir,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+
+	part, err := reader.Next()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(part.FormName, gc.Equals, "name")
+	c.Check(part.Spooled(), jc.IsFalse)
+	data, err := io.ReadAll(part)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(data), gc.Equals, "mysql")
+	c.Assert(part.Close(), jc.ErrorIsNil)
+
+	part, err = reader.Next()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(part.FormName, gc.Equals, "resource")
+	c.Check(part.FileName, gc.Equals, "mysql.tar.gz")
+	c.Check(part.Size, gc.Equals, int64(100))
+	c.Check(part.Spooled(), jc.IsTrue)
+	c.Check(s.tempFiles(c), gc.HasLen, 1)
+	data, err = io.ReadAll(part)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(data), gc.Equals, content)
+
+	// The part can be read again.
+	_, err = part.Seek(0, io.SeekStart)
+	c.Assert(err, jc.ErrorIsNil)
+	data, err = io.ReadAll(part)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(data), gc.Equals, content)
+
+	c.Assert(part.Close(), jc.ErrorIsNil)
+	c.Check(s.tempFiles(c), gc.HasLen, 0)
+
+	_, err = reader.Next()
+	c.Assert(err, gc.Equals, io.EOF)
+}
+
+func (s *multipartSuite) TestPartTooLarge(c *gc.C) {
+	for _, maxMemory := range []int64{10, 1000} {
+		c.Logf("max memory %d", maxMemory)
+		reader, err := server.NewMultipartReader(s.request(c, strings.Repeat("x", 100)), server.MultipartConfig{
+			MaxPartSize: 50,
+			MaxMemory:   maxMemory,
+			TempDir:     s.dir,
+		})
+		c.Assert(err, jc.ErrorIsNil)
+
+		part, err := reader.Next()
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(part.Close(), jc.ErrorIsNil)
+
+		_, err = reader.Next()
+		c.Assert(err, jc.ErrorIs, server.ErrPartTooLarge)
+		c.Check(err, gc.ErrorMatches, `part "resource" larger than 50 bytes: multipart part too large`)
+		c.Check(s.tempFiles(c), gc.HasLen, 0)
+	}
+}
+
+func (s *multipartSuite) TestNotMultipart(c *gc.C) {
+	req, err := http.NewRequest("POST", "https://example.com/resources", strings.NewReader("{}"))
+	c.Assert(err, jc.ErrorIsNil)
+	req.Header.Set("Content-Type", "application/json")
+
+	_, err = server.NewMultipartReader(req, server.MultipartConfig{})
+	c.Assert(err, jc.ErrorIs, errors.NotValid)
+}
+
+func (s *multipartSuite) TestInvalidConfig(c *gc.C) {
+	_, err := server.NewMultipartReader(s.request(c, ""), server.MultipartConfig{MaxPartSize: -1})
+	c.Assert(err, gc.ErrorMatches, "negative max part size not valid")
+}
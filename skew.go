@@ -0,0 +1,104 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+)
+
+// ClockSkew returns how far the clock of the server that sent the response
+// is ahead of the given local time, from the Date header of the response.
+// The result is negative if the server clock is behind. As the Date header
+// has a resolution of one second and is set before the response is sent,
+// the result is only accurate to a second or so plus the response latency.
+// False is returned if the response has no valid Date header.
+func ClockSkew(resp *http.Response, now time.Time) (time.Duration, bool) {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, false
+	}
+	return date.Sub(now.Truncate(time.Second)), true
+}
+
+// ClockSkewFunc is called when the clock skew with a host exceeds the
+// threshold given to WithClockSkewCheck.
+type ClockSkewFunc func(host string, skew time.Duration)
+
+// WithClockSkewCheck compares the Date header of every response with the
+// local clock, and logs an error when they differ by more than threshold,
+// as clock skew causes TLS and macaroon validation failures that are hard
+// to diagnose. If onSkew is not nil, it is also called. Each host is
+// reported once, until its skew is back within the threshold. A zero
+// threshold disables the check.
+func WithClockSkewCheck(threshold time.Duration, onSkew ClockSkewFunc) Option {
+	return func(opt *options) {
+		opt.clockSkewThreshold = threshold
+		opt.onClockSkew = onSkew
+	}
+}
+
+// clockSkewTransport checks responses for clock skew.
+type clockSkewTransport struct {
+	wrappedRoundTripper http.RoundTripper
+	snapshot            *clientSnapshot
+	clock               clock.Clock
+	hooks               hookRunner
+	threshold           time.Duration
+	onSkew              ClockSkewFunc
+
+	mu     *sync.Mutex
+	skewed map[string]bool
+}
+
+func newClockSkewTransport(transport http.RoundTripper, snapshot *clientSnapshot, clk clock.Clock, hooks hookRunner, threshold time.Duration, onSkew ClockSkewFunc) clockSkewTransport {
+	return clockSkewTransport{
+		wrappedRoundTripper: transport,
+		snapshot:            snapshot,
+		clock:               clk,
+		hooks:               hooks,
+		threshold:           threshold,
+		onSkew:              onSkew,
+		mu:                  &sync.Mutex{},
+		skewed:              make(map[string]bool),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t clockSkewTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.wrappedRoundTripper.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+	skew, ok := ClockSkew(res, t.clock.Now())
+	if !ok {
+		return res, nil
+	}
+
+	host := req.URL.Host
+	exceeded := skew > t.threshold || skew < -t.threshold
+	t.mu.Lock()
+	report := exceeded && !t.skewed[host]
+	if exceeded {
+		t.skewed[host] = true
+	} else {
+		delete(t.skewed, host)
+	}
+	t.mu.Unlock()
+	if !report {
+		return res, nil
+	}
+
+	logger := t.snapshot.load().logger
+	logger.Errorf("clock skew of %s with %s exceeds %s, check the time is synchronized", skew, host, t.threshold)
+	if t.onSkew != nil {
+		t.hooks.run(logger, "clock skew callback", func() {
+			t.onSkew(host, skew)
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/juju/clock/testclock"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type skewSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&skewSuite{})
+
+var serverTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func (s *skewSuite) TestClockSkew(c *gc.C) {
+	resp := &http.Response{Header: http.Header{}}
+	_, ok := ClockSkew(resp, serverTime)
+	c.Assert(ok, jc.IsFalse)
+
+	resp.Header.Set("Date", serverTime.Format(http.TimeFormat))
+	skew, ok := ClockSkew(resp, serverTime.Add(-time.Minute))
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(skew, gc.Equals, time.Minute)
+
+	skew, ok = ClockSkew(resp, serverTime.Add(90*time.Second+500*time.Millisecond))
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(skew, gc.Equals, -90*time.Second)
+}
+
+func (s *skewSuite) TestClockSkewCheck(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Date", serverTime.Format(http.TimeFormat))
+	}))
+	defer server.Close()
+
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	logger := NewMockLogger(ctrl)
+	logger.EXPECT().IsTraceEnabled().Return(false).AnyTimes()
+	logger.EXPECT().Errorf("clock skew of %s with %s exceeds %s, check the time is synchronized",
+		gomock.Any(), gomock.Any(), time.Minute).Times(2)
+
+	type report struct {
+		host string
+		skew time.Duration
+	}
+	var reports []report
+	clk := testclock.NewClock(serverTime.Add(10 * time.Minute))
+	client := NewClient(
+		WithLogger(logger),
+		WithClock(clk),
+		WithClockSkewCheck(time.Minute, func(host string, skew time.Duration) {
+			reports = append(reports, report{host: host, skew: skew})
+		}),
+	)
+	get := func() {
+		resp, err := client.Get(context.TODO(), server.URL)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	}
+
+	get()
+	get()
+	host := server.Listener.Addr().String()
+	c.Assert(reports, jc.DeepEquals, []report{{host: host, skew: -10 * time.Minute}})
+
+	// Once the clock is back in sync, skew is reported again.
+	clk.Advance(-10 * time.Minute)
+	get()
+	clk.Advance(-5 * time.Minute)
+	get()
+	c.Assert(reports, jc.DeepEquals, []report{
+		{host: host, skew: -10 * time.Minute},
+		{host: host, skew: 5 * time.Minute},
+	})
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+)
+
+type skipVerifyKey struct{}
+
+// ContextWithSkipVerify returns a context that disables TLS certificate
+// verification for requests made with it, allowing a single request to be
+// made to an endpoint with a self-signed certificate without putting the
+// whole client into insecure mode. It only has an effect on clients created
+// with WithPerRequestSkipVerify, other clients verify as normal.
+func ContextWithSkipVerify(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipVerifyKey{}, true)
+}
+
+// SkipVerifyFromContext returns true if TLS certificate verification was
+// disabled for the context using ContextWithSkipVerify.
+func SkipVerifyFromContext(ctx context.Context) bool {
+	skip, _ := ctx.Value(skipVerifyKey{}).(bool)
+	return skip
+}
+
+// WithPerRequestSkipVerify allows TLS certificate verification to be
+// disabled for individual requests, using ContextWithSkipVerify. Every such
+// request is logged as an error. All verification is skipped, including that
+// of the pins of WithPinnedCertificates and WithPinnedSPKIHashes, and of
+// the CA certificates of WithCACertificateSource.
+func WithPerRequestSkipVerify(value bool) Option {
+	return func(opt *options) {
+		opt.perRequestSkipVerify = value
+	}
+}
+
+// skipVerifyTransport sends requests which have opted out of TLS
+// verification through a separate insecure transport.
+type skipVerifyTransport struct {
+	wrappedRoundTripper http.RoundTripper
+	insecure            http.RoundTripper
+	snapshot            *clientSnapshot
+}
+
+// newSkipVerifyTransport creates a skipVerifyTransport that sends verified
+// requests through wrapped, with the insecure transport cloned from the
+// given transport so that it shares the same settings and middlewares.
+func newSkipVerifyTransport(wrapped http.RoundTripper, transport *http.Transport, snapshot *clientSnapshot) skipVerifyTransport {
+	insecure := transport.Clone()
+	if insecure.TLSClientConfig == nil {
+		insecure.TLSClientConfig = &tls.Config{}
+	}
+	insecure.TLSClientConfig.InsecureSkipVerify = true
+	// The verification done in place of the chain verification, of the
+	// certificate pins or of a certificate source, is skipped too.
+	insecure.TLSClientConfig.VerifyConnection = nil
+	return skipVerifyTransport{
+		wrappedRoundTripper: wrapped,
+		insecure:            insecure,
+		snapshot:            snapshot,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t skipVerifyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !SkipVerifyFromContext(req.Context()) {
+		return t.wrappedRoundTripper.RoundTrip(req)
+	}
+	t.snapshot.load().logger.Errorf("INSECURE: skipping TLS certificate verification for %s %s", req.Method, req.URL)
+	return t.insecure.RoundTrip(req)
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type skipVerifySuite struct {
+	testing.IsolationSuite
+	server *httptest.Server
+}
+
+var _ = gc.Suite(&skipVerifySuite{})
+
+func (s *skipVerifySuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+}
+
+func (s *skipVerifySuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+	s.IsolationSuite.TearDownTest(c)
+}
+
+func (s *skipVerifySuite) TestContext(c *gc.C) {
+	ctx := context.Background()
+	c.Assert(SkipVerifyFromContext(ctx), jc.IsFalse)
+	c.Assert(SkipVerifyFromContext(ContextWithSkipVerify(ctx)), jc.IsTrue)
+}
+
+func (s *skipVerifySuite) TestSkipVerifyPerRequest(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	logger := NewMockLogger(ctrl)
+	logger.EXPECT().IsTraceEnabled().Return(false).AnyTimes()
+	logger.EXPECT().Errorf("INSECURE: skipping TLS certificate verification for %s %s", "GET", gomock.Any())
+
+	client := NewClient(WithPerRequestSkipVerify(true), WithLogger(logger))
+
+	_, err := client.Get(context.Background(), s.server.URL)
+	c.Assert(err, gc.ErrorMatches, `.*certificate.*`)
+
+	resp, err := client.Get(ContextWithSkipVerify(context.Background()), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+}
+
+func (s *skipVerifySuite) TestSkipVerifyNotAllowed(c *gc.C) {
+	client := NewClient()
+
+	_, err := client.Get(ContextWithSkipVerify(context.Background()), s.server.URL)
+	c.Assert(err, gc.ErrorMatches, `.*certificate.*`)
+}
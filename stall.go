@@ -0,0 +1,309 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// ErrStalled is matched, using errors.Is, by the error returned for a
+// transfer aborted because it stalled, see WithStallDetection.
+const ErrStalled = errors.ConstError("transfer stalled")
+
+// StalledError is returned by a read or write of a connection whose
+// throughput fell below the minimum of the client's StallPolicy.
+type StalledError struct {
+	// Address is the remote address of the connection.
+	Address string
+	// Bytes is the number of bytes transferred in the window.
+	Bytes int64
+	// Window is the period the throughput was measured over.
+	Window time.Duration
+	// MinThroughput is the minimum throughput, in bytes per second.
+	MinThroughput int64
+}
+
+// Error implements error.
+func (e *StalledError) Error() string {
+	return fmt.Sprintf("%s: %d bytes in %s from %s, below %d bytes/s", ErrStalled, e.Bytes, e.Window, e.Address, e.MinThroughput)
+}
+
+// Is returns true for ErrStalled.
+func (e *StalledError) Is(target error) bool {
+	return target == ErrStalled
+}
+
+// StallPolicy configures the detection of stalled transfers.
+type StallPolicy struct {
+	// MinThroughput is the minimum number of bytes per second a connection
+	// must transfer, measured over the window. If zero, only a connection
+	// that transfers nothing at all for a window is stalled.
+	MinThroughput int64
+
+	// Window is the period the throughput is measured over.
+	Window time.Duration
+}
+
+// Validate validates the StallPolicy for any issues.
+func (p StallPolicy) Validate() error {
+	if p.MinThroughput < 0 {
+		return errors.NotValidf("negative min throughput")
+	}
+	if p.Window <= 0 {
+		return errors.NotValidf("window %s", p.Window)
+	}
+	return nil
+}
+
+// minBytes returns the number of bytes to transfer in a window.
+func (p StallPolicy) minBytes() int64 {
+	return int64(float64(p.MinThroughput) * p.Window.Seconds())
+}
+
+// WithStallDetection aborts transfers that stall, such as a download from a
+// server that trickles a byte every few seconds, which never trips an
+// overall timeout sized for the largest transfer. While a request is
+// written, and while its response is read, read and write deadlines are
+// set on the connection at the end of each window of the policy, and a
+// connection whose throughput over the window is below the minimum fails
+// with a *StalledError. The wait for the response to be sent isn't
+// measured, see WithTimeout for that.
+//
+// Like WithResolver, it applies to the transport built by the client, not
+// to a base round tripper.
+func WithStallDetection(policy StallPolicy) Option {
+	return func(opt *options) {
+		opt.stallPolicy = &policy
+	}
+}
+
+// stallDialContext wraps the connections dialed to detect stalls.
+func stallDialContext(dial dialContextFunc, policy StallPolicy) dialContextFunc {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &stallConn{Conn: conn, policy: policy}, nil
+	}
+}
+
+// stallConn measures the throughput of a connection while requests are
+// active on it, failing reads and writes once it stalls.
+type stallConn struct {
+	net.Conn
+	policy StallPolicy
+
+	mu          sync.Mutex
+	active      int
+	windowStart time.Time
+	windowBytes int64
+	err         error
+}
+
+// begin starts measuring the throughput, if it wasn't already measured
+// for another request, as with HTTP/2.
+func (c *stallConn) begin() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active++
+	if c.active == 1 {
+		c.startWindow(time.Now())
+	}
+}
+
+// end stops measuring the throughput once no request is active, so that an
+// idle connection in the pool isn't stalled.
+func (c *stallConn) end() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active--
+	if c.active == 0 {
+		_ = c.Conn.SetDeadline(time.Time{})
+	}
+}
+
+// startWindow starts a window of the throughput, with a deadline at its end,
+// with the mutex held.
+func (c *stallConn) startWindow(now time.Time) {
+	c.windowStart = now
+	c.windowBytes = 0
+	_ = c.Conn.SetDeadline(now.Add(c.policy.Window))
+}
+
+// Read implements io.Reader.
+func (c *stallConn) Read(p []byte) (int, error) {
+	for {
+		n, err := c.Conn.Read(p)
+		if retry, err := c.transferred(n, err); !retry {
+			return n, err
+		}
+	}
+}
+
+// Write implements io.Writer.
+func (c *stallConn) Write(p []byte) (int, error) {
+	var written int
+	for {
+		n, err := c.Conn.Write(p[written:])
+		written += n
+		if retry, err := c.transferred(n, err); !retry {
+			return written, err
+		}
+	}
+}
+
+// transferred records the bytes transferred by a read or write, returning
+// a *StalledError if the window ended with too few of them. It returns true
+// if the read or write was interrupted by the deadline at the end of a
+// window with enough of them, to be retried.
+func (c *stallConn) transferred(n int, err error) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return false, c.err
+	}
+	if c.active == 0 {
+		return false, err
+	}
+	c.windowBytes += int64(n)
+	now := time.Now()
+	if now.Sub(c.windowStart) < c.policy.Window {
+		return false, err
+	}
+	if c.windowBytes == 0 || c.windowBytes < c.policy.minBytes() {
+		c.err = &StalledError{
+			Address:       c.Conn.RemoteAddr().String(),
+			Bytes:         c.windowBytes,
+			Window:        c.policy.Window,
+			MinThroughput: c.policy.MinThroughput,
+		}
+		return false, c.err
+	}
+	c.startWindow(now)
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout(), err
+}
+
+// findStallConn returns the stallConn of a connection of the transport,
+// which may be wrapped by a TLS connection, or nil.
+func findStallConn(conn net.Conn) *stallConn {
+	for {
+		switch c := conn.(type) {
+		case *stallConn:
+			return c
+		case *rawHeaderConn:
+			conn = c.Conn
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		default:
+			return nil
+		}
+	}
+}
+
+// stallTransport marks the connections of requests active while a request
+// is written and while its response is read.
+type stallTransport struct {
+	wrappedRoundTripper http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t stallTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	state := &stallRequest{}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			state.update(func() {
+				// The transport may retry the request on another
+				// connection.
+				state.conn = findStallConn(info.Conn)
+				state.wrote = false
+				state.reading = false
+			})
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			state.update(func() { state.wrote = true })
+		},
+		GotFirstResponseByte: func() {
+			state.update(func() { state.reading = true })
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	res, err := t.wrappedRoundTripper.RoundTrip(req)
+	if err != nil || res.Body == nil || res.Body == http.NoBody {
+		state.update(func() { state.done = true })
+		return res, err
+	}
+	res.Body = &stallBody{ReadCloser: res.Body, state: state}
+	return res, nil
+}
+
+// stallRequest is the state of a request, activating its connection while
+// the request is written and while the response is read.
+type stallRequest struct {
+	mu      sync.Mutex
+	conn    *stallConn
+	wrote   bool
+	reading bool
+	done    bool
+
+	// active is the connection activated for the request, if any.
+	active *stallConn
+}
+
+// update applies the change to the state, beginning or ending the
+// measurement of the throughput of the connection as the request becomes
+// active or inactive, or moves to another connection.
+func (r *stallRequest) update(change func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	change()
+	var active *stallConn
+	if !r.done && (!r.wrote || r.reading) {
+		active = r.conn
+	}
+	if active == r.active {
+		return
+	}
+	if r.active != nil {
+		r.active.end()
+	}
+	if active != nil {
+		active.begin()
+	}
+	r.active = active
+}
+
+// stallBody ends the request once the response body is read or closed.
+type stallBody struct {
+	io.ReadCloser
+	state *stallRequest
+}
+
+// Read implements io.Reader.
+func (b *stallBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.state.update(func() { b.state.done = true })
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (b *stallBody) Close() error {
+	b.state.update(func() { b.state.done = true })
+	return b.ReadCloser.Close()
+}
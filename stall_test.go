@@ -0,0 +1,147 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type stallSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&stallSuite{})
+
+var stallPolicy = StallPolicy{
+	MinThroughput: 1000,
+	Window:        100 * time.Millisecond,
+}
+
+// serveChunks returns a server that writes the chunks of the response body,
+// sleeping for the delay before each.
+func serveChunks(delay time.Duration, chunks ...string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		for _, chunk := range chunks {
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+			_, _ = io.WriteString(w, chunk)
+		}
+	}))
+	return server
+}
+
+func (s *stallSuite) TestTrickleStalls(c *gc.C) {
+	server := serveChunks(20*time.Millisecond, strings.Split(strings.Repeat("x", 50), "")...)
+	defer server.Close()
+
+	client := NewClient(WithStallDetection(stallPolicy))
+	resp, err := client.Get(context.Background(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	defer resp.Body.Close()
+	_, err = io.ReadAll(resp.Body)
+	c.Assert(err, jc.ErrorIs, ErrStalled)
+	c.Check(err, gc.ErrorMatches, `transfer stalled: \d+ bytes in 100ms from 127.0.0.1:\d+, below 1000 bytes/s`)
+	stalledErr, ok := errors.AsType[*StalledError](err)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(stalledErr.Bytes < 100, jc.IsTrue)
+	c.Check(stalledErr.Window, gc.Equals, 100*time.Millisecond)
+	c.Check(stalledErr.MinThroughput, gc.Equals, int64(1000))
+}
+
+func (s *stallSuite) TestSilenceStalls(c *gc.C) {
+	server := serveChunks(time.Second, "x")
+	defer server.Close()
+
+	client := NewClient(WithStallDetection(StallPolicy{Window: 100 * time.Millisecond}))
+	resp, err := client.Get(context.Background(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	defer resp.Body.Close()
+	_, err = io.ReadAll(resp.Body)
+	c.Check(err, jc.ErrorIs, ErrStalled)
+}
+
+func (s *stallSuite) TestSteadyTransfer(c *gc.C) {
+	chunk := strings.Repeat("x", 10000)
+	server := serveChunks(60*time.Millisecond, chunk, chunk, chunk, chunk, chunk)
+	defer server.Close()
+
+	client := NewClient(WithStallDetection(stallPolicy))
+	resp, err := client.Get(context.Background(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(body, gc.HasLen, 50000)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+}
+
+func (s *stallSuite) TestWaitForResponseNotMeasured(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	client := NewClient(WithStallDetection(stallPolicy))
+	resp, err := client.Get(context.Background(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(body), gc.Equals, "ok")
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+}
+
+func (s *stallSuite) TestIdleConnectionNotStalled(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	client := NewClient(WithStallDetection(stallPolicy))
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(context.Background(), server.URL)
+		c.Assert(err, jc.ErrorIsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+		time.Sleep(300 * time.Millisecond)
+	}
+	c.Check(client.Stats().ConnectionsOpened, gc.Equals, int64(1))
+	c.Check(client.Stats().ConnectionsOpen, gc.Equals, int64(1))
+}
+
+func (s *stallSuite) TestValidate(c *gc.C) {
+	c.Check(stallPolicy.Validate(), jc.ErrorIsNil)
+	c.Check(StallPolicy{Window: time.Second, MinThroughput: -1}.Validate(), gc.ErrorMatches, `negative min throughput not valid`)
+	c.Check(StallPolicy{}.Validate(), gc.ErrorMatches, `window 0s not valid`)
+
+	opts := newOptions()
+	WithStallDetection(StallPolicy{})(opts)
+	c.Check(opts.validate(), gc.ErrorMatches, `stall policy: window 0s not valid`)
+}
+
+func (s *stallSuite) TestWithBaseRoundTripper(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	opts := newOptions()
+	WithBaseRoundTripper(NewMockRoundTripper(ctrl))(opts)
+	WithStallDetection(stallPolicy)(opts)
+	c.Check(opts.validate(), gc.ErrorMatches, `stall detection with a base round tripper that is not an \*http.Transport not valid`)
+}
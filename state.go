@@ -0,0 +1,60 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/juju/errors"
+	"github.com/juju/loggo/v2"
+)
+
+// clientState holds the parts of the client configuration that can be
+// changed after the client has been constructed.
+type clientState struct {
+	logger      Logger
+	recorder    RequestRecorder
+	retryPolicy RetryPolicy
+	sampling    *SamplingPolicy
+}
+
+// clientSnapshot provides race free access to the clientState. Readers load
+// an immutable snapshot of the state, writers replace the snapshot as a
+// whole, so a request in flight always sees a consistent configuration.
+type clientSnapshot struct {
+	mu    sync.Mutex
+	value atomic.Value
+}
+
+func newClientSnapshot(state clientState) *clientSnapshot {
+	s := &clientSnapshot{}
+	s.value.Store(state)
+	return s
+}
+
+// load returns the current state, or the default state of a client not
+// built by NewClient.
+func (s *clientSnapshot) load() clientState {
+	if s == nil {
+		return clientState{logger: loggo.GetLogger("http")}
+	}
+	return s.value.Load().(clientState)
+}
+
+// update applies the given function to a copy of the current state and
+// stores the result as the new state. A client not built by NewClient has
+// no state to update, so a NotValid error is returned.
+func (s *clientSnapshot) update(fn func(*clientState)) error {
+	if s == nil {
+		return errors.NotValidf("updating client not built by NewClient")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.load()
+	fn(&state)
+	s.value.Store(state)
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/loggo/v2"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"go.uber.org/mock/gomock"
+	gc "gopkg.in/check.v1"
+)
+
+type stateSuite struct {
+	testing.IsolationSuite
+	server *httptest.Server
+}
+
+var _ = gc.Suite(&stateSuite{})
+
+func (s *stateSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+}
+
+func (s *stateSuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+	s.IsolationSuite.TearDownTest(c)
+}
+
+func (s *stateSuite) TestSetRequestRecorder(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	first := NewMockRequestRecorder(ctrl)
+	first.EXPECT().Record("GET", gomock.Any(), gomock.Any(), gomock.Any())
+	second := NewMockRequestRecorder(ctrl)
+	second.EXPECT().Record("GET", gomock.Any(), gomock.Any(), gomock.Any())
+
+	client := NewClient(WithRequestRecorder(first))
+	resp, err := client.Get(context.TODO(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+
+	err = client.SetRequestRecorder(second)
+	c.Assert(err, jc.ErrorIsNil)
+	resp, err = client.Get(context.TODO(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+
+	// A nil recorder disables recording.
+	err = client.SetRequestRecorder(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	resp, err = client.Get(context.TODO(), s.server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+}
+
+func (s *stateSuite) TestSetNotSupported(c *gc.C) {
+	client := NewClient()
+	err := client.SetRequestRecorder(nil)
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+	err = client.SetRetryPolicy(RetryPolicy{Attempts: 1, Delay: time.Second, MaxDelay: time.Second})
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+}
+
+func (s *stateSuite) TestSetWithoutNewClient(c *gc.C) {
+	client := &Client{}
+	client.SetLogger(loggo.GetLogger("juju.http"))
+	err := client.SetRequestSampling(nil)
+	c.Assert(err, gc.ErrorMatches, `updating client not built by NewClient not valid`)
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+	err = client.SetRequestRecorder(nil)
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+}
+
+func (s *stateSuite) TestSetRetryPolicyValidates(c *gc.C) {
+	client := NewClient(WithRequestRetrier(RetryPolicy{Attempts: 1, Delay: time.Second, MaxDelay: time.Second}))
+	err := client.SetRetryPolicy(RetryPolicy{})
+	c.Assert(err, gc.ErrorMatches, `expected at least one attempt`)
+}
+
+// TestConcurrentReconfigure is intended to be run with the race detector
+// enabled, as done by the Makefile.
+func (s *stateSuite) TestConcurrentReconfigure(c *gc.C) {
+	ctrl := gomock.NewController(c)
+	defer ctrl.Finish()
+
+	recorder := NewMockRequestRecorder(ctrl)
+	recorder.EXPECT().Record(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+
+	client := NewClient(
+		WithRequestRecorder(recorder),
+		WithRequestRetrier(RetryPolicy{Attempts: 1, Delay: time.Second, MaxDelay: time.Second}),
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(context.TODO(), s.server.URL)
+			c.Check(err, jc.ErrorIsNil)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			client.SetLogger(loggo.GetLogger("http.test"))
+			c.Check(client.SetRequestRecorder(recorder), jc.ErrorIsNil)
+			c.Check(client.SetRetryPolicy(RetryPolicy{Attempts: 2, Delay: time.Second, MaxDelay: time.Second}), jc.ErrorIsNil)
+		}()
+	}
+	wg.Wait()
+	c.Assert(client.Stats().Requests, gc.Equals, int64(4))
+}
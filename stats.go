@@ -0,0 +1,154 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"expvar"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/juju/errors"
+)
+
+// Stats holds the counters describing the requests made through a Client.
+type Stats struct {
+	// Requests is the number of requests made through the client.
+	Requests int64
+	// Errors is the number of requests that returned an error.
+	Errors int64
+	// Retries is the number of retry attempts made by the retry middleware.
+	Retries int64
+	// ConnectionsOpened is the number of connections dialed by the client.
+	ConnectionsOpened int64
+	// ConnectionsOpen is the number of connections dialed by the client that
+	// are currently open, whether in use or idle in the pool.
+	ConnectionsOpen int64
+	// RetryBudget is the state of the retry budget, if the client's retry
+	// policy has one.
+	RetryBudget *RetryBudgetStats
+}
+
+// clientStats holds the live counters of a Client.
+type clientStats struct {
+	requests    int64
+	errors      int64
+	retries     int64
+	connsOpened int64
+	connsOpen   int64
+}
+
+func (s *clientStats) recordRequest(err error) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.requests, 1)
+	if err != nil {
+		atomic.AddInt64(&s.errors, 1)
+	}
+}
+
+func (s *clientStats) recordRetry() {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.retries, 1)
+}
+
+func (s *clientStats) snapshot() Stats {
+	if s == nil {
+		return Stats{}
+	}
+	return Stats{
+		Requests:          atomic.LoadInt64(&s.requests),
+		Errors:            atomic.LoadInt64(&s.errors),
+		Retries:           atomic.LoadInt64(&s.retries),
+		ConnectionsOpened: atomic.LoadInt64(&s.connsOpened),
+		ConnectionsOpen:   atomic.LoadInt64(&s.connsOpen),
+	}
+}
+
+// resetCounters returns the counters, resetting them to zero. Gauges, such
+// as the number of open connections, are returned but not reset.
+func (s *clientStats) resetCounters() Stats {
+	if s == nil {
+		return Stats{}
+	}
+	return Stats{
+		Requests:          atomic.SwapInt64(&s.requests, 0),
+		Errors:            atomic.SwapInt64(&s.errors, 0),
+		Retries:           atomic.SwapInt64(&s.retries, 0),
+		ConnectionsOpened: atomic.SwapInt64(&s.connsOpened, 0),
+		ConnectionsOpen:   atomic.LoadInt64(&s.connsOpen),
+	}
+}
+
+// Sub returns the change in the counters since the previous snapshot, for
+// polling collectors that need deltas. Gauges, such as ConnectionsOpen and
+// RetryBudget, are taken from s as is.
+func (s Stats) Sub(previous Stats) Stats {
+	return Stats{
+		Requests:          s.Requests - previous.Requests,
+		Errors:            s.Errors - previous.Errors,
+		Retries:           s.Retries - previous.Retries,
+		ConnectionsOpened: s.ConnectionsOpened - previous.ConnectionsOpened,
+		ConnectionsOpen:   s.ConnectionsOpen,
+		RetryBudget:       s.RetryBudget,
+	}
+}
+
+type dialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// countingDialContext wraps the dial function so that connections are counted
+// in the stats.
+func (s *clientStats) countingDialContext(dial dialContextFunc) dialContextFunc {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		atomic.AddInt64(&s.connsOpened, 1)
+		atomic.AddInt64(&s.connsOpen, 1)
+		return &countedConn{Conn: conn, stats: s}, nil
+	}
+}
+
+// countedConn decrements the open connection count when closed.
+type countedConn struct {
+	net.Conn
+	stats *clientStats
+	once  sync.Once
+}
+
+// Close implements net.Conn.
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		atomic.AddInt64(&c.stats.connsOpen, -1)
+	})
+	return c.Conn.Close()
+}
+
+// expvarMutex serializes publishing, as expvar panics on duplicate names.
+var expvarMutex sync.Mutex
+
+// PublishExpvar publishes the client statistics as an expvar variable with the
+// given name, allowing them to be inspected without a metrics stack. The
+// value is a JSON object with the fields of Stats. An AlreadyExists error is
+// returned if the name is already in use.
+func (c *Client) PublishExpvar(name string) error {
+	expvarMutex.Lock()
+	defer expvarMutex.Unlock()
+
+	if expvar.Get(name) != nil {
+		return errors.AlreadyExistsf("expvar %q", name)
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return c.Stats()
+	}))
+	return nil
+}
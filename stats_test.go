@@ -0,0 +1,124 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type statsSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&statsSuite{})
+
+func (s *statsSuite) TestConnectionStats(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := NewClient()
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(context.TODO(), server.URL)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	}
+	_, err := client.Get(context.TODO(), "btc://secret/wallet")
+	c.Assert(err, gc.NotNil)
+
+	stats := client.Stats()
+	c.Assert(stats.Requests, gc.Equals, int64(4))
+	c.Assert(stats.Errors, gc.Equals, int64(1))
+	// The connection is reused from the pool.
+	c.Assert(stats.ConnectionsOpened, gc.Equals, int64(1))
+	c.Assert(stats.ConnectionsOpen, gc.Equals, int64(1))
+
+	client.Client().CloseIdleConnections()
+	c.Assert(client.Stats().ConnectionsOpen, gc.Equals, int64(0))
+}
+
+func (s *statsSuite) TestSnapshotAndReset(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := NewClient()
+	get := func() {
+		resp, err := client.Get(context.TODO(), server.URL)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	}
+	get()
+	get()
+
+	stats := client.SnapshotAndReset()
+	c.Assert(stats.Requests, gc.Equals, int64(2))
+	c.Assert(stats.ConnectionsOpened, gc.Equals, int64(1))
+	c.Assert(stats.ConnectionsOpen, gc.Equals, int64(1))
+
+	get()
+	stats = client.SnapshotAndReset()
+	c.Assert(stats.Requests, gc.Equals, int64(1))
+	c.Assert(stats.ConnectionsOpened, gc.Equals, int64(0))
+	// The gauge is not reset.
+	c.Assert(stats.ConnectionsOpen, gc.Equals, int64(1))
+}
+
+func (s *statsSuite) TestSub(c *gc.C) {
+	budget := &RetryBudgetStats{Available: 3}
+	previous := Stats{
+		Requests:          10,
+		Errors:            2,
+		Retries:           1,
+		ConnectionsOpened: 3,
+		ConnectionsOpen:   3,
+	}
+	current := Stats{
+		Requests:          15,
+		Errors:            3,
+		Retries:           4,
+		ConnectionsOpened: 4,
+		ConnectionsOpen:   1,
+		RetryBudget:       budget,
+	}
+	c.Assert(current.Sub(previous), jc.DeepEquals, Stats{
+		Requests:          5,
+		Errors:            1,
+		Retries:           3,
+		ConnectionsOpened: 1,
+		ConnectionsOpen:   1,
+		RetryBudget:       budget,
+	})
+}
+
+var expvarRuns int
+
+func (s *statsSuite) TestPublishExpvar(c *gc.C) {
+	client := NewClient()
+	_, err := client.Get(context.TODO(), "btc://secret/wallet")
+	c.Assert(err, gc.NotNil)
+
+	// Expvars can't be unpublished, so use a unique name for each run.
+	expvarRuns++
+	name := fmt.Sprintf("juju.http.test.%d", expvarRuns)
+
+	err = client.PublishExpvar(name)
+	c.Assert(err, jc.ErrorIsNil)
+	err = client.PublishExpvar(name)
+	c.Assert(err, jc.Satisfies, errors.IsAlreadyExists)
+
+	var stats Stats
+	err = json.Unmarshal([]byte(expvar.Get(name).String()), &stats)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(stats.Requests, gc.Equals, int64(1))
+	c.Assert(stats.Errors, gc.Equals, int64(1))
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package testhelpers provides helpers for testing code that uses the juju
+// http client.
+package testhelpers
+
+import (
+	"time"
+
+	"github.com/juju/clock/testclock"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	jujuhttp "github.com/juju/http/v2"
+)
+
+// Clock is a test clock for driving the time dependent parts of a client,
+// configured with WithClock, deterministically.
+type Clock struct {
+	*testclock.Clock
+}
+
+// NewClock returns a new Clock set to the given time.
+func NewClock(now time.Time) *Clock {
+	return &Clock{
+		Clock: testclock.NewClock(now),
+	}
+}
+
+// WaitAdvance waits for n timers to be waiting on the clock, then advances
+// it by d. The test fails if the timers don't show up within
+// testing.LongWait.
+func (c *Clock) WaitAdvance(t *gc.C, d time.Duration, n int) {
+	err := c.Clock.WaitAdvance(d, testing.LongWait, n)
+	t.Assert(err, jc.ErrorIsNil)
+}
+
+// AutoAdvancing returns a clock that shares the time of this Clock, but
+// advances it by the requested duration whenever After or AfterFunc is
+// called. Retry backoff, timeouts and other waits using the returned clock
+// complete immediately, while Now still reflects the elapsed virtual time.
+func (c *Clock) AutoAdvancing() *testclock.AutoAdvancingClock {
+	return &testclock.AutoAdvancingClock{
+		Clock:   c.Clock,
+		Advance: c.Clock.Advance,
+	}
+}
+
+// retryPollInterval is how often AdvanceThroughRetries checks for the
+// retry middleware waiting on the clock, or the request completing.
+const retryPollInterval = 10 * time.Millisecond
+
+// AdvanceThroughRetries drives a request made with the retry policy through
+// its retries, waiting for the retry middleware to wait on the clock before
+// each retry, then advancing the clock by the delay of the retry, as given
+// by RetryPolicy.RetryDelays. It stops once done is closed, so that a
+// request that succeeds before all of its retries, for example against a
+// testhelpers.Flaky server, ends it early. It returns the number of
+// retries it advanced through. The test fails if the middleware doesn't
+// wait on the clock within testing.LongWait.
+//
+// The clock must be used by nothing but the retry middleware. Retry-After
+// headers aren't followed. For a random BackoffFunc, such as
+// ExponentialWithJitter, pass the policy with ExponentialBackoff in its
+// place, whose delays bound those of the jitter.
+func (c *Clock) AdvanceThroughRetries(t *gc.C, policy jujuhttp.RetryPolicy, done <-chan struct{}) int {
+	delays := policy.RetryDelays()
+	for i, delay := range delays {
+		timeout := time.After(testing.LongWait)
+		for {
+			if c.Clock.WaitAdvance(delay, 0, 1) == nil {
+				break
+			}
+			select {
+			case <-done:
+				return i
+			case <-timeout:
+				t.Fatalf("retry %d not waiting on the clock after %s", i+1, testing.LongWait)
+			case <-time.After(retryPollInterval):
+			}
+		}
+	}
+	return len(delays)
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package testhelpers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	jujuhttp "github.com/juju/http/v2"
+	"github.com/juju/http/v2/testhelpers"
+)
+
+type clockSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&clockSuite{})
+
+func (s *clockSuite) TestAutoAdvancingRetries(c *gc.C) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := testhelpers.NewClock(start)
+	client := jujuhttp.NewClient(
+		jujuhttp.WithClock(clk.AutoAdvancing()),
+		jujuhttp.WithRequestRetrier(jujuhttp.RetryPolicy{
+			Attempts: 3,
+			Delay:    time.Hour,
+			MaxDelay: 2 * time.Hour,
+		}),
+	)
+	resp, err := client.Get(context.TODO(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(clk.Now().Sub(start), gc.Equals, 2*time.Hour)
+}
+
+func (s *clockSuite) TestWaitAdvance(c *gc.C) {
+	clk := testhelpers.NewClock(time.Now())
+	ch := make(chan struct{})
+	go func() {
+		<-clk.After(time.Minute)
+		close(ch)
+	}()
+	clk.WaitAdvance(c, time.Minute, 1)
+	select {
+	case <-ch:
+	case <-time.After(testing.LongWait):
+		c.Fatalf("timed out waiting for clock")
+	}
+}
+
+func (s *clockSuite) TestAdvanceThroughRetries(c *gc.C) {
+	server := httptest.NewServer(testhelpers.Flaky(10, http.StatusServiceUnavailable, testhelpers.OK))
+	defer server.Close()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := testhelpers.NewClock(start)
+	policy := jujuhttp.RetryPolicy{
+		Attempts:    4,
+		Delay:       time.Minute,
+		MaxDelay:    time.Hour,
+		BackoffFunc: jujuhttp.ExponentialBackoff(time.Minute, time.Hour),
+	}
+	client := jujuhttp.NewClient(
+		jujuhttp.WithClock(clk),
+		jujuhttp.WithRequestRetrier(policy),
+	)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := client.Get(context.TODO(), server.URL)
+		c.Check(err, gc.ErrorMatches, `.*attempt count exceeded.*`)
+	}()
+	retries := clk.AdvanceThroughRetries(c, policy, done)
+	<-done
+	c.Check(retries, gc.Equals, 3)
+	c.Check(clk.Now().Sub(start), gc.Equals, 7*time.Minute)
+}
+
+func (s *clockSuite) TestAdvanceThroughRetriesSucceedsEarly(c *gc.C) {
+	server := httptest.NewServer(testhelpers.Flaky(1, http.StatusServiceUnavailable, testhelpers.OK))
+	defer server.Close()
+
+	clk := testhelpers.NewClock(time.Now())
+	policy := jujuhttp.RetryPolicy{
+		Attempts: 4,
+		Delay:    time.Minute,
+		MaxDelay: time.Hour,
+	}
+	client := jujuhttp.NewClient(
+		jujuhttp.WithClock(clk),
+		jujuhttp.WithRequestRetrier(policy),
+	)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := client.Get(context.TODO(), server.URL)
+		c.Check(err, jc.ErrorIsNil)
+		c.Check(resp.StatusCode, gc.Equals, http.StatusOK)
+		_ = resp.Body.Close()
+	}()
+	c.Check(clk.AdvanceThroughRetries(c, policy, done), gc.Equals, 1)
+}
@@ -0,0 +1,147 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package testhelpers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The handlers in this file reproduce common server behaviours, so that
+// code using the juju http client can be tested against realistic server
+// quirks. They can be composed, for example:
+//
+//	server := httptest.NewServer(
+//		testhelpers.Flaky(2, http.StatusBadGateway,
+//			testhelpers.RangeContent("charm.zip", time.Now(), data)))
+
+// OK is a handler that responds with 200 OK and an empty body.
+var OK = http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+// Counter wraps a handler, counting the requests it receives.
+type Counter struct {
+	handler http.Handler
+	count   int64
+}
+
+// NewCounter returns a Counter wrapping the given handler.
+func NewCounter(next http.Handler) *Counter {
+	return &Counter{handler: next}
+}
+
+// ServeHTTP implements http.Handler.
+func (c *Counter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&c.count, 1)
+	c.handler.ServeHTTP(w, r)
+}
+
+// Count returns the number of requests received.
+func (c *Counter) Count() int {
+	return int(atomic.LoadInt64(&c.count))
+}
+
+// Flaky returns a handler that fails the first failures requests with the
+// given status code, then delegates to next.
+func Flaky(failures int, status int, next http.Handler) http.Handler {
+	var count int64
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&count, 1) <= int64(failures) {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimited returns a handler that allows limit requests per window,
+// responding to any further requests with 429 Too Many Requests and a
+// Retry-After header giving the number of seconds until the window resets.
+func RateLimited(limit int, window time.Duration, next http.Handler) http.Handler {
+	var (
+		mu    sync.Mutex
+		start time.Time
+		count int
+	)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		now := time.Now()
+		if now.Sub(start) >= window {
+			start, count = now, 0
+		}
+		count++
+		allowed := count <= limit
+		reset := start.Add(window).Sub(now)
+		mu.Unlock()
+
+		if !allowed {
+			seconds := int(reset.Round(time.Second) / time.Second)
+			if seconds < 1 {
+				seconds = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SlowHeaders returns a handler that waits for delay before delegating to
+// next, so that response headers are delayed. The wait is abandoned if the
+// client goes away.
+func SlowHeaders(delay time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RedirectChain returns a handler that redirects requests for "/" through
+// hops intermediate redirects, "/redirect/<n>", before finally redirecting
+// to target with the given status code.
+func RedirectChain(hops int, status int, target string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remaining := hops
+		if r.URL.Path != "/" {
+			var n int
+			if _, err := fmt.Sscanf(r.URL.Path, "/redirect/%d", &n); err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			remaining = n
+		}
+		if remaining <= 0 {
+			http.Redirect(w, r, target, status)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/redirect/%d", remaining-1), status)
+	})
+}
+
+// RangeContent returns a handler serving content with support for Range,
+// If-Range and conditional requests, and a strong ETag derived from the
+// content length and modification time.
+func RangeContent(name string, modtime time.Time, content []byte) http.Handler {
+	etag := fmt.Sprintf(`"%x-%x"`, modtime.UnixNano(), len(content))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, name, modtime, bytes.NewReader(content))
+	})
+}
+
+// NewServer starts a httptest.Server with the given handler. The caller is
+// responsible for closing the server.
+func NewServer(handler http.Handler) *httptest.Server {
+	return httptest.NewServer(handler)
+}
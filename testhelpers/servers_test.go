@@ -0,0 +1,101 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package testhelpers_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	jujuhttp "github.com/juju/http/v2"
+	"github.com/juju/http/v2/testhelpers"
+)
+
+type serversSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&serversSuite{})
+
+func (s *serversSuite) get(c *gc.C, client *jujuhttp.Client, url string) *http.Response {
+	resp, err := client.Get(context.TODO(), url)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	return resp
+}
+
+func (s *serversSuite) TestFlakyWithRetries(c *gc.C) {
+	counter := testhelpers.NewCounter(testhelpers.Flaky(2, http.StatusBadGateway, testhelpers.OK))
+	server := testhelpers.NewServer(counter)
+	defer server.Close()
+
+	client := jujuhttp.NewClient(jujuhttp.WithRequestRetrier(jujuhttp.RetryPolicy{
+		Attempts: 3,
+		Delay:    time.Millisecond,
+		MaxDelay: time.Second,
+	}))
+	resp := s.get(c, client, server.URL)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(counter.Count(), gc.Equals, 3)
+}
+
+func (s *serversSuite) TestRateLimited(c *gc.C) {
+	server := testhelpers.NewServer(testhelpers.RateLimited(1, time.Minute, testhelpers.OK))
+	defer server.Close()
+
+	client := jujuhttp.NewClient()
+	c.Assert(s.get(c, client, server.URL).StatusCode, gc.Equals, http.StatusOK)
+	resp := s.get(c, client, server.URL)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusTooManyRequests)
+	c.Assert(resp.Header.Get("Retry-After"), gc.Equals, "60")
+}
+
+func (s *serversSuite) TestSlowHeaders(c *gc.C) {
+	server := testhelpers.NewServer(testhelpers.SlowHeaders(time.Minute, testhelpers.OK))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := jujuhttp.NewClient().Get(ctx, server.URL)
+	c.Assert(err, gc.ErrorMatches, `.*context deadline exceeded.*`)
+}
+
+func (s *serversSuite) TestRedirectChain(c *gc.C) {
+	counter := testhelpers.NewCounter(testhelpers.RedirectChain(3, http.StatusFound, "/redirect/-1"))
+	server := testhelpers.NewServer(counter)
+	defer server.Close()
+
+	client := jujuhttp.NewClient()
+	client.Client().CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.URL.Path == "/redirect/-1" {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+	resp := s.get(c, client, server.URL)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusFound)
+	c.Assert(counter.Count(), gc.Equals, 4)
+}
+
+func (s *serversSuite) TestRangeContent(c *gc.C) {
+	server := testhelpers.NewServer(testhelpers.RangeContent("blob", time.Now(), []byte("0123456789")))
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	req.Header.Set("Range", "bytes=4-")
+	resp, err := jujuhttp.NewClient().Do(req)
+	c.Assert(err, jc.ErrorIsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusPartialContent)
+	c.Assert(resp.Header.Get("ETag"), gc.Not(gc.Equals), "")
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(body), gc.Equals, "456789")
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// WithTimeout sets the time limit of every request made by the client, as
+// http.Client.Timeout does. It includes connecting, any retries and
+// redirects, and reading the response body. A zero timeout, the default,
+// leaves requests without a time limit, other than that of their context.
+func WithTimeout(value time.Duration) Option {
+	return func(opt *options) {
+		opt.timeout = value
+	}
+}
+
+// DoWithTimeout sends an HTTP request, as Do does, failing it if it, and
+// the reading of its response body, do not complete within the timeout.
+// The timeout applies in addition to any deadline of the context of the
+// request and to the client's timeout, the earliest of which wins. A zero
+// timeout sends the request without a time limit of its own.
+//
+// The error of a request that timed out satisfies
+// errors.Is(err, context.DeadlineExceeded).
+func (c *Client) DoWithTimeout(req *http.Request, timeout time.Duration) (*http.Response, error) {
+	if timeout <= 0 {
+		return c.Do(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := c.Do(req.WithContext(ctx))
+	return withTimeoutBody(resp, err, cancel)
+}
+
+// GetWithTimeout issues a GET to the specified URL, as Get does, within the
+// timeout, see DoWithTimeout.
+//
+// When err is nil, resp always contains a non-nil resp.Body.
+// Caller should close resp.Body when done reading from it.
+func (c *Client) GetWithTimeout(ctx context.Context, path string, timeout time.Duration) (*http.Response, error) {
+	if timeout <= 0 {
+		return c.Get(ctx, path)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	resp, err := c.Get(ctx, path)
+	return withTimeoutBody(resp, err, cancel)
+}
+
+// withTimeoutBody releases the timeout of a request once its response body
+// is closed, or at once if there is no body to read.
+func withTimeoutBody(resp *http.Response, err error, cancel context.CancelFunc) (*http.Response, error) {
+	if err != nil || resp.Body == nil {
+		cancel()
+		return resp, err
+	}
+	resp.Body = &timeoutBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// timeoutBody releases the timeout of a request once its response body is
+// closed.
+type timeoutBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close implements io.Closer.
+func (b *timeoutBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
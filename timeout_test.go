@@ -0,0 +1,114 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type timeoutSuite struct {
+	testing.IsolationSuite
+
+	server  *httptest.Server
+	release chan struct{}
+}
+
+var _ = gc.Suite(&timeoutSuite{})
+
+func (s *timeoutSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.release = make(chan struct{})
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-s.release:
+			case <-r.Context().Done():
+			}
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+}
+
+func (s *timeoutSuite) TearDownTest(c *gc.C) {
+	close(s.release)
+	s.server.Close()
+	s.IsolationSuite.TearDownTest(c)
+}
+
+func (s *timeoutSuite) TestWithTimeout(c *gc.C) {
+	client := NewClient(WithTimeout(10 * time.Millisecond))
+	c.Check(client.Client().Timeout, gc.Equals, 10*time.Millisecond)
+
+	_, err := client.Get(context.Background(), s.server.URL+"/slow")
+	c.Assert(err, gc.NotNil)
+	c.Check(ClassifyError(err), gc.Equals, FaultTimeout)
+}
+
+func (s *timeoutSuite) TestWithTimeoutNegative(c *gc.C) {
+	client := NewClient(WithTimeout(-time.Second))
+	_, err := client.Get(context.Background(), s.server.URL)
+	c.Check(err, gc.ErrorMatches, `.*negative timeout not valid`)
+}
+
+func (s *timeoutSuite) TestGetWithTimeout(c *gc.C) {
+	client := NewClient()
+
+	resp, err := client.GetWithTimeout(context.Background(), s.server.URL, time.Minute)
+	c.Assert(err, jc.ErrorIsNil)
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(body), gc.Equals, "ok")
+	c.Check(resp.Body.Close(), jc.ErrorIsNil)
+
+	_, err = client.GetWithTimeout(context.Background(), s.server.URL+"/slow", 10*time.Millisecond)
+	c.Check(errors.Is(err, context.DeadlineExceeded), jc.IsTrue)
+}
+
+func (s *timeoutSuite) TestDoWithTimeout(c *gc.C) {
+	client := NewClient()
+
+	req, err := http.NewRequest(http.MethodGet, s.server.URL+"/slow", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = client.DoWithTimeout(req, 10*time.Millisecond)
+	c.Check(errors.Is(err, context.DeadlineExceeded), jc.IsTrue)
+
+	// The timeout is distinct from the context, which is left untouched.
+	c.Check(req.Context().Err(), jc.ErrorIsNil)
+}
+
+func (s *timeoutSuite) TestDoWithTimeoutCoversBody(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	resp, err := NewClient().DoWithTimeout(req, 50*time.Millisecond)
+	c.Assert(err, jc.ErrorIsNil)
+	defer resp.Body.Close()
+
+	_, err = io.ReadAll(resp.Body)
+	c.Check(errors.Is(err, context.DeadlineExceeded), jc.IsTrue)
+}
+
+func (s *timeoutSuite) TestDoWithoutTimeout(c *gc.C) {
+	req, err := http.NewRequest(http.MethodGet, s.server.URL, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	resp, err := NewClient().DoWithTimeout(req, 0)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(resp.Body, gc.Not(gc.FitsTypeOf), &timeoutBody{})
+	c.Check(resp.Body.Close(), jc.ErrorIsNil)
+}
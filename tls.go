@@ -10,6 +10,9 @@ import (
 )
 
 // TransportMiddleware represents a way to add an adapter to the existing transport.
+// A middleware may modify the transport it is given, which belongs to the
+// client being built, or return another transport, which the client clones
+// so that it is never shared with other clients.
 type TransportMiddleware func(*http.Transport) *http.Transport
 
 // TransportConfig holds the configurable values for setting up a http
@@ -18,6 +21,7 @@ type TransportConfig struct {
 	TLSConfig           *tls.Config
 	DisableKeepAlives   bool
 	TLSHandshakeTimeout time.Duration
+	ConnectionPool      ConnectionPoolConfig
 	Middlewares         []TransportMiddleware
 }
 
@@ -29,8 +33,20 @@ func NewHTTPTLSTransport(config TransportConfig) *http.Transport {
 		DisableKeepAlives:   config.DisableKeepAlives,
 		TLSHandshakeTimeout: config.TLSHandshakeTimeout,
 	}
-	for _, middlewareFn := range config.Middlewares {
-		transport = middlewareFn(transport)
+	config.ConnectionPool.apply(transport)
+	return applyTransportMiddlewares(transport, config.Middlewares)
+}
+
+// applyTransportMiddlewares applies the middlewares, in order, to a
+// transport owned by the caller. A middleware may modify the transport it is
+// given, or return another one, which is cloned in case it is shared, such
+// as a package level transport. The transport returned is then owned by the
+// caller alone, and safe to modify.
+func applyTransportMiddlewares(transport *http.Transport, middlewares []TransportMiddleware) *http.Transport {
+	for _, middleware := range middlewares {
+		if result := middleware(transport); result != transport {
+			transport = result.Clone()
+		}
 	}
 	return transport
 }
@@ -90,3 +106,14 @@ func SecureTLSConfig() *tls.Config {
 		MinVersion:   tls.VersionTLS12,
 	}
 }
+
+// isSecureCipherSuite returns true if the cipher suite is implemented by the
+// crypto/tls package and is not considered insecure.
+func isSecureCipherSuite(id uint16) bool {
+	for _, suite := range tls.CipherSuites() {
+		if suite.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/juju/errors"
+)
+
+// BodyFunc returns a new reader of a request body from its start, such as
+// a freshly opened file. It is called once for each attempt of a request,
+// so that an upload can be retried.
+type BodyFunc func() (io.ReadCloser, error)
+
+// ReaderAtBody returns a BodyFunc reading the size bytes of r, such as an
+// *os.File, from the start for each attempt.
+func ReaderAtBody(r io.ReaderAt, size int64) BodyFunc {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(io.NewSectionReader(r, 0, size)), nil
+	}
+}
+
+// PostBody issues a POST to the specified URL, with the body returned by
+// the BodyFunc, of the size given, or -1 if unknown, and the content type
+// given. An empty content type leaves the Content-Type header unset.
+//
+// Unlike a body passed to Post, which is consumed by the first attempt,
+// the body is streamed afresh for every attempt, so that the client's
+// RetryPolicy can safely retry the upload.
+//
+// When err is nil, resp always contains a non-nil resp.Body.
+// Caller should close resp.Body when done reading from it.
+func (c *Client) PostBody(ctx context.Context, path string, contentType string, body BodyFunc, size int64) (resp *http.Response, err error) {
+	return c.sendBody(ctx, "POST", path, body, size, contentType)
+}
+
+// PutBody issues a PUT to the specified URL, with the body returned by the
+// BodyFunc, of the size given, or -1 if unknown, and the content type
+// given. An empty content type leaves the Content-Type header unset.
+//
+// Unlike a body passed to Put, which is consumed by the first attempt, the
+// body is streamed afresh for every attempt, so that the client's
+// RetryPolicy can safely retry the upload.
+//
+// When err is nil, resp always contains a non-nil resp.Body.
+// Caller should close resp.Body when done reading from it.
+func (c *Client) PutBody(ctx context.Context, path string, contentType string, body BodyFunc, size int64) (resp *http.Response, err error) {
+	return c.sendBody(ctx, "PUT", path, body, size, contentType)
+}
+
+// sendBody creates a request for the method and URL, with a body that is
+// read again by the BodyFunc for every attempt, and sends it.
+func (c *Client) sendBody(ctx context.Context, method, path string, body BodyFunc, size int64, contentType string) (*http.Response, error) {
+	if body == nil {
+		return nil, errors.NotValidf("nil body func")
+	}
+	req, err := http.NewRequestWithContext(ctx, method, path, nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if size != 0 {
+		if req.Body, err = body(); err != nil {
+			return nil, errors.Annotate(err, "cannot read request body")
+		}
+		req.GetBody = body
+		req.ContentLength = size
+		if size < 0 {
+			req.ContentLength = -1
+		}
+	}
+	return c.sendRequest(req, path, contentType)
+}
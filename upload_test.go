@@ -0,0 +1,137 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type uploadSuite struct {
+	testing.IsolationSuite
+
+	server *httptest.Server
+
+	mu       sync.Mutex
+	failures int
+	bodies   []string
+	lengths  []int64
+}
+
+var _ = gc.Suite(&uploadSuite{})
+
+func (s *uploadSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.failures = 0
+	s.bodies = nil
+	s.lengths = nil
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.bodies = append(s.bodies, r.Method+" "+r.Header.Get("Content-Type")+" "+string(body))
+		s.lengths = append(s.lengths, r.ContentLength)
+		if s.failures > 0 {
+			s.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	s.AddCleanup(func(*gc.C) { s.server.Close() })
+}
+
+func (s *uploadSuite) newClient() *Client {
+	return NewClient(WithRequestRetrier(RetryPolicy{
+		Attempts: 3,
+		Delay:    time.Millisecond,
+		MaxDelay: time.Millisecond,
+	}))
+}
+
+func (s *uploadSuite) TestPostBodyRetried(c *gc.C) {
+	s.failures = 2
+	var opened int
+	resp, err := s.newClient().PostBody(context.Background(), s.server.URL, "application/zip", func() (io.ReadCloser, error) {
+		opened++
+		return io.NopCloser(io.MultiReader(strings.NewReader("charm "), strings.NewReader("archive"))), nil
+	}, 13)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Check(resp.StatusCode, gc.Equals, http.StatusOK)
+	c.Check(opened, gc.Equals, 3)
+	c.Check(s.bodies, jc.DeepEquals, []string{
+		"POST application/zip charm archive",
+		"POST application/zip charm archive",
+		"POST application/zip charm archive",
+	})
+	c.Check(s.lengths, jc.DeepEquals, []int64{13, 13, 13})
+}
+
+func (s *uploadSuite) TestPutBodyReaderAt(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "ubuntu.charm")
+	c.Assert(os.WriteFile(path, []byte("charm archive"), 0o644), jc.ErrorIsNil)
+	file, err := os.Open(path)
+	c.Assert(err, jc.ErrorIsNil)
+	defer file.Close()
+
+	s.failures = 1
+	resp, err := s.newClient().PutBody(context.Background(), s.server.URL, "", ReaderAtBody(file, 13), 13)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Check(s.bodies, jc.DeepEquals, []string{
+		"PUT  charm archive",
+		"PUT  charm archive",
+	})
+}
+
+func (s *uploadSuite) TestUnknownSize(c *gc.C) {
+	resp, err := s.newClient().PostBody(context.Background(), s.server.URL, "text/plain", func() (io.ReadCloser, error) {
+		return io.NopCloser(io.MultiReader(strings.NewReader("log batch"))), nil
+	}, -1)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Check(s.bodies, jc.DeepEquals, []string{"POST text/plain log batch"})
+	c.Check(s.lengths, jc.DeepEquals, []int64{-1})
+}
+
+func (s *uploadSuite) TestEmptyBody(c *gc.C) {
+	resp, err := s.newClient().PostBody(context.Background(), s.server.URL, "", func() (io.ReadCloser, error) {
+		c.Fatalf("empty body opened")
+		return nil, nil
+	}, 0)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Check(s.lengths, jc.DeepEquals, []int64{0})
+}
+
+func (s *uploadSuite) TestBodyErrors(c *gc.C) {
+	_, err := s.newClient().PostBody(context.Background(), s.server.URL, "", nil, 1)
+	c.Check(err, gc.ErrorMatches, `nil body func not valid`)
+
+	_, err = s.newClient().PostBody(context.Background(), s.server.URL, "", func() (io.ReadCloser, error) {
+		return nil, errors.New("file removed")
+	}, 1)
+	c.Check(err, gc.ErrorMatches, `cannot read request body: file removed`)
+	c.Check(s.bodies, gc.HasLen, 0)
+}
+
+func (s *uploadSuite) TestPostNotReplayable(c *gc.C) {
+	s.failures = 1
+	resp, err := s.newClient().Post(context.Background(), s.server.URL, "", io.MultiReader(strings.NewReader("body")))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+	c.Check(resp.StatusCode, gc.Equals, http.StatusServiceUnavailable)
+	c.Check(s.bodies, jc.DeepEquals, []string{"POST  body"})
+}
@@ -0,0 +1,261 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+)
+
+// OtherService is the name the requests to hosts that match none of the
+// services of a UsageAggregator are counted under.
+const OtherService = "other"
+
+// UsageService is an external service whose usage is counted by name.
+type UsageService struct {
+	// Name is the name the service is reported as, such as "charmhub".
+	Name string
+
+	// Hosts are patterns, using path.Match syntax, matching the host names
+	// of the service, such as "api.charmhub.io" or "*.s3.amazonaws.com".
+	Hosts []string
+}
+
+// UsageConfig configures a UsageAggregator.
+type UsageConfig struct {
+	// Services are the services whose usage is counted by name. A request
+	// is counted under the first service with a host pattern matching its
+	// host, or under OtherService if there is none.
+	Services []UsageService
+
+	// Clock is used to time the reports. If nil, the wall clock is used.
+	Clock clock.Clock
+}
+
+// Validate validates the UsageConfig for any issues.
+func (c UsageConfig) Validate() error {
+	for _, service := range c.Services {
+		if service.Name == "" || service.Name == OtherService {
+			return errors.NotValidf("service name %q", service.Name)
+		}
+		for _, host := range service.Hosts {
+			if _, err := path.Match(host, ""); err != nil {
+				return errors.NotValidf("host pattern %q of service %q", host, service.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// UsageReport is an anonymized report of the requests made to external
+// services over a period.
+type UsageReport struct {
+	// Start is the start of the period, when the aggregator was created or
+	// last reset.
+	Start time.Time `json:"start"`
+	// End is the end of the period, when the report was exported.
+	End time.Time `json:"end"`
+	// Endpoints are the counts of the requests, by service and method,
+	// ordered by service and method.
+	Endpoints []EndpointUsage `json:"endpoints"`
+}
+
+// EndpointUsage counts the requests made to a service with a method. The
+// counts are bucketed, rounded down to a power of ten, so that they don't
+// identify a deployment.
+type EndpointUsage struct {
+	// Service is the name of the service, or OtherService.
+	Service string `json:"service"`
+	// Method is the method of the requests, such as "GET".
+	Method string `json:"method"`
+	// Calls is the bucketed number of requests, whether they succeeded or
+	// failed.
+	Calls int64 `json:"calls"`
+	// Faults are the bucketed numbers of requests that failed, keyed by the
+	// name of their FaultClass, such as "timeout" or "server-status".
+	Faults map[string]int64 `json:"faults,omitempty"`
+}
+
+// UsageAggregator is a RequestRecorder that counts the requests made to
+// external services, so that deployments can opt in to reporting which
+// services they call most, and how those calls fail. Only the configured
+// name of the service, the method and the fault class of each request are
+// kept, never its URL, host or any other detail, and the counts are
+// bucketed when exported.
+//
+// It is used with WithRequestRecorderChain, alongside any other recorder,
+// and may be shared by the clients of a process. It is safe for concurrent
+// use.
+type UsageAggregator struct {
+	services []UsageService
+	clock    clock.Clock
+
+	mu     sync.Mutex
+	start  time.Time
+	counts map[usageKey]*usageCount
+}
+
+// usageKey identifies the requests of an endpoint.
+type usageKey struct {
+	service string
+	method  string
+}
+
+// usageCount counts the requests of an endpoint.
+type usageCount struct {
+	calls  int64
+	faults map[FaultClass]int64
+}
+
+var _ RequestRecorder = (*UsageAggregator)(nil)
+
+// NewUsageAggregator returns a UsageAggregator configured by the config.
+func NewUsageAggregator(config UsageConfig) (*UsageAggregator, error) {
+	if err := config.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if config.Clock == nil {
+		config.Clock = clock.WallClock
+	}
+	services := make([]UsageService, len(config.Services))
+	for i, service := range config.Services {
+		services[i] = UsageService{Name: service.Name}
+		for _, host := range service.Hosts {
+			services[i].Hosts = append(services[i].Hosts, strings.ToLower(host))
+		}
+	}
+	return &UsageAggregator{
+		services: services,
+		clock:    config.Clock,
+		start:    config.Clock.Now(),
+		counts:   make(map[usageKey]*usageCount),
+	}, nil
+}
+
+// Record implements RequestRecorder.
+func (a *UsageAggregator) Record(method string, url *url.URL, res *http.Response, _ time.Duration) {
+	a.count(method, url, ClassifyResponse(res))
+}
+
+// RecordError implements RequestRecorder.
+func (a *UsageAggregator) RecordError(method string, url *url.URL, err error) {
+	a.count(method, url, ClassifyError(err))
+}
+
+// count counts a request with the fault class.
+func (a *UsageAggregator) count(method string, url *url.URL, fault FaultClass) {
+	key := usageKey{
+		service: a.service(url),
+		method:  usageMethod(method),
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	count, ok := a.counts[key]
+	if !ok {
+		count = &usageCount{faults: make(map[FaultClass]int64)}
+		a.counts[key] = count
+	}
+	count.calls++
+	if fault != FaultNone {
+		count.faults[fault]++
+	}
+}
+
+// service returns the name of the service of the URL.
+func (a *UsageAggregator) service(url *url.URL) string {
+	if url == nil {
+		return OtherService
+	}
+	host := strings.ToLower(url.Hostname())
+	for _, service := range a.services {
+		for _, pattern := range service.Hosts {
+			if matched, _ := path.Match(pattern, host); matched {
+				return service.Name
+			}
+		}
+	}
+	return OtherService
+}
+
+// usageMethod returns the method, or "OTHER" if it isn't a standard method,
+// so that a caller can't smuggle data into the report through it.
+func usageMethod(method string) string {
+	switch method {
+	case "":
+		return http.MethodGet
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+// Export returns the report of the requests counted since the aggregator
+// was created or last reset.
+func (a *UsageAggregator) Export() UsageReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.report()
+}
+
+// ExportAndReset returns the report of the requests counted since the
+// aggregator was created or last reset, and resets the counts, so that
+// successive reports cover successive periods.
+func (a *UsageAggregator) ExportAndReset() UsageReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	report := a.report()
+	a.start = report.End
+	a.counts = make(map[usageKey]*usageCount)
+	return report
+}
+
+// report returns the report of the counts, with the mutex held.
+func (a *UsageAggregator) report() UsageReport {
+	report := UsageReport{
+		Start: a.start,
+		End:   a.clock.Now(),
+	}
+	for key, count := range a.counts {
+		endpoint := EndpointUsage{
+			Service: key.service,
+			Method:  key.method,
+			Calls:   usageBucket(count.calls),
+		}
+		for fault, n := range count.faults {
+			if endpoint.Faults == nil {
+				endpoint.Faults = make(map[string]int64)
+			}
+			endpoint.Faults[fault.String()] = usageBucket(n)
+		}
+		report.Endpoints = append(report.Endpoints, endpoint)
+	}
+	slices.SortFunc(report.Endpoints, func(a, b EndpointUsage) int {
+		if c := strings.Compare(a.Service, b.Service); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Method, b.Method)
+	})
+	return report
+}
+
+// usageBucket rounds the count down to a power of ten.
+func usageBucket(n int64) int64 {
+	if n <= 0 {
+		return 0
+	}
+	bucket := int64(1)
+	for bucket <= n/10 {
+		bucket *= 10
+	}
+	return bucket
+}
@@ -0,0 +1,160 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/juju/clock/testclock"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type usageSuite struct {
+	testing.IsolationSuite
+
+	clock *testclock.Clock
+}
+
+var _ = gc.Suite(&usageSuite{})
+
+func (s *usageSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.clock = testclock.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+}
+
+func (s *usageSuite) newAggregator(c *gc.C) *UsageAggregator {
+	aggregator, err := NewUsageAggregator(UsageConfig{
+		Services: []UsageService{{
+			Name:  "charmhub",
+			Hosts: []string{"api.charmhub.io"},
+		}, {
+			Name:  "s3",
+			Hosts: []string{"*.S3.amazonaws.com", "s3.amazonaws.com"},
+		}},
+		Clock: s.clock,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	return aggregator
+}
+
+func mustParseURL(c *gc.C, rawURL string) *url.URL {
+	u, err := url.Parse(rawURL)
+	c.Assert(err, jc.ErrorIsNil)
+	return u
+}
+
+func (s *usageSuite) TestExport(c *gc.C) {
+	aggregator := s.newAggregator(c)
+	charmhub := mustParseURL(c, "https://api.charmhub.io/v2/charms/info/ubuntu?channel=stable")
+	for i := 0; i < 25; i++ {
+		aggregator.Record("GET", charmhub, &http.Response{StatusCode: http.StatusOK}, time.Second)
+	}
+	for i := 0; i < 3; i++ {
+		aggregator.Record("GET", charmhub, &http.Response{StatusCode: http.StatusBadGateway}, time.Second)
+	}
+	aggregator.RecordError("PUT", mustParseURL(c, "https://juju-backups.s3.amazonaws.com/model-uuid"), context.DeadlineExceeded)
+	aggregator.Record("GET", mustParseURL(c, "https://10.0.0.1:17070/model/uuid/charms"), &http.Response{StatusCode: http.StatusOK}, time.Second)
+	aggregator.Record("PROPFIND", mustParseURL(c, "https://secret.example.com"), &http.Response{StatusCode: http.StatusNotFound}, time.Second)
+
+	s.clock.Advance(time.Hour)
+	report := aggregator.Export()
+	c.Check(report.Start, gc.Equals, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	c.Check(report.End, gc.Equals, time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC))
+	c.Check(report.Endpoints, jc.DeepEquals, []EndpointUsage{{
+		Service: "charmhub",
+		Method:  "GET",
+		Calls:   10,
+		Faults:  map[string]int64{"server-status": 1},
+	}, {
+		Service: "other",
+		Method:  "GET",
+		Calls:   1,
+	}, {
+		Service: "other",
+		Method:  "OTHER",
+		Calls:   1,
+		Faults:  map[string]int64{"client-status": 1},
+	}, {
+		Service: "s3",
+		Method:  "PUT",
+		Calls:   1,
+		Faults:  map[string]int64{"timeout": 1},
+	}})
+
+	// Nothing identifying the requests is exported.
+	data, err := json.Marshal(report)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(data), gc.Not(gc.Matches), `.*(charmhub.io|10.0.0.1|example.com|juju-backups|PROPFIND|stable).*`)
+}
+
+func (s *usageSuite) TestExportAndReset(c *gc.C) {
+	aggregator := s.newAggregator(c)
+	aggregator.Record("GET", mustParseURL(c, "https://api.charmhub.io"), &http.Response{StatusCode: http.StatusOK}, time.Second)
+
+	s.clock.Advance(time.Hour)
+	report := aggregator.ExportAndReset()
+	c.Check(report.Endpoints, gc.HasLen, 1)
+
+	s.clock.Advance(time.Hour)
+	report = aggregator.Export()
+	c.Check(report.Start, gc.Equals, time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC))
+	c.Check(report.Endpoints, gc.HasLen, 0)
+}
+
+func (s *usageSuite) TestBucket(c *gc.C) {
+	for n, bucket := range map[int64]int64{
+		0: 0, 1: 1, 9: 1, 10: 10, 99: 10, 100: 100, 12345: 10000,
+	} {
+		c.Check(usageBucket(n), gc.Equals, bucket, gc.Commentf("%d", n))
+	}
+}
+
+func (s *usageSuite) TestWithClient(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	aggregator, err := NewUsageAggregator(UsageConfig{
+		Services: []UsageService{{Name: "local", Hosts: []string{"127.0.0.1"}}},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	client := NewClient(WithRequestRecorderChain(aggregator))
+	resp, err := client.Get(context.Background(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Body.Close(), jc.ErrorIsNil)
+
+	c.Check(aggregator.Export().Endpoints, jc.DeepEquals, []EndpointUsage{{
+		Service: "local",
+		Method:  "GET",
+		Calls:   1,
+	}})
+}
+
+func (s *usageSuite) TestValidate(c *gc.C) {
+	tests := []struct {
+		config UsageConfig
+		err    string
+	}{{
+		config: UsageConfig{Services: []UsageService{{Hosts: []string{"api.charmhub.io"}}}},
+		err:    `service name "" not valid`,
+	}, {
+		config: UsageConfig{Services: []UsageService{{Name: OtherService}}},
+		err:    `service name "other" not valid`,
+	}, {
+		config: UsageConfig{Services: []UsageService{{Name: "charmhub", Hosts: []string{"["}}}},
+		err:    `host pattern "\[" of service "charmhub" not valid`,
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.err)
+		c.Check(test.config.Validate(), gc.ErrorMatches, test.err)
+		_, err := NewUsageAggregator(test.config)
+		c.Check(err, gc.ErrorMatches, test.err)
+	}
+}
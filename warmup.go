@@ -0,0 +1,67 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// Warmup establishes connections to the hosts ahead of use, so that
+// latency sensitive operations, such as bootstrap and upgrades, don't wait
+// on DNS resolution, dialing and TLS handshakes. A host is a URL, such as
+// "https://api.charmhub.io", or a host name, with an optional port, which
+// is reached over https.
+//
+// Each host is sent a HEAD request for its root path, completing the TLS
+// handshake and, for HTTP/2 servers, the settings exchange. The connection
+// is left in the idle pool of the client, so is only kept while the
+// transport keeps idle connections. Any response warms the connection,
+// whatever its status code. The hosts are warmed concurrently, and the
+// error of the first host that failed, if any, is returned once all are
+// done.
+func (c *Client) Warmup(ctx context.Context, hosts ...string) error {
+	errs := make([]error, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			errs[i] = c.warmup(ctx, host)
+		}(i, host)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) warmup(ctx context.Context, host string) error {
+	rawURL := host
+	if !strings.Contains(host, "://") {
+		rawURL = "https://" + host
+	}
+	req, err := http.NewRequestWithContext(ctx, "HEAD", rawURL, nil)
+	if err != nil {
+		return errors.Annotatef(err, "warming up %q", host)
+	}
+	req.URL.Path = "/"
+	req.URL.RawQuery = ""
+	resp, err := c.Do(req)
+	if err != nil {
+		return errors.Annotatef(err, "warming up %q", host)
+	}
+	// Read the body to the end, so the connection returns to the pool.
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type warmupSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&warmupSuite{})
+
+func (s *warmupSuite) TestWarmup(c *gc.C) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	err := client.Warmup(context.Background(), server.URL+"/ignored?q=1")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(requests, jc.DeepEquals, []string{"HEAD /"})
+	c.Check(client.Stats().ConnectionsOpened, gc.Equals, int64(1))
+
+	// The warm connection is reused.
+	resp, err := client.Get(context.Background(), server.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	_ = resp.Body.Close()
+	c.Check(client.Stats().ConnectionsOpened, gc.Equals, int64(1))
+}
+
+func (s *warmupSuite) TestWarmupTLS(c *gc.C) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := NewClient(WithSkipHostnameVerification(true))
+	err := client.Warmup(context.Background(), server.Listener.Addr().String())
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(client.Stats().ConnectionsOpened, gc.Equals, int64(1))
+}
+
+func (s *warmupSuite) TestWarmupError(c *gc.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, jc.ErrorIsNil)
+	dead := "http://" + listener.Addr().String()
+	c.Assert(listener.Close(), jc.ErrorIsNil)
+
+	err = NewClient().Warmup(context.Background(), server.URL, dead)
+	c.Assert(err, gc.ErrorMatches, `warming up "`+dead+`": .*connection refused`)
+	c.Check(ClassifyError(err), gc.Equals, FaultConnectRefused)
+}